@@ -0,0 +1,137 @@
+// Command clickhouse-backfill copies clicks and events stored in Postgres
+// before a cutoff into ClickHouse. Run it once after switching the
+// analytics mode to "dual", with -before set to when dual writes started,
+// so rows are neither missed nor copied twice.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tracking/analysis/internal/clickhouse"
+	"github.com/tracking/analysis/internal/config"
+	"github.com/tracking/analysis/internal/database"
+	"github.com/tracking/analysis/internal/models"
+	"github.com/tracking/analysis/internal/repo"
+)
+
+const batchSize = 10000
+
+func main() {
+	configFilename := flag.String("c", "config", "config file name (without extension)")
+	configDirs := flag.String("cPath", "./,./configs/", "comma-separated config search paths")
+	source := flag.String("source", "all", "clicks, events or all")
+	fromFlag := flag.String("from", "", "copy rows at or after this RFC 3339 time (default: all)")
+	beforeFlag := flag.String("before", "", "copy rows before this RFC 3339 time (required)")
+	flag.Parse()
+
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, nil)))
+	if err := run(*configFilename, *configDirs, *source, *fromFlag, *beforeFlag); err != nil {
+		slog.Error("backfill failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+func run(configFilename, configDirs, source, fromFlag, beforeFlag string) error {
+	if source != "all" && source != "clicks" && source != "events" {
+		return fmt.Errorf("unsupported source %q", source)
+	}
+	if beforeFlag == "" {
+		return fmt.Errorf("-before is required")
+	}
+	before, err := time.Parse(time.RFC3339, beforeFlag)
+	if err != nil {
+		return fmt.Errorf("-before: %w", err)
+	}
+	var from time.Time
+	if fromFlag != "" {
+		if from, err = time.Parse(time.RFC3339, fromFlag); err != nil {
+			return fmt.Errorf("-from: %w", err)
+		}
+	}
+
+	var cfg config.Config
+	if err := config.InitConfiguration(configFilename, strings.Split(configDirs, ","), &cfg); err != nil {
+		return err
+	}
+	db, err := database.Init(cfg.PostgresConfiguration.DSN())
+	if err != nil {
+		return err
+	}
+	ac := cfg.AnalyticsConfiguration
+	if ac.ClickHouseURL == "" {
+		return fmt.Errorf("AnalyticsConfiguration.ClickHouseURL is not set")
+	}
+	ch := clickhouse.New(ac.ClickHouseURL, ac.ClickHouseDatabase, ac.ClickHouseUser, ac.ClickHousePassword)
+	if err := repo.MigrateClickHouse(context.Background(), ch); err != nil {
+		return err
+	}
+
+	rows := repo.NewExportRepo(db)
+	// BETWEEN is inclusive, so stop just short of the cutoff.
+	filter := repo.ExportFilter{Start: from, End: before.Add(-time.Microsecond), IncludeBots: true}
+
+	if source != "events" {
+		store := repo.NewClickHouseClickStore(ch, db)
+		var batch []models.Click
+		flush := func() error {
+			if len(batch) == 0 {
+				return nil
+			}
+			if err := store.BatchCreate(batch); err != nil {
+				return err
+			}
+			slog.Info("clicks copied", "rows", len(batch), "through", batch[len(batch)-1].TS)
+			batch = batch[:0]
+			return nil
+		}
+		err := rows.EachClick(filter, func(c *models.Click) error {
+			batch = append(batch, *c)
+			if len(batch) == batchSize {
+				return flush()
+			}
+			return nil
+		})
+		if err == nil {
+			err = flush()
+		}
+		if err != nil {
+			return fmt.Errorf("clicks: %w", err)
+		}
+	}
+
+	if source != "clicks" {
+		store := repo.NewClickHouseEventStore(ch, db)
+		var batch []models.Event
+		flush := func() error {
+			if len(batch) == 0 {
+				return nil
+			}
+			if err := store.BatchCreate(batch); err != nil {
+				return err
+			}
+			slog.Info("events copied", "rows", len(batch), "through", batch[len(batch)-1].TS)
+			batch = batch[:0]
+			return nil
+		}
+		err := rows.EachEvent(filter, func(e *models.Event) error {
+			batch = append(batch, *e)
+			if len(batch) == batchSize {
+				return flush()
+			}
+			return nil
+		})
+		if err == nil {
+			err = flush()
+		}
+		if err != nil {
+			return fmt.Errorf("events: %w", err)
+		}
+	}
+	return nil
+}
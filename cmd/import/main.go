@@ -0,0 +1,99 @@
+// Command import loads a site's history exported from Matomo, Plausible,
+// GA4 (BigQuery) or any tool described by a mapping file into events,
+// through the analytics store the config selects. Rerunning it on the same
+// export adds nothing.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tracking/analysis/internal/config"
+	"github.com/tracking/analysis/internal/database"
+	"github.com/tracking/analysis/internal/importer"
+	"github.com/tracking/analysis/internal/privacy"
+	"github.com/tracking/analysis/internal/repo"
+)
+
+func main() {
+	configFilename := flag.String("c", "config", "config file name (without extension)")
+	configDirs := flag.String("cPath", "./,./configs/", "comma-separated config search paths")
+	siteID := flag.String("site", "", "site ID to import into (required)")
+	source := flag.String("source", "", "matomo, plausible, ga4 or mapping (required)")
+	format := flag.String("format", "", "csv or ndjson (default: ndjson for ga4, csv otherwise)")
+	file := flag.String("file", "-", "export file, - for stdin")
+	mappingFile := flag.String("mapping", "", "JSON mapping file, for -source mapping")
+	flag.Parse()
+
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, nil)))
+	res, err := run(*configFilename, *configDirs, *siteID, *source, *format, *file, *mappingFile)
+	slog.Info("import finished", "read", res.Read, "imported", res.Imported, "duplicates", res.Duplicates, "skipped", res.Skipped, "sessions", res.Sessions)
+	for _, msg := range res.Errors {
+		slog.Warn("record skipped", "reason", msg)
+	}
+	if err != nil {
+		slog.Error("import failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+func run(configFilename, configDirs, siteID, source, format, file, mappingFile string) (importer.Result, error) {
+	var res importer.Result
+	if siteID == "" || source == "" {
+		return res, fmt.Errorf("-site and -source are required")
+	}
+	opts := importer.Options{SiteID: siteID, Source: source, Format: format}
+	if mappingFile != "" {
+		data, err := os.ReadFile(mappingFile)
+		if err != nil {
+			return res, err
+		}
+		if opts.Mapping, err = importer.ParseMapping(data); err != nil {
+			return res, err
+		}
+	}
+
+	var in io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return res, err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	var cfg config.Config
+	if err := config.InitConfiguration(configFilename, strings.Split(configDirs, ","), &cfg); err != nil {
+		return res, err
+	}
+	db, err := database.Init(cfg.PostgresConfiguration.DSN())
+	if err != nil {
+		return res, err
+	}
+	site, err := repo.NewSiteRepo(db).GetByID(siteID)
+	if err != nil {
+		return res, fmt.Errorf("site %s: %w", siteID, err)
+	}
+	if loc, err := time.LoadLocation(site.Timezone); err == nil {
+		opts.Location = loc
+	}
+	opts.Privacy = privacy.Settings{IPMode: site.IPMode, DropUA: site.DropUA}
+	opts.Secret = cfg.SecurityConfiguration.TokenSecret
+
+	_, events, err := repo.NewStores(cfg.AnalyticsConfiguration, db, repo.NewClickRepo(db), repo.NewEventRepo(db))
+	if err != nil {
+		return res, err
+	}
+	res, err = importer.Run(in, opts, events)
+	if err != nil || res.Imported == 0 {
+		return res, err
+	}
+	res.Sessions, err = repo.NewSessionRepo(db).Rebuild(res.First, res.Last, siteID)
+	return res, err
+}
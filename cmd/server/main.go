@@ -1,22 +1,36 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log/slog"
+	"net"
+	"net/http"
 	"os"
 	"strings"
+	"time"
+	_ "time/tzdata" // stats timezones must resolve even without system zoneinfo
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/tracking/analysis/internal/alert"
+	"github.com/tracking/analysis/internal/bot"
 	"github.com/tracking/analysis/internal/cache"
+	"github.com/tracking/analysis/internal/clickhouse"
 	"github.com/tracking/analysis/internal/config"
 	"github.com/tracking/analysis/internal/database"
+	"github.com/tracking/analysis/internal/dedup"
+	"github.com/tracking/analysis/internal/export"
 	"github.com/tracking/analysis/internal/geo"
 	"github.com/tracking/analysis/internal/handler"
+	"github.com/tracking/analysis/internal/notify"
 	"github.com/tracking/analysis/internal/repo"
 	"github.com/tracking/analysis/internal/rpc"
+	"github.com/tracking/analysis/internal/schedule"
 	"github.com/tracking/analysis/internal/security"
+	"github.com/tracking/analysis/internal/sink"
+	"github.com/tracking/analysis/internal/webhook"
 )
 
 func main() {
@@ -81,6 +95,15 @@ func main() {
 		slog.Info("GeoIP disabled (no database path configured)")
 	}
 
+	// Init bot detection
+	challenges := bot.NewChallenger(cfg.SecurityConfiguration.TokenSecret, cfg.BotConfiguration.ChallengeBits, rdb)
+	botPipeline, err := bot.NewPipeline(&cfg.BotConfiguration, nil, challenges)
+	if err != nil {
+		slog.Error("failed to init bot detection", "error", err)
+		os.Exit(1)
+	}
+	defer botPipeline.Close()
+
 	// Load RSA key pair
 	privKey, pubKey, err := security.LoadKeyPair(cfg.SecurityConfiguration.RSAPrivateKeyPath, cfg.SecurityConfiguration.RSAPublicKeyPath)
 	if err != nil {
@@ -98,6 +121,67 @@ func main() {
 	clickRepo := repo.NewClickRepo(db)
 	eventRepo := repo.NewEventRepo(db)
 	tokenRepo := repo.NewTokenRepo(db)
+	privacyRepo := repo.NewPrivacyRepo(db)
+	sessionRepo := repo.NewSessionRepo(db)
+	reportRepo := repo.NewReportRepo(db)
+	webhookRepo := repo.NewWebhookRepo(db)
+	alertRepo := repo.NewAlertRepo(db)
+	scheduledReportRepo := repo.NewScheduledReportRepo(db)
+	exportRepo := repo.NewExportRepo(db)
+	fraudRepo := repo.NewFraudRepo(db)
+	outboxRepo := repo.NewOutboxRepo(db, cfg.SinkConfiguration.Sinks)
+
+	// Start the streaming sinks; stored rows reach them through the outbox
+	sinks, err := sink.New(cfg.SinkConfiguration, rdb)
+	if err != nil {
+		slog.Error("failed to init sinks", "error", err)
+		os.Exit(1)
+	}
+	if len(sinks) > 0 {
+		clickRepo.Outbox = outboxRepo
+		eventRepo.Outbox = outboxRepo
+		go sink.NewService(outboxRepo, sinks).Run(context.Background())
+	}
+
+	// Clicks and events are stored and queried through the analytics stores.
+	// Sessions, custom and fraud reports, privacy requests, exports and the
+	// streaming sinks still read raw rows from Postgres, so it must keep
+	// receiving them.
+	ac := cfg.AnalyticsConfiguration
+	if ac.Mode == repo.ModeClickHouse {
+		slog.Error("analytics mode clickhouse is not supported by the server yet; use mode dual with read_from clickhouse")
+		os.Exit(1)
+	}
+	clickStore, eventStore, err := repo.NewStores(ac, db, clickRepo, eventRepo)
+	if err != nil {
+		slog.Error("failed to init analytics store", "error", err)
+		os.Exit(1)
+	}
+	if ac.Mode == repo.ModeDual {
+		privacyRepo.ClickHouse = clickhouse.New(ac.ClickHouseURL, ac.ClickHouseDatabase, ac.ClickHouseUser, ac.ClickHousePassword)
+	}
+
+	// Start the webhook delivery worker
+	webhooks := webhook.NewService(webhookRepo, tokenRepo, rdb)
+	go webhooks.Run(context.Background())
+
+	// Start the alert scheduler
+	mailer := &notify.Mailer{Config: &cfg.SMTPConfiguration}
+	alerts := alert.NewService(alertRepo, clickStore, eventStore, rdb, webhooks, mailer)
+	go alerts.Run(context.Background())
+
+	// Start the bulk export worker
+	exportStore, err := export.NewStore(cfg.ExportConfiguration)
+	if err != nil {
+		slog.Error("failed to init export storage", "error", err)
+		os.Exit(1)
+	}
+	exportTTL := time.Duration(cfg.ExportConfiguration.URLTTLSeconds) * time.Second
+	exports := export.NewService(exportRepo, exportStore, cfg.SecurityConfiguration.TokenSecret, cfg.ServiceConfiguration.ExportURL, exportTTL)
+	go exports.Run(context.Background())
+
+	// Store the duplicate click counts buffered in Redis
+	go dedup.RunHitFlush(context.Background(), rdb, fraudRepo.AddDedupHits)
 
 	// Set up JSON-RPC dispatcher
 	dispatcher := rpc.NewDispatcher()
@@ -111,21 +195,43 @@ func main() {
 		TargetRepo:   targetRepo,
 		SiteRepo:     siteRepo,
 		TokenRepo:    tokenRepo,
-		ClickRepo:    clickRepo,
-		EventRepo:    eventRepo,
+		ClickRepo:    clickStore,
+		EventRepo:    eventStore,
+		PrivacyRepo:  privacyRepo,
+		SessionRepo:  sessionRepo,
+		ReportRepo:   reportRepo,
+		WebhookRepo:  webhookRepo,
+		Webhooks:     webhooks,
+		AlertRepo:    alertRepo,
+		Alerts:       alerts,
+
+		ScheduledReportRepo: scheduledReportRepo,
+		ExportRepo:          exportRepo,
+		Exports:             exports,
+		FraudRepo:           fraudRepo,
+		Redis:               rdb,
 	}
 	adminHandlers.Register(dispatcher)
 
+	// Start the scheduled report sender; it reads stats through the admin handlers
+	schedules := schedule.NewService(scheduledReportRepo, adminHandlers.ReportStats, mailer)
+	adminHandlers.Schedules = schedules
+	go schedules.Run(context.Background())
+
 	// Register track handlers
 	trackHandlers := &rpc.TrackHandlers{
 		Config:      &cfg,
 		Redis:       rdb,
 		PrivKey:     privKey,
-		ClickRepo:   clickRepo,
-		EventRepo:   eventRepo,
+		TrackerRepo: trackerRepo,
+		ClickRepo:   clickStore,
+		EventRepo:   eventStore,
+		SessionRepo: sessionRepo,
 		SiteRepo:    siteRepo,
 		TokenRepo:   tokenRepo,
 		GeoResolver: geoResolver,
+		Webhooks:    webhooks,
+		Bot:         botPipeline,
 	}
 	dispatcher.Register("track.collectClick", trackHandlers.CollectClick)
 	dispatcher.Register("track.collectEvents", trackHandlers.CollectEvents)
@@ -133,7 +239,8 @@ func main() {
 	// Set up tracking HTTP handlers
 	trackingHandler := &handler.TrackingHandler{
 		Config:      &cfg,
-		ClickRepo:   clickRepo,
+		TrackerRepo: trackerRepo,
+		ClickRepo:   clickStore,
 		TargetRepo:  targetRepo,
 		TokenRepo:   tokenRepo,
 		PubKey:      pubKey,
@@ -141,6 +248,16 @@ func main() {
 		Redis:       rdb,
 		BotCfg:      &cfg.BotConfiguration,
 		GeoResolver: geoResolver,
+		Webhooks:    webhooks,
+		Bot:         botPipeline,
+		Challenges:  challenges,
+	}
+	exportHandler := &handler.ExportHandler{
+		Repo:    exportRepo,
+		Exports: exports,
+	}
+	streamHandler := &handler.StreamHandler{
+		Redis: rdb,
 	}
 
 	// Set up Gin router
@@ -160,14 +277,28 @@ func main() {
 
 	// Routes
 	r.POST("/rpc", dispatcher.GinHandler())
+	r.POST("/rpc/beacon", dispatcher.BeaconHandler("track.collectEvents"))
 	r.GET("/t/:token", trackingHandler.HandleJSTrack)
 	r.GET("/r/:token", trackingHandler.HandleRedirectTrack)
 	r.GET("/sdk/track.js", trackingHandler.HandleSDK)
 	r.GET("/public-keys.json", trackingHandler.HandlePublicKeys)
+	r.GET("/admin/stream", streamHandler.HandleStream)
+	r.GET("/exports/:id", exportHandler.HandleDownload)
 
 	addr := fmt.Sprintf(":%s", cfg.ServiceConfiguration.Port)
 	slog.Info("starting server", "addr", addr)
-	if err := r.Run(addr); err != nil {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		slog.Error("server failed", "error", err)
+		os.Exit(1)
+	}
+	// The listener records request header order for bot fingerprinting
+	srv := &http.Server{
+		Addr:        addr,
+		Handler:     bot.HeaderOrderHandler(r),
+		ConnContext: bot.ConnContext,
+	}
+	if err := srv.Serve(bot.HeaderOrderListener(ln)); err != nil {
 		slog.Error("server failed", "error", err)
 		os.Exit(1)
 	}
@@ -0,0 +1,325 @@
+// Package alert evaluates alert rules against the stats summaries and
+// notifies when a rule starts or stops firing.
+package alert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/tracking/analysis/internal/models"
+	"github.com/tracking/analysis/internal/notify"
+	"github.com/tracking/analysis/internal/repo"
+	"github.com/tracking/analysis/internal/webhook"
+)
+
+// Conditions a rule can test.
+const (
+	ConditionAbove   = "above"
+	ConditionBelow   = "below"
+	ConditionDropPct = "drop_pct"
+	ConditionRisePct = "rise_pct"
+)
+
+// Rule states.
+const (
+	StateOK       = "ok"
+	StateFiring   = "firing"
+	StateResolved = "resolved" // alert events only
+)
+
+// Notification channels.
+const (
+	NotifyWebhook = "webhook"
+	NotifyEmail   = "email"
+	NotifySlack   = "slack"
+)
+
+// Metrics lists the stats summary keys each source can alert on.
+var Metrics = map[string][]string{
+	"clicks": {"total", "unique_visitors", "bots", "bot_rate"},
+	"events": {"total", "unique_visitors", "unique_sessions", "bots", "bot_rate"},
+}
+
+// EvalInterval is how often each rule is evaluated.
+const EvalInterval = 5 * time.Minute
+
+const (
+	tickInterval  = time.Minute
+	notifyTimeout = 10 * time.Second
+)
+
+// ValidMetric reports whether metric can be alerted on for source.
+func ValidMetric(source, metric string) bool {
+	for _, m := range Metrics[source] {
+		if m == metric {
+			return true
+		}
+	}
+	return false
+}
+
+// Relative reports whether condition compares against a baseline.
+func Relative(condition string) bool {
+	return condition == ConditionDropPct || condition == ConditionRisePct
+}
+
+// Evaluate reports whether rule fires for the current value. Relative
+// conditions never fire without a positive baseline.
+func Evaluate(rule *models.AlertRule, current float64, baseline *float64) bool {
+	switch rule.Condition {
+	case ConditionAbove:
+		return current > rule.Threshold
+	case ConditionBelow:
+		return current < rule.Threshold
+	case ConditionDropPct:
+		return baseline != nil && *baseline > 0 && current <= *baseline*(1-rule.Threshold/100)
+	case ConditionRisePct:
+		return baseline != nil && *baseline > 0 && current >= *baseline*(1+rule.Threshold/100)
+	}
+	return false
+}
+
+// summaryValue picks metric out of a stats summary, as StatsClicks and
+// StatsEvents report it.
+func summaryValue(metric string, total, uniqueVisitors, uniqueSessions, bots int64) float64 {
+	switch metric {
+	case "total":
+		return float64(total)
+	case "unique_visitors":
+		return float64(uniqueVisitors)
+	case "unique_sessions":
+		return float64(uniqueSessions)
+	case "bots":
+		return float64(bots)
+	case "bot_rate":
+		if total == 0 {
+			return 0
+		}
+		return float64(bots) / float64(total) * 100
+	}
+	return 0
+}
+
+type Service struct {
+	Repo      *repo.AlertRepo
+	ClickRepo repo.ClickStore
+	EventRepo repo.EventStore
+	Redis     *redis.Client
+	Webhooks  *webhook.Service
+	Mailer    *notify.Mailer
+	Client    *http.Client
+}
+
+func NewService(alertRepo *repo.AlertRepo, clickRepo repo.ClickStore, eventRepo repo.EventStore, rdb *redis.Client, webhooks *webhook.Service, mailer *notify.Mailer) *Service {
+	return &Service{
+		Repo:      alertRepo,
+		ClickRepo: clickRepo,
+		EventRepo: eventRepo,
+		Redis:     rdb,
+		Webhooks:  webhooks,
+		Mailer:    mailer,
+		Client:    &http.Client{Timeout: notifyTimeout},
+	}
+}
+
+func (s *Service) value(rule *models.AlertRule, start, end time.Time) (float64, error) {
+	if rule.Source == "events" {
+		total, uv, us, bots, err := s.EventRepo.Summary(start, end, rule.SiteID)
+		return summaryValue(rule.Metric, total, uv, us, bots), err
+	}
+	total, uv, bots, err := s.ClickRepo.Summary(start, end, rule.TrackerID, rule.CampaignID, rule.ChannelID)
+	return summaryValue(rule.Metric, total, uv, 0, bots), err
+}
+
+// Measure returns the metric over the window ending at now and, for
+// relative conditions, its mean over the same window on each of the
+// previous BaselineDays days.
+func (s *Service) Measure(rule *models.AlertRule, now time.Time) (float64, *float64, error) {
+	start := now.Add(-time.Duration(rule.WindowMinutes) * time.Minute)
+	current, err := s.value(rule, start, now)
+	if err != nil || !Relative(rule.Condition) {
+		return current, nil, err
+	}
+	var sum float64
+	for d := 1; d <= rule.BaselineDays; d++ {
+		v, err := s.value(rule, start.AddDate(0, 0, -d), now.AddDate(0, 0, -d))
+		if err != nil {
+			return current, nil, err
+		}
+		sum += v
+	}
+	baseline := sum / float64(rule.BaselineDays)
+	return current, &baseline, nil
+}
+
+// Run evaluates due rules until ctx is done.
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.evaluateDue(ctx, now)
+		}
+	}
+}
+
+func (s *Service) evaluateDue(ctx context.Context, now time.Time) {
+	rules, err := s.Repo.ListActive()
+	if err != nil {
+		slog.Warn("alert rule list failed", "error", err)
+		return
+	}
+	for i := range rules {
+		// The lock spaces evaluations by EvalInterval across all replicas.
+		ok, err := s.Redis.SetNX(ctx, "alert:eval:"+rules[i].ID, 1, EvalInterval-5*time.Second).Result()
+		if err != nil || !ok {
+			continue
+		}
+		s.evaluate(ctx, &rules[i], now)
+	}
+}
+
+// evaluate measures rule and notifies only when its state changes, so a
+// persisting condition alerts once and its end sends a recovery notice. If
+// every channel fails, the rule keeps its previous state so the next
+// evaluation notifies again.
+func (s *Service) evaluate(ctx context.Context, rule *models.AlertRule, now time.Time) {
+	current, baseline, err := s.Measure(rule, now)
+	if err != nil {
+		slog.Warn("alert evaluation failed", "error", err, "rule", rule.ID)
+		return
+	}
+	rule.LastValue, rule.LastBaseline, rule.LastEvaluated = current, baseline, &now
+
+	state := StateOK
+	if Evaluate(rule, current, baseline) {
+		state = StateFiring
+	}
+	if state != rule.State {
+		event := &models.AlertEvent{RuleID: rule.ID, State: state, Value: current, Baseline: baseline}
+		if state == StateOK {
+			event.State = StateResolved
+		}
+		event.Message = Message(rule, event.State, current, baseline)
+		failed, err := s.notify(ctx, rule, event)
+		if failed > 0 && failed == len(rule.Notify) {
+			slog.Warn("alert notification failed on every channel, will retry", "error", err, "rule", rule.ID)
+		} else {
+			if err != nil {
+				event.NotifyErr = err.Error()
+				slog.Warn("alert notification failed", "error", err, "rule", rule.ID)
+			}
+			rule.State, rule.StateChanged = state, &now
+			if err := s.Repo.CreateEvent(event); err != nil {
+				slog.Error("alert event write failed", "error", err, "rule", rule.ID)
+			}
+		}
+	}
+	if err := s.Repo.SaveState(rule); err != nil {
+		slog.Error("alert state write failed", "error", err, "rule", rule.ID)
+	}
+}
+
+// notify sends event to every channel of rule, returning how many failed.
+func (s *Service) notify(ctx context.Context, rule *models.AlertRule, event *models.AlertEvent) (failed int, err error) {
+	var errs []error
+	for _, channel := range rule.Notify {
+		var err error
+		switch channel {
+		case NotifyWebhook:
+			scope := webhook.Scope{TrackerID: rule.TrackerID, SiteID: rule.SiteID}
+			err = s.Webhooks.Emit(ctx, webhook.KindAlert, scope, Payload(rule, event))
+		case NotifyEmail:
+			subject, _, _ := strings.Cut(event.Message, "\n")
+			err = s.Mailer.Send(notify.Mail{To: rule.EmailTo, Subject: subject, Text: event.Message})
+		case NotifySlack:
+			err = notify.PostSlack(ctx, s.Client, rule.SlackURL, event.Message)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", channel, err))
+		}
+	}
+	return len(errs), errors.Join(errs...)
+}
+
+// Message describes a state change, subject line first.
+func Message(rule *models.AlertRule, state string, current float64, baseline *float64) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%s] %s\n\n", strings.ToUpper(state), rule.Name)
+	fmt.Fprintf(&b, "%s %s over the last %d minutes%s: %s", rule.Source, rule.Metric, rule.WindowMinutes, scopeText(rule), formatValue(current))
+	switch rule.Condition {
+	case ConditionAbove:
+		fmt.Fprintf(&b, " (alerts above %s)", formatValue(rule.Threshold))
+	case ConditionBelow:
+		fmt.Fprintf(&b, " (alerts below %s)", formatValue(rule.Threshold))
+	default:
+		direction := "drop"
+		if rule.Condition == ConditionRisePct {
+			direction = "rise"
+		}
+		if baseline != nil {
+			fmt.Fprintf(&b, " vs %d-day baseline %s", rule.BaselineDays, formatValue(*baseline))
+			if *baseline > 0 {
+				fmt.Fprintf(&b, " (%+.1f%%)", (current-*baseline) / *baseline * 100)
+			}
+		}
+		fmt.Fprintf(&b, " (alerts on a %s%% %s)", formatValue(rule.Threshold), direction)
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+func scopeText(rule *models.AlertRule) string {
+	var parts []string
+	for _, p := range []struct{ name, id string }{
+		{"tracker", rule.TrackerID},
+		{"campaign", rule.CampaignID},
+		{"channel", rule.ChannelID},
+		{"site", rule.SiteID},
+	} {
+		if p.id != "" {
+			parts = append(parts, p.name+" "+p.id)
+		}
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return " for " + strings.Join(parts, ", ")
+}
+
+func formatValue(v float64) string {
+	if v == float64(int64(v)) {
+		return fmt.Sprintf("%d", int64(v))
+	}
+	return fmt.Sprintf("%.2f", v)
+}
+
+// Payload is the data of an alert webhook.
+func Payload(rule *models.AlertRule, event *models.AlertEvent) map[string]any {
+	return map[string]any{
+		"rule_id":        rule.ID,
+		"name":           rule.Name,
+		"state":          event.State,
+		"source":         rule.Source,
+		"metric":         rule.Metric,
+		"condition":      rule.Condition,
+		"threshold":      rule.Threshold,
+		"window_minutes": rule.WindowMinutes,
+		"value":          event.Value,
+		"baseline":       event.Baseline,
+		"tracker_id":     rule.TrackerID,
+		"campaign_id":    rule.CampaignID,
+		"channel_id":     rule.ChannelID,
+		"site_id":        rule.SiteID,
+		"message":        event.Message,
+	}
+}
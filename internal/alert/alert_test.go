@@ -0,0 +1,88 @@
+package alert
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tracking/analysis/internal/models"
+)
+
+func ptr(f float64) *float64 { return &f }
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		name      string
+		condition string
+		threshold float64
+		current   float64
+		baseline  *float64
+		want      bool
+	}{
+		{"above", ConditionAbove, 30, 35.5, nil, true},
+		{"not above", ConditionAbove, 30, 30, nil, false},
+		{"zero events", ConditionBelow, 1, 0, nil, true},
+		{"some events", ConditionBelow, 1, 3, nil, false},
+		{"dropped by half", ConditionDropPct, 50, 50, ptr(100), true},
+		{"dropped less", ConditionDropPct, 50, 51, ptr(100), false},
+		{"drop without baseline", ConditionDropPct, 50, 0, ptr(0), false},
+		{"doubled", ConditionRisePct, 100, 200, ptr(100), true},
+		{"rose less", ConditionRisePct, 100, 199, ptr(100), false},
+		{"rise without baseline", ConditionRisePct, 100, 10, nil, false},
+	}
+	for _, tt := range tests {
+		rule := &models.AlertRule{Condition: tt.condition, Threshold: tt.threshold}
+		if got := Evaluate(rule, tt.current, tt.baseline); got != tt.want {
+			t.Errorf("%s: Evaluate = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSummaryValue(t *testing.T) {
+	if got := summaryValue("bot_rate", 200, 50, 0, 30); got != 15 {
+		t.Errorf("bot_rate = %v, want 15", got)
+	}
+	if got := summaryValue("bot_rate", 0, 0, 0, 0); got != 0 {
+		t.Errorf("bot_rate of no traffic = %v, want 0", got)
+	}
+	if got := summaryValue("unique_sessions", 10, 4, 6, 0); got != 6 {
+		t.Errorf("unique_sessions = %v, want 6", got)
+	}
+}
+
+func TestValidMetric(t *testing.T) {
+	if !ValidMetric("events", "unique_sessions") {
+		t.Error("events should support unique_sessions")
+	}
+	if ValidMetric("clicks", "unique_sessions") {
+		t.Error("clicks should not support unique_sessions")
+	}
+}
+
+func TestMessage(t *testing.T) {
+	rule := &models.AlertRule{
+		Name:          "Channel X traffic",
+		Source:        "clicks",
+		Metric:        "total",
+		ChannelID:     "ch-1",
+		Condition:     ConditionDropPct,
+		Threshold:     50,
+		WindowMinutes: 60,
+		BaselineDays:  7,
+	}
+	msg := Message(rule, StateFiring, 20, ptr(80))
+	subject, body, _ := strings.Cut(msg, "\n")
+	if subject != "[FIRING] Channel X traffic" {
+		t.Errorf("subject = %q", subject)
+	}
+	for _, want := range []string{"for channel ch-1", ": 20 vs 7-day baseline 80 (-75.0%)", "50% drop"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body missing %q: %s", want, body)
+		}
+	}
+
+	rule.Condition, rule.Metric, rule.Threshold = ConditionAbove, "bot_rate", 30
+	msg = Message(rule, StateResolved, 12.5, nil)
+	if !strings.HasPrefix(msg, "[RESOLVED]") || !strings.Contains(msg, "12.50 (alerts above 30)") {
+		t.Errorf("unexpected message %q", msg)
+	}
+}
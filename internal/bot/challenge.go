@@ -0,0 +1,131 @@
+package bot
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ChallengeTTL is how long a click page's proof of work stays valid.
+const ChallengeTTL = 10 * time.Minute
+
+// missingProofStrength scores a click page report without a proof of work.
+const missingProofStrength = 0.25
+
+var (
+	errChallengeForged   = errors.New("challenge not issued here")
+	errChallengeExpired  = errors.New("challenge expired")
+	errChallengeUnsolved = errors.New("challenge not solved")
+	errChallengeReused   = errors.New("challenge already used")
+)
+
+// Challenge is a proof of work for the click page: find a nonce such that
+// SHA-256(Seed + ":" + nonce) starts with Bits zero bits.
+type Challenge struct {
+	Seed string
+	Bits int
+}
+
+// Challenger issues and verifies click page proofs of work. Seeds are
+// signed rather than stored, and each is accepted once.
+type Challenger struct {
+	secret []byte
+	bits   int
+	rdb    *redis.Client
+}
+
+// NewChallenger returns a Challenger asking for bits zero bits, or nil
+// when bits is 0, which turns the proof of work off.
+func NewChallenger(secret string, bits int, rdb *redis.Client) *Challenger {
+	if bits <= 0 {
+		return nil
+	}
+	return &Challenger{secret: []byte(secret), bits: min(bits, 32), rdb: rdb}
+}
+
+// Issue returns a new challenge. It is nil-safe: a nil Challenger issues
+// the zero Challenge.
+func (c *Challenger) Issue(now time.Time) Challenge {
+	if c == nil {
+		return Challenge{}
+	}
+	var b [8]byte
+	rand.Read(b[:])
+	body := strconv.FormatInt(now.Unix(), 10) + "." + hex.EncodeToString(b[:])
+	return Challenge{Seed: body + "." + c.mac(body), Bits: c.bits}
+}
+
+func (c *Challenger) mac(body string) string {
+	m := hmac.New(sha256.New, c.secret)
+	fmt.Fprintf(m, "challenge|%d|%s", c.bits, body)
+	return hex.EncodeToString(m.Sum(nil)[:16])
+}
+
+// Verify checks that nonce solves seed and claims seed, so the same
+// solution cannot be replayed for another click.
+func (c *Challenger) Verify(ctx context.Context, seed, nonce string, now time.Time) error {
+	i := strings.LastIndexByte(seed, '.')
+	if i < 0 || !hmac.Equal([]byte(seed[i+1:]), []byte(c.mac(seed[:i]))) {
+		return errChallengeForged
+	}
+	ts, _, _ := strings.Cut(seed, ".")
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if issued := time.Unix(unix, 0); err != nil || now.Sub(issued) > ChallengeTTL || issued.After(now.Add(time.Minute)) {
+		return errChallengeExpired
+	}
+	if leadingZeroBits(sha256.Sum256([]byte(seed+":"+nonce))) < c.bits {
+		return errChallengeUnsolved
+	}
+	// Fail open when Redis is unavailable
+	ok, err := c.rdb.SetNX(ctx, "bot:challenge:"+seed[i+1:], 1, ChallengeTTL).Result()
+	if err == nil && !ok {
+		return errChallengeReused
+	}
+	return nil
+}
+
+func leadingZeroBits(sum [sha256.Size]byte) int {
+	n := 0
+	for _, b := range sum {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
+
+// proofOfWorkDetector flags click page reports without a valid, unused
+// solution to the page's challenge. A wrong, expired or reused solution is
+// certain; a missing one is weak, since slow devices give up before
+// solving and a cached page may predate the challenge.
+type proofOfWorkDetector struct {
+	challenges *Challenger
+}
+
+func (proofOfWorkDetector) Name() string { return "proof_of_work" }
+
+func (d proofOfWorkDetector) Detect(ctx context.Context, r *Request) (float64, string) {
+	if r.Env == nil {
+		return 0, ""
+	}
+	pow := envMap(envMap(r.Env, ChecksKey), "pow")
+	seed := envString(pow, "seed")
+	if seed == "" {
+		return missingProofStrength, "no proof of work"
+	}
+	if err := d.challenges.Verify(ctx, seed, envString(pow, "nonce"), time.Now()); err != nil {
+		return 1, "proof of work: " + err.Error()
+	}
+	return 0, ""
+}
@@ -0,0 +1,159 @@
+package bot
+
+import (
+	"context"
+	"crypto/sha256"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tracking/analysis/internal/config"
+	"github.com/tracking/analysis/internal/models"
+)
+
+func solve(c Challenge) string {
+	for n := 0; ; n++ {
+		nonce := strconv.Itoa(n)
+		if leadingZeroBits(sha256.Sum256([]byte(c.Seed+":"+nonce))) >= c.Bits {
+			return nonce
+		}
+	}
+}
+
+func TestChallenger(t *testing.T) {
+	_, rdb := setupRedis(t)
+	ctx := context.Background()
+	c := NewChallenger("secret", 8, rdb)
+	now := time.Now()
+
+	ch := c.Issue(now)
+	if ch.Bits != 8 || strings.Count(ch.Seed, ".") != 2 {
+		t.Fatalf("challenge = %+v", ch)
+	}
+	nonce := solve(ch)
+	if err := c.Verify(ctx, ch.Seed, nonce, now); err != nil {
+		t.Fatalf("Verify = %v", err)
+	}
+	if err := c.Verify(ctx, ch.Seed, nonce, now); err != errChallengeReused {
+		t.Errorf("replay = %v", err)
+	}
+
+	ch = c.Issue(now)
+	nonce = solve(ch)
+	other := NewChallenger("other secret", 8, rdb)
+	tests := []struct {
+		name string
+		err  error
+		fn   func() error
+	}{
+		{"forged", errChallengeForged, func() error { return other.Verify(ctx, ch.Seed, nonce, now) }},
+		{"tampered", errChallengeForged, func() error { return c.Verify(ctx, "1"+ch.Seed, nonce, now) }},
+		{"expired", errChallengeExpired, func() error { return c.Verify(ctx, ch.Seed, nonce, now.Add(ChallengeTTL+time.Second)) }},
+		{"unsolved", errChallengeUnsolved, func() error { return c.Verify(ctx, ch.Seed, nonce+"x", now) }},
+	}
+	for _, tt := range tests {
+		if err := tt.fn(); err != tt.err {
+			t.Errorf("%s: Verify = %v, want %v", tt.name, err, tt.err)
+		}
+	}
+
+	if NewChallenger("secret", 0, rdb) != nil {
+		t.Error("0 bits should turn the challenge off")
+	}
+	if ch := (*Challenger)(nil).Issue(now); ch.Seed != "" {
+		t.Errorf("nil Challenger issued %+v", ch)
+	}
+}
+
+func TestPipeline_ClickPageChecks(t *testing.T) {
+	_, rdb := setupRedis(t)
+	c := NewChallenger("secret", 4, rdb)
+	p, err := NewPipeline(&config.BotConfiguration{}, stubResolver{}, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	browser := func() *Request {
+		ch := c.Issue(time.Now())
+		return &Request{
+			UA: chromeUA, AcceptLang: "de-DE,de;q=0.9,en;q=0.8", SecFetch: "navigate", Country: "DE",
+			Env: models.JSONMap{
+				"language": "de-DE",
+				"timezone": "Europe/Berlin",
+				ChecksKey: map[string]any{
+					"webdriver": false,
+					"plugins":   float64(5),
+					"mimes":     float64(2),
+					"webgl":     "ANGLE (NVIDIA, NVIDIA GeForce RTX 3060 Direct3D11 vs_5_0 ps_5_0, D3D11)",
+					"pow":       map[string]any{"seed": ch.Seed, "nonce": solve(ch)},
+					"gestures":  map[string]any{"first_ms": nil, "moves": float64(0), "clicks": float64(0), "untrusted": float64(0)},
+				},
+			},
+		}
+	}
+	b := browser()
+	if v := p.Score(ctx, b); v.Score != 0 {
+		t.Fatalf("browser verdict = %+v", v)
+	}
+	if v := p.Score(ctx, b); v.Score != 30 || v.Signals[0].Reason != "proof of work: challenge already used" {
+		t.Errorf("replayed verdict = %+v", v)
+	}
+
+	headless := browser()
+	checks := headless.Env[ChecksKey].(map[string]any)
+	checks["webdriver"] = true
+	checks["plugins"], checks["mimes"] = float64(0), float64(0)
+	checks["webgl"] = "Google SwiftShader"
+	checks["gestures"] = map[string]any{"first_ms": float64(12), "clicks": float64(1), "untrusted": float64(0)}
+	delete(checks, "pow")
+	headless.AcceptLang = "de-DE,de;q=0.9"
+	headless.Env["language"] = "en-US"
+	headless.Env["timezone"] = "America/Chicago"
+	v := p.Score(ctx, headless)
+	got := map[string]int{}
+	for _, s := range v.Signals {
+		got[s.Name] = s.Points
+	}
+	want := map[string]int{
+		"webdriver": 60, "plugins": 10, "webgl": 40, "gestures": 10,
+		"proof_of_work": 8, "language_mismatch": 30, "timezone_mismatch": 20,
+	}
+	for name, points := range want {
+		if got[name] != points {
+			t.Errorf("%s = %d points, want %d (signals %+v)", name, got[name], points, v.Signals)
+		}
+	}
+	if v.Score != 100 {
+		t.Errorf("score = %d", v.Score)
+	}
+
+	// Requests without a click page report skip the page checks
+	if v := p.Score(ctx, &Request{UA: chromeUA, AcceptLang: "en", SecFetch: "cors", Country: "DE"}); v.Score != 0 {
+		t.Errorf("event verdict = %+v", v)
+	}
+}
+
+func TestEnvironmentDetectors(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		d    Detector
+		r    *Request
+		want float64
+	}{
+		{"plugins without mimes", pluginsDetector{}, &Request{Env: models.JSONMap{ChecksKey: map[string]any{"plugins": float64(3), "mimes": float64(0)}}}, 1},
+		{"mobile without plugins", pluginsDetector{}, &Request{UA: "Mozilla/5.0 (Linux; Android 14) Chrome/120.0 Mobile Safari/537.36", Env: models.JSONMap{ChecksKey: map[string]any{"plugins": float64(0), "mimes": float64(0)}}}, 0},
+		{"synthetic events", gesturesDetector{}, &Request{Env: models.JSONMap{ChecksKey: map[string]any{"gestures": map[string]any{"untrusted": float64(3)}}}}, 1},
+		{"touch tap", gesturesDetector{}, &Request{Env: models.JSONMap{ChecksKey: map[string]any{"gestures": map[string]any{"first_ms": float64(400), "clicks": float64(1), "touches": float64(1)}}}}, 0},
+		{"language region differs", languageDetector{}, &Request{AcceptLang: "en-GB,en;q=0.9", Env: models.JSONMap{"language": "en-US"}}, 0},
+		{"legacy zone name", timezoneDetector{}, &Request{Country: "IN", Env: models.JSONMap{"timezone": "Asia/Calcutta"}}, 0},
+		{"unknown zone", timezoneDetector{}, &Request{Country: "DE", Env: models.JSONMap{"timezone": "UTC"}}, 0},
+		{"no country", timezoneDetector{}, &Request{Env: models.JSONMap{"timezone": "Asia/Tokyo"}}, 0},
+	}
+	for _, tt := range tests {
+		if got, reason := tt.d.Detect(ctx, tt.r); got != tt.want {
+			t.Errorf("%s = %v (%s), want %v", tt.name, got, reason, tt.want)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	crawlerLookupTimeout = 2 * time.Second
+	crawlerCacheTTL      = 6 * time.Hour
+	crawlerErrorTTL      = 5 * time.Minute
+	crawlerCacheSize     = 10000
+	// crawlerMaxLookups bounds the verifications in flight; claims beyond
+	// it are retried on a later request.
+	crawlerMaxLookups = 16
+)
+
+// Resolver does the reverse and forward DNS lookups that verify crawlers.
+// *net.Resolver implements it.
+type Resolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// crawler is a search engine crawler that can be verified by DNS: its
+// address reverse-resolves into one of domains, and that host resolves
+// back to the address.
+type crawler struct {
+	name    string
+	tokens  []string // lower-case user agent substrings
+	domains []string
+}
+
+var crawlers = []crawler{
+	{"googlebot", []string{"googlebot", "adsbot-google", "mediapartners-google", "google-inspectiontool", "storebot-google", "googleother"}, []string{"googlebot.com", "google.com", "googleusercontent.com"}},
+	{"bingbot", []string{"bingbot", "adidxbot", "bingpreview"}, []string{"search.msn.com"}},
+	{"applebot", []string{"applebot"}, []string{"applebot.apple.com"}},
+	{"yandexbot", []string{"yandex"}, []string{"yandex.ru", "yandex.net", "yandex.com"}},
+	{"baiduspider", []string{"baiduspider"}, []string{"baidu.com", "baidu.jp"}},
+}
+
+type crawlerCheck struct {
+	host    string // verified host, empty when verification failed
+	err     error
+	expires time.Time
+}
+
+// crawlerDetector fires for user agents claiming to be a known crawler.
+// Verified crawlers are certain bots; unverified claims are impersonation.
+// Verification runs in the background, so scoring never waits on DNS; a
+// claim counts as unverified until its lookup completes.
+type crawlerDetector struct {
+	resolver Resolver
+
+	mu      sync.Mutex
+	cache   map[string]crawlerCheck // by crawler name and IP
+	pending map[string]bool
+	lookups sync.WaitGroup
+}
+
+func newCrawlerDetector(resolver Resolver) *crawlerDetector {
+	return &crawlerDetector{resolver: resolver, cache: make(map[string]crawlerCheck), pending: make(map[string]bool)}
+}
+
+func (d *crawlerDetector) Name() string { return "crawler" }
+
+func (d *crawlerDetector) Detect(_ context.Context, r *Request) (float64, string) {
+	c := claimedCrawler(r.UA)
+	if c == nil {
+		return 0, ""
+	}
+	check, ok := d.check(c, r.IP)
+	switch {
+	case !ok:
+		return 1, fmt.Sprintf("claims %s, verification pending", c.name)
+	case check.host != "":
+		return 1, fmt.Sprintf("verified %s (%s)", c.name, check.host)
+	case check.err != nil:
+		return 1, fmt.Sprintf("claims %s, lookup failed: %v", c.name, check.err)
+	}
+	return 1, fmt.Sprintf("claims %s, but %s is not in %s", c.name, r.IP, strings.Join(c.domains, ", "))
+}
+
+// verifiedName returns the name of the crawler r verifiably comes from.
+func (d *crawlerDetector) verifiedName(_ context.Context, r *Request) string {
+	c := claimedCrawler(r.UA)
+	if c == nil {
+		return ""
+	}
+	if check, ok := d.check(c, r.IP); !ok || check.host == "" {
+		return ""
+	}
+	return c.name
+}
+
+func claimedCrawler(ua string) *crawler {
+	ua = strings.ToLower(ua)
+	for i := range crawlers {
+		for _, token := range crawlers[i].tokens {
+			if strings.Contains(ua, token) {
+				return &crawlers[i]
+			}
+		}
+	}
+	return nil
+}
+
+// check returns the cached verification of ip for c. On a miss it starts
+// a lookup, unless one is running or crawlerMaxLookups are, and reports
+// false.
+func (d *crawlerDetector) check(c *crawler, ip string) (crawlerCheck, bool) {
+	key := c.name + "|" + ip
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if cached, ok := d.cache[key]; ok && time.Now().Before(cached.expires) {
+		return cached, true
+	}
+	if d.pending[key] || len(d.pending) >= crawlerMaxLookups {
+		return crawlerCheck{}, false
+	}
+	d.pending[key] = true
+	d.lookups.Add(1)
+	go d.lookup(key, c, ip)
+	return crawlerCheck{}, false
+}
+
+func (d *crawlerDetector) lookup(key string, c *crawler, ip string) {
+	defer d.lookups.Done()
+	ctx, cancel := context.WithTimeout(context.Background(), crawlerLookupTimeout)
+	defer cancel()
+	host, err := d.verify(ctx, c, ip)
+	now := time.Now()
+	check := crawlerCheck{host: host, err: err, expires: now.Add(crawlerCacheTTL)}
+	if err != nil {
+		check.expires = now.Add(crawlerErrorTTL)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.pending, key)
+	if len(d.cache) >= crawlerCacheSize {
+		clear(d.cache)
+	}
+	d.cache[key] = check
+}
+
+// verify does the reverse lookup, then confirms the forward lookup of a
+// matching host returns ip. It returns "" without error when ip does not
+// belong to the crawler.
+func (d *crawlerDetector) verify(ctx context.Context, c *crawler, ip string) (string, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "", nil
+	}
+	addr = addr.Unmap()
+	names, err := d.resolver.LookupAddr(ctx, addr.String())
+	if err != nil && len(names) == 0 {
+		if isNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	for _, name := range names {
+		host := strings.ToLower(strings.TrimSuffix(name, "."))
+		if !inDomains(host, c.domains) {
+			continue
+		}
+		addrs, err := d.resolver.LookupHost(ctx, host)
+		if err != nil && !isNotFound(err) {
+			return "", err
+		}
+		for _, a := range addrs {
+			if fwd, err := netip.ParseAddr(a); err == nil && fwd.Unmap() == addr {
+				return host, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+func inDomains(host string, domains []string) bool {
+	for _, domain := range domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// isNotFound reports whether err is a DNS "no such host" answer rather
+// than a failed lookup.
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
@@ -0,0 +1,129 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+// stubResolver answers from fixed maps; unknown names are NXDOMAIN.
+type stubResolver struct {
+	ptr   map[string][]string
+	hosts map[string][]string
+	err   error
+	calls *int
+}
+
+func (r stubResolver) LookupAddr(_ context.Context, addr string) ([]string, error) {
+	if r.calls != nil {
+		*r.calls++
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if names, ok := r.ptr[addr]; ok {
+		return names, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+}
+
+func (r stubResolver) LookupHost(_ context.Context, host string) ([]string, error) {
+	if addrs, ok := r.hosts[host]; ok {
+		return addrs, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+const googlebotUA = "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"
+
+func TestCrawlerDetector(t *testing.T) {
+	calls := 0
+	res := stubResolver{
+		ptr: map[string][]string{
+			"66.249.66.1": {"crawl-66-249-66-1.googlebot.com."},
+			"203.0.113.5": {"crawl-fake.googlebot.com.evil.test."},
+			"203.0.113.6": {"crawl-66-249-66-1.googlebot.com."}, // PTR the attacker controls
+		},
+		hosts: map[string][]string{"crawl-66-249-66-1.googlebot.com": {"66.249.66.1"}},
+		calls: &calls,
+	}
+	d := newCrawlerDetector(res)
+	ctx := context.Background()
+
+	tests := []struct {
+		name, ua, ip, reason string
+		strength             float64
+		verified             bool
+	}{
+		{"browser", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/120.0", "66.249.66.1", "", 0, false},
+		{"verified", googlebotUA, "66.249.66.1", "verified googlebot (crawl-66-249-66-1.googlebot.com)", 1, true},
+		{"no PTR", googlebotUA, "198.51.100.1", "claims googlebot, but 198.51.100.1 is not in", 1, false},
+		{"PTR outside domain", googlebotUA, "203.0.113.5", "claims googlebot", 1, false},
+		{"forward mismatch", googlebotUA, "203.0.113.6", "claims googlebot", 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Request{UA: tt.ua, IP: tt.ip}
+			d.Detect(ctx, r)
+			d.lookups.Wait()
+			strength, reason := d.Detect(ctx, r)
+			if strength != tt.strength || !strings.HasPrefix(reason, tt.reason) {
+				t.Errorf("Detect = %v, %q", strength, reason)
+			}
+			if got := d.verifiedName(ctx, r) != ""; got != tt.verified {
+				t.Errorf("verified = %v", got)
+			}
+		})
+	}
+
+	before := calls
+	d.Detect(ctx, &Request{UA: googlebotUA, IP: "66.249.66.1"})
+	if calls != before {
+		t.Error("verification not cached")
+	}
+}
+
+func TestCrawlerDetector_Pending(t *testing.T) {
+	release := make(chan struct{})
+	d := newCrawlerDetector(blockingResolver{release})
+	ctx := context.Background()
+	r := &Request{UA: googlebotUA, IP: "66.249.66.1"}
+	strength, reason := d.Detect(ctx, r)
+	if strength != 1 || reason != "claims googlebot, verification pending" || d.verifiedName(ctx, r) != "" {
+		t.Errorf("Detect = %v, %q", strength, reason)
+	}
+	for i := 2; i <= crawlerMaxLookups+5; i++ {
+		d.Detect(ctx, &Request{UA: googlebotUA, IP: fmt.Sprintf("198.51.100.%d", i)})
+	}
+	d.mu.Lock()
+	inFlight := len(d.pending)
+	d.mu.Unlock()
+	if inFlight != crawlerMaxLookups {
+		t.Errorf("%d lookups in flight, want %d", inFlight, crawlerMaxLookups)
+	}
+	close(release)
+	d.lookups.Wait()
+}
+
+// blockingResolver answers nothing until release is closed.
+type blockingResolver struct{ release chan struct{} }
+
+func (r blockingResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	<-r.release
+	return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+}
+
+func (r blockingResolver) LookupHost(context.Context, string) ([]string, error) { return nil, nil }
+
+func TestCrawlerDetector_LookupError(t *testing.T) {
+	d := newCrawlerDetector(stubResolver{err: errors.New("timeout")})
+	d.Detect(context.Background(), &Request{UA: googlebotUA, IP: "66.249.66.1"})
+	d.lookups.Wait()
+	strength, reason := d.Detect(context.Background(), &Request{UA: googlebotUA, IP: "66.249.66.1"})
+	if strength != 1 || !strings.Contains(reason, "lookup failed") {
+		t.Errorf("Detect = %v, %q", strength, reason)
+	}
+}
@@ -3,11 +3,15 @@ package bot
 import (
 	"context"
 	"fmt"
+	"math"
+	"net"
+	"net/http"
 	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 	"github.com/tracking/analysis/internal/config"
+	"github.com/tracking/analysis/internal/models"
 )
 
 var botPatterns = []string{
@@ -15,37 +19,248 @@ var botPatterns = []string{
 	"selenium", "puppeteer", "scrapy", "wget", "curl",
 }
 
-func Score(ua, acceptLang, secFetch, referer string, recentHits int) int {
-	score := 0
-	uaLower := strings.ToLower(ua)
+// DefaultWeights are the detector weights used when BotConfiguration
+// does not set them.
+var DefaultWeights = map[string]int{
+	"ua":                50,
+	"accept_language":   20,
+	"sec_fetch":         20,
+	"frequency":         30,
+	"datacenter":        30,
+	"tor":               40,
+	"crawler":           60,
+	"http_version":      20,
+	"header_order":      30,
+	"webdriver":         60,
+	"plugins":           20,
+	"webgl":             40,
+	"gestures":          20,
+	"proof_of_work":     30,
+	"language_mismatch": 30,
+	"timezone_mismatch": 20,
+}
+
+// PropsKey is the click and event prop holding a Verdict's breakdown.
+const PropsKey = "bot"
+
+// Request is what the detectors see of a click or event request.
+type Request struct {
+	IP          string
+	UA          string
+	AcceptLang  string
+	SecFetch    string
+	Referer     string
+	RecentHits  int            // requests from IP in the last 10 seconds
+	Proto       string         // e.g. "HTTP/1.1"
+	Header      http.Header    // all request headers
+	HeaderOrder []string       // lower-case header names as sent; nil when unknown
+	Country     string         // GeoIP country of IP
+	Env         models.JSONMap // the click page's environment report; nil for other requests
+}
+
+// FromHTTP describes req to the detectors; the caller sets IP and
+// RecentHits. A nil req gives an empty Request.
+func FromHTTP(req *http.Request) *Request {
+	if req == nil {
+		return &Request{Header: http.Header{}}
+	}
+	return &Request{
+		UA:          req.Header.Get("User-Agent"),
+		AcceptLang:  req.Header.Get("Accept-Language"),
+		SecFetch:    req.Header.Get("Sec-Fetch-Mode"),
+		Referer:     req.Header.Get("Referer"),
+		Proto:       req.Proto,
+		Header:      req.Header,
+		HeaderOrder: HeaderOrder(req.Context()),
+	}
+}
+
+// Detector is one bot signal. Detect returns how strongly r looks
+// automated, from 0 (not at all) to 1, and a reason when it is above 0.
+type Detector interface {
+	Name() string
+	Detect(ctx context.Context, r *Request) (strength float64, reason string)
+}
+
+// Signal is a detector that fired and the points it added.
+type Signal struct {
+	Name   string `json:"name"`
+	Points int    `json:"points"`
+	Reason string `json:"reason"`
+}
+
+// Verdict is a request's bot score, 0 to 100, and what made it up.
+type Verdict struct {
+	Score   int
+	Signals []Signal
+	// Crawler names a search engine crawler whose address was verified.
+	// Such requests are bots but are never rejected.
+	Crawler string
+}
 
-	// Check for bot-like user agents
+// Annotate sets the verdict's breakdown as props[PropsKey], dropping any
+// value the client sent under that key, and returns props.
+func (v Verdict) Annotate(props models.JSONMap) models.JSONMap {
+	if len(v.Signals) == 0 {
+		delete(props, PropsKey)
+		return props
+	}
+	if props == nil {
+		props = models.JSONMap{}
+	}
+	breakdown := map[string]any{"score": v.Score, "signals": v.Signals}
+	if v.Crawler != "" {
+		breakdown["crawler"] = v.Crawler
+	}
+	props[PropsKey] = breakdown
+	return props
+}
+
+type stage struct {
+	detector Detector
+	weight   int
+}
+
+// Pipeline scores requests as the weighted sum of its detectors.
+type Pipeline struct {
+	stages []stage
+	closer func() error
+}
+
+// NewPipeline builds the detectors cfg enables. The datacenter and Tor
+// detectors need their list files, and the protocol detectors need to see
+// the client's connection; resolver verifies crawlers and defaults to the
+// system resolver. The proof of work detector runs only with challenges.
+func NewPipeline(cfg *config.BotConfiguration, resolver Resolver, challenges *Challenger) (*Pipeline, error) {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	p := &Pipeline{}
+	add := func(d Detector) {
+		w, ok := cfg.Weights[d.Name()]
+		if !ok {
+			w = DefaultWeights[d.Name()]
+		}
+		if w > 0 {
+			p.stages = append(p.stages, stage{d, w})
+		}
+	}
+	add(uaDetector{})
+	add(acceptLanguageDetector{})
+	add(secFetchDetector{})
+	add(frequencyDetector{})
+	if cfg.DatacenterCIDRFile != "" || cfg.DatacenterASNFile != "" {
+		d, err := newDatacenterDetector(cfg.DatacenterCIDRFile, cfg.DatacenterASNFile, cfg.ASNDatabasePath)
+		if err != nil {
+			return nil, err
+		}
+		p.closer = d.close
+		add(d)
+	}
+	if cfg.TorExitListFile != "" {
+		d, err := newTorDetector(cfg.TorExitListFile)
+		if err != nil {
+			return nil, err
+		}
+		add(d)
+	}
+	add(newCrawlerDetector(resolver))
+	if cfg.ProtocolHeader != "" || cfg.DirectClients {
+		add(httpVersionDetector{header: cfg.ProtocolHeader})
+	}
+	if cfg.DirectClients {
+		add(headerOrderDetector{})
+	}
+	add(webdriverDetector{})
+	add(pluginsDetector{})
+	add(webglDetector{})
+	add(gesturesDetector{})
+	if challenges != nil {
+		add(proofOfWorkDetector{challenges})
+	}
+	add(languageDetector{})
+	add(timezoneDetector{})
+	return p, nil
+}
+
+// Score runs every detector over r.
+func (p *Pipeline) Score(ctx context.Context, r *Request) Verdict {
+	var v Verdict
+	total := 0
+	for _, s := range p.stages {
+		strength, reason := s.detector.Detect(ctx, r)
+		if strength <= 0 {
+			continue
+		}
+		points := int(math.Round(float64(s.weight) * math.Min(strength, 1)))
+		total += points
+		v.Signals = append(v.Signals, Signal{Name: s.detector.Name(), Points: points, Reason: reason})
+		if c, ok := s.detector.(*crawlerDetector); ok {
+			v.Crawler = c.verifiedName(ctx, r)
+		}
+	}
+	v.Score = min(total, 100)
+	return v
+}
+
+// Close releases the ASN database, if one is open.
+func (p *Pipeline) Close() error {
+	if p.closer == nil {
+		return nil
+	}
+	return p.closer()
+}
+
+// uaDetector matches bot-like user agents.
+type uaDetector struct{}
+
+func (uaDetector) Name() string { return "ua" }
+
+func (uaDetector) Detect(_ context.Context, r *Request) (float64, string) {
+	uaLower := strings.ToLower(r.UA)
 	for _, pattern := range botPatterns {
 		if strings.Contains(uaLower, pattern) {
-			score += 50
-			break
+			return 1, fmt.Sprintf("user agent contains %q", pattern)
 		}
 	}
+	return 0, ""
+}
 
-	// No Accept-Language header
-	if acceptLang == "" {
-		score += 20
-	}
+// acceptLanguageDetector flags requests without Accept-Language, which
+// every browser sends.
+type acceptLanguageDetector struct{}
+
+func (acceptLanguageDetector) Name() string { return "accept_language" }
 
-	// All Sec-Fetch-* headers missing
-	if secFetch == "" {
-		score += 20
+func (acceptLanguageDetector) Detect(_ context.Context, r *Request) (float64, string) {
+	if r.AcceptLang == "" {
+		return 1, "no Accept-Language"
 	}
+	return 0, ""
+}
+
+// secFetchDetector flags requests without Sec-Fetch-Mode.
+type secFetchDetector struct{}
+
+func (secFetchDetector) Name() string { return "sec_fetch" }
 
-	// Suspicious frequency with no referer
-	if referer == "" && recentHits > 10 {
-		score += 30
+func (secFetchDetector) Detect(_ context.Context, r *Request) (float64, string) {
+	if r.SecFetch == "" {
+		return 1, "no Sec-Fetch-Mode"
 	}
+	return 0, ""
+}
+
+// frequencyDetector flags bursts of requests without a referer.
+type frequencyDetector struct{}
+
+func (frequencyDetector) Name() string { return "frequency" }
 
-	if score > 100 {
-		score = 100
+func (frequencyDetector) Detect(_ context.Context, r *Request) (float64, string) {
+	if r.Referer == "" && r.RecentHits > 10 {
+		return 1, fmt.Sprintf("%d hits in 10s without referer", r.RecentHits)
 	}
-	return score
+	return 0, ""
 }
 
 func IsBot(score int, cfg *config.BotConfiguration) (blocked bool, suspected bool) {
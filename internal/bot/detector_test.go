@@ -1,13 +1,24 @@
 package bot
 
 import (
+	"context"
 	"testing"
 
 	"github.com/tracking/analysis/internal/config"
 )
 
+// defaultScore runs the default pipeline over the header heuristics.
+func defaultScore(ua, acceptLang, secFetch, referer string, recentHits int) int {
+	p, err := NewPipeline(&config.BotConfiguration{}, stubResolver{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	r := &Request{UA: ua, AcceptLang: acceptLang, SecFetch: secFetch, Referer: referer, RecentHits: recentHits}
+	return p.Score(context.Background(), r).Score
+}
+
 func TestScore_NormalBrowser(t *testing.T) {
-	score := Score(
+	score := defaultScore(
 		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36",
 		"en-US,en;q=0.9",
 		"navigate",
@@ -20,7 +31,7 @@ func TestScore_NormalBrowser(t *testing.T) {
 }
 
 func TestScore_BotUA(t *testing.T) {
-	score := Score(
+	score := defaultScore(
 		"Googlebot/2.1 (+http://www.google.com/bot.html)",
 		"en-US",
 		"navigate",
@@ -33,7 +44,7 @@ func TestScore_BotUA(t *testing.T) {
 }
 
 func TestScore_HeadlessUA(t *testing.T) {
-	score := Score(
+	score := defaultScore(
 		"Mozilla/5.0 HeadlessChrome/90.0",
 		"en-US",
 		"navigate",
@@ -46,7 +57,7 @@ func TestScore_HeadlessUA(t *testing.T) {
 }
 
 func TestScore_NoAcceptLanguage(t *testing.T) {
-	score := Score(
+	score := defaultScore(
 		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36",
 		"",        // no Accept-Language
 		"navigate",
@@ -59,7 +70,7 @@ func TestScore_NoAcceptLanguage(t *testing.T) {
 }
 
 func TestScore_NoSecFetch(t *testing.T) {
-	score := Score(
+	score := defaultScore(
 		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36",
 		"en-US",
 		"", // no Sec-Fetch
@@ -72,7 +83,7 @@ func TestScore_NoSecFetch(t *testing.T) {
 }
 
 func TestScore_SuspiciousFrequency(t *testing.T) {
-	score := Score(
+	score := defaultScore(
 		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36",
 		"en-US",
 		"navigate",
@@ -86,7 +97,7 @@ func TestScore_SuspiciousFrequency(t *testing.T) {
 
 func TestScore_MaxCap(t *testing.T) {
 	// Bot UA (+50) + no Accept-Language (+20) + no Sec-Fetch (+20) + suspicious freq (+30) = 120 → capped at 100
-	score := Score(
+	score := defaultScore(
 		"Googlebot/2.1",
 		"",
 		"",
@@ -0,0 +1,181 @@
+package bot
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/tracking/analysis/internal/models"
+)
+
+// The detectors in this file read the environment the JS click page
+// reports in Request.Env. They do nothing for requests without one.
+
+// ChecksKey is the click page env entry holding the automation checks.
+// CollectClick drops it once scored, so it is never stored.
+const ChecksKey = "checks"
+
+func envMap(m models.JSONMap, key string) models.JSONMap {
+	switch v := m[key].(type) {
+	case map[string]any:
+		return v
+	case models.JSONMap:
+		return v
+	}
+	return nil
+}
+
+func envString(m models.JSONMap, key string) string {
+	s, _ := m[key].(string)
+	return s
+}
+
+func envNumber(m models.JSONMap, key string) (float64, bool) {
+	n, ok := m[key].(float64)
+	return n, ok
+}
+
+// webdriverDetector flags browsers driven by WebDriver, which sets
+// navigator.webdriver.
+type webdriverDetector struct{}
+
+func (webdriverDetector) Name() string { return "webdriver" }
+
+func (webdriverDetector) Detect(_ context.Context, r *Request) (float64, string) {
+	if webdriver, _ := envMap(r.Env, ChecksKey)["webdriver"].(bool); webdriver {
+		return 1, "navigator.webdriver is set"
+	}
+	return 0, ""
+}
+
+// pluginsDetector compares navigator.plugins with navigator.mimeTypes.
+// Desktop browsers list their PDF viewer in both; headless builds and
+// patched automation often list neither, or only one.
+type pluginsDetector struct{}
+
+func (pluginsDetector) Name() string { return "plugins" }
+
+func (pluginsDetector) Detect(_ context.Context, r *Request) (float64, string) {
+	checks := envMap(r.Env, ChecksKey)
+	plugins, ok1 := envNumber(checks, "plugins")
+	mimes, ok2 := envNumber(checks, "mimes")
+	if !ok1 || !ok2 {
+		return 0, ""
+	}
+	if (plugins == 0) != (mimes == 0) {
+		return 1, fmt.Sprintf("%d plugins but %d mime types", int(plugins), int(mimes))
+	}
+	family := browserFamily(r.UA)
+	mobile := strings.Contains(r.UA, "Mobile") || strings.Contains(r.UA, "Android")
+	if plugins == 0 && (family == "chrome" || family == "firefox") && !mobile {
+		return 0.5, "no plugins in desktop " + family
+	}
+	return 0, ""
+}
+
+// softwareRenderers are WebGL renderers of CPU rasterisers, which
+// headless browsers fall back to on servers without a GPU.
+var softwareRenderers = []string{"swiftshader", "llvmpipe", "softpipe", "mesa offscreen", "software rasterizer"}
+
+// webglDetector flags software WebGL renderers.
+type webglDetector struct{}
+
+func (webglDetector) Name() string { return "webgl" }
+
+func (webglDetector) Detect(_ context.Context, r *Request) (float64, string) {
+	renderer := envString(envMap(r.Env, ChecksKey), "webgl")
+	lower := strings.ToLower(renderer)
+	for _, s := range softwareRenderers {
+		if strings.Contains(lower, s) {
+			return 1, "software WebGL renderer " + renderer
+		}
+	}
+	return 0, ""
+}
+
+// gesturesDetector looks at the input events the click page saw before
+// redirecting. Most visitors make none in that time; scripts dispatch
+// synthetic ones, or act faster than a person can.
+type gesturesDetector struct{}
+
+func (gesturesDetector) Name() string { return "gestures" }
+
+func (gesturesDetector) Detect(_ context.Context, r *Request) (float64, string) {
+	g := envMap(envMap(r.Env, ChecksKey), "gestures")
+	if untrusted, _ := envNumber(g, "untrusted"); untrusted > 0 {
+		return 1, fmt.Sprintf("%d synthetic input events", int(untrusted))
+	}
+	if first, ok := envNumber(g, "first_ms"); ok && first < 50 {
+		return 0.5, fmt.Sprintf("input %dms after page load", int(first))
+	}
+	clicks, _ := envNumber(g, "clicks")
+	moves, _ := envNumber(g, "moves")
+	touches, _ := envNumber(g, "touches")
+	if clicks > 0 && moves == 0 && touches == 0 {
+		return 0.5, "click without pointer movement"
+	}
+	return 0, ""
+}
+
+// languageDetector flags a navigator.language missing from the
+// Accept-Language header. Browsers derive both from the same setting;
+// automation often overrides only the header.
+type languageDetector struct{}
+
+func (languageDetector) Name() string { return "language_mismatch" }
+
+func (languageDetector) Detect(_ context.Context, r *Request) (float64, string) {
+	lang := envString(r.Env, "language")
+	if lang == "" || r.AcceptLang == "" {
+		return 0, ""
+	}
+	want := primaryLanguage(lang)
+	for _, part := range strings.Split(r.AcceptLang, ",") {
+		tag, _, _ := strings.Cut(part, ";")
+		if primaryLanguage(tag) == want {
+			return 0, ""
+		}
+	}
+	return 1, fmt.Sprintf("navigator.language %s not in Accept-Language %q", lang, r.AcceptLang)
+}
+
+func primaryLanguage(tag string) string {
+	tag, _, _ = strings.Cut(strings.TrimSpace(tag), "-")
+	tag, _, _ = strings.Cut(tag, "_")
+	return strings.ToLower(tag)
+}
+
+// timezoneDetector flags a browser time zone in another country than the
+// GeoIP country of the address. Travellers and VPN users trip it too, so
+// its default weight is low.
+type timezoneDetector struct{}
+
+func (timezoneDetector) Name() string { return "timezone_mismatch" }
+
+func (timezoneDetector) Detect(_ context.Context, r *Request) (float64, string) {
+	tz := envString(r.Env, "timezone")
+	if tz == "" || r.Country == "" {
+		return 0, ""
+	}
+	country, ok := zoneCountries()[tz]
+	if !ok || country == r.Country {
+		return 0, ""
+	}
+	return 1, fmt.Sprintf("time zone %s is in %s, address in %s", tz, country, r.Country)
+}
+
+//go:embed zones.tab
+var zonesTab string
+
+// zoneCountries maps IANA time zone names to ISO 3166 country codes.
+var zoneCountries = sync.OnceValue(func() map[string]string {
+	m := make(map[string]string)
+	for _, line := range strings.Split(zonesTab, "\n") {
+		if country, zone, ok := strings.Cut(line, "\t"); ok && !strings.HasPrefix(line, "#") {
+			m[zone] = country
+		}
+	}
+	return m
+})
@@ -0,0 +1,103 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// browserFamily names the engine family a user agent claims, or "" for
+// non-browser agents. Every iOS browser is WebKit and behaves like Safari.
+func browserFamily(ua string) string {
+	switch {
+	case strings.Contains(ua, "iPhone") || strings.Contains(ua, "iPad"):
+		if strings.Contains(ua, "AppleWebKit/") {
+			return "safari"
+		}
+	case strings.Contains(ua, "Firefox/"):
+		return "firefox"
+	case strings.Contains(ua, "Chrome/") || strings.Contains(ua, "Chromium/"):
+		return "chrome"
+	case strings.Contains(ua, "Safari/") && strings.Contains(ua, "Version/"):
+		return "safari"
+	}
+	return ""
+}
+
+// httpVersionDetector flags browser user agents on old HTTP versions.
+// Browsers have not sent HTTP/1.0 in decades; scripts still do. When the
+// edge reports the client's protocol in header, only that is used, and
+// HTTP/1.1 is suspicious too, since browsers speak HTTP/2 or later to any
+// TLS edge that offers it. Without header the request's own protocol is
+// the client's, which holds only when clients connect directly.
+type httpVersionDetector struct {
+	header string
+}
+
+func (httpVersionDetector) Name() string { return "http_version" }
+
+func (d httpVersionDetector) Detect(_ context.Context, r *Request) (float64, string) {
+	family := browserFamily(r.UA)
+	if family == "" {
+		return 0, ""
+	}
+	proto, edge := r.Proto, d.header != ""
+	if edge {
+		// The request's own protocol is the proxy's; without the header
+		// the client's is unknown.
+		proto = strings.ToUpper(r.Header.Get(d.header))
+	}
+	switch {
+	case proto == "HTTP/1.0":
+		return 1, family + " user agent over HTTP/1.0"
+	case edge && strings.HasPrefix(proto, "HTTP/1"):
+		return 0.5, family + " user agent over " + proto
+	}
+	return 0, ""
+}
+
+// headerOrders lists, per browser family, headers every request of that
+// browser carries in this relative order. HTTP libraries order them
+// differently, so a browser user agent sending them out of order is
+// likely a script with a copied user agent.
+var headerOrders = map[string][]string{
+	"chrome":  {"user-agent", "accept", "accept-encoding", "accept-language"},
+	"firefox": {"user-agent", "accept", "accept-language", "accept-encoding"},
+	"safari":  {"accept-language", "user-agent", "accept-encoding"},
+}
+
+// headerOrderDetector compares the order headers arrived in with the
+// claimed browser's. It only sees HTTP/1.x requests, through
+// HeaderOrderListener.
+type headerOrderDetector struct{}
+
+func (headerOrderDetector) Name() string { return "header_order" }
+
+func (headerOrderDetector) Detect(_ context.Context, r *Request) (float64, string) {
+	if len(r.HeaderOrder) == 0 {
+		return 0, ""
+	}
+	family := browserFamily(r.UA)
+	anchors := headerOrders[family]
+	if anchors == nil {
+		return 0, ""
+	}
+	pos := make(map[string]int, len(r.HeaderOrder))
+	for i, name := range r.HeaderOrder {
+		if _, seen := pos[name]; !seen {
+			pos[name] = i
+		}
+	}
+	prev := ""
+	for _, name := range anchors {
+		i, ok := pos[name]
+		if !ok {
+			continue
+		}
+		if prev != "" && i < pos[prev] {
+			return 1, fmt.Sprintf("%s before %s, unlike %s", name, prev, family)
+		}
+		prev = name
+	}
+	return 0, ""
+}
@@ -0,0 +1,233 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// net/http keeps request headers in a map, losing the order the client
+// sent them in. HeaderOrderListener recovers it by watching the bytes of
+// each HTTP/1.x connection; ConnContext and HeaderOrderHandler hand the
+// order of each request to its handlers:
+//
+//	srv := &http.Server{Handler: bot.HeaderOrderHandler(h), ConnContext: bot.ConnContext}
+//	srv.Serve(bot.HeaderOrderListener(ln))
+//
+// TLS and HTTP/2 connections are passed through without an order.
+
+const (
+	maxHeadLine    = 16 << 10
+	maxHeadHeaders = 200
+	maxQueuedHeads = 16
+)
+
+type connKey struct{}
+type orderKey struct{}
+
+// HeaderOrderListener wraps l to record request header order.
+func HeaderOrderListener(l net.Listener) net.Listener {
+	return orderListener{l}
+}
+
+type orderListener struct {
+	net.Listener
+}
+
+func (l orderListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &orderConn{Conn: c}, nil
+}
+
+// ConnContext is an http.Server ConnContext hook that makes a connection
+// from HeaderOrderListener available to HeaderOrderHandler.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	if oc, ok := c.(*orderConn); ok {
+		return context.WithValue(ctx, connKey{}, oc)
+	}
+	return ctx
+}
+
+// HeaderOrderHandler attaches each request's header order to its context,
+// where HeaderOrder finds it.
+func HeaderOrderHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if oc, ok := r.Context().Value(connKey{}).(*orderConn); ok && r.ProtoMajor == 1 {
+			if names := oc.next(); names != nil {
+				r = r.WithContext(context.WithValue(r.Context(), orderKey{}, names))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// HeaderOrder returns the lower-case header names of the request ctx
+// belongs to, in the order they were sent, or nil when unknown.
+func HeaderOrder(ctx context.Context) []string {
+	names, _ := ctx.Value(orderKey{}).([]string)
+	return names
+}
+
+// orderConn parses request heads out of the bytes read from the client.
+// The server reads and handles one request at a time per connection, so
+// the oldest unclaimed head belongs to the request being handled.
+type orderConn struct {
+	net.Conn
+
+	mu    sync.Mutex
+	p     headParser
+	heads [][]string
+}
+
+func (c *orderConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.mu.Lock()
+		for _, head := range c.p.feed(b[:n]) {
+			if len(c.heads) < maxQueuedHeads {
+				c.heads = append(c.heads, head)
+			}
+		}
+		c.mu.Unlock()
+	}
+	return n, err
+}
+
+func (c *orderConn) next() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.heads) == 0 {
+		return nil
+	}
+	head := c.heads[0]
+	c.heads = c.heads[1:]
+	return head
+}
+
+type parseState int
+
+const (
+	stateRequestLine parseState = iota
+	stateHeaders
+	stateBody
+	stateChunkSize
+	stateChunkData
+	stateTrailer
+	stateDone // not HTTP/1.x, or malformed; stop watching
+)
+
+// headParser follows an HTTP/1.x request stream far enough to find where
+// each request's head starts: it reads heads line by line and skips
+// bodies by Content-Length or chunked framing.
+type headParser struct {
+	state   parseState
+	line    []byte
+	names   []string
+	length  int64 // body or chunk bytes left to skip
+	chunked bool
+}
+
+// feed consumes b and returns the header names of every head it completes.
+func (p *headParser) feed(b []byte) [][]string {
+	var heads [][]string
+	for len(b) > 0 && p.state != stateDone {
+		if p.state == stateBody || p.state == stateChunkData {
+			n := min(int64(len(b)), p.length)
+			b, p.length = b[n:], p.length-n
+			if p.length == 0 {
+				if p.state == stateBody {
+					p.state = stateRequestLine
+				} else {
+					p.state = stateChunkSize
+				}
+			}
+			continue
+		}
+
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			p.line = append(p.line, b...)
+			if len(p.line) > maxHeadLine {
+				p.state = stateDone
+			}
+			return heads
+		}
+		p.line = append(p.line, b[:i]...)
+		b = b[i+1:]
+		line := strings.TrimSuffix(string(p.line), "\r")
+		p.line = p.line[:0]
+		if head := p.handleLine(line); head != nil {
+			heads = append(heads, head)
+		}
+	}
+	return heads
+}
+
+func (p *headParser) handleLine(line string) []string {
+	switch p.state {
+	case stateRequestLine:
+		if line == "" {
+			return nil // stray CRLF between requests
+		}
+		_, proto, ok := strings.Cut(line[strings.IndexByte(line, ' ')+1:], " ")
+		if !ok || !strings.HasPrefix(proto, "HTTP/1.") {
+			p.state = stateDone // HTTP/2 preface, TLS or garbage
+			return nil
+		}
+		p.names, p.length, p.chunked = nil, 0, false
+		p.state = stateHeaders
+
+	case stateHeaders:
+		if line != "" {
+			name, value, ok := strings.Cut(line, ":")
+			if !ok || len(p.names) == maxHeadHeaders {
+				p.state = stateDone
+				return nil
+			}
+			name = strings.ToLower(strings.TrimSpace(name))
+			p.names = append(p.names, name)
+			switch name {
+			case "content-length":
+				p.length, _ = strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+			case "transfer-encoding":
+				p.chunked = strings.Contains(strings.ToLower(value), "chunked")
+			}
+			return nil
+		}
+		head := p.names
+		switch {
+		case p.chunked:
+			p.state = stateChunkSize
+		case p.length > 0:
+			p.state = stateBody
+		default:
+			p.state = stateRequestLine
+		}
+		return head
+
+	case stateChunkSize:
+		size, _, _ := strings.Cut(line, ";")
+		n, err := strconv.ParseInt(strings.TrimSpace(size), 16, 64)
+		switch {
+		case err != nil:
+			p.state = stateDone
+		case n == 0:
+			p.state = stateTrailer
+		default:
+			p.length, p.state = n+2, stateChunkData // data and its CRLF
+		}
+
+	case stateTrailer:
+		if line == "" {
+			p.state = stateRequestLine
+		}
+	}
+	return nil
+}
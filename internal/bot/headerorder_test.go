@@ -0,0 +1,95 @@
+package bot
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func mustAddr(s string) netip.Addr {
+	return netip.MustParseAddr(s)
+}
+
+func TestHeadParser(t *testing.T) {
+	stream := "GET /a HTTP/1.1\r\nHost: x\r\nUser-Agent: u\r\nAccept: */*\r\n\r\n" +
+		"\r\nPOST /rpc HTTP/1.1\r\nHost: x\r\nContent-Length: 27\r\nContent-Type: text/plain\r\n\r\n" +
+		"GET /fake HTTP/1.1\r\nX: y\r\n\r\n" + // body that looks like a request
+		"POST /c HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n4;ext=1\r\nab\r\n\r\n0\r\nTrailer: t\r\n\r\n" +
+		"GET /d HTTP/1.0\r\naccept-language: de\r\n\r\n"
+	want := [][]string{
+		{"host", "user-agent", "accept"},
+		{"host", "content-length", "content-type"},
+		{"host", "transfer-encoding"},
+		{"accept-language"},
+	}
+
+	// Any split of the stream into reads gives the same heads.
+	for _, size := range []int{1, 3, 7, len(stream)} {
+		var p headParser
+		var got [][]string
+		for b := []byte(stream); len(b) > 0; {
+			n := min(size, len(b))
+			got = append(got, p.feed(b[:n])...)
+			b = b[n:]
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("reads of %d: heads = %q", size, got)
+		}
+	}
+}
+
+func TestHeadParser_StopsOnHTTP2(t *testing.T) {
+	var p headParser
+	heads := p.feed([]byte("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\nGET / HTTP/1.1\r\nHost: x\r\n\r\n"))
+	if len(heads) != 0 || p.state != stateDone {
+		t.Errorf("heads = %q, state = %d", heads, p.state)
+	}
+}
+
+func TestHeaderOrderServer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	orders := make(chan []string, 2)
+	srv := &http.Server{
+		Handler: HeaderOrderHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.Copy(io.Discard, r.Body)
+			orders <- HeaderOrder(r.Context())
+		})),
+		ConnContext: ConnContext,
+	}
+	go srv.Serve(HeaderOrderListener(ln))
+	t.Cleanup(func() { srv.Close() })
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	requests := []string{
+		"POST / HTTP/1.1\r\nHost: x\r\nAccept-Encoding: gzip\r\nUser-Agent: u\r\nContent-Length: 5\r\n\r\nhello",
+		"GET / HTTP/1.1\r\nUser-Agent: u\r\nHost: x\r\nAccept: */*\r\n\r\n",
+	}
+	want := [][]string{
+		{"host", "accept-encoding", "user-agent", "content-length"},
+		{"user-agent", "host", "accept"},
+	}
+	for i, req := range requests {
+		fmt.Fprint(conn, req)
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got := <-orders; !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("request %d order = %q", i, got)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package bot
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// listCheckInterval is how often list files are checked for changes.
+const listCheckInterval = time.Minute
+
+// prefixSet matches addresses against a set of CIDR prefixes, one map
+// lookup per distinct prefix length.
+type prefixSet struct {
+	bits []int // distinct prefix lengths, longest first
+	set  map[netip.Prefix]bool
+}
+
+func (s *prefixSet) add(p netip.Prefix) {
+	if s.set == nil {
+		s.set = make(map[netip.Prefix]bool)
+	}
+	p = p.Masked()
+	if !s.set[p] {
+		s.set[p] = true
+		i := sort.Search(len(s.bits), func(i int) bool { return s.bits[i] <= p.Bits() })
+		if i == len(s.bits) || s.bits[i] != p.Bits() {
+			s.bits = append(s.bits, 0)
+			copy(s.bits[i+1:], s.bits[i:])
+			s.bits[i] = p.Bits()
+		}
+	}
+}
+
+func (s *prefixSet) match(addr netip.Addr) (netip.Prefix, bool) {
+	addr = addr.Unmap()
+	for _, b := range s.bits {
+		if b > addr.BitLen() {
+			continue
+		}
+		if p, err := addr.Prefix(b); err == nil && s.set[p] {
+			return p, true
+		}
+	}
+	return netip.Prefix{}, false
+}
+
+func (s *prefixSet) len() int { return len(s.set) }
+
+// listFile is a local list that is parsed on load and reloaded when its
+// modification time changes. A failed reload keeps the previous contents.
+type listFile[T any] struct {
+	path  string
+	parse func(*bufio.Scanner) (T, error)
+
+	mu      sync.Mutex
+	value   T
+	modTime time.Time
+	checked time.Time
+}
+
+func loadListFile[T any](path string, parse func(*bufio.Scanner) (T, error)) (*listFile[T], error) {
+	l := &listFile[T]{path: path, parse: parse}
+	if err := l.reload(time.Now()); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *listFile[T]) get() T {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now := time.Now(); now.Sub(l.checked) >= listCheckInterval {
+		if err := l.reload(now); err != nil {
+			slog.Warn("bot list reload failed", "path", l.path, "error", err)
+		}
+	}
+	return l.value
+}
+
+// reload rereads the file if it changed. Callers hold l.mu, except when
+// loading.
+func (l *listFile[T]) reload(now time.Time) error {
+	l.checked = now
+	info, err := os.Stat(l.path)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(l.modTime) {
+		return nil
+	}
+	f, err := os.Open(l.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	value, err := l.parse(bufio.NewScanner(f))
+	if err != nil {
+		return fmt.Errorf("%s: %w", l.path, err)
+	}
+	l.value, l.modTime = value, info.ModTime()
+	return nil
+}
+
+// listFields returns the first field of each line of s, skipping blank
+// lines and # comments.
+func listFields(s *bufio.Scanner, fn func(line int, field string) error) error {
+	for n := 1; s.Scan(); n++ {
+		line, _, _ := strings.Cut(s.Text(), "#")
+		fields := strings.FieldsFunc(line, func(r rune) bool { return r == ' ' || r == '\t' || r == ',' })
+		if len(fields) == 0 {
+			continue
+		}
+		if err := fn(n, fields[0]); err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+	}
+	return s.Err()
+}
+
+// parseCIDRList reads one CIDR prefix or address per line.
+func parseCIDRList(s *bufio.Scanner) (*prefixSet, error) {
+	set := &prefixSet{}
+	err := listFields(s, func(_ int, field string) error {
+		p, err := parsePrefix(field)
+		if err == nil {
+			set.add(p)
+		}
+		return err
+	})
+	return set, err
+}
+
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		return netip.ParsePrefix(s)
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// parseASNList reads one AS number per line, with or without "AS".
+func parseASNList(s *bufio.Scanner) (map[uint]bool, error) {
+	asns := make(map[uint]bool)
+	err := listFields(s, func(_ int, field string) error {
+		n, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(field), "AS"), 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid ASN %q", field)
+		}
+		asns[uint(n)] = true
+		return nil
+	})
+	return asns, err
+}
+
+// parseTorList reads the Tor Project's bulk exit list (one address per
+// line) or its exit-addresses format, taking the address of each
+// "ExitAddress" line. Lines without an address are skipped.
+func parseTorList(s *bufio.Scanner) (*prefixSet, error) {
+	set := &prefixSet{}
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		field := fields[0]
+		if field == "ExitAddress" && len(fields) > 1 {
+			field = fields[1]
+		}
+		if addr, err := netip.ParseAddr(field); err == nil {
+			addr = addr.Unmap()
+			set.add(netip.PrefixFrom(addr, addr.BitLen()))
+		}
+	}
+	return set, s.Err()
+}
+
+// datacenterDetector flags addresses in cloud and hosting networks, by
+// CIDR list or by the AS the address belongs to.
+type datacenterDetector struct {
+	cidrs  *listFile[*prefixSet]
+	asns   *listFile[map[uint]bool]
+	lookup func(net.IP) (uint, string) // address to AS number and organisation
+	close  func() error
+}
+
+func newDatacenterDetector(cidrFile, asnFile, asnDatabase string) (*datacenterDetector, error) {
+	d := &datacenterDetector{close: func() error { return nil }}
+	var err error
+	if cidrFile != "" {
+		if d.cidrs, err = loadListFile(cidrFile, parseCIDRList); err != nil {
+			return nil, fmt.Errorf("datacenter CIDR list: %w", err)
+		}
+		slog.Info("datacenter CIDR list loaded", "path", cidrFile, "prefixes", d.cidrs.get().len())
+	}
+	if asnFile != "" {
+		if asnDatabase == "" {
+			return nil, fmt.Errorf("DatacenterASNFile needs ASNDatabasePath")
+		}
+		if d.asns, err = loadListFile(asnFile, parseASNList); err != nil {
+			return nil, fmt.Errorf("datacenter ASN list: %w", err)
+		}
+		db, err := geoip2.Open(asnDatabase)
+		if err != nil {
+			return nil, fmt.Errorf("ASN database: %w", err)
+		}
+		d.close = db.Close
+		d.lookup = func(ip net.IP) (uint, string) {
+			rec, err := db.ASN(ip)
+			if err != nil {
+				return 0, ""
+			}
+			return rec.AutonomousSystemNumber, rec.AutonomousSystemOrganization
+		}
+		slog.Info("datacenter ASN list loaded", "path", asnFile, "asns", len(d.asns.get()))
+	}
+	return d, nil
+}
+
+func (d *datacenterDetector) Name() string { return "datacenter" }
+
+func (d *datacenterDetector) Detect(_ context.Context, r *Request) (float64, string) {
+	addr, err := netip.ParseAddr(r.IP)
+	if err != nil {
+		return 0, ""
+	}
+	if d.cidrs != nil {
+		if p, ok := d.cidrs.get().match(addr); ok {
+			return 1, "address in datacenter range " + p.String()
+		}
+	}
+	if d.asns != nil && d.lookup != nil {
+		if asn, org := d.lookup(net.IP(addr.Unmap().AsSlice())); asn != 0 && d.asns.get()[asn] {
+			return 1, fmt.Sprintf("address in datacenter AS%d %s", asn, org)
+		}
+	}
+	return 0, ""
+}
+
+// torDetector flags Tor exit relays.
+type torDetector struct {
+	exits *listFile[*prefixSet]
+}
+
+func newTorDetector(path string) (*torDetector, error) {
+	exits, err := loadListFile(path, parseTorList)
+	if err != nil {
+		return nil, fmt.Errorf("Tor exit list: %w", err)
+	}
+	slog.Info("Tor exit list loaded", "path", path, "exits", exits.get().len())
+	return &torDetector{exits: exits}, nil
+}
+
+func (d *torDetector) Name() string { return "tor" }
+
+func (d *torDetector) Detect(_ context.Context, r *Request) (float64, string) {
+	addr, err := netip.ParseAddr(r.IP)
+	if err != nil {
+		return 0, ""
+	}
+	if _, ok := d.exits.get().match(addr); ok {
+		return 1, "Tor exit relay"
+	}
+	return 0, ""
+}
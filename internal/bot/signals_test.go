@@ -0,0 +1,222 @@
+package bot
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tracking/analysis/internal/config"
+	"github.com/tracking/analysis/internal/models"
+)
+
+const chromeUA = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+
+func writeList(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "list.txt")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPipeline_WeightsAndBreakdown(t *testing.T) {
+	cfg := &config.BotConfiguration{
+		Weights:            map[string]int{"accept_language": 0, "datacenter": 50},
+		DatacenterCIDRFile: writeList(t, "# cloud\n203.0.113.0/24\n2001:db8::/32, example\n"),
+	}
+	p, err := NewPipeline(cfg, stubResolver{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Request{IP: "203.0.113.9", UA: chromeUA, SecFetch: "navigate"}
+	v := p.Score(context.Background(), r)
+	if v.Score != 50 || len(v.Signals) != 1 || v.Signals[0].Name != "datacenter" || v.Signals[0].Reason != "address in datacenter range 203.0.113.0/24" {
+		t.Fatalf("verdict = %+v", v)
+	}
+
+	props := v.Annotate(models.JSONMap{"screen": "1920x1080", PropsKey: "spoofed"})
+	breakdown, _ := props[PropsKey].(map[string]any)
+	if props["screen"] != "1920x1080" || breakdown["score"] != 50 || !reflect.DeepEqual(breakdown["signals"], v.Signals) {
+		t.Errorf("props = %v", props)
+	}
+	clean := Verdict{}.Annotate(models.JSONMap{PropsKey: "spoofed"})
+	if _, ok := clean[PropsKey]; ok {
+		t.Errorf("client value kept: %v", clean)
+	}
+
+	r.IP = "2001:db8:1::1"
+	if v := p.Score(context.Background(), r); v.Score != 50 {
+		t.Errorf("IPv6 verdict = %+v", v)
+	}
+}
+
+func TestPipeline_VerifiedCrawler(t *testing.T) {
+	res := stubResolver{
+		ptr:   map[string][]string{"66.249.66.1": {"crawl-66-249-66-1.googlebot.com."}},
+		hosts: map[string][]string{"crawl-66-249-66-1.googlebot.com": {"66.249.66.1"}},
+	}
+	p, _ := NewPipeline(&config.BotConfiguration{}, res, nil)
+	r := &Request{IP: "66.249.66.1", UA: googlebotUA, AcceptLang: "en", SecFetch: "navigate"}
+	if v := p.Score(context.Background(), r); v.Crawler != "" {
+		t.Errorf("verified before the lookup finished: %+v", v)
+	}
+	for _, s := range p.stages {
+		if c, ok := s.detector.(*crawlerDetector); ok {
+			c.lookups.Wait()
+		}
+	}
+	v := p.Score(context.Background(), r)
+	if v.Crawler != "googlebot" || v.Score != 100 {
+		t.Errorf("verdict = %+v", v)
+	}
+}
+
+func TestDatacenterDetector_ASN(t *testing.T) {
+	d := &datacenterDetector{asns: &listFile[map[uint]bool]{value: map[uint]bool{16509: true}, checked: time.Now()}}
+	d.lookup = func(ip net.IP) (uint, string) {
+		if ip.String() == "198.51.100.7" {
+			return 16509, "AMAZON-02"
+		}
+		return 3320, "DTAG"
+	}
+	if s, reason := d.Detect(context.Background(), &Request{IP: "198.51.100.7"}); s != 1 || reason != "address in datacenter AS16509 AMAZON-02" {
+		t.Errorf("datacenter = %v, %q", s, reason)
+	}
+	if s, _ := d.Detect(context.Background(), &Request{IP: "192.0.2.1"}); s != 0 {
+		t.Error("residential address flagged")
+	}
+}
+
+func TestParseASNList(t *testing.T) {
+	asns, err := parseASNList(bufio.NewScanner(strings.NewReader("AS16509 Amazon\n15169 # Google\n\nas8075\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(asns, map[uint]bool{16509: true, 15169: true, 8075: true}) {
+		t.Errorf("asns = %v", asns)
+	}
+	if _, err := parseASNList(bufio.NewScanner(strings.NewReader("amazon\n"))); err == nil {
+		t.Error("invalid ASN accepted")
+	}
+}
+
+func TestTorDetector(t *testing.T) {
+	exitAddresses := "ExitNode 0011BD2485AD45D984EC4159C88FC066E5E3300E\nPublished 2024-03-04 01:02:03\n" +
+		"LastStatus 2024-03-04 02:00:00\nExitAddress 192.0.2.44 2024-03-04 02:03:04\n"
+	for name, content := range map[string]string{"bulk": "192.0.2.44\n198.51.100.2\n", "exit-addresses": exitAddresses} {
+		d, err := newTorDetector(writeList(t, content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s, _ := d.Detect(context.Background(), &Request{IP: "192.0.2.44"}); s != 1 {
+			t.Errorf("%s: exit not flagged", name)
+		}
+		if s, _ := d.Detect(context.Background(), &Request{IP: "192.0.2.45"}); s != 0 {
+			t.Errorf("%s: non-exit flagged", name)
+		}
+	}
+}
+
+func TestListFile_Reload(t *testing.T) {
+	path := writeList(t, "192.0.2.1\n")
+	l, err := loadListFile(path, parseCIDRList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(path, []byte("192.0.2.2\n"), 0o644)
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Hour))
+	l.checked = time.Time{}
+	if _, ok := l.get().match(mustAddr("192.0.2.2")); !ok {
+		t.Error("changed list not reloaded")
+	}
+
+	os.WriteFile(path, []byte("not an address/99\n"), 0o644)
+	os.Chtimes(path, time.Now(), time.Now().Add(2*time.Hour))
+	l.checked = time.Time{}
+	if _, ok := l.get().match(mustAddr("192.0.2.2")); !ok {
+		t.Error("failed reload dropped the list")
+	}
+}
+
+func TestHTTPVersionDetector(t *testing.T) {
+	tests := []struct {
+		ua, proto, edge string
+		header          string
+		want            float64
+	}{
+		{chromeUA, "HTTP/1.1", "", "", 0},
+		{chromeUA, "HTTP/1.0", "", "", 1},
+		{"curl/8.0", "HTTP/1.0", "", "", 0},
+		{chromeUA, "HTTP/1.1", "HTTP/2.0", "X-Client-Proto", 0},
+		{chromeUA, "HTTP/1.1", "HTTP/1.1", "X-Client-Proto", 0.5},
+		{chromeUA, "HTTP/1.1", "", "X-Client-Proto", 0},
+		{chromeUA, "HTTP/1.0", "", "X-Client-Proto", 0}, // the proxy's protocol
+	}
+	for _, tt := range tests {
+		h := http.Header{}
+		if tt.edge != "" {
+			h.Set("X-Client-Proto", tt.edge)
+		}
+		got, _ := httpVersionDetector{header: tt.header}.Detect(context.Background(), &Request{UA: tt.ua, Proto: tt.proto, Header: h})
+		if got != tt.want {
+			t.Errorf("%s %s edge %q = %v, want %v", tt.ua, tt.proto, tt.edge, got, tt.want)
+		}
+	}
+}
+
+func TestNewPipeline_ProtocolDetectors(t *testing.T) {
+	tests := []struct {
+		cfg  config.BotConfiguration
+		want []string
+	}{
+		{config.BotConfiguration{}, nil},
+		{config.BotConfiguration{ProtocolHeader: "X-Client-Proto"}, []string{"http_version"}},
+		{config.BotConfiguration{DirectClients: true}, []string{"http_version", "header_order"}},
+	}
+	for _, tt := range tests {
+		p, err := NewPipeline(&tt.cfg, stubResolver{}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, s := range p.stages {
+			if name := s.detector.Name(); name == "http_version" || name == "header_order" {
+				got = append(got, name)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%+v: detectors %v, want %v", tt.cfg, got, tt.want)
+		}
+	}
+}
+
+func TestHeaderOrderDetector(t *testing.T) {
+	firefoxUA := "Mozilla/5.0 (X11; Linux x86_64; rv:123.0) Gecko/20100101 Firefox/123.0"
+	tests := []struct {
+		name  string
+		ua    string
+		order []string
+		want  float64
+	}{
+		{"chrome", chromeUA, []string{"host", "connection", "user-agent", "accept", "sec-fetch-mode", "accept-encoding", "accept-language"}, 0},
+		{"python requests as chrome", chromeUA, []string{"host", "user-agent", "accept-encoding", "accept", "connection", "accept-language"}, 1},
+		{"firefox", firefoxUA, []string{"host", "user-agent", "accept", "accept-language", "accept-encoding", "connection"}, 0},
+		{"chrome order as firefox", firefoxUA, []string{"host", "user-agent", "accept", "accept-encoding", "accept-language"}, 1},
+		{"unknown order", chromeUA, nil, 0},
+		{"not a browser", "python-requests/2.31", []string{"accept", "user-agent"}, 0},
+	}
+	for _, tt := range tests {
+		got, reason := headerOrderDetector{}.Detect(context.Background(), &Request{UA: tt.ua, HeaderOrder: tt.order})
+		if got != tt.want {
+			t.Errorf("%s = %v (%s), want %v", tt.name, got, reason, tt.want)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+// Package clickhouse is a small client for the ClickHouse HTTP interface:
+// parameterised queries, JSONEachRow inserts and streamed JSONEachRow
+// results.
+package clickhouse
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTimeout = 60 * time.Second
+	// TimeFormat is how DateTime64(6) values are written in parameters and
+	// inserted rows, always in UTC.
+	TimeFormat = "2006-01-02 15:04:05.000000"
+)
+
+// Params binds the {name:Type} placeholders of a query. Values are
+// strings, integers, bools, time.Time or []string.
+type Params map[string]any
+
+type Client struct {
+	URL      string // e.g. http://localhost:8123
+	Database string
+	User     string
+	Password string
+	HTTP     *http.Client
+}
+
+func New(rawURL, database, user, password string) *Client {
+	return &Client{
+		URL:      strings.TrimSuffix(rawURL, "/"),
+		Database: database,
+		User:     user,
+		Password: password,
+		HTTP:     &http.Client{Timeout: defaultTimeout},
+	}
+}
+
+// Exec runs a statement that returns no rows, such as DDL.
+func (c *Client) Exec(ctx context.Context, query string, params Params) error {
+	body, err := c.do(ctx, nil, strings.NewReader(query), params)
+	if err != nil {
+		return err
+	}
+	return body.Close()
+}
+
+// Mutate runs an ALTER TABLE … DELETE or UPDATE and returns once the
+// mutation has finished on every replica, rather than when it is queued.
+func (c *Client) Mutate(ctx context.Context, query string, params Params) error {
+	body, err := c.do(ctx, url.Values{"mutations_sync": {"2"}}, strings.NewReader(query), params)
+	if err != nil {
+		return err
+	}
+	return body.Close()
+}
+
+// Insert writes rows, marshalled as JSON objects keyed by column name, into
+// table. Inserts are batched server-side with async_insert and return once
+// the rows are written.
+func (c *Client) Insert(ctx context.Context, table string, rows []any) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, row := range rows {
+		if err := enc.Encode(row); err != nil {
+			return err
+		}
+	}
+	q := url.Values{
+		"query":                 {"INSERT INTO " + table + " FORMAT JSONEachRow"},
+		"async_insert":          {"1"},
+		"wait_for_async_insert": {"1"},
+	}
+	body, err := c.do(ctx, q, &buf, nil)
+	if err != nil {
+		return err
+	}
+	return body.Close()
+}
+
+// Each runs a SELECT and calls fn once per result row; decode unmarshals
+// the row's JSON object into v.
+func (c *Client) Each(ctx context.Context, query string, params Params, fn func(decode func(v any) error) error) error {
+	q := url.Values{"default_format": {"JSONEachRow"}, "output_format_json_quote_64bit_integers": {"0"}}
+	body, err := c.do(ctx, q, strings.NewReader(query), params)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	dec := json.NewDecoder(bufio.NewReader(body))
+	for dec.More() {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return err
+		}
+		if err := fn(func(v any) error { return json.Unmarshal(raw, v) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Select runs a SELECT and returns its rows decoded into T.
+func Select[T any](ctx context.Context, c *Client, query string, params Params) ([]T, error) {
+	out := []T{}
+	err := c.Each(ctx, query, params, func(decode func(any) error) error {
+		var row T
+		if err := decode(&row); err != nil {
+			return err
+		}
+		out = append(out, row)
+		return nil
+	})
+	return out, err
+}
+
+func (c *Client) do(ctx context.Context, q url.Values, body io.Reader, params Params) (io.ReadCloser, error) {
+	if q == nil {
+		q = url.Values{}
+	}
+	if c.Database != "" {
+		q.Set("database", c.Database)
+	}
+	for name, v := range params {
+		s, err := FormatParam(v)
+		if err != nil {
+			return nil, fmt.Errorf("clickhouse: param %s: %w", name, err)
+		}
+		q.Set("param_"+name, s)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL+"/?"+q.Encode(), body)
+	if err != nil {
+		return nil, err
+	}
+	if c.User != "" {
+		req.SetBasicAuth(c.User, c.Password)
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("clickhouse: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp.Body, nil
+}
+
+// FormatParam renders v in the text form ClickHouse parses query
+// parameters from.
+func FormatParam(v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case bool:
+		if v {
+			return "1", nil
+		}
+		return "0", nil
+	case time.Time:
+		return v.UTC().Format(TimeFormat), nil
+	case []string:
+		quoted := make([]string, len(v))
+		for i, s := range v {
+			quoted[i] = quote(s)
+		}
+		return "[" + strings.Join(quoted, ",") + "]", nil
+	}
+	return "", fmt.Errorf("unsupported type %T", v)
+}
+
+// quote writes s as a single-quoted ClickHouse string literal.
+func quote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
@@ -0,0 +1,99 @@
+package clickhouse
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatParam(t *testing.T) {
+	cases := []struct {
+		in   any
+		want string
+	}{
+		{"a'b", "a'b"},
+		{42, "42"},
+		{int64(-7), "-7"},
+		{true, "1"},
+		{time.Date(2024, 3, 4, 5, 6, 7, 8000, time.FixedZone("X", 3600)), "2024-03-04 04:06:07.000008"},
+		{[]string{"pageview", `it's \ odd`}, `['pageview','it\'s \\ odd']`},
+	}
+	for _, c := range cases {
+		got, err := FormatParam(c.in)
+		if err != nil || got != c.want {
+			t.Errorf("FormatParam(%v) = %q, %v; want %q", c.in, got, err, c.want)
+		}
+	}
+	if _, err := FormatParam(1.5); err == nil {
+		t.Error("expected error for float")
+	}
+}
+
+func TestInsert(t *testing.T) {
+	var query, body, user string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query().Get("query")
+		if r.URL.Query().Get("async_insert") != "1" || r.URL.Query().Get("database") != "analytics" {
+			t.Errorf("unexpected settings %v", r.URL.Query())
+		}
+		user, _, _ = r.BasicAuth()
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL+"/", "analytics", "writer", "secret")
+	err := c.Insert(context.Background(), "events", []any{
+		map[string]any{"id": "1", "type": "pageview"},
+		map[string]any{"id": "2", "type": "signup"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query != "INSERT INTO events FORMAT JSONEachRow" || user != "writer" {
+		t.Errorf("query %q, user %q", query, user)
+	}
+	if want := "{\"id\":\"1\",\"type\":\"pageview\"}\n{\"id\":\"2\",\"type\":\"signup\"}\n"; body != want {
+		t.Errorf("body %q, want %q", body, want)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		if !strings.Contains(string(b), "{site_id:String}") || r.URL.Query().Get("param_site_id") != "s1" {
+			t.Errorf("unexpected request %q %v", b, r.URL.Query())
+		}
+		io.WriteString(w, "{\"name\":\"DE\",\"count\":3}\n{\"name\":\"FR\",\"count\":1}\n")
+	}))
+	defer srv.Close()
+
+	type row struct {
+		Name  string `json:"name"`
+		Count int64  `json:"count"`
+	}
+	rows, err := Select[row](context.Background(), New(srv.URL, "", "", ""),
+		"SELECT country AS name, count() AS count FROM events WHERE site_id = {site_id:String} GROUP BY name", Params{"site_id": "s1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0] != (row{"DE", 3}) || rows[1] != (row{"FR", 1}) {
+		t.Errorf("rows = %v", rows)
+	}
+}
+
+func TestError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Code: 60. DB::Exception: Table default.clicks does not exist.", http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	err := New(srv.URL, "", "", "").Exec(context.Background(), "SELECT 1 FROM clicks", nil)
+	if err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("got %v", err)
+	}
+}
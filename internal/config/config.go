@@ -17,6 +17,10 @@ type Config struct {
 	RateLimitConfiguration RateLimitConfiguration `mapstructure:"RateLimitConfiguration"`
 	BotConfiguration       BotConfiguration       `mapstructure:"BotConfiguration"`
 	GeoIPConfiguration     GeoIPConfiguration     `mapstructure:"GeoIPConfiguration"`
+	SMTPConfiguration      SMTPConfiguration      `mapstructure:"SMTPConfiguration"`
+	ExportConfiguration    ExportConfiguration    `mapstructure:"ExportConfiguration"`
+	SinkConfiguration      SinkConfiguration      `mapstructure:"SinkConfiguration"`
+	AnalyticsConfiguration AnalyticsConfiguration `mapstructure:"AnalyticsConfiguration"`
 }
 
 type GeoIPConfiguration struct {
@@ -24,6 +28,65 @@ type GeoIPConfiguration struct {
 	DownloadURL  string `mapstructure:"DownloadURL"`
 }
 
+// SMTPConfiguration is the relay for alert and report emails. An empty Host
+// disables email.
+type SMTPConfiguration struct {
+	Host     string `mapstructure:"Host"`
+	Port     int    `mapstructure:"Port"`
+	Username string `mapstructure:"Username"`
+	Password string `mapstructure:"Password"`
+	From     string `mapstructure:"From"`
+}
+
+// ExportConfiguration is where bulk exports are stored: "local" writes
+// files under Dir, "s3" uploads to an S3-compatible bucket such as MinIO.
+type ExportConfiguration struct {
+	Storage       string `mapstructure:"Storage"`
+	Dir           string `mapstructure:"Dir"`
+	S3Endpoint    string `mapstructure:"S3Endpoint"`
+	S3Region      string `mapstructure:"S3Region"`
+	S3Bucket      string `mapstructure:"S3Bucket"`
+	S3AccessKey   string `mapstructure:"S3AccessKey"`
+	S3SecretKey   string `mapstructure:"S3SecretKey"`
+	S3PathStyle   bool   `mapstructure:"S3PathStyle"`
+	URLTTLSeconds int    `mapstructure:"URLTTLSeconds"`
+}
+
+// SinkConfiguration selects the streaming sinks stored clicks and events
+// are published to. Sinks lists any of "kafka", "nats" and "redis"; empty
+// disables publishing. Each sink names its topics, subjects or streams
+// <prefix>clicks and <prefix>events. KafkaSASLMechanism is "plain",
+// "scram-sha-256" or "scram-sha-512"; empty disables SASL.
+type SinkConfiguration struct {
+	Sinks              []string `mapstructure:"Sinks"`
+	KafkaBrokers       []string `mapstructure:"KafkaBrokers"`
+	KafkaTopicPrefix   string   `mapstructure:"KafkaTopicPrefix"`
+	KafkaTLS           bool     `mapstructure:"KafkaTLS"`
+	KafkaSASLMechanism string   `mapstructure:"KafkaSASLMechanism"`
+	KafkaUsername      string   `mapstructure:"KafkaUsername"`
+	KafkaPassword      string   `mapstructure:"KafkaPassword"`
+	NATSURL            string   `mapstructure:"NATSURL"`
+	NATSSubjectPrefix  string   `mapstructure:"NATSSubjectPrefix"`
+	NATSCredsFile      string   `mapstructure:"NATSCredsFile"`
+	RedisStreamPrefix  string   `mapstructure:"RedisStreamPrefix"`
+	RedisStreamMaxLen  int64    `mapstructure:"RedisStreamMaxLen"`
+}
+
+// AnalyticsConfiguration selects where clicks and events are stored and
+// queried. Mode "postgres" (the default) uses Postgres only; "dual" writes
+// to both and reads from ReadFrom, "postgres" or "clickhouse", so
+// ClickHouse can be backfilled and checked before the switch. "clickhouse"
+// stores in ClickHouse only; the import command accepts it, but the server
+// refuses it while features still read rows from Postgres.
+type AnalyticsConfiguration struct {
+	Mode               string `mapstructure:"Mode"`
+	ReadFrom           string `mapstructure:"ReadFrom"`
+	ClickHouseURL      string `mapstructure:"ClickHouseURL"`
+	ClickHouseDatabase string `mapstructure:"ClickHouseDatabase"`
+	ClickHouseUser     string `mapstructure:"ClickHouseUser"`
+	ClickHousePassword string `mapstructure:"ClickHousePassword"`
+}
+
 type ServiceConfiguration struct {
 	Port           string   `mapstructure:"Port"`
 	Debug          bool     `mapstructure:"Debug"`
@@ -81,10 +144,25 @@ type RateLimitConfiguration struct {
 	PerTrackerIPPerMinute int `mapstructure:"PerTrackerIPPerMinute"`
 }
 
+// BotConfiguration sets the bot score thresholds and the detectors that
+// make up the score. Weights gives each detector's points at full
+// strength by name; detectors left out keep their default weight and a
+// weight of 0 turns one off. The list files are reread when they change.
+// The http_version detector needs ProtocolHeader or DirectClients and the
+// header_order detector needs DirectClients, since a proxy speaks its own
+// protocol and reorders headers.
 type BotConfiguration struct {
-	MarkThreshold  int    `mapstructure:"MarkThreshold"`
-	BlockThreshold int    `mapstructure:"BlockThreshold"`
-	BlockMode      string `mapstructure:"BlockMode"`
+	MarkThreshold      int            `mapstructure:"MarkThreshold"`
+	BlockThreshold     int            `mapstructure:"BlockThreshold"`
+	BlockMode          string         `mapstructure:"BlockMode"`
+	Weights            map[string]int `mapstructure:"Weights"`
+	ASNDatabasePath    string         `mapstructure:"ASNDatabasePath"`    // GeoLite2-ASN, needed for DatacenterASNFile
+	DatacenterASNFile  string         `mapstructure:"DatacenterASNFile"`  // one ASN per line
+	DatacenterCIDRFile string         `mapstructure:"DatacenterCIDRFile"` // one CIDR or IP per line
+	TorExitListFile    string         `mapstructure:"TorExitListFile"`    // bulk exit list or exit-addresses
+	ProtocolHeader     string         `mapstructure:"ProtocolHeader"`     // set by the edge to the client's HTTP version
+	DirectClients      bool           `mapstructure:"DirectClients"`      // clients connect with no proxy in front, so protocol and header order are theirs
+	ChallengeBits      int            `mapstructure:"ChallengeBits"`      // click page proof of work difficulty; 0 turns it off
 }
 
 func InitConfiguration(configName string, configPaths []string, config *Config) error {
@@ -1,6 +1,8 @@
 package database
 
 import (
+	"fmt"
+
 	"github.com/tracking/analysis/internal/models"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -11,6 +13,9 @@ func Init(dsn string) (*gorm.DB, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := backfillNotNull(db); err != nil {
+		return nil, err
+	}
 	err = db.AutoMigrate(
 		&models.Tracker{},
 		&models.Campaign{},
@@ -20,9 +25,76 @@ func Init(dsn string) (*gorm.DB, error) {
 		&models.Click{},
 		&models.Event{},
 		&models.Token{},
+		&models.PrivacyRequest{},
+		&models.Session{},
+		&models.Webhook{},
+		&models.WebhookDelivery{},
+		&models.AlertRule{},
+		&models.AlertEvent{},
+		&models.ScheduledReport{},
+		&models.ScheduledReportRun{},
+		&models.ExportJob{},
+		&models.OutboxMessage{},
+		&models.ClickDedupHit{},
 	)
 	if err != nil {
 		return nil, err
 	}
+	if err := createIndexesConcurrently(db); err != nil {
+		return nil, err
+	}
 	return db, nil
 }
+
+// concurrentIndexes are built outside AutoMigrate, which would lock their
+// tables against writes for the whole build; on a large events table that
+// stalls ingestion for minutes.
+var concurrentIndexes = []struct{ name, table, columns string }{
+	// Matches redirect clicks to their landing page's events in fraud reports
+	{"idx_events_ip_ts", "events", "ip, ts"},
+}
+
+// createIndexesConcurrently builds the missing concurrentIndexes without
+// blocking writes. A build that was interrupted leaves an invalid index
+// behind, which is dropped and built again.
+func createIndexesConcurrently(db *gorm.DB) error {
+	for _, idx := range concurrentIndexes {
+		var valid []bool
+		err := db.Raw(`SELECT i.indisvalid FROM pg_index i JOIN pg_class c ON c.oid = i.indexrelid
+			WHERE c.relname = ? AND c.relnamespace = current_schema()::regnamespace`, idx.name).Scan(&valid).Error
+		if err != nil {
+			return err
+		}
+		if len(valid) > 0 && valid[0] {
+			continue
+		}
+		if len(valid) > 0 {
+			if err := db.Exec("DROP INDEX CONCURRENTLY IF EXISTS " + idx.name).Error; err != nil {
+				return err
+			}
+		}
+		err = db.Exec(fmt.Sprintf("CREATE INDEX CONCURRENTLY IF NOT EXISTS %s ON %s (%s)", idx.name, idx.table, idx.columns)).Error
+		if err != nil {
+			return fmt.Errorf("create index %s: %w", idx.name, err)
+		}
+	}
+	return nil
+}
+
+// backfillNotNull clears NULLs from columns that were first added without
+// a default, so AutoMigrate can make them NOT NULL. Rows stored before
+// the columns existed hold NULL, which readers cannot scan into strings.
+func backfillNotNull(db *gorm.DB) error {
+	for _, c := range []struct{ table, column string }{
+		{"clicks", "browser"}, {"clicks", "os"}, {"events", "browser"}, {"events", "os"},
+	} {
+		if !db.Migrator().HasColumn(c.table, c.column) {
+			continue
+		}
+		err := db.Exec(fmt.Sprintf("UPDATE %s SET %s = '' WHERE %s IS NULL", c.table, c.column, c.column)).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,11 +2,17 @@ package dedup
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 	"github.com/tracking/analysis/internal/config"
+	"github.com/tracking/analysis/internal/models"
 )
 
 func CheckClickDedup(ctx context.Context, rdb *redis.Client, cfg *config.SecurityConfiguration, trackerID, channelID, visitorID string) bool {
@@ -18,3 +24,127 @@ func CheckClickDedup(ctx context.Context, rdb *redis.Client, cfg *config.Securit
 	}
 	return !set // isDuplicate = true if key already existed
 }
+
+// ClaimEventBatch records a client-generated batch ID for a site. It returns
+// false if the batch was already accepted, so SDK retries of a batch whose
+// response was lost are not stored twice.
+func ClaimEventBatch(ctx context.Context, rdb *redis.Client, siteID, batchID string, ttl time.Duration) bool {
+	if batchID == "" {
+		return true
+	}
+	key := fmt.Sprintf("dedup:batch:%s:%s", siteID, batchID)
+	set, err := rdb.SetNX(ctx, key, 1, ttl).Result()
+	if err != nil {
+		return true // fail open
+	}
+	return set
+}
+
+// ReleaseEventBatch forgets a claimed batch ID so the batch can be retried
+// after a failed write.
+func ReleaseEventBatch(ctx context.Context, rdb *redis.Client, siteID, batchID string) {
+	if batchID == "" {
+		return
+	}
+	rdb.Del(ctx, fmt.Sprintf("dedup:batch:%s:%s", siteID, batchID))
+}
+
+// hitsKey is the Redis hash buffering dropped duplicate clicks, by hour,
+// tracker, campaign and channel, until RunHitFlush stores them.
+const hitsKey = "dedup:hits"
+
+// HitFlushInterval is how often buffered duplicate counts are stored, and
+// so how far behind fraud reports may be.
+const HitFlushInterval = 30 * time.Second
+
+// claimHits renames the buffer to a key of the caller's own, so counts
+// recorded while it is stored start a new buffer and two servers never
+// store the same counts.
+var claimHits = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then return 0 end
+redis.call('RENAME', KEYS[1], KEYS[2])
+return 1`)
+
+// RecordHit counts one click dropped as a duplicate at the given time. The
+// count is buffered in Redis rather than written to the database, so a
+// burst of duplicates costs one HINCRBY each.
+func RecordHit(ctx context.Context, rdb *redis.Client, trackerID, campaignID, channelID string, at time.Time) error {
+	field := fmt.Sprintf("%d|%s|%s|%s", at.UTC().Truncate(time.Hour).Unix(), trackerID, campaignID, channelID)
+	return rdb.HIncrBy(ctx, hitsKey, field, 1).Err()
+}
+
+// RunHitFlush passes the buffered duplicate counts to store every
+// HitFlushInterval until ctx is done. store adds them to the stored counts.
+func RunHitFlush(ctx context.Context, rdb *redis.Client, store func([]models.ClickDedupHit) error) {
+	ticker := time.NewTicker(HitFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if n, err := flushHits(ctx, rdb, store); err != nil {
+			slog.Warn("dedup hit flush failed", "hits", n, "error", err)
+		}
+	}
+}
+
+// flushHits claims the buffer and stores it. When store fails the counts
+// are added back to the buffer for the next flush.
+func flushHits(ctx context.Context, rdb *redis.Client, store func([]models.ClickDedupHit) error) (int, error) {
+	var b [8]byte
+	rand.Read(b[:])
+	claimed := hitsKey + ":flush:" + hex.EncodeToString(b[:])
+	ok, err := claimHits.Run(ctx, rdb, []string{hitsKey, claimed}).Int()
+	if err != nil || ok == 0 {
+		return 0, err
+	}
+	fields, err := rdb.HGetAll(ctx, claimed).Result()
+	if err != nil {
+		return 0, err
+	}
+	hits := make([]models.ClickDedupHit, 0, len(fields))
+	for field, count := range fields {
+		if hit, ok := parseHit(field, count); ok {
+			hits = append(hits, hit)
+		}
+	}
+	if err := store(hits); err != nil {
+		_, restoreErr := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for field, count := range fields {
+				n, _ := strconv.ParseInt(count, 10, 64)
+				pipe.HIncrBy(ctx, hitsKey, field, n)
+			}
+			pipe.Del(ctx, claimed)
+			return nil
+		})
+		if restoreErr != nil {
+			return len(hits), fmt.Errorf("%w; restoring counts: %v", err, restoreErr)
+		}
+		return len(hits), err
+	}
+	return len(hits), rdb.Del(ctx, claimed).Err()
+}
+
+func parseHit(field, count string) (models.ClickDedupHit, bool) {
+	parts := strings.Split(field, "|")
+	if len(parts) != 4 {
+		return models.ClickDedupHit{}, false
+	}
+	hour, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return models.ClickDedupHit{}, false
+	}
+	n, err := strconv.ParseInt(count, 10, 64)
+	if err != nil {
+		return models.ClickDedupHit{}, false
+	}
+	return models.ClickDedupHit{
+		Hour:       time.Unix(hour, 0).UTC(),
+		TrackerID:  parts[1],
+		CampaignID: parts[2],
+		ChannelID:  parts[3],
+		Count:      n,
+	}, true
+}
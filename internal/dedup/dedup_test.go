@@ -2,11 +2,14 @@ package dedup
 
 import (
 	"context"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/alicebob/miniredis/v2"
 	"github.com/redis/go-redis/v9"
 	"github.com/tracking/analysis/internal/config"
+	"github.com/tracking/analysis/internal/models"
 )
 
 func setupRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
@@ -66,3 +69,86 @@ func TestCheckClickDedup_TTLExpiry(t *testing.T) {
 		t.Error("after TTL expiry, same click should not be duplicate")
 	}
 }
+
+func TestClaimEventBatch(t *testing.T) {
+	_, rdb := setupRedis(t)
+	ctx := context.Background()
+
+	if !ClaimEventBatch(ctx, rdb, "site-1", "batch-1", time.Hour) {
+		t.Fatal("first claim should succeed")
+	}
+	if ClaimEventBatch(ctx, rdb, "site-1", "batch-1", time.Hour) {
+		t.Error("second claim of the same batch should fail")
+	}
+	if !ClaimEventBatch(ctx, rdb, "site-2", "batch-1", time.Hour) {
+		t.Error("same batch ID on another site should succeed")
+	}
+}
+
+func TestClaimEventBatch_Release(t *testing.T) {
+	_, rdb := setupRedis(t)
+	ctx := context.Background()
+
+	ClaimEventBatch(ctx, rdb, "site-1", "batch-1", time.Hour)
+	ReleaseEventBatch(ctx, rdb, "site-1", "batch-1")
+	if !ClaimEventBatch(ctx, rdb, "site-1", "batch-1", time.Hour) {
+		t.Error("claim after release should succeed")
+	}
+}
+
+func TestClaimEventBatch_EmptyID(t *testing.T) {
+	_, rdb := setupRedis(t)
+	ctx := context.Background()
+
+	ClaimEventBatch(ctx, rdb, "site-1", "", time.Hour)
+	if !ClaimEventBatch(ctx, rdb, "site-1", "", time.Hour) {
+		t.Error("batches without an ID are never deduplicated")
+	}
+}
+
+func TestFlushHits(t *testing.T) {
+	mr, rdb := setupRedis(t)
+	ctx := context.Background()
+	at := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+
+	RecordHit(ctx, rdb, "tracker-1", "camp-1", "channel-1", at)
+	RecordHit(ctx, rdb, "tracker-1", "camp-1", "channel-1", at.Add(10*time.Minute))
+	RecordHit(ctx, rdb, "tracker-1", "camp-1", "channel-1", at.Add(time.Hour))
+
+	// A failed store keeps the counts for the next flush
+	failed := errors.New("db down")
+	if _, err := flushHits(ctx, rdb, func([]models.ClickDedupHit) error { return failed }); !errors.Is(err, failed) {
+		t.Fatalf("flush error = %v", err)
+	}
+	RecordHit(ctx, rdb, "tracker-1", "camp-1", "channel-1", at)
+
+	var stored []models.ClickDedupHit
+	n, err := flushHits(ctx, rdb, func(hits []models.ClickDedupHit) error {
+		stored = append(stored, hits...)
+		return nil
+	})
+	if err != nil || n != 2 {
+		t.Fatalf("flush = %d, %v", n, err)
+	}
+	counts := map[time.Time]int64{}
+	for _, h := range stored {
+		if h.TrackerID != "tracker-1" || h.CampaignID != "camp-1" || h.ChannelID != "channel-1" {
+			t.Errorf("hit = %+v", h)
+		}
+		counts[h.Hour] = h.Count
+	}
+	if counts[at.Truncate(time.Hour)] != 3 || counts[at.Truncate(time.Hour).Add(time.Hour)] != 1 {
+		t.Errorf("counts = %v", counts)
+	}
+	if keys := mr.Keys(); len(keys) != 0 {
+		t.Errorf("keys left after flush: %v", keys)
+	}
+
+	n, err = flushHits(ctx, rdb, func([]models.ClickDedupHit) error {
+		t.Error("store called with an empty buffer")
+		return nil
+	})
+	if err != nil || n != 0 {
+		t.Errorf("empty flush = %d, %v", n, err)
+	}
+}
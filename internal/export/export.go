@@ -0,0 +1,223 @@
+// Package export writes raw clicks and events to CSV, NDJSON or Parquet
+// files in the background and serves them through signed, expiring URLs.
+package export
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/tracking/analysis/internal/models"
+	"github.com/tracking/analysis/internal/repo"
+)
+
+// Job statuses.
+const (
+	StatusQueued  = "queued"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+const (
+	pollInterval = 5 * time.Second
+	// A running job heartbeats every heartbeatInterval; one silent for
+	// staleAfter lost its worker and is picked up again.
+	heartbeatInterval = 30 * time.Second
+	staleAfter        = 2 * time.Minute
+	// DefaultURLTTL is how long download URLs stay valid by default.
+	DefaultURLTTL = time.Hour
+)
+
+type Service struct {
+	Repo    *repo.ExportRepo
+	Store   Store
+	Secret  []byte
+	BaseURL string
+	URLTTL  time.Duration
+}
+
+func NewService(exportRepo *repo.ExportRepo, store Store, secret, baseURL string, urlTTL time.Duration) *Service {
+	if urlTTL <= 0 {
+		urlTTL = DefaultURLTTL
+	}
+	return &Service{Repo: exportRepo, Store: store, Secret: []byte(secret), BaseURL: baseURL, URLTTL: urlTTL}
+}
+
+// Key is where a job's file is stored. Grouping by source and date range
+// keeps daily dumps easy to load into a warehouse by prefix.
+func Key(job *models.ExportJob) string {
+	return fmt.Sprintf("exports/%s/%s_%s/%s.%s", job.Source, job.StartDate, job.EndDate, job.ID, job.Format)
+}
+
+// Filename is the download name of a job's file.
+func Filename(job *models.ExportJob) string {
+	return fmt.Sprintf("%s-%s-%s.%s", job.Source, job.StartDate, job.EndDate, job.Format)
+}
+
+func (s *Service) signature(id string, expires int64) string {
+	mac := hmac.New(sha256.New, s.Secret)
+	fmt.Fprintf(mac, "export:%s:%d", id, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// DownloadURL returns a signed /exports/:id URL valid for URLTTL, and its
+// expiry.
+func (s *Service) DownloadURL(id string, now time.Time) (string, time.Time) {
+	expires := now.Add(s.URLTTL).Truncate(time.Second)
+	return fmt.Sprintf("%s/exports/%s?expires=%d&sig=%s", s.BaseURL, id, expires.Unix(), s.signature(id, expires.Unix())), expires
+}
+
+// Verify checks the expires and sig query values of a download URL.
+func (s *Service) Verify(id, expires, sig string, now time.Time) bool {
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || now.Unix() > exp {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(s.signature(id, exp)))
+}
+
+// Run processes queued jobs one at a time until ctx is done.
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for ctx.Err() == nil {
+				now := time.Now()
+				job, err := s.Repo.ClaimNext(now, now.Add(-staleAfter))
+				if err != nil {
+					slog.Warn("export claim failed", "error", err)
+					break
+				}
+				if job == nil {
+					break
+				}
+				s.process(ctx, job)
+			}
+		}
+	}
+}
+
+func (s *Service) process(ctx context.Context, job *models.ExportJob) {
+	var rows atomic.Int64
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(heartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				if err := s.Repo.Heartbeat(job.ID, rows.Load(), now); err != nil {
+					slog.Warn("export heartbeat failed", "error", err, "job", job.ID)
+				}
+			}
+		}
+	}()
+
+	size, err := s.generate(ctx, job, &rows)
+	close(done)
+	now := time.Now()
+	job.Rows, job.FinishedAt = rows.Load(), &now
+	if err != nil {
+		job.Status, job.Error = StatusFailed, err.Error()
+		slog.Warn("export failed", "error", err, "job", job.ID)
+	} else {
+		job.Status, job.Size = StatusDone, size
+		slog.Info("export finished", "job", job.ID, "rows", job.Rows, "bytes", size)
+	}
+	if err := s.Repo.Update(job); err != nil {
+		slog.Error("export job write failed", "error", err, "job", job.ID)
+	}
+}
+
+// generate streams the rows of job into a temporary file, stores it under
+// Key(job) and returns its size. rows counts rows as they are written.
+func (s *Service) generate(ctx context.Context, job *models.ExportJob, rows *atomic.Int64) (int64, error) {
+	loc, err := time.LoadLocation(job.Timezone)
+	if err != nil {
+		return 0, err
+	}
+	start, err := time.ParseInLocation("2006-01-02", job.StartDate, loc)
+	if err != nil {
+		return 0, err
+	}
+	end, err := time.ParseInLocation("2006-01-02", job.EndDate, loc)
+	if err != nil {
+		return 0, err
+	}
+	filter := repo.ExportFilter{
+		Start:       start,
+		End:         end.AddDate(0, 0, 1).Add(-time.Nanosecond),
+		TrackerID:   job.TrackerID,
+		CampaignID:  job.CampaignID,
+		ChannelID:   job.ChannelID,
+		SiteID:      job.SiteID,
+		EventType:   job.EventType,
+		IncludeBots: job.IncludeBots,
+	}
+
+	tmp, err := os.CreateTemp("", "export-*."+job.Format)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	buf := bufio.NewWriterSize(tmp, 1<<20)
+	cols := ClickColumns
+	if job.Source == "events" {
+		cols = EventColumns
+	}
+	w, err := NewWriter(job.Format, buf, cols)
+	if err != nil {
+		return 0, err
+	}
+	write := func(row []any) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rows.Add(1)
+		return w.Write(row)
+	}
+	if job.Source == "events" {
+		err = s.Repo.EachEvent(filter, func(e *models.Event) error { return write(eventRow(e)) })
+	} else {
+		err = s.Repo.EachClick(filter, func(c *models.Click) error { return write(clickRow(c)) })
+	}
+	if err != nil {
+		return 0, err
+	}
+	if err := w.Close(); err != nil {
+		return 0, err
+	}
+	if err := buf.Flush(); err != nil {
+		return 0, err
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	job.Storage, job.Key = s.Store.Name(), Key(job)
+	if err := s.Store.Put(ctx, job.Key, tmp, size, ContentTypes[job.Format]); err != nil {
+		return 0, fmt.Errorf("store: %w", err)
+	}
+	return size, nil
+}
@@ -0,0 +1,205 @@
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/tracking/analysis/internal/models"
+)
+
+// Export formats.
+const (
+	FormatCSV     = "csv"
+	FormatNDJSON  = "ndjson"
+	FormatParquet = "parquet"
+)
+
+// ContentTypes maps each format to the MIME type of its file.
+var ContentTypes = map[string]string{
+	FormatCSV:     "text/csv; charset=utf-8",
+	FormatNDJSON:  "application/x-ndjson",
+	FormatParquet: "application/vnd.apache.parquet",
+}
+
+// Column types.
+const (
+	TypeString = iota
+	TypeJSON   // a string holding a JSON document
+	TypeBool
+	TypeTime
+)
+
+// Column is one field of an exported row. Optional columns may hold nil.
+type Column struct {
+	Name     string
+	Type     int
+	Optional bool
+}
+
+var ClickColumns = []Column{
+	{Name: "id"}, {Name: "ts", Type: TypeTime},
+	{Name: "tracker_id"}, {Name: "campaign_id"}, {Name: "channel_id"}, {Name: "target_id"},
+	{Name: "visitor_id"}, {Name: "ip"}, {Name: "country"},
+	{Name: "ua"}, {Name: "browser"}, {Name: "os"}, {Name: "lang"}, {Name: "referer"},
+	{Name: "props", Type: TypeJSON},
+	{Name: "suspected_bot", Type: TypeBool}, {Name: "is_bot", Type: TypeBool},
+}
+
+func clickRow(c *models.Click) []any {
+	return []any{
+		c.ID, c.TS,
+		c.TrackerID, c.CampaignID, c.ChannelID, c.TargetID,
+		c.VisitorID, c.IP, c.Country,
+		c.UA, c.Browser, c.OS, c.Lang, c.Referer,
+		propsJSON(c.Props),
+		c.SuspectedBot, c.IsBot,
+	}
+}
+
+var EventColumns = []Column{
+	{Name: "id"}, {Name: "ts", Type: TypeTime},
+	{Name: "client_ts", Type: TypeTime, Optional: true}, {Name: "server_ts", Type: TypeTime},
+	{Name: "site_id"}, {Name: "type"}, {Name: "visitor_id"}, {Name: "session_id"},
+	{Name: "url"}, {Name: "title"}, {Name: "referrer"},
+	{Name: "ip"}, {Name: "country"}, {Name: "ua"}, {Name: "browser"}, {Name: "os"}, {Name: "lang"},
+	{Name: "props", Type: TypeJSON},
+	{Name: "consent", Type: TypeBool}, {Name: "suspected_bot", Type: TypeBool}, {Name: "is_bot", Type: TypeBool},
+	{Name: "import_source"},
+}
+
+func eventRow(e *models.Event) []any {
+	var clientTS any
+	if e.ClientTS != nil {
+		clientTS = *e.ClientTS
+	}
+	return []any{
+		e.ID, e.TS,
+		clientTS, e.ServerTS,
+		e.SiteID, e.Type, e.VisitorID, e.SessionID,
+		e.URL, e.Title, e.Referrer,
+		e.IP, e.Country, e.UA, e.Browser, e.OS, e.Lang,
+		propsJSON(e.Props),
+		e.Consent, e.SuspectedBot, e.IsBot,
+		e.ImportSource,
+	}
+}
+
+func propsJSON(props models.JSONMap) string {
+	if len(props) == 0 {
+		return "{}"
+	}
+	b, err := json.Marshal(props)
+	if err != nil {
+		return "{}"
+	}
+	return string(b)
+}
+
+// RowWriter encodes rows of one column layout. Close flushes buffered rows
+// and writes any trailer; it does not close the underlying writer.
+type RowWriter interface {
+	Write(row []any) error
+	Close() error
+}
+
+// NewWriter returns a RowWriter for format.
+func NewWriter(format string, w io.Writer, cols []Column) (RowWriter, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVWriter(w, cols)
+	case FormatNDJSON:
+		return &ndjsonWriter{w: bufio.NewWriter(w), cols: cols}, nil
+	case FormatParquet:
+		return newParquetWriter(w, cols), nil
+	}
+	return nil, fmt.Errorf("unsupported format: %s", format)
+}
+
+type csvWriter struct {
+	w      *csv.Writer
+	record []string
+}
+
+func newCSVWriter(w io.Writer, cols []Column) (*csvWriter, error) {
+	cw := &csvWriter{w: csv.NewWriter(w), record: make([]string, len(cols))}
+	for i, c := range cols {
+		cw.record[i] = c.Name
+	}
+	return cw, cw.w.Write(cw.record)
+}
+
+func (w *csvWriter) Write(row []any) error {
+	for i, v := range row {
+		w.record[i] = formatText(v)
+	}
+	return w.w.Write(w.record)
+}
+
+func (w *csvWriter) Close() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+// formatText renders a value for CSV: times as RFC 3339 in UTC and nulls
+// as empty fields.
+func formatText(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case bool:
+		return strconv.FormatBool(x)
+	case time.Time:
+		return x.UTC().Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(v)
+}
+
+// ndjsonWriter writes one JSON object per line with keys in column order.
+// JSON columns are embedded as objects rather than strings.
+type ndjsonWriter struct {
+	w    *bufio.Writer
+	cols []Column
+}
+
+func (w *ndjsonWriter) Write(row []any) error {
+	w.w.WriteByte('{')
+	for i, c := range w.cols {
+		if i > 0 {
+			w.w.WriteByte(',')
+		}
+		name, _ := json.Marshal(c.Name)
+		w.w.Write(name)
+		w.w.WriteByte(':')
+		var b []byte
+		var err error
+		switch v := row[i].(type) {
+		case time.Time:
+			b, err = json.Marshal(v.UTC().Format(time.RFC3339Nano))
+		case string:
+			if c.Type == TypeJSON {
+				b = []byte(v)
+			} else {
+				b, err = json.Marshal(v)
+			}
+		default:
+			b, err = json.Marshal(v)
+		}
+		if err != nil {
+			return err
+		}
+		w.w.Write(b)
+	}
+	w.w.WriteString("}\n")
+	return nil
+}
+
+func (w *ndjsonWriter) Close() error {
+	return w.w.Flush()
+}
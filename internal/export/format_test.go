@@ -0,0 +1,72 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tracking/analysis/internal/models"
+)
+
+func sampleEvents() []*models.Event {
+	ts := time.Date(2024, 3, 4, 10, 0, 0, 0, time.UTC)
+	client := ts.Add(-2 * time.Second)
+	return []*models.Event{
+		{ID: "e1", TS: ts, ClientTS: &client, ServerTS: ts, SiteID: "s1", Type: "pageview", Title: `Say "hi", world`, Props: models.JSONMap{"plan": "pro"}, Consent: true},
+		{ID: "e2", TS: ts.Add(time.Minute), ServerTS: ts.Add(time.Minute), SiteID: "s1", Type: "signup", IsBot: true},
+	}
+}
+
+func TestCSVWriter(t *testing.T) {
+	var b bytes.Buffer
+	w, err := NewWriter(FormatCSV, &b, EventColumns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range sampleEvents() {
+		w.Write(eventRow(e))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "id,ts,client_ts,server_ts,site_id,type,") {
+		t.Fatalf("csv:\n%s", b.String())
+	}
+	if !strings.Contains(lines[1], `"Say ""hi"", world"`) || !strings.Contains(lines[1], `"{""plan"":""pro""}"`) {
+		t.Errorf("row 1 not quoted: %s", lines[1])
+	}
+	if !strings.HasPrefix(lines[2], "e2,2024-03-04T10:01:00Z,,") {
+		t.Errorf("null client_ts not empty: %s", lines[2])
+	}
+}
+
+func TestNDJSONWriter(t *testing.T) {
+	var b bytes.Buffer
+	w, _ := NewWriter(FormatNDJSON, &b, EventColumns)
+	for _, e := range sampleEvents() {
+		w.Write(eventRow(e))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("ndjson:\n%s", b.String())
+	}
+	if !strings.HasPrefix(lines[0], `{"id":"e1","ts":"2024-03-04T10:00:00Z","client_ts":"2024-03-04T09:59:58Z",`) {
+		t.Errorf("keys out of order: %s", lines[0])
+	}
+	var row map[string]any
+	if err := json.Unmarshal([]byte(lines[1]), &row); err != nil {
+		t.Fatalf("line 2: %v", err)
+	}
+	if row["client_ts"] != nil || row["is_bot"] != true {
+		t.Errorf("row 2 = %v", row)
+	}
+	if props, ok := row["props"].(map[string]any); !ok || len(props) != 0 {
+		t.Errorf("props not an object: %v", row["props"])
+	}
+}
@@ -0,0 +1,88 @@
+package export
+
+import (
+	"io"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// parquetGroupRows is the number of rows buffered per row group.
+const parquetGroupRows = 100_000
+
+// parquetWriter writes gzip-compressed Parquet. Strings are UTF8 or JSON
+// byte arrays, times are INT64 microsecond UTC timestamps. Parquet groups
+// list fields by name, so the file's columns are in name order.
+type parquetWriter struct {
+	w    *parquet.Writer
+	cols []Column
+	// index maps each column to its position in the file's schema.
+	index []int
+	row   parquet.Row
+}
+
+func parquetNode(c Column) parquet.Node {
+	var n parquet.Node
+	switch c.Type {
+	case TypeBool:
+		n = parquet.Leaf(parquet.BooleanType)
+	case TypeTime:
+		n = parquet.Timestamp(parquet.Microsecond)
+	case TypeJSON:
+		n = parquet.JSON()
+	default:
+		n = parquet.String()
+	}
+	if c.Optional {
+		n = parquet.Optional(n)
+	}
+	return n
+}
+
+func newParquetWriter(w io.Writer, cols []Column) *parquetWriter {
+	group := make(parquet.Group, len(cols))
+	for _, c := range cols {
+		group[c.Name] = parquetNode(c)
+	}
+	schema := parquet.NewSchema("export", group)
+	pw := &parquetWriter{
+		w: parquet.NewWriter(w, schema,
+			parquet.Compression(&parquet.Gzip),
+			parquet.MaxRowsPerRowGroup(parquetGroupRows),
+			parquet.CreatedBy("tracking-analysis export", "", "")),
+		cols:  cols,
+		index: make([]int, len(cols)),
+		row:   make(parquet.Row, len(cols)),
+	}
+	for i, c := range cols {
+		leaf, _ := schema.Lookup(c.Name)
+		pw.index[i] = leaf.ColumnIndex
+	}
+	return pw
+}
+
+func (w *parquetWriter) Write(row []any) error {
+	for i, v := range row {
+		var value parquet.Value
+		switch x := v.(type) {
+		case string:
+			value = parquet.ByteArrayValue([]byte(x))
+		case time.Time:
+			value = parquet.Int64Value(x.UnixMicro())
+		case bool:
+			value = parquet.BooleanValue(x)
+		}
+		def := 0
+		if w.cols[i].Optional && v != nil {
+			def = 1
+		}
+		w.row[w.index[i]] = value.Level(0, def, w.index[i])
+	}
+	_, err := w.w.WriteRows([]parquet.Row{w.row})
+	return err
+}
+
+// Close writes the last row group and the footer.
+func (w *parquetWriter) Close() error {
+	return w.w.Close()
+}
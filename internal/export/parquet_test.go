@@ -0,0 +1,93 @@
+package export
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// readParquet reads a file back with the parquet-go reader, keyed by
+// column name.
+func readParquet(t *testing.T, file []byte) (*parquet.File, []map[string]parquet.Value) {
+	t.Helper()
+	f, err := parquet.OpenFile(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := parquet.NewReader(f)
+	defer r.Close()
+	cols := f.Schema().Columns()
+	var out []map[string]parquet.Value
+	rows := make([]parquet.Row, 10)
+	for {
+		n, err := r.ReadRows(rows)
+		for _, row := range rows[:n] {
+			m := make(map[string]parquet.Value, len(row))
+			for _, v := range row {
+				m[cols[v.Column()][0]] = v
+			}
+			out = append(out, m)
+		}
+		if err == io.EOF {
+			return f, out
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestParquetWriter_RoundTrip(t *testing.T) {
+	var b bytes.Buffer
+	w := newParquetWriter(&b, EventColumns)
+	for _, e := range sampleEvents() {
+		if err := w.Write(eventRow(e)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, rows := readParquet(t, b.Bytes())
+
+	if f.NumRows() != 2 || len(rows) != 2 {
+		t.Fatalf("read %d rows, footer says %d", len(rows), f.NumRows())
+	}
+	leaf, ok := f.Schema().Lookup("client_ts")
+	if !ok || !leaf.Node.Optional() || leaf.Node.Type().LogicalType().Timestamp == nil {
+		t.Errorf("client_ts schema = %v", leaf.Node)
+	}
+	if leaf, _ := f.Schema().Lookup("props"); leaf.Node.Type().LogicalType().Json == nil {
+		t.Errorf("props schema = %v", leaf.Node)
+	}
+
+	first, second := rows[0], rows[1]
+	if first["type"].String() != "pageview" || second["type"].String() != "signup" {
+		t.Errorf("type = %v, %v", first["type"], second["type"])
+	}
+	if first["title"].String() != `Say "hi", world` || first["props"].String() != `{"plan":"pro"}` {
+		t.Errorf("title, props = %v, %v", first["title"], first["props"])
+	}
+	client := time.Date(2024, 3, 4, 9, 59, 58, 0, time.UTC)
+	if first["client_ts"].Int64() != client.UnixMicro() || !second["client_ts"].IsNull() {
+		t.Errorf("client_ts = %v, %v", first["client_ts"], second["client_ts"])
+	}
+	if first["is_bot"].Boolean() || !second["is_bot"].Boolean() || !first["consent"].Boolean() {
+		t.Errorf("is_bot = %v, %v", first["is_bot"], second["is_bot"])
+	}
+}
+
+func TestParquetWriterEmpty(t *testing.T) {
+	var b bytes.Buffer
+	w := newParquetWriter(&b, ClickColumns)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, rows := readParquet(t, b.Bytes())
+	if f.NumRows() != 0 || len(rows) != 0 || len(f.Schema().Columns()) != len(ClickColumns) {
+		t.Errorf("empty file: %d rows, %d columns", len(rows), len(f.Schema().Columns()))
+	}
+}
@@ -0,0 +1,142 @@
+package export
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/tracking/analysis/internal/config"
+)
+
+// Storage backends.
+const (
+	StorageLocal = "local"
+	StorageS3    = "s3"
+)
+
+// ErrNotFound is returned by Store.Open for a missing object.
+var ErrNotFound = errors.New("export file not found")
+
+// Store holds finished export files.
+type Store interface {
+	Name() string
+	// Put stores size bytes read from f under key.
+	Put(ctx context.Context, key string, f *os.File, size int64, contentType string) error
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// NewStore returns the store cfg selects; local storage is the default.
+func NewStore(cfg config.ExportConfiguration) (Store, error) {
+	switch cfg.Storage {
+	case "", StorageLocal:
+		dir := cfg.Dir
+		if dir == "" {
+			dir = "data/exports"
+		}
+		return &LocalStore{Dir: dir}, nil
+	case StorageS3:
+		if cfg.S3Endpoint == "" || cfg.S3Bucket == "" {
+			return nil, errors.New("s3 export storage needs S3Endpoint and S3Bucket")
+		}
+		endpoint, err := url.Parse(cfg.S3Endpoint)
+		if err != nil || endpoint.Host == "" || (endpoint.Path != "" && endpoint.Path != "/") {
+			return nil, fmt.Errorf("invalid S3Endpoint %q, want scheme and host only", cfg.S3Endpoint)
+		}
+		region := cfg.S3Region
+		if region == "" {
+			region = "us-east-1"
+		}
+		// PathStyle addresses objects as endpoint/bucket/key, which MinIO
+		// needs, rather than bucket.endpoint/key.
+		lookup := minio.BucketLookupDNS
+		if cfg.S3PathStyle {
+			lookup = minio.BucketLookupPath
+		}
+		client, err := minio.New(endpoint.Host, &minio.Options{
+			Creds:        credentials.NewStaticV4(cfg.S3AccessKey, cfg.S3SecretKey, ""),
+			Secure:       endpoint.Scheme == "https",
+			Region:       region,
+			BucketLookup: lookup,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &S3Store{Client: client, Bucket: cfg.S3Bucket}, nil
+	}
+	return nil, fmt.Errorf("unsupported export storage %q", cfg.Storage)
+}
+
+// LocalStore keeps exports as files under Dir.
+type LocalStore struct {
+	Dir string
+}
+
+func (s *LocalStore) Name() string { return StorageLocal }
+
+func (s *LocalStore) path(key string) string {
+	return filepath.Join(s.Dir, filepath.FromSlash(key))
+}
+
+func (s *LocalStore) Put(_ context.Context, key string, f *os.File, _ int64, _ string) error {
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	// Copy then rename, so a partial file is never visible under key.
+	out, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	if _, err := io.Copy(out, f); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), path)
+}
+
+func (s *LocalStore) Open(_ context.Context, key string) (io.ReadCloser, error) {
+	f, err := os.Open(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+// S3Store keeps exports in an S3-compatible bucket.
+type S3Store struct {
+	Client *minio.Client
+	Bucket string
+}
+
+func (s *S3Store) Name() string { return StorageS3 }
+
+func (s *S3Store) Put(ctx context.Context, key string, f *os.File, size int64, contentType string) error {
+	_, err := s.Client.PutObject(ctx, s.Bucket, key, f, size, minio.PutObjectOptions{ContentType: contentType})
+	return err
+}
+
+func (s *S3Store) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	obj, err := s.Client.GetObject(ctx, s.Bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	// GetObject is lazy; Stat sends the request and surfaces a missing key
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return obj, nil
+}
@@ -0,0 +1,128 @@
+package export
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tracking/analysis/internal/config"
+)
+
+func tempFile(t *testing.T, content string) *os.File {
+	t.Helper()
+	f, err := os.CreateTemp(t.TempDir(), "src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(content)
+	f.Seek(0, io.SeekStart)
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+// decodeAWSChunked strips the framing of a streaming SigV4 upload, which
+// minio-go sends over plain HTTP.
+func decodeAWSChunked(t *testing.T, body string) string {
+	var out strings.Builder
+	for body != "" {
+		header, rest, _ := strings.Cut(body, "\r\n")
+		sizeHex, _, _ := strings.Cut(header, ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil || int64(len(rest)) < size+2 {
+			t.Errorf("bad chunk %q", body)
+			return out.String()
+		}
+		out.WriteString(rest[:size])
+		body = rest[size+2:]
+	}
+	return out.String()
+}
+
+func TestS3StorePathStyle(t *testing.T) {
+	var gotPath, gotBody, gotAuth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotAuth = r.URL.Path, r.Header.Get("Authorization")
+		if r.Method == http.MethodPut {
+			b, _ := io.ReadAll(r.Body)
+			gotBody = decodeAWSChunked(t, string(b))
+			return
+		}
+		if r.URL.Path == "/exports/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		io.WriteString(w, gotBody)
+	}))
+	defer srv.Close()
+
+	store, err := NewStore(config.ExportConfiguration{Storage: "s3", S3Endpoint: srv.URL, S3Bucket: "exports", S3AccessKey: "minio", S3SecretKey: "minio123", S3PathStyle: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := store.Put(ctx, "exports/clicks/a.csv", tempFile(t, "id\n1\n"), 5, "text/csv"); err != nil {
+		t.Fatal(err)
+	}
+	if gotPath != "/exports/exports/clicks/a.csv" || gotBody != "id\n1\n" || !strings.HasPrefix(gotAuth, "AWS4-HMAC-SHA256 Credential=minio/") {
+		t.Errorf("put path=%s body=%q auth=%s", gotPath, gotBody, gotAuth)
+	}
+	rc, err := store.Open(ctx, "exports/clicks/a.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(b) != "id\n1\n" {
+		t.Errorf("get = %q", b)
+	}
+	if _, err := store.Open(ctx, "missing"); err != ErrNotFound {
+		t.Errorf("missing object: %v", err)
+	}
+}
+
+func TestLocalStore(t *testing.T) {
+	dir := t.TempDir()
+	store := &LocalStore{Dir: dir}
+	ctx := context.Background()
+	if err := store.Put(ctx, "exports/events/b.ndjson", tempFile(t, "{}\n"), 3, ""); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "exports", "events", "b.ndjson")); string(b) != "{}\n" {
+		t.Errorf("file = %q", b)
+	}
+	if _, err := store.Open(ctx, "exports/events/missing"); err != ErrNotFound {
+		t.Errorf("missing file: %v", err)
+	}
+}
+
+func TestDownloadURL(t *testing.T) {
+	s := NewService(nil, nil, "secret", "https://t.example.com", time.Hour)
+	now := time.Unix(1700000000, 0)
+	raw, expires := s.DownloadURL("job-1", now)
+	if !expires.Equal(now.Add(time.Hour)) {
+		t.Errorf("expires = %v", expires)
+	}
+	u, _ := url.Parse(raw)
+	if u.Path != "/exports/job-1" {
+		t.Errorf("path = %s", u.Path)
+	}
+	q := u.Query()
+	if !s.Verify("job-1", q.Get("expires"), q.Get("sig"), now) {
+		t.Error("valid URL rejected")
+	}
+	if s.Verify("job-2", q.Get("expires"), q.Get("sig"), now) {
+		t.Error("URL accepted for another job")
+	}
+	if s.Verify("job-1", q.Get("expires"), q.Get("sig"), now.Add(2*time.Hour)) {
+		t.Error("expired URL accepted")
+	}
+}
@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tracking/analysis/internal/export"
+	"github.com/tracking/analysis/internal/repo"
+)
+
+type ExportHandler struct {
+	Repo    *repo.ExportRepo
+	Exports *export.Service
+}
+
+// GET /exports/:id?expires=&sig= — downloads a finished export. The URL is
+// signed and expiring so it can be handed to scripts without an admin token.
+func (h *ExportHandler) HandleDownload(c *gin.Context) {
+	id := c.Param("id")
+	if !h.Exports.Verify(id, c.Query("expires"), c.Query("sig"), time.Now()) {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+	job, err := h.Repo.GetByID(id)
+	if err != nil || job.Status != export.StatusDone {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	f, err := h.Exports.Store.Open(c.Request.Context(), job.Key)
+	if err != nil {
+		if errors.Is(err, export.ErrNotFound) {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		slog.Error("export open failed", "error", err, "job", id)
+		c.AbortWithStatus(http.StatusBadGateway)
+		return
+	}
+	defer f.Close()
+
+	c.Header("Content-Type", export.ContentTypes[job.Format])
+	c.Header("Content-Length", strconv.FormatInt(job.Size, 10))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", export.Filename(job)))
+	c.Status(http.StatusOK)
+	if _, err := io.Copy(c.Writer, f); err != nil {
+		slog.Warn("export download interrupted", "error", err, "job", id)
+	}
+}
@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/tracking/analysis/internal/live"
+)
+
+// activeInterval is how often active visitor counts are pushed; it also
+// keeps idle connections from being closed by proxies.
+const activeInterval = 5 * time.Second
+
+type StreamHandler struct {
+	Redis *redis.Client
+}
+
+// GET /admin/stream — live clicks, events and active visitor counts as SSE.
+// Authorized by a single-use ?ticket= from admin.stream.ticket.
+func (h *StreamHandler) HandleStream(c *gin.Context) {
+	ctx := c.Request.Context()
+	ticket := c.Query("ticket")
+	if ticket == "" {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	ok, err := live.RedeemTicket(ctx, h.Redis, ticket)
+	if err != nil {
+		slog.Error("stream ticket lookup failed", "error", err)
+		c.AbortWithStatus(http.StatusServiceUnavailable)
+		return
+	}
+	if !ok {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	siteID, trackerID := c.Query("site_id"), c.Query("tracker_id")
+
+	sub := h.Redis.Subscribe(ctx, live.Channel)
+	defer sub.Close()
+	if _, err := sub.Receive(ctx); err != nil {
+		slog.Error("stream subscribe failed", "error", err)
+		c.AbortWithStatus(http.StatusServiceUnavailable)
+		return
+	}
+	msgs := sub.Channel()
+	ticker := time.NewTicker(activeInterval)
+	defer ticker.Stop()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	h.sendActive(c, siteID, trackerID)
+	c.Stream(func(io.Writer) bool {
+		select {
+		case <-ctx.Done():
+			return false
+		case m, ok := <-msgs:
+			if !ok {
+				return false
+			}
+			var msg live.Message
+			if err := json.Unmarshal([]byte(m.Payload), &msg); err != nil || !msg.Matches(siteID, trackerID) {
+				return true
+			}
+			c.SSEvent(msg.Kind, json.RawMessage(m.Payload))
+		case <-ticker.C:
+			h.sendActive(c, siteID, trackerID)
+		}
+		return true
+	})
+}
+
+// sendActive pushes the active visitor count for the stream's scope: the
+// filtered site, else the filtered tracker, else all traffic.
+func (h *StreamHandler) sendActive(c *gin.Context, siteID, trackerID string) {
+	scope, id := "all", ""
+	if siteID != "" {
+		scope, id = "site", siteID
+	} else if trackerID != "" {
+		scope, id = "tracker", trackerID
+	}
+	n, err := live.ActiveVisitors(c.Request.Context(), h.Redis, scope, id, time.Now())
+	if err != nil {
+		slog.Warn("active visitor count failed", "error", err, "scope", scope)
+		return
+	}
+	c.SSEvent("active", gin.H{
+		"scope":           scope,
+		"id":              id,
+		"active_visitors": n,
+		"window_seconds":  int(live.ActiveWindow / time.Second),
+	})
+	c.Writer.Flush()
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/rsa"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -11,15 +12,19 @@ import (
 	"github.com/tracking/analysis/internal/bot"
 	"github.com/tracking/analysis/internal/config"
 	"github.com/tracking/analysis/internal/geo"
+	"github.com/tracking/analysis/internal/live"
 	"github.com/tracking/analysis/internal/models"
+	"github.com/tracking/analysis/internal/privacy"
 	"github.com/tracking/analysis/internal/repo"
 	"github.com/tracking/analysis/internal/sdk"
 	"github.com/tracking/analysis/internal/security"
+	"github.com/tracking/analysis/internal/webhook"
 )
 
 type TrackingHandler struct {
 	Config      *config.Config
-	ClickRepo   *repo.ClickRepo
+	TrackerRepo *repo.TrackerRepo
+	ClickRepo   repo.ClickStore
 	TargetRepo  *repo.TargetRepo
 	TokenRepo   *repo.TokenRepo
 	PubKey      *rsa.PublicKey
@@ -27,6 +32,9 @@ type TrackingHandler struct {
 	Redis       *redis.Client
 	BotCfg      *config.BotConfiguration
 	GeoResolver *geo.Resolver
+	Webhooks    *webhook.Service
+	Bot         *bot.Pipeline
+	Challenges  *bot.Challenger
 }
 
 // GET /t/:token — JS-based click tracking page
@@ -37,6 +45,10 @@ func (h *TrackingHandler) HandleJSTrack(c *gin.Context) {
 		c.String(http.StatusBadRequest, "invalid token")
 		return
 	}
+	if tkn.Expired(time.Now()) {
+		c.String(http.StatusGone, "token expired")
+		return
+	}
 
 	pubPEM, err := security.PublicKeyPEM(h.PubKey)
 	if err != nil {
@@ -51,7 +63,13 @@ func (h *TrackingHandler) HandleJSTrack(c *gin.Context) {
 		return
 	}
 
-	html := sdk.GenerateClickPage(token, pubPEM, h.Config.SecurityConfiguration.KID, h.Config.ServiceConfiguration.ExportURL, target.URL)
+	appendClickID := false
+	if tracker, err := h.TrackerRepo.GetByID(tkn.TrackerID); err == nil {
+		appendClickID = tracker.AppendClickID
+	}
+
+	challenge := h.Challenges.Issue(time.Now())
+	html := sdk.GenerateClickPage(token, pubPEM, h.Config.SecurityConfiguration.KID, h.Config.ServiceConfiguration.ExportURL, target.URL, challenge.Seed, challenge.Bits, appendClickID)
 	c.Header("Content-Type", "text/html; charset=utf-8")
 	c.String(http.StatusOK, html)
 }
@@ -64,6 +82,10 @@ func (h *TrackingHandler) HandleRedirectTrack(c *gin.Context) {
 		c.String(http.StatusBadRequest, "invalid token")
 		return
 	}
+	if tkn.Expired(time.Now()) {
+		c.String(http.StatusGone, "token expired")
+		return
+	}
 
 	// Look up target URL from DB (never from token)
 	target, err := h.TargetRepo.GetByID(tkn.TargetID)
@@ -75,22 +97,39 @@ func (h *TrackingHandler) HandleRedirectTrack(c *gin.Context) {
 	// Bot detection (mark only, never block on 302)
 	ua := c.GetHeader("User-Agent")
 	lang := c.GetHeader("Accept-Language")
-	secFetch := c.GetHeader("Sec-Fetch-Mode")
 	referer := c.GetHeader("Referer")
-	recentHits := bot.CountRecentHits(c.Request.Context(), h.Redis, c.ClientIP())
-	botScore := bot.Score(ua, lang, secFetch, referer, recentHits)
-	_, suspected := bot.IsBot(botScore, h.BotCfg)
+	br := bot.FromHTTP(c.Request)
+	br.IP = c.ClientIP()
+	br.RecentHits = bot.CountRecentHits(c.Request.Context(), h.Redis, br.IP)
+	verdict := h.Bot.Score(c.Request.Context(), br)
+	_, suspected := bot.IsBot(verdict.Score, h.BotCfg)
+
+	// Apply the tracker's privacy settings after geo and bot scoring
+	privacySettings := privacy.Strictest
+	appendClickID := false
+	if tracker, err := h.TrackerRepo.GetByID(tkn.TrackerID); err == nil {
+		privacySettings = privacy.Settings{IPMode: tracker.IPMode, DropUA: tracker.DropUA}
+		appendClickID = tracker.AppendClickID
+	} else {
+		slog.Error("tracker lookup failed, storing click with the strictest privacy mode", "error", err, "token", token)
+	}
+	now := time.Now()
+	storedIP, storedUA := privacy.Apply(privacySettings, c.ClientIP(), ua, h.Config.SecurityConfiguration.TokenSecret, now)
+	browser, osName := repo.ParseUA(ua)
 
 	// Record click
 	click := &models.Click{
-		TS:           time.Now(),
+		TS:           now,
 		TrackerID:    tkn.TrackerID,
 		TargetID:     tkn.TargetID,
-		IP:           c.ClientIP(),
+		IP:           storedIP,
 		Country:      h.GeoResolver.Country(c.ClientIP()),
-		UA:           ua,
+		UA:           storedUA,
+		Browser:      browser,
+		OS:           osName,
 		Lang:         lang,
 		Referer:      referer,
+		Props:        verdict.Annotate(nil),
 		SuspectedBot: suspected,
 		IsBot:        suspected,
 	}
@@ -100,11 +139,50 @@ func (h *TrackingHandler) HandleRedirectTrack(c *gin.Context) {
 	if tkn.ChannelID != "" {
 		click.ChannelID = tkn.ChannelID
 	}
+	landing := target.URL
 	if err := h.ClickRepo.Create(click); err != nil {
 		slog.Error("failed to record click", "error", err, "token", token)
+	} else {
+		h.notifyClick(c, click)
+		if appendClickID {
+			landing = withClickID(target.URL, click.ID)
+		}
+	}
+
+	c.Redirect(http.StatusFound, landing)
+}
+
+// withClickID appends the click ID to the landing URL, keeping the target's
+// own query string as it is. Unparseable targets are left unchanged.
+func withClickID(target, clickID string) string {
+	u, err := url.Parse(target)
+	if err != nil || clickID == "" {
+		return target
 	}
+	param := repo.ClickIDParam + "=" + url.QueryEscape(clickID)
+	if u.RawQuery == "" {
+		u.RawQuery = param
+	} else {
+		u.RawQuery += "&" + param
+	}
+	return u.String()
+}
 
-	c.Redirect(http.StatusFound, target.URL)
+// notifyClick fans a recorded click out to live streams and webhooks.
+func (h *TrackingHandler) notifyClick(c *gin.Context, click *models.Click) {
+	ctx := c.Request.Context()
+	if err := live.Publish(ctx, h.Redis, live.ClickMessage(click)); err != nil {
+		slog.Warn("failed to publish live click", "error", err)
+	}
+	var err error
+	if click.IsBot {
+		err = h.Webhooks.RecordBotBlocked(ctx, webhook.Scope{TrackerID: click.TrackerID})
+	} else {
+		err = h.Webhooks.Emit(ctx, webhook.KindClick, webhook.Scope{TrackerID: click.TrackerID}, webhook.ClickData(click))
+	}
+	if err != nil {
+		slog.Warn("click webhook failed", "error", err)
+	}
 }
 
 // GET /sdk/track.js — serve the JS SDK
@@ -0,0 +1,129 @@
+package importer
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tracking/analysis/internal/models"
+	"golang.org/x/text/language"
+	"golang.org/x/text/language/display"
+)
+
+// ga4Params are the event_params mapped onto event columns rather than
+// props.
+var ga4Params = map[string]bool{
+	"page_location": true, "page_title": true, "page_referrer": true, "ga_session_id": true,
+}
+
+// mapGA4 maps a row of the GA4 BigQuery export (events_YYYYMMDD tables
+// exported as newline-delimited JSON). page_view becomes pageview, other
+// event names are kept, and the remaining event_params go into props.
+func mapGA4(rec map[string]any) ([]item, error) {
+	name, micros := str(rec["event_name"]), str(rec["event_timestamp"])
+	if name == "" || micros == "" {
+		return nil, errors.New("missing event_name or event_timestamp")
+	}
+	ts, err := unixTime(micros, time.Microsecond)
+	if err != nil {
+		return nil, err
+	}
+	params := ga4EventParams(rec["event_params"])
+
+	e := models.Event{
+		TS:        ts,
+		Type:      name,
+		VisitorID: str(rec["user_pseudo_id"]),
+		URL:       str(params["page_location"]),
+		Title:     str(params["page_title"]),
+		Referrer:  str(params["page_referrer"]),
+		Country:   countryCode(str(lookup(rec, "geo.country"))),
+		Browser:   str(lookup(rec, "device.web_info.browser")),
+		OS:        str(lookup(rec, "device.operating_system")),
+		Lang:      str(lookup(rec, "device.language")),
+		Props:     models.JSONMap{},
+	}
+	if name == "page_view" {
+		e.Type = "pageview"
+	}
+	if sid := str(params["ga_session_id"]); sid != "" && e.VisitorID != "" {
+		e.SessionID = e.VisitorID + "." + sid
+	}
+	for k, v := range params {
+		if !ga4Params[k] {
+			e.Props[k] = v
+		}
+	}
+	if uid := str(rec["user_id"]); uid != "" {
+		e.Props["user_id"] = uid
+	}
+	if len(e.Props) == 0 {
+		e.Props = nil
+	}
+
+	// BigQuery rows have no ID; these fields tell apart events that share
+	// a timestamp.
+	key := strings.Join([]string{e.VisitorID, micros, name, str(rec["event_bundle_sequence_id"]), str(rec["batch_event_index"])}, "|")
+	return []item{{key: key, event: e}}, nil
+}
+
+// ga4EventParams flattens event_params, a list of {key, value} where value
+// holds one of string_value, int_value, float_value or double_value.
+func ga4EventParams(v any) map[string]any {
+	list, _ := v.([]any)
+	params := make(map[string]any, len(list))
+	for _, p := range list {
+		p, _ := p.(map[string]any)
+		key := str(p["key"])
+		value, _ := p["value"].(map[string]any)
+		if key == "" || value == nil {
+			continue
+		}
+		for _, field := range []string{"string_value", "int_value", "float_value", "double_value"} {
+			if v := value[field]; v != nil {
+				// BigQuery writes INT64 values as JSON strings.
+				if s, ok := v.(string); ok && field == "int_value" {
+					v = json.Number(s)
+				}
+				params[key] = scalar(v)
+				break
+			}
+		}
+	}
+	return params
+}
+
+var (
+	countryOnce  sync.Once
+	countryNames map[string]string
+)
+
+// countryCode maps the English country name GA4 exports to an ISO 3166-1
+// code, via the CLDR names plus the spellings where GA4 differs.
+func countryCode(name string) string {
+	countryOnce.Do(func() {
+		countryNames = map[string]string{
+			"türkiye": "TR", "hong kong": "HK", "macao": "MO", "macau": "MO",
+			"palestine": "PS", "côte d'ivoire": "CI", "north macedonia": "MK",
+			"eswatini": "SZ", "cabo verde": "CV", "congo": "CG",
+			"democratic republic of the congo": "CD", "the bahamas": "BS",
+		}
+		names := display.English.Regions()
+		for a := 'A'; a <= 'Z'; a++ {
+			for b := 'A'; b <= 'Z'; b++ {
+				r, err := language.ParseRegion(string([]rune{a, b}))
+				if err != nil || !r.IsCountry() {
+					continue
+				}
+				if n := strings.ToLower(names.Name(r)); n != "" {
+					if _, ok := countryNames[n]; !ok {
+						countryNames[n] = r.String()
+					}
+				}
+			}
+		}
+	})
+	return countryNames[strings.ToLower(strings.TrimSpace(name))]
+}
@@ -0,0 +1,353 @@
+// Package importer maps exports from other analytics tools onto events, so
+// a site moving here keeps its history. Imported events carry their source
+// in ImportSource and a deterministic ID derived from the source record,
+// which makes re-running an import a no-op.
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tracking/analysis/internal/models"
+	"github.com/tracking/analysis/internal/privacy"
+	"github.com/tracking/analysis/internal/repo"
+)
+
+// Sources.
+const (
+	SourceMatomo    = "matomo"    // Live.getLastVisitsDetails visits
+	SourcePlausible = "plausible" // imported_pages daily rollups
+	SourceGA4       = "ga4"       // BigQuery event export
+	SourceMapping   = "mapping"   // any export, described by a Mapping
+)
+
+// Input formats. NDJSON input may also be a single JSON array of objects.
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+const (
+	batchSize = 1000
+	maxErrors = 20
+	maxType   = 50 // models.Event.Type column size
+)
+
+// ErrStore wraps errors from the Store, to tell them apart from bad input.
+var ErrStore = errors.New("storing imported events")
+
+// idNamespace seeds the name-based UUIDs of imported events.
+var idNamespace = uuid.MustParse("8f0f6bde-7c1e-4c55-9d1a-5b2f3c7a9e41")
+
+// Store is where imported events go; repo.EventStore implements it.
+type Store interface {
+	Import(events []models.Event) (int64, error)
+}
+
+type Options struct {
+	SiteID   string
+	Source   string
+	Format   string         // defaults to ndjson for GA4 and csv otherwise
+	Mapping  *Mapping       // required for SourceMapping
+	Location *time.Location // zone of date-only and zoneless times; defaults to UTC
+	Privacy  privacy.Settings
+	Secret   string // keys hashed IPs under privacy.IPModeHash
+}
+
+type Result struct {
+	Read       int      `json:"read"`       // input records
+	Imported   int64    `json:"imported"`   // events stored
+	Duplicates int64    `json:"duplicates"` // events already stored by an earlier run
+	Skipped    int      `json:"skipped"`    // records that could not be mapped
+	Errors     []string `json:"errors,omitempty"`
+	// Sessions is set by callers that rebuild the site's sessions over
+	// [First, Last] after the import.
+	Sessions    int64     `json:"sessions"`
+	First, Last time.Time `json:"-"` // time range of the mapped events
+}
+
+// item is a mapped event and the natural key its ID is derived from.
+type item struct {
+	key   string
+	event models.Event
+}
+
+type mapper func(rec map[string]any) ([]item, error)
+
+func newMapper(opts *Options) (mapper, error) {
+	switch opts.Source {
+	case SourceMatomo:
+		return mapMatomo, nil
+	case SourcePlausible:
+		return func(rec map[string]any) ([]item, error) { return mapPlausible(rec, opts.Location) }, nil
+	case SourceGA4:
+		if opts.Format == FormatCSV {
+			return nil, errors.New("ga4 exports are nested and must be ndjson")
+		}
+		return mapGA4, nil
+	case SourceMapping:
+		if opts.Mapping == nil {
+			return nil, errors.New("a mapping is required for the mapping source")
+		}
+		if err := opts.Mapping.Validate(); err != nil {
+			return nil, err
+		}
+		return func(rec map[string]any) ([]item, error) { return opts.Mapping.apply(rec, opts.Location) }, nil
+	}
+	return nil, fmt.Errorf("unsupported source %q", opts.Source)
+}
+
+// Validate fills in the default format and location and checks that the
+// options describe a supported import.
+func (o *Options) Validate() error {
+	if o.SiteID == "" {
+		return errors.New("a site is required")
+	}
+	if o.Format == "" {
+		o.Format = FormatCSV
+		if o.Source == SourceGA4 {
+			o.Format = FormatNDJSON
+		}
+	}
+	if o.Format != FormatCSV && o.Format != FormatNDJSON {
+		return fmt.Errorf("unsupported format %q", o.Format)
+	}
+	if o.Location == nil {
+		o.Location = time.UTC
+	}
+	_, err := newMapper(o)
+	return err
+}
+
+// Run reads the export in r, maps it onto events for opts.SiteID and
+// writes them to store in batches. Records that cannot be mapped are
+// skipped and counted; malformed input or a store error (wrapping
+// ErrStore) stops the run, and the result then covers the batches stored
+// so far.
+func Run(r io.Reader, opts Options, store Store) (Result, error) {
+	var res Result
+	if err := opts.Validate(); err != nil {
+		return res, err
+	}
+	m, _ := newMapper(&opts)
+
+	now := time.Now()
+	batch := make([]models.Event, 0, batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		n, err := store.Import(batch)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrStore, err)
+		}
+		res.Imported += n
+		res.Duplicates += int64(len(batch)) - n
+		batch = batch[:0]
+		return nil
+	}
+	err := eachRecord(r, opts.Format, func(n int, rec map[string]any) error {
+		res.Read++
+		items, err := m(rec)
+		if err != nil {
+			res.Skipped++
+			if len(res.Errors) < maxErrors {
+				res.Errors = append(res.Errors, fmt.Sprintf("record %d: %v", n, err))
+			}
+			return nil
+		}
+		for _, it := range items {
+			e := it.event
+			e.ID = uuid.NewSHA1(idNamespace, []byte(opts.SiteID+"\x00"+opts.Source+"\x00"+it.key)).String()
+			e.SiteID = opts.SiteID
+			e.ImportSource = opts.Source
+			e.ServerTS = e.TS
+			e.CreatedAt = now
+			if len(e.Type) > maxType {
+				e.Type = e.Type[:maxType]
+			}
+			if e.UA != "" && e.Browser == "" && e.OS == "" {
+				e.Browser, e.OS = repo.ParseUA(e.UA)
+			}
+			ip, ua := privacy.Apply(opts.Privacy, e.IP, e.UA, opts.Secret, e.TS)
+			if e.IP != "" {
+				e.IP = ip
+			}
+			e.UA = ua
+			if res.First.IsZero() || e.TS.Before(res.First) {
+				res.First = e.TS
+			}
+			if e.TS.After(res.Last) {
+				res.Last = e.TS
+			}
+			batch = append(batch, e)
+			if len(batch) == batchSize {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	return res, err
+}
+
+// eachRecord calls fn with every record of r, numbered from 1. CSV
+// records are keyed by the header row and hold strings; JSON numbers are
+// kept as json.Number.
+func eachRecord(r io.Reader, format string, fn func(n int, rec map[string]any) error) error {
+	br := bufio.NewReader(r)
+	if bom, _ := br.Peek(3); bytes.Equal(bom, []byte("\xef\xbb\xbf")) {
+		br.Discard(3)
+	}
+	if format == FormatCSV {
+		cr := csv.NewReader(br)
+		cr.FieldsPerRecord = -1
+		header, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for n := 1; ; n++ {
+			row, err := cr.Read()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			rec := make(map[string]any, len(header))
+			for i, name := range header {
+				if i < len(row) {
+					rec[strings.TrimSpace(name)] = row[i]
+				}
+			}
+			if err := fn(n, rec); err != nil {
+				return err
+			}
+		}
+	}
+
+	dec := json.NewDecoder(br)
+	dec.UseNumber()
+	array := false
+	if first, err := firstByte(br); err != nil {
+		return nil // empty input
+	} else if first == '[' {
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+		array = true
+	}
+	for n := 1; ; n++ {
+		if array && !dec.More() {
+			return nil
+		}
+		var rec map[string]any
+		if err := dec.Decode(&rec); err == io.EOF && !array {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("record %d: %w", n, err)
+		}
+		if err := fn(n, rec); err != nil {
+			return err
+		}
+	}
+}
+
+// firstByte peeks at the first non-space byte of br.
+func firstByte(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+			return b, br.UnreadByte()
+		}
+	}
+}
+
+// lookup returns the value at a dotted path of nested objects, or, for
+// flat records such as CSV rows, the value keyed by the whole path.
+func lookup(rec map[string]any, path string) any {
+	if v, ok := rec[path]; ok {
+		return v
+	}
+	head, rest, ok := strings.Cut(path, ".")
+	if !ok {
+		return nil
+	}
+	if sub, ok := rec[head].(map[string]any); ok {
+		return lookup(sub, rest)
+	}
+	return nil
+}
+
+// str renders a scalar record value as a string; nested values and nulls
+// are empty.
+func str(v any) string {
+	switch v := v.(type) {
+	case string:
+		return strings.TrimSpace(v)
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
+
+// scalar converts a record value for storage in props, keeping numbers
+// numeric.
+func scalar(v any) any {
+	if n, ok := v.(json.Number); ok {
+		if i, err := n.Int64(); err == nil {
+			return i
+		}
+		if f, err := n.Float64(); err == nil {
+			return f
+		}
+	}
+	return v
+}
+
+// unixTime parses a Unix timestamp in the given unit, accepting fractions.
+func unixTime(s string, unit time.Duration) (time.Time, error) {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(0, 0).Add(time.Duration(i) * unit).UTC(), nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", s)
+	}
+	return time.Unix(0, int64(f*float64(unit))).UTC(), nil
+}
+
+// country normalises a two-letter country code, dropping the placeholders
+// tools use for unknown locations.
+func country(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if len(code) != 2 || code == "XX" || code == "ZZ" {
+		return ""
+	}
+	return code
+}
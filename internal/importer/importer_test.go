@@ -0,0 +1,262 @@
+package importer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tracking/analysis/internal/models"
+	"github.com/tracking/analysis/internal/privacy"
+)
+
+// memStore keeps imported events by ID, like the ON CONFLICT insert.
+type memStore struct {
+	events map[string]models.Event
+	err    error
+}
+
+func (s *memStore) Import(events []models.Event) (int64, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	if s.events == nil {
+		s.events = map[string]models.Event{}
+	}
+	var n int64
+	for _, e := range events {
+		if _, ok := s.events[e.ID]; !ok {
+			s.events[e.ID] = e
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *memStore) byType(typ string) []models.Event {
+	var out []models.Event
+	for _, e := range s.events {
+		if e.Type == typ {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+const matomoJSON = `[{
+	"idVisit": "42", "visitorId": "abc123", "visitIp": "203.0.113.9", "countryCode": "de",
+	"browserName": "Firefox", "operatingSystemName": "Linux", "languageCode": "de-de",
+	"referrerUrl": "https://search.test/", "userId": null,
+	"actionDetails": [
+		{"type": "action", "url": "https://ex.test/", "pageTitle": "Home", "timestamp": 1709528400},
+		{"type": "event", "eventCategory": "video", "eventAction": "play", "eventName": "intro", "eventValue": 3, "timestamp": 1709528460},
+		{"type": "goal", "goalName": "Signup", "goalId": "1", "revenue": 0, "timestamp": 1709528500}
+	]
+}]`
+
+func TestRun_Matomo(t *testing.T) {
+	store := &memStore{}
+	res, err := Run(strings.NewReader(matomoJSON), Options{SiteID: "s1", Source: SourceMatomo, Format: FormatNDJSON}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Read != 1 || res.Imported != 3 || res.Skipped != 0 {
+		t.Fatalf("result = %+v", res)
+	}
+
+	pv := store.byType("pageview")
+	if len(pv) != 1 {
+		t.Fatalf("pageviews = %v", pv)
+	}
+	e := pv[0]
+	if e.SiteID != "s1" || e.ImportSource != SourceMatomo || e.SessionID != "matomo-42" || e.VisitorID != "abc123" ||
+		e.Country != "DE" || e.Browser != "Firefox" || e.Title != "Home" || e.Referrer != "https://search.test/" ||
+		!e.TS.Equal(time.Unix(1709528400, 0)) || !e.ServerTS.Equal(e.TS) {
+		t.Errorf("pageview = %+v", e)
+	}
+
+	play := store.byType("play")
+	if len(play) != 1 || play[0].Props["category"] != "video" || play[0].Props["name"] != "intro" || play[0].Props["value"] != int64(3) {
+		t.Errorf("event = %+v", play)
+	}
+	if play[0].Referrer != "" {
+		t.Errorf("referrer repeated on later action: %q", play[0].Referrer)
+	}
+	if goal := store.byType("goal"); len(goal) != 1 || goal[0].Props["goal"] != "Signup" {
+		t.Errorf("goal = %+v", goal)
+	}
+}
+
+func TestRun_MatomoCSV(t *testing.T) {
+	csv := "idVisit,visitorId,countryCode,actionDetails_0_type,actionDetails_0_url,actionDetails_0_timestamp,actionDetails_1_type,actionDetails_1_url,actionDetails_1_timestamp\n" +
+		"7,v7,xx,action,https://ex.test/a,1709528400,outlink,https://other.test/,1709528410\n" +
+		"8,v8,fr,,,,,,\n"
+	store := &memStore{}
+	res, err := Run(strings.NewReader(csv), Options{SiteID: "s1", Source: SourceMatomo}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Read != 2 || res.Imported != 2 || res.Skipped != 1 || len(res.Errors) != 1 {
+		t.Fatalf("result = %+v", res)
+	}
+	out := store.byType("outlink")
+	if len(out) != 1 || out[0].URL != "https://other.test/" || out[0].Country != "" {
+		t.Errorf("outlink = %+v", out)
+	}
+}
+
+func TestRun_RerunAddsNothing(t *testing.T) {
+	store := &memStore{}
+	opts := Options{SiteID: "s1", Source: SourceMatomo, Format: FormatNDJSON}
+	if _, err := Run(strings.NewReader(matomoJSON), opts, store); err != nil {
+		t.Fatal(err)
+	}
+	res, err := Run(strings.NewReader(matomoJSON), opts, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Imported != 0 || res.Duplicates != 3 || len(store.events) != 3 {
+		t.Errorf("rerun = %+v, stored %d", res, len(store.events))
+	}
+
+	// The same export for another site is not a duplicate.
+	opts.SiteID = "s2"
+	if res, _ := Run(strings.NewReader(matomoJSON), opts, store); res.Imported != 3 {
+		t.Errorf("other site imported %d", res.Imported)
+	}
+}
+
+func TestRun_Plausible(t *testing.T) {
+	csv := "date,hostname,page,visitors,pageviews,exits,time_on_page\n" +
+		"2024-03-04,ex.test,/pricing,2,5,1,30\n" +
+		"2024-03-04,ex.test,/,1,1,1,10\n"
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	store := &memStore{}
+	res, err := Run(strings.NewReader(csv), Options{SiteID: "s1", Source: SourcePlausible, Location: berlin}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Imported != 6 {
+		t.Fatalf("result = %+v", res)
+	}
+	if noon := time.Date(2024, 3, 4, 11, 0, 0, 0, time.UTC); !res.First.Equal(noon) || !res.Last.Equal(noon) {
+		t.Errorf("range = %v - %v", res.First, res.Last)
+	}
+	visitors := map[string]bool{}
+	for _, e := range store.events {
+		if e.URL == "https://ex.test/" {
+			if visitors[e.VisitorID] {
+				t.Errorf("visitor %s shared across pages", e.VisitorID)
+			}
+			continue
+		}
+		visitors[e.VisitorID] = true
+		if e.URL != "https://ex.test/pricing" || e.Type != "pageview" || e.Props["rollup"] != true ||
+			!e.TS.Equal(time.Date(2024, 3, 4, 11, 0, 0, 0, time.UTC)) {
+			t.Errorf("event = %+v", e)
+		}
+	}
+	if len(visitors) != 2 {
+		t.Errorf("visitors = %v", visitors)
+	}
+}
+
+func TestRun_GA4(t *testing.T) {
+	ndjson := `{"event_date":"20240304","event_timestamp":"1709528400123456","event_name":"page_view","user_pseudo_id":"123.456",` +
+		`"event_params":[{"key":"page_location","value":{"string_value":"https://ex.test/"}},{"key":"ga_session_id","value":{"int_value":"1709528400"}},` +
+		`{"key":"engagement_time_msec","value":{"int_value":"1200"}},{"key":"percent","value":{"double_value":0.5}}],` +
+		`"device":{"operating_system":"Windows","language":"en-us","web_info":{"browser":"Chrome"}},"geo":{"country":"United States"}}
+{"event_timestamp":1709528401000000,"event_name":"purchase","user_pseudo_id":"123.456","geo":{"country":"Türkiye"}}
+`
+	store := &memStore{}
+	res, err := Run(strings.NewReader(ndjson), Options{SiteID: "s1", Source: SourceGA4}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Imported != 2 {
+		t.Fatalf("result = %+v", res)
+	}
+	pv := store.byType("pageview")
+	if len(pv) != 1 {
+		t.Fatalf("pageviews = %v", store.events)
+	}
+	e := pv[0]
+	if e.URL != "https://ex.test/" || e.SessionID != "123.456.1709528400" || e.Country != "US" || e.Browser != "Chrome" ||
+		e.OS != "Windows" || !e.TS.Equal(time.UnixMicro(1709528400123456)) {
+		t.Errorf("pageview = %+v", e)
+	}
+	if e.Props["engagement_time_msec"] != int64(1200) || e.Props["percent"] != 0.5 || e.Props["page_location"] != nil {
+		t.Errorf("props = %v", e.Props)
+	}
+	if p := store.byType("purchase"); len(p) != 1 || p[0].Country != "TR" {
+		t.Errorf("purchase = %+v", p)
+	}
+
+	if _, err := Run(strings.NewReader(""), Options{SiteID: "s1", Source: SourceGA4, Format: FormatCSV}, store); err == nil {
+		t.Error("ga4 csv accepted")
+	}
+}
+
+func TestRun_Mapping(t *testing.T) {
+	m, err := ParseMapping([]byte(`{
+		"fields": {"ts": "time", "type": "event", "url": "page.url", "visitor_id": "uid", "ip": "ip", "ua": "ua"},
+		"ts_format": "unix_ms",
+		"defaults": {"type": "pageview"},
+		"id": "event_id",
+		"props": ["page.section"]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ndjson := `{"event_id":"a1","time":1709528400000,"uid":"u1","ip":"198.51.100.7","ua":"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36","page":{"url":"https://ex.test/docs","section":"docs"}}
+{"event_id":"a2","time":1709528401000,"event":"signup","uid":"u1"}
+{"time":1709528402000,"uid":"u2"}
+`
+	store := &memStore{}
+	opts := Options{SiteID: "s1", Source: SourceMapping, Format: FormatNDJSON, Mapping: m, Privacy: privacy.Settings{IPMode: privacy.IPModeTruncate, DropUA: true}}
+	res, err := Run(strings.NewReader(ndjson), opts, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Imported != 2 || res.Skipped != 1 || !strings.Contains(res.Errors[0], "record 3: missing event_id") {
+		t.Fatalf("result = %+v", res)
+	}
+	pv := store.byType("pageview")
+	if len(pv) != 1 || pv[0].URL != "https://ex.test/docs" || pv[0].Props["section"] != "docs" {
+		t.Fatalf("pageview = %+v", pv)
+	}
+	if pv[0].IP != "198.51.100.0" || pv[0].UA != "" || pv[0].Browser != "Chrome" || pv[0].OS != "Windows" {
+		t.Errorf("privacy not applied: %+v", pv[0])
+	}
+	if len(store.byType("signup")) != 1 {
+		t.Errorf("signup not imported: %v", store.events)
+	}
+}
+
+func TestParseMapping_Invalid(t *testing.T) {
+	for _, doc := range []string{
+		`{"fields": {"type": "event"}}`,
+		`{"fields": {"ts": "t", "colour": "c"}, "defaults": {"type": "x"}}`,
+		`{"fields": {"ts": "t"}}`,
+		`not json`,
+	} {
+		if _, err := ParseMapping([]byte(doc)); err == nil {
+			t.Errorf("%s: accepted", doc)
+		}
+	}
+}
+
+func TestRun_StoreError(t *testing.T) {
+	store := &memStore{err: errors.New("down")}
+	_, err := Run(strings.NewReader(matomoJSON), Options{SiteID: "s1", Source: SourceMatomo, Format: FormatNDJSON}, store)
+	if !errors.Is(err, ErrStore) {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestRun_MalformedInput(t *testing.T) {
+	_, err := Run(strings.NewReader(`{"idVisit":`), Options{SiteID: "s1", Source: SourceMatomo, Format: FormatNDJSON}, &memStore{})
+	if err == nil || errors.Is(err, ErrStore) {
+		t.Errorf("err = %v", err)
+	}
+}
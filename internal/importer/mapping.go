@@ -0,0 +1,155 @@
+package importer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tracking/analysis/internal/models"
+)
+
+// Mapping describes how the columns of an arbitrary export map onto
+// events. Column paths may be dotted to reach into nested JSON objects.
+//
+//	{
+//	  "fields": {"ts": "time", "type": "event", "url": "page.url", "visitor_id": "uid"},
+//	  "ts_format": "unix_ms",
+//	  "defaults": {"type": "pageview"},
+//	  "id": "event_id",
+//	  "props": ["plan", "utm.source"]
+//	}
+type Mapping struct {
+	// Fields maps event fields to columns; ts is required.
+	Fields map[string]string `json:"fields"`
+	// TSFormat is unix, unix_ms, unix_us, rfc3339 (the default) or a Go
+	// time layout, read in the import's location when it has no zone.
+	TSFormat string `json:"ts_format"`
+	// Defaults fill event fields whose column is empty or unmapped.
+	Defaults map[string]string `json:"defaults"`
+	// ID names a column identifying each record, so re-imports of edited
+	// exports still de-duplicate. Without it the whole record is the key.
+	ID string `json:"id"`
+	// Props lists columns copied into props, keyed by their last segment.
+	Props []string `json:"props"`
+}
+
+// mappingFields are the event fields a Mapping can set.
+var mappingFields = map[string]func(e *models.Event, v string){
+	"type":       func(e *models.Event, v string) { e.Type = v },
+	"visitor_id": func(e *models.Event, v string) { e.VisitorID = v },
+	"session_id": func(e *models.Event, v string) { e.SessionID = v },
+	"url":        func(e *models.Event, v string) { e.URL = v },
+	"title":      func(e *models.Event, v string) { e.Title = v },
+	"referrer":   func(e *models.Event, v string) { e.Referrer = v },
+	"ip":         func(e *models.Event, v string) { e.IP = v },
+	"country":    func(e *models.Event, v string) { e.Country = country(v) },
+	"ua":         func(e *models.Event, v string) { e.UA = v },
+	"browser":    func(e *models.Event, v string) { e.Browser = v },
+	"os":         func(e *models.Event, v string) { e.OS = v },
+	"lang":       func(e *models.Event, v string) { e.Lang = v },
+}
+
+// ParseMapping decodes and validates a JSON mapping file.
+func ParseMapping(data []byte) (*Mapping, error) {
+	var m Mapping
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("mapping: %w", err)
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (m *Mapping) Validate() error {
+	if m.Fields["ts"] == "" {
+		return errors.New("mapping: fields.ts is required")
+	}
+	for field := range m.Fields {
+		if _, ok := mappingFields[field]; !ok && field != "ts" {
+			return fmt.Errorf("mapping: unknown field %q", field)
+		}
+	}
+	for field := range m.Defaults {
+		if _, ok := mappingFields[field]; !ok {
+			return fmt.Errorf("mapping: unknown default %q", field)
+		}
+	}
+	if m.Fields["type"] == "" && m.Defaults["type"] == "" {
+		return errors.New("mapping: fields.type or defaults.type is required")
+	}
+	return nil
+}
+
+func (m *Mapping) apply(rec map[string]any, loc *time.Location) ([]item, error) {
+	raw := str(lookup(rec, m.Fields["ts"]))
+	if raw == "" {
+		return nil, errors.New("missing timestamp")
+	}
+	ts, err := m.parseTS(raw, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	e := models.Event{TS: ts}
+	for field, set := range mappingFields {
+		v := ""
+		if col := m.Fields[field]; col != "" {
+			v = str(lookup(rec, col))
+		}
+		if v == "" {
+			v = m.Defaults[field]
+		}
+		set(&e, v)
+	}
+	if e.Type == "" {
+		return nil, errors.New("missing type")
+	}
+	for _, col := range m.Props {
+		if v := lookup(rec, col); v != nil && v != "" {
+			if e.Props == nil {
+				e.Props = models.JSONMap{}
+			}
+			e.Props[col[strings.LastIndex(col, ".")+1:]] = scalar(v)
+		}
+	}
+
+	var key string
+	if m.ID != "" {
+		if key = str(lookup(rec, m.ID)); key == "" {
+			return nil, fmt.Errorf("missing %s", m.ID)
+		}
+	} else {
+		// Maps marshal with sorted keys, so equal records share a key.
+		b, err := json.Marshal(rec)
+		if err != nil {
+			return nil, err
+		}
+		key = string(b)
+	}
+	return []item{{key: key, event: e}}, nil
+}
+
+func (m *Mapping) parseTS(s string, loc *time.Location) (time.Time, error) {
+	switch m.TSFormat {
+	case "unix":
+		return unixTime(s, time.Second)
+	case "unix_ms":
+		return unixTime(s, time.Millisecond)
+	case "unix_us":
+		return unixTime(s, time.Microsecond)
+	case "", "rfc3339":
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid timestamp %q", s)
+		}
+		return t.UTC(), nil
+	}
+	t, err := time.ParseInLocation(m.TSFormat, s, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", s)
+	}
+	return t.UTC(), nil
+}
@@ -0,0 +1,141 @@
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tracking/analysis/internal/models"
+)
+
+// mapMatomo maps one visit from Matomo's Live.getLastVisitsDetails API.
+// Every entry of actionDetails becomes an event: page views as pageview,
+// custom events under their action, and goals, outlinks, downloads and
+// site searches under their action type. CSV exports flatten the actions
+// into actionDetails_<n>_<field> columns.
+func mapMatomo(visit map[string]any) ([]item, error) {
+	idVisit := str(visit["idVisit"])
+	if idVisit == "" {
+		return nil, errors.New("missing idVisit")
+	}
+	actions := matomoActions(visit)
+	if len(actions) == 0 {
+		return nil, errors.New("visit has no actionDetails")
+	}
+
+	base := models.Event{
+		VisitorID: str(visit["visitorId"]),
+		SessionID: "matomo-" + idVisit,
+		IP:        str(visit["visitIp"]),
+		Country:   country(str(visit["countryCode"])),
+		Browser:   str(visit["browserName"]),
+		OS:        str(visit["operatingSystemName"]),
+		Lang:      str(visit["languageCode"]),
+	}
+	if uid := str(visit["userId"]); uid != "" {
+		base.Props = models.JSONMap{"user_id": uid}
+	}
+
+	items := make([]item, 0, len(actions))
+	for i, a := range actions {
+		ts := str(a["timestamp"])
+		if ts == "" {
+			return nil, fmt.Errorf("action %d: missing timestamp", i)
+		}
+		t, err := unixTime(ts, time.Second)
+		if err != nil {
+			return nil, fmt.Errorf("action %d: %w", i, err)
+		}
+		e := base
+		e.TS = t
+		e.URL = str(a["url"])
+		e.Props = models.JSONMap{}
+		for k, v := range base.Props {
+			e.Props[k] = v
+		}
+		if i == 0 {
+			e.Referrer = str(visit["referrerUrl"])
+		}
+
+		switch typ := str(a["type"]); typ {
+		case "action":
+			e.Type = "pageview"
+			e.Title = str(a["pageTitle"])
+		case "event":
+			e.Type = str(a["eventAction"])
+			if e.Type == "" {
+				e.Type = "event"
+			}
+			setProp(e.Props, "category", a["eventCategory"])
+			setProp(e.Props, "name", a["eventName"])
+			setProp(e.Props, "value", a["eventValue"])
+		case "goal":
+			e.Type = "goal"
+			setProp(e.Props, "goal", a["goalName"])
+			setProp(e.Props, "goal_id", a["goalId"])
+			setProp(e.Props, "revenue", a["revenue"])
+		case "search":
+			e.Type = "search"
+			setProp(e.Props, "keyword", a["siteSearchKeyword"])
+		case "":
+			return nil, fmt.Errorf("action %d: missing type", i)
+		default: // outlink, download, ecommerceOrder, ...
+			e.Type = typ
+		}
+		if len(e.Props) == 0 {
+			e.Props = nil
+		}
+		items = append(items, item{key: idVisit + "/" + strconv.Itoa(i), event: e})
+	}
+	return items, nil
+}
+
+// matomoActions returns a visit's actions, unflattening CSV columns.
+func matomoActions(visit map[string]any) []map[string]any {
+	if list, ok := visit["actionDetails"].([]any); ok {
+		actions := make([]map[string]any, 0, len(list))
+		for _, a := range list {
+			if m, ok := a.(map[string]any); ok {
+				actions = append(actions, m)
+			}
+		}
+		return actions
+	}
+
+	byIndex := map[int]map[string]any{}
+	for col, v := range visit {
+		rest, ok := strings.CutPrefix(col, "actionDetails_")
+		if !ok {
+			continue
+		}
+		idx, field, ok := strings.Cut(rest, "_")
+		n, err := strconv.Atoi(idx)
+		if !ok || err != nil || str(v) == "" {
+			continue
+		}
+		if byIndex[n] == nil {
+			byIndex[n] = map[string]any{}
+		}
+		byIndex[n][field] = v
+	}
+	indexes := make([]int, 0, len(byIndex))
+	for n := range byIndex {
+		indexes = append(indexes, n)
+	}
+	sort.Ints(indexes)
+	actions := make([]map[string]any, len(indexes))
+	for i, n := range indexes {
+		actions[i] = byIndex[n]
+	}
+	return actions
+}
+
+func setProp(props models.JSONMap, key string, v any) {
+	if v == nil || v == "" {
+		return
+	}
+	props[key] = scalar(v)
+}
@@ -0,0 +1,82 @@
+package importer
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tracking/analysis/internal/models"
+)
+
+// maxRollupViews bounds the events one rollup row expands into.
+const maxRollupViews = 100000
+
+// mapPlausible expands a row of Plausible's imported_pages export (date,
+// hostname, page, visitors, pageviews) into its page views. Plausible only
+// keeps daily totals, so the events are placed at noon of the day and
+// spread over synthetic visitors scoped to the page, since the export
+// cannot tell whether two pages' visitors were the same people. Counts and
+// unique visitors per day and page match the source; site-wide unique
+// visitors are the sum over pages, an upper bound. The events are flagged
+// with the rollup prop.
+func mapPlausible(rec map[string]any, loc *time.Location) ([]item, error) {
+	date, page := str(rec["date"]), str(rec["page"])
+	if date == "" || page == "" {
+		return nil, errors.New("missing date or page")
+	}
+	day, err := time.ParseInLocation("2006-01-02", date, loc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid date %q", date)
+	}
+	views, err := count(rec, "pageviews")
+	if err != nil {
+		return nil, err
+	}
+	if views > maxRollupViews {
+		return nil, fmt.Errorf("%d pageviews exceed the limit of %d per row", views, maxRollupViews)
+	}
+	visitors, err := count(rec, "visitors")
+	if err != nil {
+		return nil, err
+	}
+	if visitors < 1 || visitors > views {
+		visitors = max(views, 1)
+	}
+
+	host := str(rec["hostname"])
+	url := page
+	if host != "" && strings.HasPrefix(page, "/") {
+		url = "https://" + host + page
+	}
+	ts := time.Date(day.Year(), day.Month(), day.Day(), 12, 0, 0, 0, loc).UTC()
+	key := date + "|" + host + "|" + page
+	pageHash := sha1.Sum([]byte(key))
+	items := make([]item, views)
+	for k := range items {
+		visitor := fmt.Sprintf("plausible-%x-%d", pageHash[:8], k%visitors)
+		items[k] = item{key: key + "|" + strconv.Itoa(k), event: models.Event{
+			TS:        ts,
+			Type:      "pageview",
+			VisitorID: visitor,
+			SessionID: visitor,
+			URL:       url,
+			Props:     models.JSONMap{"rollup": true},
+		}}
+	}
+	return items, nil
+}
+
+func count(rec map[string]any, col string) (int, error) {
+	s := str(rec[col])
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q", col, s)
+	}
+	return n, nil
+}
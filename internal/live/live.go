@@ -0,0 +1,185 @@
+// Package live fans ingested clicks and events out to admin stream
+// subscribers through Redis pub/sub, so every replica sees every message.
+package live
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/tracking/analysis/internal/models"
+)
+
+const (
+	// Channel is the pub/sub channel carrying every live message.
+	Channel = "live:stream"
+	// ActiveWindow is how recently a visitor must have been seen to count
+	// as active.
+	ActiveWindow = 5 * time.Minute
+	// TicketTTL is how long a stream ticket can be redeemed after it is
+	// issued.
+	TicketTTL = 30 * time.Second
+)
+
+// Message is the stream form of a click or event. It carries no IP or
+// User-Agent, only what a wall display needs.
+type Message struct {
+	Kind       string    `json:"kind"` // "click" or "event"
+	TS         time.Time `json:"ts"`
+	TrackerID  string    `json:"tracker_id,omitempty"`
+	CampaignID string    `json:"campaign_id,omitempty"`
+	ChannelID  string    `json:"channel_id,omitempty"`
+	SiteID     string    `json:"site_id,omitempty"`
+	Type       string    `json:"type,omitempty"`
+	URL        string    `json:"url,omitempty"`
+	Country    string    `json:"country,omitempty"`
+	Browser    string    `json:"browser,omitempty"`
+	IsBot      bool      `json:"is_bot"`
+
+	visitor string
+}
+
+func ClickMessage(c *models.Click) Message {
+	return Message{
+		Kind:       "click",
+		TS:         c.TS,
+		TrackerID:  c.TrackerID,
+		CampaignID: c.CampaignID,
+		ChannelID:  c.ChannelID,
+		Country:    c.Country,
+		Browser:    c.Browser,
+		IsBot:      c.IsBot,
+		visitor:    firstNonEmpty(c.VisitorID, c.IP),
+	}
+}
+
+func EventMessage(e *models.Event) Message {
+	return Message{
+		Kind:    "event",
+		TS:      e.TS,
+		SiteID:  e.SiteID,
+		Type:    e.Type,
+		URL:     e.URL,
+		Country: e.Country,
+		Browser: e.Browser,
+		IsBot:   e.IsBot,
+		visitor: firstNonEmpty(e.VisitorID, e.SessionID, e.IP),
+	}
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// activeKey is the sorted set of visitors seen recently in a scope, scored
+// by last-seen Unix time. scope is "site", "tracker" or "all". Scores only
+// move forward, so events delivered late cannot age out a visitor.
+func activeKey(scope, id string) string {
+	if scope == "all" {
+		return "live:active:all"
+	}
+	return fmt.Sprintf("live:active:%s:%s", scope, id)
+}
+
+// Publish broadcasts msgs and marks their non-bot visitors active,
+// trimming visitors who went quiet from the sets it touches so they stay
+// bounded while no stream is reading them. Errors are returned for
+// logging only; ingestion must not fail because of them.
+func Publish(ctx context.Context, rdb *redis.Client, msgs ...Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	pipe := rdb.Pipeline()
+	touched := make(map[string]bool)
+	for _, m := range msgs {
+		data, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		pipe.Publish(ctx, Channel, data)
+
+		if m.IsBot || m.visitor == "" {
+			continue
+		}
+		member := redis.Z{Score: float64(m.TS.Unix()), Member: m.visitor}
+		keys := []string{activeKey("all", "")}
+		if m.SiteID != "" {
+			keys = append(keys, activeKey("site", m.SiteID))
+		}
+		if m.TrackerID != "" {
+			keys = append(keys, activeKey("tracker", m.TrackerID))
+		}
+		for _, k := range keys {
+			pipe.ZAddGT(ctx, k, member)
+			touched[k] = true
+		}
+	}
+	cutoff := fmt.Sprintf("(%d", time.Now().Add(-ActiveWindow).Unix())
+	for k := range touched {
+		pipe.ZRemRangeByScore(ctx, k, "-inf", cutoff)
+		pipe.Expire(ctx, k, 2*ActiveWindow)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// ActiveVisitors counts visitors seen in a scope within ActiveWindow of now.
+func ActiveVisitors(ctx context.Context, rdb *redis.Client, scope, id string, now time.Time) (int64, error) {
+	key := activeKey(scope, id)
+	cutoff := now.Add(-ActiveWindow).Unix()
+	pipe := rdb.Pipeline()
+	pipe.ZRemRangeByScore(ctx, key, "-inf", fmt.Sprintf("(%d", cutoff))
+	count := pipe.ZCard(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return count.Val(), nil
+}
+
+// Matches reports whether m passes the stream filters; empty filters match all.
+func (m *Message) Matches(siteID, trackerID string) bool {
+	if siteID != "" && m.SiteID != siteID {
+		return false
+	}
+	if trackerID != "" && m.TrackerID != trackerID {
+		return false
+	}
+	return true
+}
+
+func ticketKey(ticket string) string {
+	return "live:ticket:" + ticket
+}
+
+// IssueTicket stores a random single-use ticket that opens one stream
+// within TicketTTL. EventSource cannot set headers, so the ticket travels in
+// the URL instead of the admin token, which would be left in access logs.
+func IssueTicket(ctx context.Context, rdb *redis.Client) (string, error) {
+	b := make([]byte, 16)
+	rand.Read(b)
+	ticket := hex.EncodeToString(b)
+	if err := rdb.Set(ctx, ticketKey(ticket), 1, TicketTTL).Err(); err != nil {
+		return "", err
+	}
+	return ticket, nil
+}
+
+// RedeemTicket consumes ticket, reporting whether it was issued and has not
+// expired or been used.
+func RedeemTicket(ctx context.Context, rdb *redis.Client, ticket string) (bool, error) {
+	err := rdb.GetDel(ctx, ticketKey(ticket)).Err()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,162 @@
+package live
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/tracking/analysis/internal/models"
+)
+
+func setupRedis(t *testing.T) *redis.Client {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	return redis.NewClient(&redis.Options{Addr: mr.Addr()})
+}
+
+func TestPublish_ActiveVisitors(t *testing.T) {
+	rdb := setupRedis(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	msgs := []Message{
+		EventMessage(&models.Event{SiteID: "site-1", VisitorID: "v1", TS: now}),
+		EventMessage(&models.Event{SiteID: "site-1", VisitorID: "v1", TS: now}),
+		EventMessage(&models.Event{SiteID: "site-1", SessionID: "s2", TS: now.Add(-time.Minute)}),
+		EventMessage(&models.Event{SiteID: "site-1", VisitorID: "bot", TS: now, IsBot: true}),
+		EventMessage(&models.Event{SiteID: "site-2", VisitorID: "v3", TS: now.Add(-10 * time.Minute)}),
+		ClickMessage(&models.Click{TrackerID: "tr-1", VisitorID: "v4", TS: now}),
+	}
+	if err := Publish(ctx, rdb, msgs...); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+
+	tests := []struct {
+		scope, id string
+		want      int64
+	}{
+		{"all", "", 3},
+		{"site", "site-1", 2},
+		{"site", "site-2", 0},
+		{"tracker", "tr-1", 1},
+	}
+	for _, tt := range tests {
+		got, err := ActiveVisitors(ctx, rdb, tt.scope, tt.id, now)
+		if err != nil {
+			t.Fatalf("ActiveVisitors(%s, %s): %v", tt.scope, tt.id, err)
+		}
+		if got != tt.want {
+			t.Errorf("ActiveVisitors(%s, %s) = %d, want %d", tt.scope, tt.id, got, tt.want)
+		}
+	}
+
+	got, _ := ActiveVisitors(ctx, rdb, "site", "site-1", now.Add(ActiveWindow))
+	if got != 1 {
+		t.Errorf("after window: got %d active, want 1", got)
+	}
+}
+
+func TestPublish_LateEventKeepsLastSeen(t *testing.T) {
+	rdb := setupRedis(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	// A batch flushed late from the SDK outbox must not move v1's last-seen
+	// time back behind the event that arrived before it.
+	Publish(ctx, rdb, EventMessage(&models.Event{SiteID: "site-1", VisitorID: "v1", TS: now}))
+	Publish(ctx, rdb, EventMessage(&models.Event{SiteID: "site-1", VisitorID: "v1", TS: now.Add(-time.Hour)}))
+
+	if got, _ := ActiveVisitors(ctx, rdb, "site", "site-1", now); got != 1 {
+		t.Errorf("got %d active, want 1", got)
+	}
+}
+
+func TestPublish_TrimsInactive(t *testing.T) {
+	rdb := setupRedis(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	// Without a stream open nothing calls ActiveVisitors, so publishing
+	// must drop visitors who went quiet on its own
+	Publish(ctx, rdb, EventMessage(&models.Event{SiteID: "site-1", VisitorID: "v1", TS: now.Add(-time.Hour)}))
+	Publish(ctx, rdb, EventMessage(&models.Event{SiteID: "site-1", VisitorID: "v2", TS: now}))
+
+	for _, key := range []string{activeKey("all", ""), activeKey("site", "site-1")} {
+		members, err := rdb.ZRange(ctx, key, 0, -1).Result()
+		if err != nil || len(members) != 1 || members[0] != "v2" {
+			t.Errorf("%s = %v, %v", key, members, err)
+		}
+	}
+}
+
+func TestPublish_Broadcast(t *testing.T) {
+	rdb := setupRedis(t)
+	ctx := context.Background()
+
+	sub := rdb.Subscribe(ctx, Channel)
+	defer sub.Close()
+	if _, err := sub.Receive(ctx); err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+
+	click := &models.Click{TrackerID: "tr-1", IP: "1.2.3.4", UA: "curl", TS: time.Now()}
+	if err := Publish(ctx, rdb, ClickMessage(click)); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+
+	select {
+	case m := <-sub.Channel():
+		var raw map[string]any
+		if err := json.Unmarshal([]byte(m.Payload), &raw); err != nil {
+			t.Fatalf("payload: %v", err)
+		}
+		if raw["kind"] != "click" || raw["tracker_id"] != "tr-1" {
+			t.Errorf("unexpected payload %s", m.Payload)
+		}
+		for _, field := range []string{"ip", "ua", "visitor"} {
+			if _, ok := raw[field]; ok {
+				t.Errorf("payload leaks %q: %s", field, m.Payload)
+			}
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no message received")
+	}
+}
+
+func TestTicket_SingleUse(t *testing.T) {
+	rdb := setupRedis(t)
+	ctx := context.Background()
+
+	ticket, err := IssueTicket(ctx, rdb)
+	if err != nil {
+		t.Fatalf("IssueTicket: %v", err)
+	}
+	if ok, err := RedeemTicket(ctx, rdb, ticket); !ok || err != nil {
+		t.Fatalf("first redeem = %v, %v", ok, err)
+	}
+	if ok, _ := RedeemTicket(ctx, rdb, ticket); ok {
+		t.Error("ticket redeemed twice")
+	}
+	if ok, _ := RedeemTicket(ctx, rdb, "forged"); ok {
+		t.Error("unknown ticket accepted")
+	}
+}
+
+func TestMessage_Matches(t *testing.T) {
+	m := Message{Kind: "event", SiteID: "site-1"}
+	if !m.Matches("", "") {
+		t.Error("empty filters should match")
+	}
+	if !m.Matches("site-1", "") {
+		t.Error("matching site filter should match")
+	}
+	if m.Matches("site-2", "") {
+		t.Error("other site should not match")
+	}
+	if m.Matches("", "tr-1") {
+		t.Error("event should not match a tracker filter")
+	}
+}
@@ -64,6 +64,13 @@ func TestCheckRateLimit_OverLimit(t *testing.T) {
 	if !strings.Contains(err.Error(), "rate_limited") {
 		t.Errorf("error = %q, want rate_limited", err.Error())
 	}
+	rlErr, ok := err.(*RateLimitError)
+	if !ok {
+		t.Fatalf("error type = %T, want *RateLimitError", err)
+	}
+	if rlErr.RetryAfter <= 0 || rlErr.RetryAfter > time.Minute {
+		t.Errorf("RetryAfter = %v, want within (0, 1m]", rlErr.RetryAfter)
+	}
 }
 
 func TestCheckRateLimit_PerIPUALimit(t *testing.T) {
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// AlertRule compares a stats summary metric over a trailing window with a
+// fixed threshold or with the same window on previous days.
+type AlertRule struct {
+	ID            string     `gorm:"type:uuid;primaryKey" json:"id"`
+	Name          string     `gorm:"type:varchar(255);not null" json:"name"`
+	Source        string     `gorm:"type:varchar(10);not null" json:"source"` // "clicks" or "events"
+	Metric        string     `gorm:"type:varchar(30);not null" json:"metric"` // a stats summary key, e.g. "total" or "bot_rate"
+	TrackerID     string     `gorm:"type:varchar(36)" json:"tracker_id"`
+	CampaignID    string     `gorm:"type:varchar(36)" json:"campaign_id"`
+	ChannelID     string     `gorm:"type:varchar(36)" json:"channel_id"`
+	SiteID        string     `gorm:"type:varchar(36)" json:"site_id"`
+	Condition     string     `gorm:"type:varchar(10);not null" json:"condition"` // "above", "below", "drop_pct" or "rise_pct"
+	Threshold     float64    `gorm:"not null" json:"threshold"`
+	WindowMinutes int        `gorm:"not null;default:60" json:"window_minutes"`
+	BaselineDays  int        `gorm:"not null;default:7" json:"baseline_days"` // drop_pct and rise_pct only
+	Notify        StringList `gorm:"type:jsonb" json:"notify"`                // "webhook", "email", "slack"
+	EmailTo       StringList `gorm:"type:jsonb" json:"email_to"`
+	SlackURL      string     `gorm:"type:text" json:"slack_url"`
+	Active        bool       `gorm:"not null;default:true" json:"active"`
+	State         string     `gorm:"type:varchar(10);not null;default:'ok'" json:"state"` // "ok" or "firing"
+	LastValue     float64    `json:"last_value"`
+	LastBaseline  *float64   `json:"last_baseline"`
+	LastEvaluated *time.Time `json:"last_evaluated_at"`
+	StateChanged  *time.Time `json:"state_changed_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+func (a *AlertRule) BeforeCreate(tx *gorm.DB) error {
+	if a.ID == "" {
+		a.ID = uuid.New().String()
+	}
+	return nil
+}
+
+// AlertEvent records a rule starting or stopping firing.
+type AlertEvent struct {
+	ID        string    `gorm:"type:uuid;primaryKey" json:"id"`
+	RuleID    string    `gorm:"type:uuid;not null;index:idx_alert_events_rule_created" json:"rule_id"`
+	State     string    `gorm:"type:varchar(10);not null" json:"state"` // "firing" or "resolved"
+	Value     float64   `json:"value"`
+	Baseline  *float64  `json:"baseline"`
+	Message   string    `gorm:"type:text" json:"message"`
+	NotifyErr string    `gorm:"type:text" json:"notify_error,omitempty"`
+	CreatedAt time.Time `gorm:"index:idx_alert_events_rule_created" json:"created_at"`
+}
+
+func (e *AlertEvent) BeforeCreate(tx *gorm.DB) error {
+	if e.ID == "" {
+		e.ID = uuid.New().String()
+	}
+	return nil
+}
@@ -19,6 +19,10 @@ type Channel struct {
 	UpdatedAt  time.Time `json:"updated_at"`
 	Tracker    Tracker   `gorm:"foreignKey:TrackerID" json:"-"`
 	Campaign   Campaign  `gorm:"foreignKey:CampaignID" json:"-"`
+
+	// TargetCountries are the ISO country codes the channel is bought
+	// for; clicks from elsewhere count as off-target in fraud reports.
+	TargetCountries StringList `gorm:"type:jsonb" json:"target_countries"`
 }
 
 func (ch *Channel) BeforeCreate(tx *gorm.DB) error {
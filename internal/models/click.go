@@ -18,6 +18,8 @@ type Click struct {
 	IP           string    `gorm:"type:varchar(45)" json:"ip"`
 	Country      string    `gorm:"type:varchar(2)" json:"country"`
 	UA           string    `gorm:"type:text" json:"ua"`
+	Browser      string    `gorm:"type:varchar(50);not null;default:''" json:"browser"`
+	OS           string    `gorm:"type:varchar(50);not null;default:''" json:"os"`
 	Lang         string    `gorm:"type:varchar(50)" json:"lang"`
 	Referer      string    `gorm:"type:text" json:"referer"`
 	Props        JSONMap   `gorm:"type:jsonb" json:"props"`
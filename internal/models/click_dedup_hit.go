@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// ClickDedupHit counts the clicks of one tracker, campaign and channel that
+// were dropped as duplicates within an hour. Dropped clicks are not stored,
+// so these counters are the only record of them. Unset campaigns and
+// channels are "".
+type ClickDedupHit struct {
+	Hour       time.Time `gorm:"primaryKey" json:"hour"`
+	TrackerID  string    `gorm:"type:varchar(36);primaryKey" json:"tracker_id"`
+	CampaignID string    `gorm:"type:varchar(36);primaryKey" json:"campaign_id"`
+	ChannelID  string    `gorm:"type:varchar(36);primaryKey" json:"channel_id"`
+	Count      int64     `gorm:"not null;default:0" json:"count"`
+}
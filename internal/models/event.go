@@ -8,23 +8,29 @@ import (
 )
 
 type Event struct {
-	ID           string    `gorm:"type:uuid;primaryKey" json:"id"`
-	TS           time.Time `gorm:"not null;index:idx_events_site_ts;index:idx_events_type_ts" json:"ts"`
-	SiteID       string    `gorm:"type:uuid;not null;index:idx_events_site_ts" json:"site_id"`
-	Type         string    `gorm:"type:varchar(50);not null;index:idx_events_type_ts" json:"type"`
-	VisitorID    string    `gorm:"type:varchar(255)" json:"visitor_id"`
-	SessionID    string    `gorm:"type:varchar(255)" json:"session_id"`
-	URL          string    `gorm:"type:text" json:"url"`
-	Title        string    `gorm:"type:text" json:"title"`
-	Referrer     string    `gorm:"type:text" json:"referrer"`
-	IP           string    `gorm:"type:varchar(45)" json:"ip"`
-	Country      string    `gorm:"type:varchar(2)" json:"country"`
-	UA           string    `gorm:"type:text" json:"ua"`
-	Lang         string    `gorm:"type:varchar(50)" json:"lang"`
-	Props        JSONMap   `gorm:"type:jsonb" json:"props"`
-	SuspectedBot bool      `gorm:"default:false" json:"suspected_bot"`
-	IsBot        bool      `gorm:"default:false" json:"is_bot"`
-	CreatedAt    time.Time `json:"created_at"`
+	ID           string     `gorm:"type:uuid;primaryKey" json:"id"`
+	TS           time.Time  `gorm:"not null;index:idx_events_site_ts;index:idx_events_type_ts" json:"ts"` // skew-corrected event time
+	ClientTS     *time.Time `json:"client_ts"`                                                            // raw client clock at capture
+	ServerTS     time.Time  `json:"server_ts"`                                                            // server clock at receipt
+	SiteID       string     `gorm:"type:uuid;not null;index:idx_events_site_ts" json:"site_id"`
+	Type         string     `gorm:"type:varchar(50);not null;index:idx_events_type_ts" json:"type"`
+	VisitorID    string     `gorm:"type:varchar(255)" json:"visitor_id"`
+	SessionID    string     `gorm:"type:varchar(255)" json:"session_id"`
+	URL          string     `gorm:"type:text" json:"url"`
+	Title        string     `gorm:"type:text" json:"title"`
+	Referrer     string     `gorm:"type:text" json:"referrer"`
+	IP           string     `gorm:"type:varchar(45)" json:"ip"`
+	Country      string     `gorm:"type:varchar(2)" json:"country"`
+	UA           string     `gorm:"type:text" json:"ua"`
+	Browser      string     `gorm:"type:varchar(50);not null;default:''" json:"browser"`
+	OS           string     `gorm:"type:varchar(50);not null;default:''" json:"os"`
+	Lang         string     `gorm:"type:varchar(50)" json:"lang"`
+	Props        JSONMap    `gorm:"type:jsonb" json:"props"`
+	Consent      bool       `gorm:"default:false" json:"consent"`
+	SuspectedBot bool       `gorm:"default:false" json:"suspected_bot"`
+	IsBot        bool       `gorm:"default:false" json:"is_bot"`
+	ImportSource string     `gorm:"type:varchar(20);not null;default:''" json:"import_source"` // tool an imported event came from; empty when tracked
+	CreatedAt    time.Time  `json:"created_at"`
 }
 
 func (e *Event) BeforeCreate(tx *gorm.DB) error {
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ExportJob is a bulk export of raw clicks or events to a file.
+type ExportJob struct {
+	ID          string     `gorm:"type:uuid;primaryKey" json:"id"`
+	Source      string     `gorm:"type:varchar(10);not null" json:"source"` // "clicks" or "events"
+	Format      string     `gorm:"type:varchar(10);not null" json:"format"` // "csv", "ndjson" or "parquet"
+	StartDate   string     `gorm:"type:varchar(10);not null" json:"start_date"`
+	EndDate     string     `gorm:"type:varchar(10);not null" json:"end_date"`
+	Timezone    string     `gorm:"type:varchar(64);not null;default:'UTC'" json:"timezone"`
+	TrackerID   string     `gorm:"type:varchar(36)" json:"tracker_id"`
+	CampaignID  string     `gorm:"type:varchar(36)" json:"campaign_id"`
+	ChannelID   string     `gorm:"type:varchar(36)" json:"channel_id"`
+	SiteID      string     `gorm:"type:varchar(36)" json:"site_id"`
+	EventType   string     `gorm:"type:varchar(50)" json:"event_type"`
+	IncludeBots bool       `gorm:"not null;default:false" json:"include_bots"`
+	Status      string     `gorm:"type:varchar(10);not null;index" json:"status"` // "queued", "running", "done" or "failed"
+	Rows        int64      `json:"rows"`
+	Size        int64      `json:"size"`
+	Storage     string     `gorm:"type:varchar(10)" json:"storage"`
+	Key         string     `gorm:"type:varchar(255)" json:"key"` // object key or path relative to the export dir
+	Error       string     `gorm:"type:text" json:"error,omitempty"`
+	StartedAt   *time.Time `json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"` // heartbeat while running
+}
+
+func (j *ExportJob) BeforeCreate(tx *gorm.DB) error {
+	if j.ID == "" {
+		j.ID = uuid.New().String()
+	}
+	return nil
+}
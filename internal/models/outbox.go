@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// OutboxMessage is a stored click or event waiting to be published to one
+// sink. It is written in the transaction that stores the row and deleted
+// once the sink acknowledges it, or marked dead after too many failed
+// attempts. The serial id keeps delivery roughly in insert order.
+type OutboxMessage struct {
+	ID            int64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	Sink          string    `gorm:"type:varchar(20);not null;index:idx_outbox_messages_due,priority:1" json:"sink"`
+	Kind          string    `gorm:"type:varchar(10);not null" json:"kind"` // "clicks" or "events"
+	RowID         string    `gorm:"type:varchar(36);not null" json:"row_id"`
+	Key           string    `gorm:"type:varchar(255)" json:"key"` // partition key
+	Payload       string    `gorm:"type:text;not null" json:"payload"`
+	Attempts      int       `gorm:"not null;default:0" json:"attempts"`
+	NextAttemptAt time.Time `gorm:"not null;index:idx_outbox_messages_due,priority:2" json:"next_attempt_at"`
+	LastError     string    `gorm:"type:text" json:"last_error,omitempty"`
+	// LeasedUntil is set while a publisher holds the message; past it, the
+	// message is due again.
+	LeasedUntil *time.Time `json:"leased_until,omitempty"`
+	// DeadAt is set when the message has failed too often; dead messages
+	// are no longer published and are pruned after a while.
+	DeadAt    *time.Time `gorm:"index" json:"dead_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// PrivacyRequest is one entry in the append-only, hash-chained log of data
+// subject access and erasure requests. The subject itself is only stored as
+// a keyed hash so the log does not retain the erased identifiers.
+type PrivacyRequest struct {
+	ID             string    `gorm:"type:uuid;primaryKey" json:"id"`
+	Seq            int64     `gorm:"not null;uniqueIndex" json:"seq"`
+	Action         string    `gorm:"type:varchar(20);not null" json:"action"` // "export" or "erase"
+	Mode           string    `gorm:"type:varchar(20)" json:"mode"`            // "delete" or "anonymise" for erase
+	SubjectHash    string    `gorm:"type:varchar(64);not null;index" json:"subject_hash"`
+	ClicksAffected int64     `json:"clicks_affected"`
+	EventsAffected int64     `json:"events_affected"`
+	Requester      string    `gorm:"type:varchar(255)" json:"requester"`
+	PrevHash       string    `gorm:"type:varchar(64)" json:"prev_hash"`
+	Hash           string    `gorm:"type:varchar(64);not null" json:"hash"`
+	CreatedAt      time.Time `gorm:"index" json:"created_at"`
+}
+
+func (p *PrivacyRequest) BeforeCreate(tx *gorm.DB) error {
+	if p.ID == "" {
+		p.ID = uuid.New().String()
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ScheduledReport emails a stats report on a cron schedule.
+type ScheduledReport struct {
+	ID         string     `gorm:"type:uuid;primaryKey" json:"id"`
+	Name       string     `gorm:"type:varchar(255);not null" json:"name"`
+	Cron       string     `gorm:"type:varchar(100);not null" json:"cron"`
+	Timezone   string     `gorm:"type:varchar(64);not null;default:'UTC'" json:"timezone"` // zone the cron and report days are in
+	Source     string     `gorm:"type:varchar(10);not null" json:"source"`                 // "clicks" or "events"
+	TrackerID  string     `gorm:"type:varchar(36)" json:"tracker_id"`
+	CampaignID string     `gorm:"type:varchar(36)" json:"campaign_id"`
+	ChannelID  string     `gorm:"type:varchar(36)" json:"channel_id"`
+	SiteID     string     `gorm:"type:varchar(36)" json:"site_id"`
+	Format     string     `gorm:"type:varchar(10);not null" json:"format"` // "csv", "xlsx" or "html"
+	RangeDays  int        `gorm:"not null;default:7" json:"range_days"`    // complete days before the run covered by the report
+	Recipients StringList `gorm:"type:jsonb;not null" json:"recipients"`
+	Active     bool       `gorm:"not null;default:true" json:"active"`
+	NextRunAt  *time.Time `gorm:"index" json:"next_run_at"`
+	LastRunAt  *time.Time `json:"last_run_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+func (r *ScheduledReport) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == "" {
+		r.ID = uuid.New().String()
+	}
+	return nil
+}
+
+// ScheduledReportRun is one generated report file and the outcome of
+// emailing it.
+type ScheduledReportRun struct {
+	ID          string     `gorm:"type:uuid;primaryKey" json:"id"`
+	ReportID    string     `gorm:"type:uuid;not null;index:idx_scheduled_report_runs_report_created" json:"report_id"`
+	Trigger     string     `gorm:"type:varchar(10);not null" json:"trigger"` // "schedule" or "manual"
+	Status      string     `gorm:"type:varchar(10);not null" json:"status"`  // "sent" or "failed"
+	StartDate   string     `gorm:"type:varchar(10)" json:"start_date"`
+	EndDate     string     `gorm:"type:varchar(10)" json:"end_date"`
+	Filename    string     `gorm:"type:varchar(255)" json:"filename"`
+	ContentType string     `gorm:"type:varchar(100)" json:"content_type"`
+	Content     []byte     `gorm:"type:bytea" json:"-"`
+	Size        int        `json:"size"`
+	Recipients  StringList `gorm:"type:jsonb" json:"recipients"`
+	Error       string     `gorm:"type:text" json:"error,omitempty"`
+	CreatedAt   time.Time  `gorm:"index:idx_scheduled_report_runs_report_created" json:"created_at"`
+}
+
+func (r *ScheduledReportRun) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == "" {
+		r.ID = uuid.New().String()
+	}
+	return nil
+}
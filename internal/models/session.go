@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Session is a per-session rollup of events, maintained incrementally as
+// event batches are ingested.
+type Session struct {
+	ID              string    `gorm:"type:uuid;primaryKey" json:"id"`
+	SiteID          string    `gorm:"type:uuid;not null;uniqueIndex:idx_sessions_site_session;index:idx_sessions_site_started" json:"site_id"`
+	SessionID       string    `gorm:"type:varchar(255);not null;uniqueIndex:idx_sessions_site_session" json:"session_id"`
+	VisitorID       string    `gorm:"type:varchar(255);index" json:"visitor_id"`
+	StartedAt       time.Time `gorm:"not null;index:idx_sessions_site_started" json:"started_at"`
+	EndedAt         time.Time `gorm:"not null" json:"ended_at"`
+	DurationSeconds int64     `gorm:"not null;default:0" json:"duration_seconds"`
+	Pageviews       int64     `gorm:"not null;default:0" json:"pageviews"`
+	Events          int64     `gorm:"not null;default:0" json:"events"`
+	EntryURL        string    `gorm:"type:text" json:"entry_url"`
+	ExitURL         string    `gorm:"type:text" json:"exit_url"`
+	LandingReferrer string    `gorm:"type:text" json:"landing_referrer"`
+	UTMSource       string    `gorm:"type:varchar(255)" json:"utm_source"`
+	UTMMedium       string    `gorm:"type:varchar(255)" json:"utm_medium"`
+	UTMCampaign     string    `gorm:"type:varchar(255)" json:"utm_campaign"`
+	Country         string    `gorm:"type:varchar(2)" json:"country"`
+	IsBot           bool      `gorm:"default:false" json:"is_bot"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+func (s *Session) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == "" {
+		s.ID = uuid.New().String()
+	}
+	return nil
+}
@@ -13,6 +13,9 @@ type Site struct {
 	Domain    string    `gorm:"type:varchar(255);not null" json:"domain"`
 	SiteKey   string    `gorm:"type:varchar(64);uniqueIndex;not null" json:"site_key"`
 	Status    string    `gorm:"type:varchar(20);not null;default:'active'" json:"status"`
+	IPMode    string    `gorm:"type:varchar(10);not null;default:'full'" json:"ip_mode"` // "full", "truncate" or "hash"
+	DropUA    bool      `gorm:"default:false" json:"drop_ua"`
+	Timezone  string    `gorm:"type:varchar(64);not null;default:'UTC'" json:"timezone"` // IANA zone for daily and hourly stats
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
@@ -8,14 +8,22 @@ import (
 )
 
 type Token struct {
-	ID         string    `gorm:"type:uuid;primaryKey" json:"id"`
-	ShortCode  string    `gorm:"type:varchar(8);uniqueIndex;not null" json:"short_code"`
-	TrackerID  string    `gorm:"type:uuid;not null;index" json:"tracker_id"`
-	CampaignID string    `gorm:"type:uuid" json:"campaign_id"`
-	ChannelID  string    `gorm:"type:uuid" json:"channel_id"`
-	TargetID   string    `gorm:"type:uuid;not null" json:"target_id"`
-	Mode       string    `gorm:"type:varchar(10);not null;default:'302'" json:"mode"`
-	CreatedAt  time.Time `json:"created_at"`
+	ID         string     `gorm:"type:uuid;primaryKey" json:"id"`
+	ShortCode  string     `gorm:"type:varchar(8);uniqueIndex;not null" json:"short_code"`
+	TrackerID  string     `gorm:"type:uuid;not null;index" json:"tracker_id"`
+	CampaignID string     `gorm:"type:uuid" json:"campaign_id"`
+	ChannelID  string     `gorm:"type:uuid" json:"channel_id"`
+	TargetID   string     `gorm:"type:uuid;not null" json:"target_id"`
+	Mode       string     `gorm:"type:varchar(10);not null;default:'302'" json:"mode"`
+	ExpiresAt  *time.Time `gorm:"index" json:"expires_at"`
+	// ExpiryNotified is set once the token.expired webhook has been emitted.
+	ExpiryNotified bool      `gorm:"not null;default:false" json:"-"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// Expired reports whether the token has an expiry at or before now.
+func (t *Token) Expired(now time.Time) bool {
+	return t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)
 }
 
 func (t *Token) BeforeCreate(tx *gorm.DB) error {
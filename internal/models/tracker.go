@@ -8,12 +8,16 @@ import (
 )
 
 type Tracker struct {
-	ID        string    `gorm:"type:uuid;primaryKey" json:"id"`
-	Type      string    `gorm:"type:varchar(10);not null" json:"type"` // "ad" or "web"
-	Name      string    `gorm:"type:varchar(255);not null" json:"name"`
-	Status    string    `gorm:"type:varchar(20);not null;default:'active'" json:"status"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID            string    `gorm:"type:uuid;primaryKey" json:"id"`
+	Type          string    `gorm:"type:varchar(10);not null" json:"type"` // "ad" or "web"
+	Name          string    `gorm:"type:varchar(255);not null" json:"name"`
+	Status        string    `gorm:"type:varchar(20);not null;default:'active'" json:"status"`
+	IPMode        string    `gorm:"type:varchar(10);not null;default:'full'" json:"ip_mode"` // "full", "truncate" or "hash"
+	DropUA        bool      `gorm:"default:false" json:"drop_ua"`
+	Timezone      string    `gorm:"type:varchar(64);not null;default:'UTC'" json:"timezone"` // IANA zone for daily and hourly stats
+	AppendClickID bool      `gorm:"not null;default:false" json:"append_click_id"`           // add repo.ClickIDParam to landing URLs
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
 }
 
 func (t *Tracker) BeforeCreate(tx *gorm.DB) error {
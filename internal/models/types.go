@@ -26,3 +26,35 @@ func (j *JSONMap) Scan(value interface{}) error {
 	}
 	return json.Unmarshal(bytes, j)
 }
+
+// StringList is a list of strings stored as a JSON array.
+type StringList []string
+
+func (s StringList) Value() (driver.Value, error) {
+	if s == nil {
+		return "[]", nil
+	}
+	return json.Marshal(s)
+}
+
+func (s *StringList) Scan(value interface{}) error {
+	if value == nil {
+		*s = nil
+		return nil
+	}
+	bytes, ok := value.([]byte)
+	if !ok {
+		return errors.New("failed to scan StringList")
+	}
+	return json.Unmarshal(bytes, s)
+}
+
+// Contains reports whether v is in the list.
+func (s StringList) Contains(v string) bool {
+	for _, item := range s {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
@@ -67,3 +67,23 @@ func TestJSONMap_Scan_InvalidType(t *testing.T) {
 		t.Fatal("expected error for non-byte input")
 	}
 }
+
+func TestStringList_RoundTrip(t *testing.T) {
+	in := StringList{"click", "conversion"}
+	v, err := in.Value()
+	if err != nil {
+		t.Fatalf("Value(): %v", err)
+	}
+	var out StringList
+	if err := out.Scan(v); err != nil {
+		t.Fatalf("Scan(): %v", err)
+	}
+	if len(out) != 2 || !out.Contains("conversion") || out.Contains("bot_spike") {
+		t.Errorf("round trip = %v, want %v", out, in)
+	}
+
+	var empty StringList
+	if v, _ := empty.Value(); v != "[]" {
+		t.Errorf("nil Value() = %v, want []", v)
+	}
+}
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Webhook is an outbound subscription. Empty TrackerID and SiteID match
+// every tracker and site.
+type Webhook struct {
+	ID               string     `gorm:"type:uuid;primaryKey" json:"id"`
+	Name             string     `gorm:"type:varchar(255);not null" json:"name"`
+	URL              string     `gorm:"type:text;not null" json:"url"`
+	Secret           string     `gorm:"type:varchar(64);not null" json:"secret"`
+	Kinds            StringList `gorm:"type:jsonb;not null" json:"kinds"`
+	TrackerID        string     `gorm:"type:varchar(36);index" json:"tracker_id"`
+	SiteID           string     `gorm:"type:varchar(36);index" json:"site_id"`
+	ConversionEvents StringList `gorm:"type:jsonb" json:"conversion_events"`         // event types delivered as conversions
+	BotSpikeLimit    int        `gorm:"not null;default:100" json:"bot_spike_limit"` // blocked bots per minute that trigger bot_spike
+	Active           bool       `gorm:"not null;default:true" json:"active"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+func (w *Webhook) BeforeCreate(tx *gorm.DB) error {
+	if w.ID == "" {
+		w.ID = uuid.New().String()
+	}
+	return nil
+}
+
+// WebhookDelivery is one attempt chain to deliver a payload. Replays create
+// a new delivery so the log keeps every outcome.
+type WebhookDelivery struct {
+	ID            string     `gorm:"type:uuid;primaryKey" json:"id"`
+	WebhookID     string     `gorm:"type:uuid;not null;index:idx_webhook_deliveries_webhook_created" json:"webhook_id"`
+	Kind          string     `gorm:"type:varchar(30);not null" json:"kind"`
+	Payload       string     `gorm:"type:text;not null" json:"payload"`
+	Status        string     `gorm:"type:varchar(20);not null;index" json:"status"` // "pending", "delivered" or "failed"
+	Attempts      int        `gorm:"not null;default:0" json:"attempts"`
+	ResponseCode  int        `json:"response_code"`
+	LastError     string     `gorm:"type:text" json:"last_error"`
+	NextAttemptAt *time.Time `json:"next_attempt_at"`
+	DeliveredAt   *time.Time `json:"delivered_at"`
+	ReplayOf      string     `gorm:"type:varchar(36)" json:"replay_of,omitempty"`
+	CreatedAt     time.Time  `gorm:"index:idx_webhook_deliveries_webhook_created" json:"created_at"`
+}
+
+func (d *WebhookDelivery) BeforeCreate(tx *gorm.DB) error {
+	if d.ID == "" {
+		d.ID = uuid.New().String()
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+// Package notify sends alert and report notifications by email and to
+// Slack-compatible incoming webhooks.
+package notify
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tracking/analysis/internal/config"
+)
+
+// ErrMailDisabled is returned when no SMTP host is configured.
+var ErrMailDisabled = errors.New("smtp not configured")
+
+type Mail struct {
+	To          []string
+	Subject     string
+	Text        string
+	HTML        string // optional alternative to Text
+	Attachments []Attachment
+}
+
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+type Mailer struct {
+	Config *config.SMTPConfiguration
+}
+
+func (m *Mailer) Enabled() bool {
+	return m != nil && m.Config != nil && m.Config.Host != ""
+}
+
+// Send delivers mail through the configured relay. net/smtp upgrades to
+// STARTTLS when the server offers it; credentials are only sent when set.
+func (m *Mailer) Send(mail Mail) error {
+	if !m.Enabled() {
+		return ErrMailDisabled
+	}
+	if len(mail.To) == 0 {
+		return errors.New("no recipients")
+	}
+	port := m.Config.Port
+	if port == 0 {
+		port = 587
+	}
+	addr := m.Config.Host + ":" + strconv.Itoa(port)
+	var auth smtp.Auth
+	if m.Config.Username != "" {
+		auth = smtp.PlainAuth("", m.Config.Username, m.Config.Password, m.Config.Host)
+	}
+	return smtp.SendMail(addr, auth, m.Config.From, mail.To, buildMessage(m.Config.From, mail, time.Now()))
+}
+
+// buildMessage renders an RFC 5322 message: plain text alone, or
+// multipart/mixed holding the body (multipart/alternative when HTML is
+// set) followed by base64 attachments.
+func buildMessage(from string, mail Mail, now time.Time) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(mail.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", mail.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	if mail.HTML == "" && len(mail.Attachments) == 0 {
+		b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+		b.WriteString(crlf(mail.Text))
+		return b.Bytes()
+	}
+
+	mixed := multipart.NewWriter(&b)
+	fmt.Fprintf(&b, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", mixed.Boundary())
+	if mail.HTML == "" {
+		part, _ := mixed.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+		part.Write([]byte(crlf(mail.Text)))
+	} else {
+		var alt bytes.Buffer
+		altWriter := multipart.NewWriter(&alt)
+		part, _ := altWriter.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+		part.Write([]byte(crlf(mail.Text)))
+		part, _ = altWriter.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {"text/html; charset=utf-8"},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		part.Write(base64Lines([]byte(mail.HTML)))
+		altWriter.Close()
+		part, _ = mixed.CreatePart(textproto.MIMEHeader{"Content-Type": {"multipart/alternative; boundary=" + altWriter.Boundary()}})
+		part.Write(alt.Bytes())
+	}
+	for _, a := range mail.Attachments {
+		part, _ := mixed.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {a.ContentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename})},
+		})
+		part.Write(base64Lines(a.Data))
+	}
+	mixed.Close()
+	return b.Bytes()
+}
+
+func crlf(text string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\n", "\r\n") + "\r\n"
+}
+
+// base64Lines encodes data in 76-character lines as RFC 2045 requires.
+func base64Lines(data []byte) []byte {
+	enc := base64.StdEncoding.EncodeToString(data)
+	var b bytes.Buffer
+	for len(enc) > 76 {
+		b.WriteString(enc[:76] + "\r\n")
+		enc = enc[76:]
+	}
+	b.WriteString(enc + "\r\n")
+	return b.Bytes()
+}
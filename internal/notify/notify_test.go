@@ -0,0 +1,163 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tracking/analysis/internal/config"
+)
+
+// smtpStandIn accepts one message on a local port and sends its DATA to the
+// returned channel. It speaks just enough SMTP for net/smtp.SendMail.
+func smtpStandIn(t *testing.T) (host string, port int, messages <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	out := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		reply("220 stand-in ready")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 stand-in")
+			case cmd == "DATA":
+				reply("354 go ahead")
+				var data strings.Builder
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if l == ".\r\n" {
+						break
+					}
+					data.WriteString(l)
+				}
+				out <- data.String()
+				reply("250 queued")
+			case cmd == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, out
+}
+
+func TestBuildMessage(t *testing.T) {
+	msg := string(buildMessage("alerts@example.com", Mail{
+		To:      []string{"a@example.com", "b@example.com"},
+		Subject: "Clicks dropped",
+		Text:    "line one\nline two",
+	}, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)))
+
+	for _, want := range []string{
+		"From: alerts@example.com\r\n",
+		"To: a@example.com, b@example.com\r\n",
+		"Subject: Clicks dropped\r\n",
+		"\r\n\r\nline one\r\nline two\r\n",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("message missing %q:\n%s", want, msg)
+		}
+	}
+}
+
+func TestMailer_Disabled(t *testing.T) {
+	var m *Mailer
+	if err := m.Send(Mail{To: []string{"a@example.com"}}); err != ErrMailDisabled {
+		t.Errorf("Send on nil mailer = %v, want ErrMailDisabled", err)
+	}
+}
+
+func TestPostSlack(t *testing.T) {
+	var got map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	if err := PostSlack(context.Background(), srv.Client(), srv.URL, "hello"); err != nil {
+		t.Fatalf("PostSlack: %v", err)
+	}
+	if got["text"] != "hello" {
+		t.Errorf("posted %v, want text hello", got)
+	}
+}
+
+func TestMailer_SendWithAttachment(t *testing.T) {
+	host, port, messages := smtpStandIn(t)
+	m := &Mailer{Config: &config.SMTPConfiguration{Host: host, Port: port, From: "reports@example.com"}}
+	err := m.Send(Mail{
+		To:          []string{"am@example.com"},
+		Subject:     "Weekly numbers",
+		Text:        "See attached.",
+		HTML:        "<p>See attached.</p>",
+		Attachments: []Attachment{{Filename: "weekly.csv", ContentType: "text/csv", Data: []byte("section,name,value\r\n")}},
+	})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	var raw string
+	select {
+	case raw = <-messages:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no message received")
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("parse message: %v", err)
+	}
+	if got := msg.Header.Get("Subject"); got != "Weekly numbers" {
+		t.Errorf("subject = %q", got)
+	}
+	mediaType, params, _ := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if mediaType != "multipart/mixed" {
+		t.Fatalf("content type = %q, want multipart/mixed", mediaType)
+	}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	var types []string
+	var filename string
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+		ct, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		types = append(types, ct)
+		if part.FileName() != "" {
+			filename = part.FileName()
+		}
+	}
+	if strings.Join(types, ",") != "multipart/alternative,text/csv" || filename != "weekly.csv" {
+		t.Errorf("parts = %v, attachment %q", types, filename)
+	}
+}
@@ -0,0 +1,133 @@
+package privacy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/tracking/analysis/internal/models"
+)
+
+// IP storage modes configured per tracker and per site.
+const (
+	IPModeFull     = "full"
+	IPModeTruncate = "truncate"
+	IPModeHash     = "hash"
+)
+
+// Settings controls what is persisted for a single click or event.
+type Settings struct {
+	IPMode string
+	DropUA bool
+}
+
+// Strictest is applied when a tracker's or site's settings cannot be
+// loaded, so a lookup failure never stores more than any mode allows.
+var Strictest = Settings{IPMode: IPModeHash, DropUA: true}
+
+// ValidIPMode reports whether mode is a supported IP storage mode.
+// An empty mode is treated as IPModeFull.
+func ValidIPMode(mode string) bool {
+	switch mode {
+	case "", IPModeFull, IPModeTruncate, IPModeHash:
+		return true
+	}
+	return false
+}
+
+// Apply returns the IP and User-Agent values that may be stored under s.
+// Geo lookup and bot scoring must already have used the full values,
+// since they are discarded here.
+func Apply(s Settings, ip, ua, secret string, now time.Time) (storedIP, storedUA string) {
+	switch s.IPMode {
+	case IPModeTruncate:
+		storedIP = TruncateIP(ip)
+	case IPModeHash:
+		storedIP = HashIP(ip, secret, now)
+	default:
+		storedIP = ip
+	}
+	if !s.DropUA {
+		storedUA = ua
+	}
+	return storedIP, storedUA
+}
+
+// TruncateIP zeroes the host part of an address: IPv4 is cut to /24 and
+// IPv6 to /48. Unparseable input returns "".
+func TruncateIP(ipStr string) string {
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return ""
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// HashIP returns a keyed hash of ip that is stable for one UTC day and
+// rotates at midnight, so it can be used for same-day uniqueness without
+// allowing visitors to be linked across days.
+func HashIP(ip, secret string, now time.Time) string {
+	if ip == "" {
+		return ""
+	}
+	dayKey := hmac.New(sha256.New, []byte(secret))
+	dayKey.Write([]byte(now.UTC().Format("2006-01-02")))
+
+	mac := hmac.New(sha256.New, dayKey.Sum(nil))
+	mac.Write([]byte(ip))
+	return hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+// SubjectIPs returns every value ip may have been stored as between from
+// and to: the address itself, kept under IPModeFull, and its hash for each
+// UTC day, kept under IPModeHash. Truncated addresses are shared by a whole
+// network and do not identify a subject, so they are not included.
+func SubjectIPs(ip, secret string, from, to time.Time) []string {
+	if ip == "" {
+		return nil
+	}
+	ips := []string{ip}
+	for day := from.UTC().Truncate(24 * time.Hour); !day.After(to); day = day.Add(24 * time.Hour) {
+		ips = append(ips, HashIP(ip, secret, day))
+	}
+	return ips
+}
+
+// SubjectHash identifies a data subject in the request log without storing
+// the visitor ID or IP themselves.
+func SubjectHash(visitorID, ip, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(visitorID + "|" + ip))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ChainHash computes the hash of a request log entry. Each entry covers the
+// previous entry's hash, so editing or removing any row breaks every hash
+// after it.
+func ChainHash(r *models.PrivacyRequest, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d|%s|%s|%s|%s|%d|%d|%s|%s|%d",
+		r.Seq, r.ID, r.Action, r.Mode, r.SubjectHash, r.ClicksAffected, r.EventsAffected,
+		r.Requester, r.PrevHash, r.CreatedAt.UnixMicro())
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyChain checks a log ordered oldest first. It returns the ID of the
+// first entry whose hash or back-link does not match, or "" if intact.
+func VerifyChain(entries []models.PrivacyRequest, secret string) string {
+	prev := ""
+	for i := range entries {
+		e := &entries[i]
+		if e.Seq != int64(i+1) || e.PrevHash != prev || e.Hash != ChainHash(e, secret) {
+			return e.ID
+		}
+		prev = e.Hash
+	}
+	return ""
+}
@@ -0,0 +1,162 @@
+package privacy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tracking/analysis/internal/models"
+)
+
+func TestTruncateIP(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"203.0.113.77", "203.0.113.0"},
+		{"2001:db8:abcd:12:34::1", "2001:db8:abcd::"},
+		{"::ffff:198.51.100.9", "198.51.100.0"},
+		{"not-an-ip", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := TruncateIP(tt.in); got != tt.want {
+			t.Errorf("TruncateIP(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestHashIP_StableWithinDay(t *testing.T) {
+	morning := time.Date(2024, 5, 1, 1, 0, 0, 0, time.UTC)
+	evening := time.Date(2024, 5, 1, 23, 0, 0, 0, time.UTC)
+
+	a := HashIP("203.0.113.77", "secret", morning)
+	b := HashIP("203.0.113.77", "secret", evening)
+	if a != b {
+		t.Errorf("hash changed within the same day: %q != %q", a, b)
+	}
+	if len(a) > 45 {
+		t.Errorf("hash length = %d, must fit the ip column", len(a))
+	}
+}
+
+func TestHashIP_RotatesDaily(t *testing.T) {
+	day1 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	day2 := day1.Add(24 * time.Hour)
+
+	if HashIP("203.0.113.77", "secret", day1) == HashIP("203.0.113.77", "secret", day2) {
+		t.Error("hash should rotate between days")
+	}
+}
+
+func TestHashIP_Keyed(t *testing.T) {
+	now := time.Now()
+	if HashIP("203.0.113.77", "a", now) == HashIP("203.0.113.77", "b", now) {
+		t.Error("hash should depend on the secret")
+	}
+}
+
+func TestSubjectIPs(t *testing.T) {
+	from := time.Date(2024, 5, 1, 18, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 5, 3, 6, 0, 0, 0, time.UTC)
+	ips := SubjectIPs("203.0.113.77", "s", from, to)
+	want := []string{
+		"203.0.113.77",
+		HashIP("203.0.113.77", "s", from),
+		HashIP("203.0.113.77", "s", from.Add(24*time.Hour)),
+		HashIP("203.0.113.77", "s", to),
+	}
+	if len(ips) != len(want) {
+		t.Fatalf("SubjectIPs = %v, want %v", ips, want)
+	}
+	for i := range want {
+		if ips[i] != want[i] {
+			t.Errorf("ips[%d] = %q, want %q", i, ips[i], want[i])
+		}
+	}
+	if SubjectIPs("", "s", from, to) != nil {
+		t.Error("empty IP should match nothing")
+	}
+}
+
+func TestApply(t *testing.T) {
+	now := time.Now()
+	ip, ua := Apply(Settings{}, "203.0.113.77", "Mozilla/5.0", "s", now)
+	if ip != "203.0.113.77" || ua != "Mozilla/5.0" {
+		t.Errorf("default settings altered data: ip=%q ua=%q", ip, ua)
+	}
+
+	ip, ua = Apply(Settings{IPMode: IPModeTruncate, DropUA: true}, "203.0.113.77", "Mozilla/5.0", "s", now)
+	if ip != "203.0.113.0" {
+		t.Errorf("truncate ip = %q", ip)
+	}
+	if ua != "" {
+		t.Errorf("DropUA kept ua = %q", ua)
+	}
+
+	ip, _ = Apply(Settings{IPMode: IPModeHash}, "203.0.113.77", "", "s", now)
+	if ip != HashIP("203.0.113.77", "s", now) {
+		t.Errorf("hash ip = %q", ip)
+	}
+}
+
+func TestValidIPMode(t *testing.T) {
+	for _, m := range []string{"", IPModeFull, IPModeTruncate, IPModeHash} {
+		if !ValidIPMode(m) {
+			t.Errorf("ValidIPMode(%q) = false", m)
+		}
+	}
+	if ValidIPMode("partial") {
+		t.Error("ValidIPMode(partial) = true")
+	}
+}
+
+func buildChain(secret string, n int) []models.PrivacyRequest {
+	entries := make([]models.PrivacyRequest, n)
+	prev := ""
+	for i := range entries {
+		e := &entries[i]
+		e.ID = string(rune('a' + i))
+		e.Seq = int64(i + 1)
+		e.Action = "erase"
+		e.Mode = "delete"
+		e.SubjectHash = SubjectHash("visitor", "", secret)
+		e.ClicksAffected = int64(i)
+		e.PrevHash = prev
+		e.CreatedAt = time.Date(2024, 5, 1, 0, i, 0, 0, time.UTC)
+		e.Hash = ChainHash(e, secret)
+		prev = e.Hash
+	}
+	return entries
+}
+
+func TestVerifyChain_Intact(t *testing.T) {
+	entries := buildChain("s", 3)
+	if broken := VerifyChain(entries, "s"); broken != "" {
+		t.Errorf("intact chain reported broken at %q", broken)
+	}
+}
+
+func TestVerifyChain_Tampered(t *testing.T) {
+	entries := buildChain("s", 3)
+	entries[1].ClicksAffected = 99
+	if broken := VerifyChain(entries, "s"); broken != entries[1].ID {
+		t.Errorf("broken at %q, want %q", broken, entries[1].ID)
+	}
+}
+
+func TestVerifyChain_Removed(t *testing.T) {
+	entries := buildChain("s", 3)
+	entries = append(entries[:1], entries[2:]...)
+	if broken := VerifyChain(entries, "s"); broken == "" {
+		t.Error("removing an entry should break the chain")
+	}
+}
+
+func TestSubjectHash(t *testing.T) {
+	if SubjectHash("v1", "", "s") == SubjectHash("v2", "", "s") {
+		t.Error("different subjects should hash differently")
+	}
+	if SubjectHash("v1", "", "s") != SubjectHash("v1", "", "s") {
+		t.Error("subject hash should be deterministic")
+	}
+}
@@ -0,0 +1,83 @@
+package repo
+
+import (
+	"github.com/tracking/analysis/internal/models"
+	"gorm.io/gorm"
+)
+
+type AlertRepo struct {
+	DB *gorm.DB
+}
+
+func NewAlertRepo(db *gorm.DB) *AlertRepo {
+	return &AlertRepo{DB: db}
+}
+
+func (r *AlertRepo) Create(rule *models.AlertRule) error {
+	return r.DB.Create(rule).Error
+}
+
+func (r *AlertRepo) GetByID(id string) (*models.AlertRule, error) {
+	var rule models.AlertRule
+	err := r.DB.First(&rule, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+func (r *AlertRepo) List() ([]models.AlertRule, error) {
+	var rules []models.AlertRule
+	err := r.DB.Order("created_at DESC").Find(&rules).Error
+	return rules, err
+}
+
+func (r *AlertRepo) ListActive() ([]models.AlertRule, error) {
+	var rules []models.AlertRule
+	err := r.DB.Where("active = ?", true).Find(&rules).Error
+	return rules, err
+}
+
+func (r *AlertRepo) Update(rule *models.AlertRule) error {
+	return r.DB.Save(rule).Error
+}
+
+// Delete removes the rule and its event history.
+func (r *AlertRepo) Delete(id string) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("rule_id = ?", id).Delete(&models.AlertEvent{}).Error; err != nil {
+			return err
+		}
+		res := tx.Delete(&models.AlertRule{}, "id = ?", id)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+func (r *AlertRepo) CreateEvent(e *models.AlertEvent) error {
+	return r.DB.Create(e).Error
+}
+
+// ListEvents returns alert history newest first. An empty ruleID matches all rules.
+func (r *AlertRepo) ListEvents(ruleID string, limit int) ([]models.AlertEvent, error) {
+	var events []models.AlertEvent
+	q := r.DB
+	if ruleID != "" {
+		q = q.Where("rule_id = ?", ruleID)
+	}
+	err := q.Order("created_at DESC").Limit(limit).Find(&events).Error
+	return events, err
+}
+
+// SaveState writes only the evaluation fields, so an admin update made
+// during an evaluation is not overwritten.
+func (r *AlertRepo) SaveState(rule *models.AlertRule) error {
+	return r.DB.Model(rule).
+		Select("state", "last_value", "last_baseline", "last_evaluated", "state_changed").
+		Updates(rule).Error
+}
@@ -33,3 +33,16 @@ func (r *ChannelRepo) List(trackerID, campaignID string) ([]models.Channel, erro
 	err := q.Order("created_at DESC").Find(&channels).Error
 	return channels, err
 }
+
+func (r *ChannelRepo) GetByID(id string) (*models.Channel, error) {
+	var ch models.Channel
+	err := r.DB.First(&ch, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &ch, nil
+}
+
+func (r *ChannelRepo) Update(ch *models.Channel) error {
+	return r.DB.Save(ch).Error
+}
@@ -23,6 +23,9 @@ func (r *ClickRepo) clickFilters(q *gorm.DB, trackerID, campaignID, channelID st
 
 type ClickRepo struct {
 	DB *gorm.DB
+	// Outbox, when set, queues each stored click for the streaming sinks
+	// in the same transaction.
+	Outbox *OutboxRepo
 }
 
 func NewClickRepo(db *gorm.DB) *ClickRepo {
@@ -30,7 +33,23 @@ func NewClickRepo(db *gorm.DB) *ClickRepo {
 }
 
 func (r *ClickRepo) Create(c *models.Click) error {
-	return r.DB.Create(c).Error
+	if r.Outbox == nil {
+		return r.DB.Create(c).Error
+	}
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(c).Error; err != nil {
+			return err
+		}
+		key := c.VisitorID
+		if key == "" {
+			key = c.ID
+		}
+		return r.Outbox.Enqueue(tx, "clicks", []OutboxEntry{{RowID: c.ID, Key: key, Row: c}})
+	})
+	if err == nil {
+		r.Outbox.Notify()
+	}
+	return err
 }
 
 func (r *ClickRepo) GetByID(id string) (*models.Click, error) {
@@ -42,9 +61,9 @@ func (r *ClickRepo) GetByID(id string) (*models.Click, error) {
 	return &c, nil
 }
 
-func (r *ClickRepo) CountByDay(start, end time.Time, trackerID, campaignID, channelID string) ([]DailyCount, error) {
+func (r *ClickRepo) CountByDay(start, end time.Time, tz, trackerID, campaignID, channelID string) ([]DailyCount, error) {
 	q := r.DB.Model(&models.Click{}).
-		Select("DATE(ts) AS date, COUNT(*) AS count").
+		Select("DATE(ts AT TIME ZONE ?) AS date, COUNT(*) AS count", tz).
 		Where("ts BETWEEN ? AND ? AND is_bot = false", start, end)
 	if trackerID != "" {
 		q = q.Where("tracker_id = ?", trackerID)
@@ -56,7 +75,7 @@ func (r *ClickRepo) CountByDay(start, end time.Time, trackerID, campaignID, chan
 		q = q.Where("channel_id = ?", channelID)
 	}
 	var results []DailyCount
-	err := q.Group("DATE(ts)").Order("date").Find(&results).Error
+	err := q.Group("date").Order("date").Find(&results).Error
 	return results, err
 }
 
@@ -122,22 +141,16 @@ func (r *ClickRepo) TopReferrers(start, end time.Time, trackerID, campaignID, ch
 	if err != nil {
 		return nil, err
 	}
-	// Normalize hostnames and re-aggregate
-	hostMap := make(map[string]int64)
-	for _, r := range raw {
-		host := NormalizeReferrerHost(r.Name)
-		hostMap[host] += r.Count
-	}
-	return mapToSortedNameCounts(hostMap, limit), nil
+	return referrerHostCounts(raw, limit), nil
 }
 
-func (r *ClickRepo) RawUACounts(start, end time.Time, trackerID, campaignID, channelID string) ([]NameCount, error) {
+func (r *ClickRepo) RawUACounts(start, end time.Time, trackerID, campaignID, channelID string) ([]UACount, error) {
 	q := r.DB.Model(&models.Click{}).
-		Select("ua AS name, COUNT(*) AS count").
+		Select("ua, browser, os, COUNT(*) AS count").
 		Where("ts BETWEEN ? AND ?", start, end)
 	q = r.clickFilters(q, trackerID, campaignID, channelID)
-	var results []NameCount
-	err := q.Group("ua").Order("count DESC").Limit(500).Find(&results).Error
+	var results []UACount
+	err := q.Group("ua, browser, os").Order("count DESC").Limit(500).Find(&results).Error
 	return results, err
 }
 
@@ -161,19 +174,19 @@ func (r *ClickRepo) CountryDistribution(start, end time.Time, trackerID, campaig
 	return results, err
 }
 
-func (r *ClickRepo) BotCountByDay(start, end time.Time, trackerID, campaignID, channelID string) ([]DailyCount, error) {
+func (r *ClickRepo) BotCountByDay(start, end time.Time, tz, trackerID, campaignID, channelID string) ([]DailyCount, error) {
 	q := r.DB.Model(&models.Click{}).
-		Select("DATE(ts) AS date, COUNT(*) AS count").
+		Select("DATE(ts AT TIME ZONE ?) AS date, COUNT(*) AS count", tz).
 		Where("ts BETWEEN ? AND ? AND is_bot = true", start, end)
 	q = r.clickFilters(q, trackerID, campaignID, channelID)
 	var results []DailyCount
-	err := q.Group("DATE(ts)").Order("date").Find(&results).Error
+	err := q.Group("date").Order("date").Find(&results).Error
 	return results, err
 }
 
-func (r *ClickRepo) CountByHour(start, end time.Time, trackerID, campaignID, channelID string) ([]HourlyCount, error) {
+func (r *ClickRepo) CountByHour(start, end time.Time, tz, trackerID, campaignID, channelID string) ([]HourlyCount, error) {
 	q := r.DB.Model(&models.Click{}).
-		Select("EXTRACT(HOUR FROM ts)::int AS hour, COUNT(*) AS count").
+		Select("EXTRACT(HOUR FROM ts AT TIME ZONE ?)::int AS hour, COUNT(*) AS count", tz).
 		Where("ts BETWEEN ? AND ? AND is_bot = false", start, end)
 	q = r.clickFilters(q, trackerID, campaignID, channelID)
 	var results []HourlyCount
@@ -0,0 +1,760 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tracking/analysis/internal/clickhouse"
+	"github.com/tracking/analysis/internal/models"
+	"gorm.io/gorm"
+)
+
+// clickHouseSchema mirrors the clicks and events tables. IDs are plain
+// strings so an unset campaign or channel is "" rather than NULL, and
+// props are JSON text.
+var clickHouseSchema = []string{
+	`CREATE TABLE IF NOT EXISTS clicks (
+		id String,
+		ts DateTime64(6, 'UTC'),
+		tracker_id String,
+		campaign_id String,
+		channel_id String,
+		target_id String,
+		visitor_id String,
+		ip String,
+		country LowCardinality(String),
+		ua String,
+		browser LowCardinality(String),
+		os LowCardinality(String),
+		lang LowCardinality(String),
+		referer String,
+		props String,
+		suspected_bot Bool,
+		is_bot Bool,
+		created_at DateTime64(6, 'UTC')
+	) ENGINE = MergeTree
+	PARTITION BY toYYYYMM(ts)
+	ORDER BY (tracker_id, ts)`,
+	`CREATE TABLE IF NOT EXISTS events (
+		id String,
+		ts DateTime64(6, 'UTC'),
+		client_ts Nullable(DateTime64(6, 'UTC')),
+		server_ts DateTime64(6, 'UTC'),
+		site_id String,
+		type LowCardinality(String),
+		visitor_id String,
+		session_id String,
+		url String,
+		title String,
+		referrer String,
+		ip String,
+		country LowCardinality(String),
+		ua String,
+		browser LowCardinality(String),
+		os LowCardinality(String),
+		lang LowCardinality(String),
+		props String,
+		consent Bool,
+		suspected_bot Bool,
+		is_bot Bool,
+		import_source LowCardinality(String),
+		created_at DateTime64(6, 'UTC')
+	) ENGINE = MergeTree
+	PARTITION BY toYYYYMM(ts)
+	ORDER BY (site_id, type, ts)`,
+}
+
+// MigrateClickHouse creates the clicks and events tables if missing.
+func MigrateClickHouse(ctx context.Context, ch *clickhouse.Client) error {
+	for _, stmt := range clickHouseSchema {
+		if err := ch.Exec(ctx, stmt, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chTimeRange is the ts filter every query starts from.
+const chTimeRange = "ts BETWEEN {start:DateTime64(6, 'UTC')} AND {end:DateTime64(6, 'UTC')}"
+
+// chActorExprs are the ClickHouse spellings of actorExprs.
+var chActorExprs = map[string]string{
+	"visitor": "if(visitor_id != '', visitor_id, session_id)",
+	"session": "session_id",
+}
+
+// chQuery builds a WHERE clause and its parameters.
+type chQuery struct {
+	where  []string
+	params clickhouse.Params
+}
+
+func newCHQuery(start, end time.Time) *chQuery {
+	return &chQuery{where: []string{chTimeRange}, params: clickhouse.Params{"start": start, "end": end}}
+}
+
+// eq adds column = value when value is set.
+func (q *chQuery) eq(column, value string) *chQuery {
+	if value != "" {
+		q.where = append(q.where, fmt.Sprintf("%s = {%s:String}", column, column))
+		q.params[column] = value
+	}
+	return q
+}
+
+func (q *chQuery) and(cond string) *chQuery {
+	q.where = append(q.where, cond)
+	return q
+}
+
+func (q *chQuery) String() string {
+	return strings.Join(q.where, " AND ")
+}
+
+func chJSON(m models.JSONMap) string {
+	if m == nil {
+		return "{}"
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return "{}"
+	}
+	return string(b)
+}
+
+func chTime(t time.Time) string {
+	return t.UTC().Format(clickhouse.TimeFormat)
+}
+
+// chNames looks up the names of config entities in Postgres, which stays
+// their store.
+func chNames(db *gorm.DB, table string, ids []string) (map[string]string, error) {
+	var rows []struct {
+		ID   string
+		Name string
+	}
+	names := make(map[string]string, len(ids))
+	if len(ids) == 0 {
+		return names, nil
+	}
+	if err := db.Table(table).Select("id, name").Where("id IN ?", ids).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		names[r.ID] = r.Name
+	}
+	return names, nil
+}
+
+// chTopByGroup counts non-bot rows of table per column value and names
+// each group from nameTable. Like the Postgres join, groups whose entity
+// no longer exists are left out.
+func chTopByGroup(ch *clickhouse.Client, db *gorm.DB, table, column, nameTable string, start, end time.Time, limit int) ([]GroupCount, error) {
+	q := newCHQuery(start, end).and("NOT is_bot").and(column + " != ''")
+	counts, err := clickhouse.Select[GroupCount](context.Background(), ch, fmt.Sprintf(
+		"SELECT %[1]s AS group_id, count() AS count FROM %[2]s WHERE %[3]s GROUP BY group_id ORDER BY count DESC, group_id",
+		column, table, q), q.params)
+	if err != nil {
+		return nil, err
+	}
+	if nameTable == "" {
+		for i := range counts {
+			counts[i].Name = counts[i].GroupID
+		}
+		return counts[:min(limit, len(counts))], nil
+	}
+	ids := make([]string, len(counts))
+	for i, c := range counts {
+		ids[i] = c.GroupID
+	}
+	names, err := chNames(db, nameTable, ids)
+	if err != nil {
+		return nil, err
+	}
+	results := []GroupCount{}
+	for _, c := range counts {
+		name, ok := names[c.GroupID]
+		if !ok {
+			continue
+		}
+		c.Name = name
+		results = append(results, c)
+		if len(results) == limit {
+			break
+		}
+	}
+	return results, nil
+}
+
+// ClickHouseClickStore keeps clicks in ClickHouse. DB is Postgres, used
+// only to name trackers, campaigns and channels.
+type ClickHouseClickStore struct {
+	CH *clickhouse.Client
+	DB *gorm.DB
+}
+
+func NewClickHouseClickStore(ch *clickhouse.Client, db *gorm.DB) *ClickHouseClickStore {
+	return &ClickHouseClickStore{CH: ch, DB: db}
+}
+
+func (s *ClickHouseClickStore) Create(c *models.Click) error {
+	if c.ID == "" {
+		c.ID = uuid.New().String()
+	}
+	if c.CreatedAt.IsZero() {
+		c.CreatedAt = time.Now()
+	}
+	return s.CH.Insert(context.Background(), "clicks", []any{chClickRow(c)})
+}
+
+// BatchCreate inserts clicks in one request; the backfill uses it.
+func (s *ClickHouseClickStore) BatchCreate(clicks []models.Click) error {
+	rows := make([]any, len(clicks))
+	for i := range clicks {
+		rows[i] = chClickRow(&clicks[i])
+	}
+	return s.CH.Insert(context.Background(), "clicks", rows)
+}
+
+func chClickRow(c *models.Click) map[string]any {
+	return map[string]any{
+		"id":            c.ID,
+		"ts":            chTime(c.TS),
+		"tracker_id":    c.TrackerID,
+		"campaign_id":   c.CampaignID,
+		"channel_id":    c.ChannelID,
+		"target_id":     c.TargetID,
+		"visitor_id":    c.VisitorID,
+		"ip":            c.IP,
+		"country":       c.Country,
+		"ua":            c.UA,
+		"browser":       c.Browser,
+		"os":            c.OS,
+		"lang":          c.Lang,
+		"referer":       c.Referer,
+		"props":         chJSON(c.Props),
+		"suspected_bot": c.SuspectedBot,
+		"is_bot":        c.IsBot,
+		"created_at":    chTime(c.CreatedAt),
+	}
+}
+
+func (s *ClickHouseClickStore) query(start, end time.Time, trackerID, campaignID, channelID string) *chQuery {
+	return newCHQuery(start, end).eq("tracker_id", trackerID).eq("campaign_id", campaignID).eq("channel_id", channelID)
+}
+
+func (s *ClickHouseClickStore) CountByDay(start, end time.Time, tz, trackerID, campaignID, channelID string) ([]DailyCount, error) {
+	q := s.query(start, end, trackerID, campaignID, channelID).and("NOT is_bot")
+	return chCountByDay(s.CH, "clicks", tz, q)
+}
+
+func (s *ClickHouseClickStore) Summary(start, end time.Time, trackerID, campaignID, channelID string) (total, uniqueVisitors, bots int64, err error) {
+	q := s.query(start, end, trackerID, campaignID, channelID)
+	rows, err := clickhouse.Select[struct {
+		Total          int64 `json:"total"`
+		UniqueVisitors int64 `json:"unique_visitors"`
+		Bots           int64 `json:"bots"`
+	}](context.Background(), s.CH,
+		"SELECT count() AS total, uniqExact(visitor_id) AS unique_visitors, countIf(is_bot) AS bots FROM clicks WHERE "+q.String(), q.params)
+	if err != nil || len(rows) == 0 {
+		return 0, 0, 0, err
+	}
+	return rows[0].Total, rows[0].UniqueVisitors, rows[0].Bots, nil
+}
+
+func (s *ClickHouseClickStore) TopByGroup(start, end time.Time, dimension string, limit int) ([]GroupCount, error) {
+	tables := map[string]string{"tracker_id": "trackers", "campaign_id": "campaigns", "channel_id": "channels"}
+	table, ok := tables[dimension]
+	if !ok {
+		return nil, fmt.Errorf("unsupported dimension: %s", dimension)
+	}
+	return chTopByGroup(s.CH, s.DB, "clicks", dimension, table, start, end, limit)
+}
+
+func (s *ClickHouseClickStore) TopReferrers(start, end time.Time, trackerID, campaignID, channelID string, limit int) ([]NameCount, error) {
+	q := s.query(start, end, trackerID, campaignID, channelID).and("NOT is_bot")
+	raw, err := chNameCounts(s.CH, "clicks", "referer", q, 500)
+	if err != nil {
+		return nil, err
+	}
+	return referrerHostCounts(raw, limit), nil
+}
+
+func (s *ClickHouseClickStore) RawUACounts(start, end time.Time, trackerID, campaignID, channelID string) ([]UACount, error) {
+	return chRawUACounts(s.CH, "clicks", s.query(start, end, trackerID, campaignID, channelID))
+}
+
+func (s *ClickHouseClickStore) LanguageDistribution(start, end time.Time, trackerID, campaignID, channelID string, limit int) ([]NameCount, error) {
+	q := s.query(start, end, trackerID, campaignID, channelID).and("NOT is_bot")
+	return chNameCounts(s.CH, "clicks", "lang", q, limit)
+}
+
+func (s *ClickHouseClickStore) CountryDistribution(start, end time.Time, trackerID, campaignID, channelID string, limit int) ([]NameCount, error) {
+	q := s.query(start, end, trackerID, campaignID, channelID).and("NOT is_bot").and("country != ''")
+	return chNameCounts(s.CH, "clicks", "country", q, limit)
+}
+
+func (s *ClickHouseClickStore) BotCountByDay(start, end time.Time, tz, trackerID, campaignID, channelID string) ([]DailyCount, error) {
+	q := s.query(start, end, trackerID, campaignID, channelID).and("is_bot")
+	return chCountByDay(s.CH, "clicks", tz, q)
+}
+
+func (s *ClickHouseClickStore) CountByHour(start, end time.Time, tz, trackerID, campaignID, channelID string) ([]HourlyCount, error) {
+	q := s.query(start, end, trackerID, campaignID, channelID).and("NOT is_bot")
+	return chCountByHour(s.CH, "clicks", tz, q)
+}
+
+func chCountByDay(ch *clickhouse.Client, table, tz string, q *chQuery) ([]DailyCount, error) {
+	q.params["tz"] = tz
+	return clickhouse.Select[DailyCount](context.Background(), ch, fmt.Sprintf(
+		"SELECT toString(toDate(ts, {tz:String})) AS date, count() AS count FROM %s WHERE %s GROUP BY date ORDER BY date",
+		table, q), q.params)
+}
+
+func chCountByHour(ch *clickhouse.Client, table, tz string, q *chQuery) ([]HourlyCount, error) {
+	q.params["tz"] = tz
+	return clickhouse.Select[HourlyCount](context.Background(), ch, fmt.Sprintf(
+		"SELECT toHour(ts, {tz:String}) AS hour, count() AS count FROM %s WHERE %s GROUP BY hour ORDER BY hour",
+		table, q), q.params)
+}
+
+// chNameCounts counts rows per value of column, most frequent first.
+func chNameCounts(ch *clickhouse.Client, table, column string, q *chQuery, limit int) ([]NameCount, error) {
+	q.params["limit"] = limit
+	return clickhouse.Select[NameCount](context.Background(), ch, fmt.Sprintf(
+		"SELECT %s AS name, count() AS count FROM %s WHERE %s GROUP BY name ORDER BY count DESC, name LIMIT {limit:UInt32}",
+		column, table, q), q.params)
+}
+
+func chRawUACounts(ch *clickhouse.Client, table string, q *chQuery) ([]UACount, error) {
+	return clickhouse.Select[UACount](context.Background(), ch, fmt.Sprintf(
+		"SELECT ua, browser, os, count() AS count FROM %s WHERE %s GROUP BY ua, browser, os ORDER BY count DESC LIMIT 500",
+		table, q), q.params)
+}
+
+// ClickHouseEventStore keeps events in ClickHouse. DB is Postgres, used
+// only to name sites.
+type ClickHouseEventStore struct {
+	CH *clickhouse.Client
+	DB *gorm.DB
+}
+
+func NewClickHouseEventStore(ch *clickhouse.Client, db *gorm.DB) *ClickHouseEventStore {
+	return &ClickHouseEventStore{CH: ch, DB: db}
+}
+
+func (s *ClickHouseEventStore) BatchCreate(events []models.Event) error {
+	now := time.Now()
+	rows := make([]any, len(events))
+	for i := range events {
+		e := &events[i]
+		if e.ID == "" {
+			e.ID = uuid.New().String()
+		}
+		if e.CreatedAt.IsZero() {
+			e.CreatedAt = now
+		}
+		rows[i] = chEventRow(e)
+	}
+	return s.CH.Insert(context.Background(), "events", rows)
+}
+
+// Import inserts the events whose IDs are not stored yet. ClickHouse has
+// no unique keys, so existing IDs are looked up first; a re-imported event
+// keeps its site and timestamp, which bounds the lookup.
+func (s *ClickHouseEventStore) Import(events []models.Event) (int64, error) {
+	bySite := make(map[string][]*models.Event)
+	for i := range events {
+		bySite[events[i].SiteID] = append(bySite[events[i].SiteID], &events[i])
+	}
+	now := time.Now()
+	var rows []any
+	for siteID, group := range bySite {
+		ids := make([]string, len(group))
+		first, last := group[0].TS, group[0].TS
+		for i, e := range group {
+			ids[i] = e.ID
+			if e.TS.Before(first) {
+				first = e.TS
+			}
+			if e.TS.After(last) {
+				last = e.TS
+			}
+		}
+		q := newCHQuery(first, last).eq("site_id", siteID).and("id IN {ids:Array(String)}")
+		q.params["ids"] = ids
+		existing := make(map[string]bool)
+		err := s.CH.Each(context.Background(), "SELECT id FROM events WHERE "+q.String(), q.params, func(decode func(any) error) error {
+			var row struct {
+				ID string `json:"id"`
+			}
+			err := decode(&row)
+			existing[row.ID] = true
+			return err
+		})
+		if err != nil {
+			return 0, err
+		}
+		for _, e := range group {
+			if existing[e.ID] {
+				continue
+			}
+			existing[e.ID] = true // duplicates within the batch
+			if e.CreatedAt.IsZero() {
+				e.CreatedAt = now
+			}
+			rows = append(rows, chEventRow(e))
+		}
+	}
+	if err := s.CH.Insert(context.Background(), "events", rows); err != nil {
+		return 0, err
+	}
+	return int64(len(rows)), nil
+}
+
+func chEventRow(e *models.Event) map[string]any {
+	var clientTS *string
+	if e.ClientTS != nil {
+		t := chTime(*e.ClientTS)
+		clientTS = &t
+	}
+	return map[string]any{
+		"id":            e.ID,
+		"ts":            chTime(e.TS),
+		"client_ts":     clientTS,
+		"server_ts":     chTime(e.ServerTS),
+		"site_id":       e.SiteID,
+		"type":          e.Type,
+		"visitor_id":    e.VisitorID,
+		"session_id":    e.SessionID,
+		"url":           e.URL,
+		"title":         e.Title,
+		"referrer":      e.Referrer,
+		"ip":            e.IP,
+		"country":       e.Country,
+		"ua":            e.UA,
+		"browser":       e.Browser,
+		"os":            e.OS,
+		"lang":          e.Lang,
+		"props":         chJSON(e.Props),
+		"consent":       e.Consent,
+		"suspected_bot": e.SuspectedBot,
+		"is_bot":        e.IsBot,
+		"import_source": e.ImportSource,
+		"created_at":    chTime(e.CreatedAt),
+	}
+}
+
+func (s *ClickHouseEventStore) query(start, end time.Time, siteID string) *chQuery {
+	return newCHQuery(start, end).eq("site_id", siteID)
+}
+
+func (s *ClickHouseEventStore) CountByDay(start, end time.Time, tz, siteID string) ([]DailyCount, error) {
+	return chCountByDay(s.CH, "events", tz, s.query(start, end, siteID).and("NOT is_bot"))
+}
+
+func (s *ClickHouseEventStore) Summary(start, end time.Time, siteID string) (total, uniqueVisitors, uniqueSessions, bots int64, err error) {
+	q := s.query(start, end, siteID)
+	rows, err := clickhouse.Select[struct {
+		Total          int64 `json:"total"`
+		UniqueVisitors int64 `json:"unique_visitors"`
+		UniqueSessions int64 `json:"unique_sessions"`
+		Bots           int64 `json:"bots"`
+	}](context.Background(), s.CH,
+		// Visitors are counted as in uniqueVisitorsExpr.
+		"SELECT count() AS total, uniqExactIf(visitor_id, visitor_id != '') + uniqExactIf(session_id, visitor_id = '') AS unique_visitors, "+
+			"uniqExact(session_id) AS unique_sessions, countIf(is_bot) AS bots FROM events WHERE "+q.String(), q.params)
+	if err != nil || len(rows) == 0 {
+		return 0, 0, 0, 0, err
+	}
+	return rows[0].Total, rows[0].UniqueVisitors, rows[0].UniqueSessions, rows[0].Bots, nil
+}
+
+func (s *ClickHouseEventStore) TopByGroup(start, end time.Time, dimension string, limit int) ([]GroupCount, error) {
+	switch dimension {
+	case "site_id":
+		return chTopByGroup(s.CH, s.DB, "events", "site_id", "sites", start, end, limit)
+	case "type":
+		return chTopByGroup(s.CH, s.DB, "events", "type", "", start, end, limit)
+	}
+	return nil, fmt.Errorf("unsupported dimension: %s", dimension)
+}
+
+func (s *ClickHouseEventStore) TopReferrers(start, end time.Time, siteID string, limit int) ([]NameCount, error) {
+	raw, err := chNameCounts(s.CH, "events", "referrer", s.query(start, end, siteID).and("NOT is_bot"), 500)
+	if err != nil {
+		return nil, err
+	}
+	return referrerHostCounts(raw, limit), nil
+}
+
+func (s *ClickHouseEventStore) TopPages(start, end time.Time, siteID string, limit int) ([]NameCount, error) {
+	raw, err := chNameCounts(s.CH, "events", "url", s.query(start, end, siteID).and("NOT is_bot"), 500)
+	if err != nil {
+		return nil, err
+	}
+	return pagePathCounts(raw, limit), nil
+}
+
+func (s *ClickHouseEventStore) RawUACounts(start, end time.Time, siteID string) ([]UACount, error) {
+	return chRawUACounts(s.CH, "events", s.query(start, end, siteID))
+}
+
+func (s *ClickHouseEventStore) LanguageDistribution(start, end time.Time, siteID string, limit int) ([]NameCount, error) {
+	return chNameCounts(s.CH, "events", "lang", s.query(start, end, siteID).and("NOT is_bot"), limit)
+}
+
+func (s *ClickHouseEventStore) CountryDistribution(start, end time.Time, siteID string, limit int) ([]NameCount, error) {
+	return chNameCounts(s.CH, "events", "country", s.query(start, end, siteID).and("NOT is_bot").and("country != ''"), limit)
+}
+
+func (s *ClickHouseEventStore) BotCountByDay(start, end time.Time, tz, siteID string) ([]DailyCount, error) {
+	return chCountByDay(s.CH, "events", tz, s.query(start, end, siteID).and("is_bot"))
+}
+
+func (s *ClickHouseEventStore) CountByHour(start, end time.Time, tz, siteID string) ([]HourlyCount, error) {
+	return chCountByHour(s.CH, "events", tz, s.query(start, end, siteID).and("NOT is_bot"))
+}
+
+// Funnel streams matching events through f, as EventRepo.Funnel does.
+// chSessionSlack is how far outside the range events are read to compute
+// sessions, so that sessions running past the end are complete and those
+// that started before the start are recognised as such.
+const chSessionSlack = 24 * time.Hour
+
+// sessions selects the non-bot sessions started in [start, end], computed
+// from events as SessionsFromEvents does.
+func (s *ClickHouseEventStore) sessions(start, end time.Time, siteID string) *chQuery {
+	q := newCHQuery(start.Add(-chSessionSlack), end.Add(chSessionSlack)).eq("site_id", siteID).and("session_id != ''")
+	q.params["session_start"], q.params["session_end"] = start, end
+	return q
+}
+
+const chSessionsSQL = `SELECT site_id, session_id, min(ts) AS started_at,
+		dateDiff('second', min(ts), max(ts)) AS duration_seconds,
+		countIf(type = 'pageview') AS pageviews,
+		argMinIf(url, ts, type = 'pageview') AS entry_url,
+		argMaxIf(url, ts, type = 'pageview') AS exit_url
+	FROM events WHERE %s
+	GROUP BY site_id, session_id
+	HAVING started_at BETWEEN {session_start:DateTime64(6, 'UTC')} AND {session_end:DateTime64(6, 'UTC')} AND NOT max(is_bot)`
+
+func (s *ClickHouseEventStore) SessionSummary(start, end time.Time, siteID string) (sessions, bounces int64, avgDuration float64, err error) {
+	q := s.sessions(start, end, siteID)
+	rows, err := clickhouse.Select[struct {
+		Sessions    int64   `json:"sessions"`
+		Bounces     int64   `json:"bounces"`
+		AvgDuration float64 `json:"avg_duration"`
+	}](context.Background(), s.CH, fmt.Sprintf(
+		"SELECT count() AS sessions, countIf(pageviews = 1) AS bounces, ifNotFinite(avg(duration_seconds), 0) AS avg_duration FROM ("+chSessionsSQL+")",
+		q), q.params)
+	if err != nil || len(rows) == 0 {
+		return 0, 0, 0, err
+	}
+	return rows[0].Sessions, rows[0].Bounces, rows[0].AvgDuration, nil
+}
+
+func (s *ClickHouseEventStore) TopEntryPages(start, end time.Time, siteID string, limit int) ([]NameCount, error) {
+	return s.topSessionPages("entry_url", start, end, siteID, limit)
+}
+
+func (s *ClickHouseEventStore) TopExitPages(start, end time.Time, siteID string, limit int) ([]NameCount, error) {
+	return s.topSessionPages("exit_url", start, end, siteID, limit)
+}
+
+// topSessionPages groups sessions by column, which must be entry_url or
+// exit_url.
+func (s *ClickHouseEventStore) topSessionPages(column string, start, end time.Time, siteID string, limit int) ([]NameCount, error) {
+	q := s.sessions(start, end, siteID)
+	raw, err := clickhouse.Select[NameCount](context.Background(), s.CH, fmt.Sprintf(
+		"SELECT %s AS name, count() AS count FROM ("+chSessionsSQL+") WHERE name != '' GROUP BY name ORDER BY count DESC, name LIMIT 500",
+		column, q), q.params)
+	if err != nil {
+		return nil, err
+	}
+	return pagePathCounts(raw, limit), nil
+}
+
+func (s *ClickHouseEventStore) Funnel(start, end time.Time, siteID, by string, f *Funnel) ([]FunnelStepResult, []FunnelBreakdown, error) {
+	actor, ok := chActorExprs[by]
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported group by: %s", by)
+	}
+	types := make([]string, 0, len(f.Steps))
+	for _, st := range f.Steps {
+		types = append(types, st.Type)
+	}
+	q := s.query(start, end, siteID).and("NOT is_bot").and("type IN {types:Array(String)}").and(actor + " != ''")
+	q.params["types"] = types
+	err := s.CH.Each(context.Background(), fmt.Sprintf(
+		"SELECT %s AS actor, toUnixTimestamp64Micro(ts) AS ts_us, type, url, props, country, browser, referrer FROM events WHERE %s ORDER BY actor, ts",
+		actor, q), q.params, func(decode func(any) error) error {
+		var row struct {
+			Actor    string `json:"actor"`
+			TSMicros int64  `json:"ts_us"`
+			Type     string `json:"type"`
+			URL      string `json:"url"`
+			Props    string `json:"props"`
+			Country  string `json:"country"`
+			Browser  string `json:"browser"`
+			Referrer string `json:"referrer"`
+		}
+		if err := decode(&row); err != nil {
+			return err
+		}
+		e := FunnelEvent{Actor: row.Actor, TS: time.UnixMicro(row.TSMicros).UTC(), Type: row.Type, URL: row.URL,
+			Country: row.Country, Browser: row.Browser, Referrer: row.Referrer}
+		if row.Props != "" {
+			if err := json.Unmarshal([]byte(row.Props), &e.Props); err != nil {
+				return err
+			}
+		}
+		f.Add(e)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	steps, breakdowns := f.Result()
+	return steps, breakdowns, nil
+}
+
+// Paths streams the pageviews of sessions that viewed p.Page through p,
+// as EventRepo.Paths does.
+func (s *ClickHouseEventStore) Paths(start, end time.Time, siteID string, p *PathAnalysis, limit int) (PathResult, error) {
+	pageviews := s.query(start, end, siteID).and("NOT is_bot").and("type = 'pageview'")
+	q := s.query(start, end, siteID).and("NOT is_bot").and("type = 'pageview'").and("session_id != ''").
+		and(fmt.Sprintf("session_id IN (SELECT session_id FROM events WHERE %s AND url LIKE {page:String})", pageviews))
+	q.params["page"] = "%" + escapeLike(p.Page) + "%"
+	err := s.CH.Each(context.Background(), "SELECT session_id, url FROM events WHERE "+q.String()+" ORDER BY session_id, ts",
+		q.params, func(decode func(any) error) error {
+			var row struct {
+				SessionID string `json:"session_id"`
+				URL       string `json:"url"`
+			}
+			if err := decode(&row); err != nil {
+				return err
+			}
+			p.Add(row.SessionID, row.URL)
+			return nil
+		})
+	if err != nil {
+		return PathResult{}, err
+	}
+	return p.Result(limit), nil
+}
+
+// chCohortTrunc maps a cohort unit to the function truncating ts to its
+// first day in {tz}; weeks start on Monday, as date_trunc('week') does.
+var chCohortTrunc = map[string]string{
+	"day":  "toDate(%s, {tz:String})",
+	"week": "toMonday(%s, {tz:String})",
+}
+
+// Cohort computes the retention matrix as EventRepo.Cohort does. A
+// session's utm_source and utm_medium are read from its first pageview URL,
+// since the sessions table lives in Postgres.
+func (s *ClickHouseEventStore) Cohort(q CohortQuery) ([]CohortRow, error) {
+	unit, ok := cohortUnits[q.Unit]
+	if !ok {
+		return nil, fmt.Errorf("unsupported cohort unit: %s", q.Unit)
+	}
+	trunc := chCohortTrunc[q.Unit]
+	actor := chActorExprs["visitor"]
+
+	tz := q.Timezone
+	if tz == "" {
+		tz = "UTC"
+	}
+	params := clickhouse.Params{"tz": tz, "start": q.Start, "end": q.End, "periods": q.Periods}
+	firstWhere := []string{"NOT is_bot", actor + " != ''"}
+	actWhere := []string{"NOT is_bot", chTimeRange}
+	if q.SiteID != "" {
+		firstWhere = append(firstWhere, "site_id = {site_id:String}")
+		actWhere = append(actWhere, "site_id = {site_id:String}")
+		params["site_id"] = q.SiteID
+	}
+	if q.FirstEvent != "" {
+		firstWhere = append(firstWhere, "type = {first_event:String}")
+		params["first_event"] = q.FirstEvent
+	}
+	if q.ReturnEvent != "" {
+		actWhere = append(actWhere, "type = {return_event:String}")
+		params["return_event"] = q.ReturnEvent
+	}
+	if q.Channel != nil {
+		scope := chTimeRange
+		if q.SiteID != "" {
+			scope += " AND site_id = {site_id:String}"
+		}
+		firstWhere = append(firstWhere, actor+` IN (
+			SELECT `+actor+` FROM events
+			WHERE `+scope+` AND extractURLParameter(url, {click_param:String}) IN (
+				SELECT id FROM clicks WHERE channel_id = {channel_id:String} AND ts <= {end:DateTime64(6, 'UTC')})
+			UNION ALL
+			SELECT any(`+actor+`) FROM events
+			WHERE `+scope+` AND type = 'pageview' AND session_id != ''
+			GROUP BY session_id
+			HAVING extractURLParameter(argMin(url, ts), 'utm_source') = {utm_source:String}
+				AND {utm_source:String} != ''
+				AND extractURLParameter(argMin(url, ts), 'utm_medium') = {utm_medium:String})`)
+		params["click_param"] = ClickIDParam
+		params["channel_id"], params["utm_source"], params["utm_medium"] = q.Channel.ID, q.Channel.Source, q.Channel.Medium
+	}
+
+	firsts := fmt.Sprintf(`firsts AS (
+		SELECT %[1]s AS actor, %[2]s AS cohort_date
+		FROM events WHERE %[3]s
+		GROUP BY actor HAVING min(ts) BETWEEN {start:DateTime64(6, 'UTC')} AND {end:DateTime64(6, 'UTC')})`,
+		actor, fmt.Sprintf(trunc, "min(ts)"), strings.Join(firstWhere, " AND "))
+	activity := fmt.Sprintf(`activity AS (
+		SELECT DISTINCT %[1]s AS actor, %[2]s AS period_date
+		FROM events WHERE %[3]s)`,
+		actor, fmt.Sprintf(trunc, "ts"), strings.Join(actWhere, " AND "))
+
+	type row struct {
+		Cohort string `json:"cohort"`
+		Period int    `json:"period"`
+		Count  int64  `json:"count"`
+	}
+	sizes, err := clickhouse.Select[row](context.Background(), s.CH, "WITH "+firsts+`
+		SELECT toString(cohort_date) AS cohort, -1 AS period, count() AS count FROM firsts GROUP BY cohort_date`, params)
+	if err != nil {
+		return nil, err
+	}
+	counts, err := clickhouse.Select[row](context.Background(), s.CH, fmt.Sprintf(`WITH %s, %s
+		SELECT toString(cohort_date) AS cohort, period, uniqExact(actor) AS count FROM (
+			SELECT f.cohort_date AS cohort_date, intDiv(dateDiff('day', f.cohort_date, a.period_date), %d) AS period, a.actor AS actor
+			FROM firsts AS f INNER JOIN activity AS a ON a.actor = f.actor
+			WHERE a.period_date >= f.cohort_date)
+		WHERE period < {periods:Int64}
+		GROUP BY cohort_date, period`, firsts, activity, unit.days), params)
+	if err != nil {
+		return nil, err
+	}
+
+	toCounts := func(rows []row) ([]cohortCount, error) {
+		out := make([]cohortCount, len(rows))
+		for i, r := range rows {
+			t, err := time.Parse("2006-01-02", r.Cohort)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = cohortCount{Cohort: t, Period: r.Period, Count: r.Count}
+		}
+		return out, nil
+	}
+	sizeCounts, err := toCounts(sizes)
+	if err != nil {
+		return nil, err
+	}
+	retained, err := toCounts(counts)
+	if err != nil {
+		return nil, err
+	}
+	return buildCohortRows(sizeCounts, retained, q.Periods), nil
+}
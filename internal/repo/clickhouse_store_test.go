@@ -0,0 +1,213 @@
+package repo
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tracking/analysis/internal/clickhouse"
+	"github.com/tracking/analysis/internal/models"
+)
+
+// fakeClickHouse answers every query with body and records the last
+// query and its parameters.
+type fakeClickHouse struct {
+	body   string
+	query  string
+	params url.Values
+}
+
+func (f *fakeClickHouse) start(t *testing.T) *clickhouse.Client {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		f.query, f.params = string(b), r.URL.Query()
+		if q := r.URL.Query().Get("query"); q != "" {
+			f.query = q + "\n" + string(b)
+		}
+		io.WriteString(w, f.body)
+	}))
+	t.Cleanup(srv.Close)
+	return clickhouse.New(srv.URL, "", "", "")
+}
+
+func TestClickHouseClickStore_CountByDay(t *testing.T) {
+	fake := &fakeClickHouse{body: `{"date":"2024-03-04","count":5}` + "\n"}
+	s := NewClickHouseClickStore(fake.start(t), nil)
+	start := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)
+
+	daily, err := s.CountByDay(start, start.Add(24*time.Hour), "Europe/Berlin", "t1", "", "ch1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(daily) != 1 || daily[0] != (DailyCount{Date: "2024-03-04", Count: 5}) {
+		t.Errorf("daily = %v", daily)
+	}
+	for _, want := range []string{"toDate(ts, {tz:String})", "tracker_id = {tracker_id:String}", "channel_id = {channel_id:String}", "NOT is_bot"} {
+		if !strings.Contains(fake.query, want) {
+			t.Errorf("query missing %q: %s", want, fake.query)
+		}
+	}
+	if strings.Contains(fake.query, "campaign_id") {
+		t.Errorf("unset filter in query: %s", fake.query)
+	}
+	if fake.params.Get("param_tz") != "Europe/Berlin" || fake.params.Get("param_tracker_id") != "t1" ||
+		fake.params.Get("param_start") != "2024-03-04 00:00:00.000000" {
+		t.Errorf("params = %v", fake.params)
+	}
+}
+
+func TestClickHouseClickStore_Create(t *testing.T) {
+	fake := &fakeClickHouse{}
+	s := NewClickHouseClickStore(fake.start(t), nil)
+	c := &models.Click{TS: time.Date(2024, 3, 4, 5, 6, 7, 0, time.UTC), TrackerID: "t1", Props: models.JSONMap{"a": 1}}
+	if err := s.Create(c); err != nil {
+		t.Fatal(err)
+	}
+	if c.ID == "" || c.CreatedAt.IsZero() {
+		t.Error("id and created_at should be set")
+	}
+	for _, want := range []string{"INSERT INTO clicks FORMAT JSONEachRow", `"ts":"2024-03-04 05:06:07.000000"`, `"props":"{\"a\":1}"`, `"campaign_id":""`} {
+		if !strings.Contains(fake.query, want) {
+			t.Errorf("insert missing %q: %s", want, fake.query)
+		}
+	}
+}
+
+func TestClickHouseEventStore_Funnel(t *testing.T) {
+	fake := &fakeClickHouse{body: strings.Join([]string{
+		`{"actor":"v1","ts_us":1709528400000000,"type":"pageview","url":"https://x.test/","props":"{}","country":"DE","browser":"","referrer":""}`,
+		`{"actor":"v1","ts_us":1709528460000000,"type":"signup","url":"https://x.test/join","props":"{\"plan\":\"pro\"}","country":"DE","browser":"","referrer":""}`,
+		`{"actor":"v2","ts_us":1709528400000000,"type":"pageview","url":"https://x.test/","props":"{}","country":"FR","browser":"","referrer":""}`,
+	}, "\n")}
+	s := NewClickHouseEventStore(fake.start(t), nil)
+	f := &Funnel{Steps: []FunnelStep{{Type: "pageview"}, {Type: "signup", Props: map[string]any{"plan": "pro"}}}, Window: time.Hour}
+
+	steps, _, err := s.Funnel(time.Unix(0, 0), time.Now(), "", "visitor", f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if steps[0].Count != 2 || steps[1].Count != 1 {
+		t.Errorf("steps = %+v", steps)
+	}
+	if fake.params.Get("param_types") != "['pageview','signup']" {
+		t.Errorf("types param = %q", fake.params.Get("param_types"))
+	}
+	if _, _, err := s.Funnel(time.Now(), time.Now(), "", "tab", f); err == nil {
+		t.Error("expected error for unsupported group by")
+	}
+}
+
+func TestClickHouseEventStore_Cohort(t *testing.T) {
+	fake := &fakeClickHouse{body: `{"cohort":"2024-03-04","period":-1,"count":10}` + "\n"}
+	s := NewClickHouseEventStore(fake.start(t), nil)
+	rows, err := s.Cohort(CohortQuery{Start: time.Now().Add(-time.Hour), End: time.Now(), Unit: "week", Periods: 2,
+		Channel: &ChannelMatch{ID: "ch1", Source: "news", Medium: "email"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].Cohort != "2024-03-04" || rows[0].Size != 10 {
+		t.Errorf("rows = %+v", rows)
+	}
+	for _, want := range []string{"toMonday(", "extractURLParameter(url, {click_param:String})", "extractURLParameter(argMin(url, ts), 'utm_source')", "period < {periods:Int64}"} {
+		if !strings.Contains(fake.query, want) {
+			t.Errorf("query missing %q: %s", want, fake.query)
+		}
+	}
+	if fake.params.Get("param_utm_medium") != "email" || fake.params.Get("param_channel_id") != "ch1" || fake.params.Get("param_click_param") != "_tk_cid" {
+		t.Errorf("params = %v", fake.params)
+	}
+}
+
+type recordingClickStore struct {
+	ClickStore
+	created []string
+	err     error
+}
+
+func (s *recordingClickStore) Create(c *models.Click) error {
+	s.created = append(s.created, c.ID)
+	return s.err
+}
+
+func TestDualClickStore_Create(t *testing.T) {
+	primary, secondary := &recordingClickStore{}, &recordingClickStore{err: errors.New("down")}
+	s := &DualClickStore{ClickStore: primary, Primary: primary, Secondary: secondary}
+	if err := s.Create(&models.Click{ID: "c1"}); err != nil {
+		t.Fatalf("secondary error should not fail the write: %v", err)
+	}
+	if len(primary.created) != 1 || len(secondary.created) != 1 {
+		t.Errorf("writes: primary %v, secondary %v", primary.created, secondary.created)
+	}
+
+	primary.err = errors.New("down")
+	if err := s.Create(&models.Click{ID: "c2"}); err == nil {
+		t.Error("primary error should fail the write")
+	}
+	if len(secondary.created) != 1 {
+		t.Error("secondary should not be written after a primary failure")
+	}
+}
+
+func TestClickHouseEventStore_ImportSkipsStored(t *testing.T) {
+	fake := &fakeClickHouse{body: `{"id":"e1"}` + "\n"}
+	s := NewClickHouseEventStore(fake.start(t), nil)
+	ts := time.Date(2024, 3, 4, 5, 6, 7, 0, time.UTC)
+	events := []models.Event{
+		{ID: "e1", TS: ts, SiteID: "s1", Type: "pageview"},
+		{ID: "e2", TS: ts.Add(time.Hour), SiteID: "s1", Type: "pageview", ImportSource: "ga4"},
+		{ID: "e2", TS: ts.Add(time.Hour), SiteID: "s1", Type: "pageview", ImportSource: "ga4"},
+	}
+	n, err := s.Import(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("imported = %d, want 1", n)
+	}
+	if !strings.HasPrefix(fake.query, "INSERT INTO events") || strings.Count(fake.query, `"id":"e2"`) != 1 || strings.Contains(fake.query, `"id":"e1"`) {
+		t.Errorf("insert = %s", fake.query)
+	}
+	if !strings.Contains(fake.query, `"import_source":"ga4"`) {
+		t.Errorf("import_source not inserted: %s", fake.query)
+	}
+}
+
+func TestClickHouseEventStore_SessionStats(t *testing.T) {
+	fake := &fakeClickHouse{body: `{"sessions":4,"bounces":1,"avg_duration":30.5}` + "\n"}
+	s := NewClickHouseEventStore(fake.start(t), nil)
+	start := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)
+
+	sessions, bounces, avg, err := s.SessionSummary(start, start.Add(24*time.Hour), "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sessions != 4 || bounces != 1 || avg != 30.5 {
+		t.Errorf("summary = %d, %d, %v", sessions, bounces, avg)
+	}
+	// Events are read around the range so sessions crossing it are whole
+	if fake.params.Get("param_start") != "2024-03-03 00:00:00.000000" || fake.params.Get("param_session_start") != "2024-03-04 00:00:00.000000" {
+		t.Errorf("params = %v", fake.params)
+	}
+	for _, want := range []string{"site_id = {site_id:String}", "GROUP BY site_id, session_id", "NOT max(is_bot)"} {
+		if !strings.Contains(fake.query, want) {
+			t.Errorf("query missing %q: %s", want, fake.query)
+		}
+	}
+
+	fake.body = `{"name":"https://x.test/a?b=1","count":3}` + "\n" + `{"name":"https://x.test/a","count":2}` + "\n"
+	pages, err := s.TopEntryPages(start, start.Add(24*time.Hour), "s1", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 1 || pages[0] != (NameCount{Name: "/a", Count: 5}) {
+		t.Errorf("entry pages = %v", pages)
+	}
+	if !strings.Contains(fake.query, "SELECT entry_url AS name") {
+		t.Errorf("query = %s", fake.query)
+	}
+}
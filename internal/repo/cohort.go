@@ -0,0 +1,186 @@
+package repo
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ClickIDParam is the query parameter that carries a click's ID to the
+// landing page of trackers with AppendClickID, so the visitor's first
+// pageview links back to the click. Clicks are collected on the tracker's
+// domain, where the site's visitor ID is not available.
+const ClickIDParam = "_tk_cid"
+
+// clickIDPattern extracts the click ID from a landing URL.
+const clickIDPattern = "[?&]" + ClickIDParam + "=([0-9a-fA-F-]{36})"
+
+// cohortUnits maps a cohort granularity to its date_trunc unit and length in days.
+var cohortUnits = map[string]struct {
+	trunc string
+	days  int
+}{
+	"day":  {"day", 1},
+	"week": {"week", 7},
+}
+
+// CohortQuery selects which visitors form cohorts and what counts as a return.
+type CohortQuery struct {
+	Start, End time.Time
+	SiteID     string
+	Unit       string // "day" or "week"
+	Periods    int
+	Timezone   string // IANA zone days and weeks start in
+	// FirstEvent, when set, dates each visitor by their first event of this
+	// type instead of their first event of any type.
+	FirstEvent string
+	// ReturnEvent, when set, only counts events of this type as activity.
+	ReturnEvent string
+	// Channel, when set, restricts cohorts to visitors who landed from one
+	// of its clicks or whose session landed with its utm_source and
+	// utm_medium.
+	Channel *ChannelMatch
+}
+
+type ChannelMatch struct {
+	ID     string
+	Source string
+	Medium string
+}
+
+type CohortRow struct {
+	Cohort    string    `json:"cohort"`
+	Size      int64     `json:"size"`
+	Retained  []int64   `json:"retained"`
+	Retention []float64 `json:"retention"`
+}
+
+type cohortCount struct {
+	Cohort time.Time
+	Period int
+	Count  int64
+}
+
+// Cohort returns one row per cohort starting in [Start, End], oldest first.
+// Retained[i] counts the cohort's visitors active i periods after their
+// cohort period; activity after End is not counted.
+func (r *EventRepo) Cohort(q CohortQuery) ([]CohortRow, error) {
+	unit, ok := cohortUnits[q.Unit]
+	if !ok {
+		return nil, fmt.Errorf("unsupported cohort unit: %s", q.Unit)
+	}
+	actor := actorExprs["visitor"]
+
+	tz := q.Timezone
+	if tz == "" {
+		tz = "UTC"
+	}
+	firstWhere := []string{"is_bot = false", actor + " != ''"}
+	firstArgs := []any{tz}
+	if q.SiteID != "" {
+		firstWhere = append(firstWhere, "site_id = ?")
+		firstArgs = append(firstArgs, q.SiteID)
+	}
+	if q.FirstEvent != "" {
+		firstWhere = append(firstWhere, "type = ?")
+		firstArgs = append(firstArgs, q.FirstEvent)
+	}
+	if q.Channel != nil {
+		// Landings are only looked for where cohorts start, like first events
+		landedWhere := "url LIKE ? AND substring(url from ?) IN (SELECT id::text FROM clicks WHERE channel_id = ? AND ts <= ?) AND ts BETWEEN ? AND ?"
+		landedArgs := []any{"%" + escapeLike(ClickIDParam+"=") + "%", clickIDPattern, q.Channel.ID, q.End, q.Start, q.End}
+		sessionWhere := "utm_source != '' AND utm_source = ? AND utm_medium = ? AND started_at BETWEEN ? AND ?"
+		sessionArgs := []any{q.Channel.Source, q.Channel.Medium, q.Start, q.End}
+		if q.SiteID != "" {
+			landedWhere += " AND site_id = ?"
+			landedArgs = append(landedArgs, q.SiteID)
+			sessionWhere += " AND site_id = ?"
+			sessionArgs = append(sessionArgs, q.SiteID)
+		}
+		firstWhere = append(firstWhere, actor+` IN (
+			SELECT `+actor+` FROM events WHERE `+landedWhere+`
+			UNION
+			SELECT COALESCE(NULLIF(visitor_id, ''), session_id) FROM sessions WHERE `+sessionWhere+`)`)
+		firstArgs = append(append(firstArgs, landedArgs...), sessionArgs...)
+	}
+	firstArgs = append(firstArgs, q.Start, q.End)
+
+	actWhere := []string{"is_bot = false", "ts BETWEEN ? AND ?"}
+	actArgs := []any{tz, q.Start, q.End}
+	if q.SiteID != "" {
+		actWhere = append(actWhere, "site_id = ?")
+		actArgs = append(actArgs, q.SiteID)
+	}
+	if q.ReturnEvent != "" {
+		actWhere = append(actWhere, "type = ?")
+		actArgs = append(actArgs, q.ReturnEvent)
+	}
+
+	// Only whitelisted fragments are interpolated; every value is a placeholder.
+	firsts := fmt.Sprintf(`firsts AS (
+		SELECT %[1]s AS actor, DATE_TRUNC('%[2]s', MIN(ts) AT TIME ZONE ?) AS cohort
+		FROM events WHERE %[3]s
+		GROUP BY 1 HAVING MIN(ts) BETWEEN ? AND ?)`,
+		actor, unit.trunc, strings.Join(firstWhere, " AND "))
+	activity := fmt.Sprintf(`activity AS (
+		SELECT DISTINCT %[1]s AS actor, DATE_TRUNC('%[2]s', ts AT TIME ZONE ?) AS period
+		FROM events WHERE %[3]s)`,
+		actor, unit.trunc, strings.Join(actWhere, " AND "))
+
+	var sizes []cohortCount
+	err := r.DB.Raw("WITH "+firsts+`
+		SELECT cohort, -1 AS period, COUNT(*) AS count FROM firsts GROUP BY cohort`,
+		firstArgs...).Scan(&sizes).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var counts []cohortCount
+	args := append(append([]any{}, firstArgs...), actArgs...)
+	args = append(args, q.Periods)
+	err = r.DB.Raw(fmt.Sprintf(`WITH %s, %s, retained AS (
+		SELECT f.cohort, ((a.period::date - f.cohort::date) / %d) AS period, a.actor
+		FROM firsts f JOIN activity a ON a.actor = f.actor AND a.period >= f.cohort)
+		SELECT cohort, period, COUNT(DISTINCT actor) AS count FROM retained
+		WHERE period < ? GROUP BY cohort, period`, firsts, activity, unit.days),
+		args...).Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return buildCohortRows(sizes, counts, q.Periods), nil
+}
+
+// buildCohortRows assembles the retention matrix from cohort sizes and
+// per-period counts, filling periods with no activity with zero.
+func buildCohortRows(sizes, counts []cohortCount, periods int) []CohortRow {
+	index := make(map[string]int, len(sizes))
+	rows := make([]CohortRow, 0, len(sizes))
+	for _, s := range sizes {
+		key := s.Cohort.Format("2006-01-02")
+		index[key] = len(rows)
+		rows = append(rows, CohortRow{
+			Cohort:    key,
+			Size:      s.Count,
+			Retained:  make([]int64, periods),
+			Retention: make([]float64, periods),
+		})
+	}
+	for _, c := range counts {
+		i, ok := index[c.Cohort.Format("2006-01-02")]
+		if !ok || c.Period < 0 || c.Period >= periods {
+			continue
+		}
+		rows[i].Retained[c.Period] = c.Count
+	}
+	for i := range rows {
+		for p, n := range rows[i].Retained {
+			if rows[i].Size > 0 {
+				rows[i].Retention[p] = roundPct(n, rows[i].Size)
+			}
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Cohort < rows[j].Cohort })
+	return rows
+}
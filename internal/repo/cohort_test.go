@@ -0,0 +1,81 @@
+package repo
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestBuildCohortRows(t *testing.T) {
+	w1 := time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)
+	w2 := w1.AddDate(0, 0, 7)
+	sizes := []cohortCount{{Cohort: w2, Count: 10}, {Cohort: w1, Count: 4}}
+	counts := []cohortCount{
+		{Cohort: w1, Period: 0, Count: 4},
+		{Cohort: w1, Period: 2, Count: 1},
+		{Cohort: w2, Period: 0, Count: 10},
+		{Cohort: w2, Period: 1, Count: 3},
+		{Cohort: w2, Period: 5, Count: 1}, // beyond periods, ignored
+	}
+
+	rows := buildCohortRows(sizes, counts, 3)
+	if len(rows) != 2 || rows[0].Cohort != "2024-05-06" || rows[1].Cohort != "2024-05-13" {
+		t.Fatalf("rows not ordered oldest first: %+v", rows)
+	}
+	if got := rows[0].Retained; got[0] != 4 || got[1] != 0 || got[2] != 1 {
+		t.Errorf("first cohort retained = %v", got)
+	}
+	if got := rows[0].Retention; got[0] != 100 || got[2] != 25 {
+		t.Errorf("first cohort retention = %v", got)
+	}
+	if got := rows[1].Retention; got[1] != 30 || got[2] != 0 {
+		t.Errorf("second cohort retention = %v", got)
+	}
+}
+
+func TestClickIDPattern(t *testing.T) {
+	id := "7f3c9a52-1b0e-4c8e-9d1a-2f6b8e4c0a11"
+	re := regexp.MustCompile(clickIDPattern)
+	for _, u := range []string{
+		"https://shop.example/?" + ClickIDParam + "=" + id,
+		"https://shop.example/p?utm_source=x&" + ClickIDParam + "=" + id + "&q=1",
+	} {
+		if m := re.FindStringSubmatch(u); m == nil || m[1] != id {
+			t.Errorf("%s: match = %v", u, m)
+		}
+	}
+	if re.MatchString("https://shop.example/?x" + ClickIDParam + "=" + id) {
+		t.Error("matched a parameter that only ends with the click ID name")
+	}
+}
+
+func TestCohort_ChannelJoinsClickID(t *testing.T) {
+	db := dryRunDB(t)
+	var sqls []string
+	db.Callback().Row().After("gorm:row").Register("test:capture", func(tx *gorm.DB) {
+		sqls = append(sqls, tx.Statement.SQL.String())
+	})
+	// Scanning is unsupported in dry run mode; only the SQL matters here
+	r := &EventRepo{DB: db.Session(&gorm.Session{Logger: logger.Discard})}
+	r.Cohort(CohortQuery{Start: time.Now().Add(-time.Hour), End: time.Now(), Unit: "week", Periods: 2, SiteID: "s1",
+		Channel: &ChannelMatch{ID: "ch1", Source: "news", Medium: "email"}})
+	if len(sqls) == 0 {
+		t.Fatal("no query captured")
+	}
+	if !strings.Contains(sqls[0], "substring(url from $") || strings.Contains(sqls[0], "SELECT visitor_id FROM clicks") {
+		t.Errorf("query = %s", sqls[0])
+	}
+	// The landing lookup is bounded like the cohort's first events
+	for _, want := range []string{"url LIKE $", "ts BETWEEN $", "started_at BETWEEN $"} {
+		if !strings.Contains(sqls[0], want) {
+			t.Errorf("query missing %q: %s", want, sqls[0])
+		}
+	}
+	if strings.Count(sqls[0], "site_id = $") != 3 || strings.Contains(sqls[0], "'%_tk_cid=%'") {
+		t.Errorf("channel lookup not scoped to the site: %s", sqls[0])
+	}
+}
@@ -2,11 +2,11 @@ package repo
 
 import (
 	"fmt"
-	"net/url"
 	"time"
 
 	"github.com/tracking/analysis/internal/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 func (r *EventRepo) eventFilters(q *gorm.DB, siteID string) *gorm.DB {
@@ -18,6 +18,9 @@ func (r *EventRepo) eventFilters(q *gorm.DB, siteID string) *gorm.DB {
 
 type EventRepo struct {
 	DB *gorm.DB
+	// Outbox, when set, queues each stored event for the streaming sinks
+	// in the same transaction.
+	Outbox *OutboxRepo
 }
 
 func NewEventRepo(db *gorm.DB) *EventRepo {
@@ -25,24 +28,57 @@ func NewEventRepo(db *gorm.DB) *EventRepo {
 }
 
 func (r *EventRepo) BatchCreate(events []models.Event) error {
-	return r.DB.CreateInBatches(events, 100).Error
+	if r.Outbox == nil {
+		return r.DB.CreateInBatches(events, 100).Error
+	}
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.CreateInBatches(events, 100).Error; err != nil {
+			return err
+		}
+		entries := make([]OutboxEntry, len(events))
+		for i := range events {
+			key := events[i].VisitorID
+			if key == "" {
+				key = events[i].SessionID
+			}
+			if key == "" {
+				key = events[i].ID
+			}
+			entries[i] = OutboxEntry{RowID: events[i].ID, Key: key, Row: &events[i]}
+		}
+		return r.Outbox.Enqueue(tx, "events", entries)
+	})
+	if err == nil {
+		r.Outbox.Notify()
+	}
+	return err
+}
+
+// Import inserts events, skipping those whose ID is already stored.
+func (r *EventRepo) Import(events []models.Event) (int64, error) {
+	res := r.DB.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(events, 100)
+	return res.RowsAffected, res.Error
 }
 
-func (r *EventRepo) CountByDay(start, end time.Time, siteID string) ([]DailyCount, error) {
+func (r *EventRepo) CountByDay(start, end time.Time, tz, siteID string) ([]DailyCount, error) {
 	q := r.DB.Model(&models.Event{}).
-		Select("DATE(ts) AS date, COUNT(*) AS count").
+		Select("DATE(ts AT TIME ZONE ?) AS date, COUNT(*) AS count", tz).
 		Where("ts BETWEEN ? AND ? AND is_bot = false", start, end)
 	if siteID != "" {
 		q = q.Where("site_id = ?", siteID)
 	}
 	var results []DailyCount
-	err := q.Group("DATE(ts)").Order("date").Find(&results).Error
+	err := q.Group("date").Order("date").Find(&results).Error
 	return results, err
 }
 
+// uniqueVisitorsExpr counts consented visitors by visitor_id and, since
+// cookieless events carry no visitor_id, each cookieless session as one visitor.
+const uniqueVisitorsExpr = "COUNT(DISTINCT NULLIF(visitor_id, '')) + COUNT(DISTINCT CASE WHEN visitor_id = '' THEN session_id END)"
+
 func (r *EventRepo) Summary(start, end time.Time, siteID string) (total, uniqueVisitors, uniqueSessions, bots int64, err error) {
 	q := r.DB.Model(&models.Event{}).
-		Select("COUNT(*) AS total, COUNT(DISTINCT visitor_id) AS unique_visitors, COUNT(DISTINCT session_id) AS unique_sessions, SUM(CASE WHEN is_bot THEN 1 ELSE 0 END) AS bots").
+		Select("COUNT(*) AS total, " + uniqueVisitorsExpr + " AS unique_visitors, COUNT(DISTINCT session_id) AS unique_sessions, SUM(CASE WHEN is_bot THEN 1 ELSE 0 END) AS bots").
 		Where("ts BETWEEN ? AND ?", start, end)
 	if siteID != "" {
 		q = q.Where("site_id = ?", siteID)
@@ -95,12 +131,7 @@ func (r *EventRepo) TopReferrers(start, end time.Time, siteID string, limit int)
 	if err != nil {
 		return nil, err
 	}
-	hostMap := make(map[string]int64)
-	for _, r := range raw {
-		host := NormalizeReferrerHost(r.Name)
-		hostMap[host] += r.Count
-	}
-	return mapToSortedNameCounts(hostMap, limit), nil
+	return referrerHostCounts(raw, limit), nil
 }
 
 func (r *EventRepo) TopPages(start, end time.Time, siteID string, limit int) ([]NameCount, error) {
@@ -113,25 +144,16 @@ func (r *EventRepo) TopPages(start, end time.Time, siteID string, limit int) ([]
 	if err != nil {
 		return nil, err
 	}
-	// Strip protocol+host, keep path
-	pathMap := make(map[string]int64)
-	for _, r := range raw {
-		path := r.Name
-		if u, err := url.Parse(r.Name); err == nil && u.Path != "" {
-			path = u.Path
-		}
-		pathMap[path] += r.Count
-	}
-	return mapToSortedNameCounts(pathMap, limit), nil
+	return pagePathCounts(raw, limit), nil
 }
 
-func (r *EventRepo) RawUACounts(start, end time.Time, siteID string) ([]NameCount, error) {
+func (r *EventRepo) RawUACounts(start, end time.Time, siteID string) ([]UACount, error) {
 	q := r.DB.Model(&models.Event{}).
-		Select("ua AS name, COUNT(*) AS count").
+		Select("ua, browser, os, COUNT(*) AS count").
 		Where("ts BETWEEN ? AND ?", start, end)
 	q = r.eventFilters(q, siteID)
-	var results []NameCount
-	err := q.Group("ua").Order("count DESC").Limit(500).Find(&results).Error
+	var results []UACount
+	err := q.Group("ua, browser, os").Order("count DESC").Limit(500).Find(&results).Error
 	return results, err
 }
 
@@ -155,19 +177,19 @@ func (r *EventRepo) CountryDistribution(start, end time.Time, siteID string, lim
 	return results, err
 }
 
-func (r *EventRepo) BotCountByDay(start, end time.Time, siteID string) ([]DailyCount, error) {
+func (r *EventRepo) BotCountByDay(start, end time.Time, tz, siteID string) ([]DailyCount, error) {
 	q := r.DB.Model(&models.Event{}).
-		Select("DATE(ts) AS date, COUNT(*) AS count").
+		Select("DATE(ts AT TIME ZONE ?) AS date, COUNT(*) AS count", tz).
 		Where("ts BETWEEN ? AND ? AND is_bot = true", start, end)
 	q = r.eventFilters(q, siteID)
 	var results []DailyCount
-	err := q.Group("DATE(ts)").Order("date").Find(&results).Error
+	err := q.Group("date").Order("date").Find(&results).Error
 	return results, err
 }
 
-func (r *EventRepo) CountByHour(start, end time.Time, siteID string) ([]HourlyCount, error) {
+func (r *EventRepo) CountByHour(start, end time.Time, tz, siteID string) ([]HourlyCount, error) {
 	q := r.DB.Model(&models.Event{}).
-		Select("EXTRACT(HOUR FROM ts)::int AS hour, COUNT(*) AS count").
+		Select("EXTRACT(HOUR FROM ts AT TIME ZONE ?)::int AS hour, COUNT(*) AS count", tz).
 		Where("ts BETWEEN ? AND ? AND is_bot = false", start, end)
 	q = r.eventFilters(q, siteID)
 	var results []HourlyCount
@@ -0,0 +1,142 @@
+package repo
+
+import (
+	"errors"
+	"time"
+
+	"github.com/tracking/analysis/internal/models"
+	"gorm.io/gorm"
+)
+
+// ExportFilter selects the rows of an export.
+type ExportFilter struct {
+	Start, End  time.Time
+	TrackerID   string
+	CampaignID  string
+	ChannelID   string
+	SiteID      string
+	EventType   string
+	IncludeBots bool
+}
+
+type ExportRepo struct {
+	DB *gorm.DB
+}
+
+func NewExportRepo(db *gorm.DB) *ExportRepo {
+	return &ExportRepo{DB: db}
+}
+
+func (r *ExportRepo) Create(job *models.ExportJob) error {
+	return r.DB.Create(job).Error
+}
+
+func (r *ExportRepo) GetByID(id string) (*models.ExportJob, error) {
+	var job models.ExportJob
+	err := r.DB.First(&job, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (r *ExportRepo) List(limit int) ([]models.ExportJob, error) {
+	var jobs []models.ExportJob
+	err := r.DB.Order("created_at DESC").Limit(limit).Find(&jobs).Error
+	return jobs, err
+}
+
+func (r *ExportRepo) Update(job *models.ExportJob) error {
+	return r.DB.Save(job).Error
+}
+
+// ClaimNext marks the oldest queued job, or a running job whose heartbeat
+// stopped before stale, as running and returns it. It returns nil when
+// there is nothing to do or another replica claimed the job first.
+func (r *ExportRepo) ClaimNext(now, stale time.Time) (*models.ExportJob, error) {
+	var job models.ExportJob
+	err := r.DB.Where("status = ? OR (status = ? AND updated_at < ?)", "queued", "running", stale).
+		Order("created_at").First(&job).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	res := r.DB.Model(&models.ExportJob{}).
+		Where("id = ? AND status = ? AND updated_at = ?", job.ID, job.Status, job.UpdatedAt).
+		Updates(map[string]any{"status": "running", "started_at": now, "updated_at": now, "rows": 0})
+	if res.Error != nil || res.RowsAffected != 1 {
+		return nil, res.Error
+	}
+	job.Status, job.StartedAt, job.UpdatedAt, job.Rows = "running", &now, now, 0
+	return &job, nil
+}
+
+// Heartbeat records progress on a running job.
+func (r *ExportRepo) Heartbeat(id string, rows int64, now time.Time) error {
+	return r.DB.Model(&models.ExportJob{}).Where("id = ?", id).
+		Updates(map[string]any{"rows": rows, "updated_at": now}).Error
+}
+
+// each scans q row by row into dest in time order, calling fn after each
+// row, so exports never hold more than one row in memory.
+func (r *ExportRepo) each(q *gorm.DB, dest any, fn func() error) error {
+	rows, err := q.Order("ts").Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := r.DB.ScanRows(rows, dest); err != nil {
+			return err
+		}
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// EachClick streams the clicks matching f in time order.
+func (r *ExportRepo) EachClick(f ExportFilter, fn func(*models.Click) error) error {
+	q := r.DB.Model(&models.Click{}).Where("ts BETWEEN ? AND ?", f.Start, f.End)
+	if f.TrackerID != "" {
+		q = q.Where("tracker_id = ?", f.TrackerID)
+	}
+	if f.CampaignID != "" {
+		q = q.Where("campaign_id = ?", f.CampaignID)
+	}
+	if f.ChannelID != "" {
+		q = q.Where("channel_id = ?", f.ChannelID)
+	}
+	if !f.IncludeBots {
+		q = q.Where("is_bot = false")
+	}
+	var c models.Click
+	return r.each(q, &c, func() error {
+		row := c
+		c = models.Click{}
+		return fn(&row)
+	})
+}
+
+// EachEvent streams the events matching f in time order.
+func (r *ExportRepo) EachEvent(f ExportFilter, fn func(*models.Event) error) error {
+	q := r.DB.Model(&models.Event{}).Where("ts BETWEEN ? AND ?", f.Start, f.End)
+	if f.SiteID != "" {
+		q = q.Where("site_id = ?", f.SiteID)
+	}
+	if f.EventType != "" {
+		q = q.Where("type = ?", f.EventType)
+	}
+	if !f.IncludeBots {
+		q = q.Where("is_bot = false")
+	}
+	var e models.Event
+	return r.each(q, &e, func() error {
+		row := e
+		e = models.Event{}
+		return fn(&row)
+	})
+}
@@ -0,0 +1,321 @@
+package repo
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tracking/analysis/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// FraudRepo records dropped duplicate clicks and collects the per-channel
+// and per-campaign click counts fraud reports are built from. It reads
+// the Postgres clicks and events tables.
+type FraudRepo struct {
+	DB *gorm.DB
+}
+
+func NewFraudRepo(db *gorm.DB) *FraudRepo {
+	return &FraudRepo{DB: db}
+}
+
+// AddDedupHits adds hits to the stored counts of clicks dropped as
+// duplicates.
+func (r *FraudRepo) AddDedupHits(hits []models.ClickDedupHit) error {
+	if len(hits) == 0 {
+		return nil
+	}
+	return r.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "hour"}, {Name: "tracker_id"}, {Name: "campaign_id"}, {Name: "channel_id"}},
+		DoUpdates: clause.Assignments(map[string]any{"count": gorm.Expr("click_dedup_hits.count + excluded.count")}),
+	}).CreateInBatches(hits, 500).Error
+}
+
+// RepeatGapBounds are the upper bounds, in seconds, of the buckets
+// FraudCounts.Gaps sorts repeat clicks into; the last bucket is open.
+var RepeatGapBounds = []float64{1, 10, 60, 600, 3600}
+
+// FraudQuery scopes a fraud report. BeaconWindow is how long after a
+// redirect click an event from the same IP may arrive to confirm it. Top
+// is how many of the most common values each Distribution keeps.
+type FraudQuery struct {
+	Start        time.Time
+	End          time.Time
+	TrackerID    string
+	CampaignID   string
+	ChannelID    string
+	BeaconWindow time.Duration
+	Top          int
+}
+
+// Distribution describes how clicks spread over the values of one
+// column: how many distinct values there are, the Shannon entropy of the
+// spread in bits, and the most common values, most first.
+type Distribution struct {
+	Unique  int64
+	Entropy float64
+	Top     []NameCount
+}
+
+// TopCount returns the clicks of the most common value.
+func (d Distribution) TopCount() int64 {
+	if len(d.Top) == 0 {
+		return 0
+	}
+	return d.Top[0].Count
+}
+
+// FraudCounts are the raw counts of one channel's clicks, or of all of a
+// campaign's.
+type FraudCounts struct {
+	CampaignID string
+	ChannelID  string
+	Clicks     int64
+	Bots       int64
+	DedupHits  int64
+	// Redirects are 302 clicks, which carry no visitor ID; unconfirmed
+	// ones were not followed by an event from the same IP.
+	Redirects            int64
+	UnconfirmedRedirects int64
+	IPs                  Distribution
+	Subnets              Distribution // by fraudSubnetExpr
+	UAs                  Distribution // raw UA, or "browser / os" when dropped
+	Countries            map[string]int64
+	// FirstClicks have no earlier click from the same IP on the channel in
+	// range; Gaps counts the others by time since that click, bucketed by
+	// RepeatGapBounds.
+	FirstClicks int64
+	Gaps        []int64
+}
+
+// fraudKey identifies a channel's counts, or with total set a campaign's.
+type fraudKey struct {
+	campaign, channel string
+	total             bool
+}
+
+const (
+	fraudCampaignExpr = "COALESCE(campaign_id::text, '')"
+	fraudChannelExpr  = "COALESCE(channel_id::text, '')"
+	fraudUAExpr       = "CASE WHEN ua <> '' THEN ua ELSE browser || ' / ' || os END"
+	// fraudSubnetExpr is the /24 of an IPv4 or the /48 of an IPv6 address,
+	// the same networks ip_mode "truncate" keeps. Hashed IPs are their own
+	// subnet.
+	fraudSubnetExpr = `CASE WHEN ip ~ '^\d{1,3}(\.\d{1,3}){3}$' THEN network(set_masklen(ip::inet, 24))::text
+		WHEN ip ~ '^::ffff:\d{1,3}(\.\d{1,3}){3}$' THEN network(set_masklen(substring(ip from 8)::inet, 24))::text
+		WHEN ip ~ '^[0-9a-fA-F:]*:[0-9a-fA-F:]*$' THEN network(set_masklen(ip::inet, 48))::text
+		ELSE ip END`
+)
+
+// fraudGroups groups rows by campaign and channel and, in a second
+// grouping set, by campaign alone, with extra grouped in both. The
+// selected campaign_total column tells the two apart.
+func fraudGroups(campaign, channel, extra string) (columns, groupBy string) {
+	if extra != "" {
+		extra = ", " + extra
+	}
+	columns = fmt.Sprintf("%[1]s AS campaign_id, COALESCE(%[2]s::text, '') AS channel_id, GROUPING(%[2]s) = 1 AS campaign_total", campaign, channel)
+	groupBy = fmt.Sprintf("GROUPING SETS ((%[1]s, %[2]s%[3]s), (%[1]s%[3]s))", campaign, channel, extra)
+	return columns, groupBy
+}
+
+// scope returns the WHERE clause shared by every fraud query, with the
+// range starting at start on tsColumn.
+func (q *FraudQuery) scope(tsColumn string, start time.Time) (string, []any) {
+	where := []string{tsColumn + " BETWEEN ? AND ?"}
+	args := []any{start, q.End}
+	for _, f := range []struct{ column, value string }{
+		{"tracker_id", q.TrackerID}, {"campaign_id", q.CampaignID}, {"channel_id", q.ChannelID},
+	} {
+		if f.value != "" {
+			where = append(where, f.column+" = ?")
+			args = append(args, f.value)
+		}
+	}
+	return strings.Join(where, " AND "), args
+}
+
+// gapBucketExpr sorts a gap in seconds into RepeatGapBounds buckets,
+// with -1 for first clicks.
+func gapBucketExpr(gap string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "CASE WHEN %s IS NULL THEN -1", gap)
+	for i, bound := range RepeatGapBounds {
+		fmt.Fprintf(&b, " WHEN %s < %g THEN %d", gap, bound, i)
+	}
+	fmt.Fprintf(&b, " ELSE %d END", len(RepeatGapBounds))
+	return b.String()
+}
+
+// distributionSQL summarises the values of expr per channel and campaign
+// in the database, returning only the q.Top most common of each with the
+// distinct count and entropy, so a channel with millions of IPs costs no
+// more to report than one with ten.
+func distributionSQL(expr, where string) string {
+	columns, groupBy := fraudGroups(fraudCampaignExpr, fraudChannelExpr, expr)
+	return fmt.Sprintf(`WITH d AS (
+		SELECT %s, %s AS name, COUNT(*) AS n FROM clicks WHERE %s GROUP BY %s
+	), s AS (
+		SELECT *, n::float8 / SUM(n::float8) OVER g AS share, COUNT(*) OVER g AS uniques,
+			ROW_NUMBER() OVER (g ORDER BY n DESC, name) AS rank
+		FROM d WINDOW g AS (PARTITION BY campaign_id, channel_id, campaign_total)
+	), e AS (
+		SELECT *, SUM(-share * LN(share)) OVER (PARTITION BY campaign_id, channel_id, campaign_total) / LN(2) AS entropy FROM s
+	)
+	SELECT campaign_id, channel_id, campaign_total, name, n AS count, uniques, entropy FROM e WHERE rank <= ?`,
+		columns, expr, where, groupBy)
+}
+
+// Counts returns the fraud counts of every channel with clicks in q, and
+// of every campaign across its channels.
+func (r *FraudRepo) Counts(q FraudQuery) (channels, campaigns []FraudCounts, err error) {
+	where, args := q.scope("ts", q.Start)
+	byKey := map[fraudKey]*FraudCounts{}
+	var order []fraudKey
+	get := func(campaign, channel string, total bool) *FraudCounts {
+		if total {
+			channel = ""
+		}
+		k := fraudKey{campaign, channel, total}
+		c, ok := byKey[k]
+		if !ok {
+			c = &FraudCounts{
+				CampaignID: campaign,
+				ChannelID:  channel,
+				Countries:  map[string]int64{},
+				Gaps:       make([]int64, len(RepeatGapBounds)+1),
+			}
+			byKey[k] = c
+			order = append(order, k)
+		}
+		return c
+	}
+
+	columns, groupBy := fraudGroups(fraudCampaignExpr, fraudChannelExpr, "")
+	var totals []struct {
+		CampaignID           string
+		ChannelID            string
+		CampaignTotal        bool
+		Clicks               int64
+		Bots                 int64
+		Redirects            int64
+		UnconfirmedRedirects int64
+	}
+	err = r.DB.Raw(fmt.Sprintf(`SELECT %s, COUNT(*) AS clicks,
+		COUNT(*) FILTER (WHERE is_bot) AS bots,
+		COUNT(*) FILTER (WHERE visitor_id = '') AS redirects,
+		COUNT(*) FILTER (WHERE visitor_id = '' AND NOT EXISTS (
+			SELECT 1 FROM events e WHERE clicks.ip <> '' AND e.ip = clicks.ip
+			AND e.ts >= clicks.ts AND e.ts < clicks.ts + make_interval(secs => ?))) AS unconfirmed_redirects
+		FROM clicks WHERE %s GROUP BY %s`, columns, where, groupBy),
+		append([]any{q.BeaconWindow.Seconds()}, args...)...).Scan(&totals).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, t := range totals {
+		c := get(t.CampaignID, t.ChannelID, t.CampaignTotal)
+		c.Clicks, c.Bots = t.Clicks, t.Bots
+		c.Redirects, c.UnconfirmedRedirects = t.Redirects, t.UnconfirmedRedirects
+	}
+
+	for _, dist := range []struct {
+		expr string
+		dest func(*FraudCounts) *Distribution
+	}{
+		{"ip", func(c *FraudCounts) *Distribution { return &c.IPs }},
+		{fraudSubnetExpr, func(c *FraudCounts) *Distribution { return &c.Subnets }},
+		{fraudUAExpr, func(c *FraudCounts) *Distribution { return &c.UAs }},
+	} {
+		var rows []struct {
+			CampaignID    string
+			ChannelID     string
+			CampaignTotal bool
+			Name          string
+			Count         int64
+			Uniques       int64
+			Entropy       float64
+		}
+		err := r.DB.Raw(distributionSQL(dist.expr, where), append(args, max(q.Top, 1))...).Scan(&rows).Error
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, row := range rows {
+			d := dist.dest(get(row.CampaignID, row.ChannelID, row.CampaignTotal))
+			d.Unique, d.Entropy = row.Uniques, row.Entropy
+			d.Top = append(d.Top, NameCount{Name: row.Name, Count: row.Count})
+		}
+	}
+
+	var countries []struct {
+		CampaignID    string
+		ChannelID     string
+		CampaignTotal bool
+		Name          string
+		Count         int64
+	}
+	columns, groupBy = fraudGroups(fraudCampaignExpr, fraudChannelExpr, "country")
+	err = r.DB.Raw(fmt.Sprintf("SELECT %s, country AS name, COUNT(*) AS count FROM clicks WHERE %s GROUP BY %s",
+		columns, where, groupBy), args...).Scan(&countries).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, row := range countries {
+		get(row.CampaignID, row.ChannelID, row.CampaignTotal).Countries[row.Name] = row.Count
+	}
+
+	var gaps []struct {
+		CampaignID    string
+		ChannelID     string
+		CampaignTotal bool
+		Bucket        int
+		Count         int64
+	}
+	columns, groupBy = fraudGroups("campaign_id", "channel_id", "bucket")
+	err = r.DB.Raw(fmt.Sprintf(`SELECT %s, bucket, COUNT(*) AS count FROM (
+		SELECT campaign_id, channel_id, %s AS bucket FROM (
+			SELECT %s AS campaign_id, %s AS channel_id,
+				EXTRACT(EPOCH FROM ts - LAG(ts) OVER (PARTITION BY campaign_id, channel_id, ip ORDER BY ts)) AS gap
+			FROM clicks WHERE %s AND ip <> '') g
+		) b GROUP BY %s`,
+		columns, gapBucketExpr("gap"), fraudCampaignExpr, fraudChannelExpr, where, groupBy), args...).Scan(&gaps).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, g := range gaps {
+		c := get(g.CampaignID, g.ChannelID, g.CampaignTotal)
+		if g.Bucket < 0 {
+			c.FirstClicks += g.Count
+		} else {
+			c.Gaps[g.Bucket] += g.Count
+		}
+	}
+
+	// Dedup hits are kept per hour, so the range is widened to whole hours
+	dedupWhere, dedupArgs := q.scope("hour", q.Start.Truncate(time.Hour))
+	var hits []struct {
+		CampaignID    string
+		ChannelID     string
+		CampaignTotal bool
+		Count         int64
+	}
+	columns, groupBy = fraudGroups("campaign_id", "channel_id", "")
+	err = r.DB.Raw(fmt.Sprintf("SELECT %s, SUM(count) AS count FROM click_dedup_hits WHERE %s GROUP BY %s", columns, dedupWhere, groupBy),
+		dedupArgs...).Scan(&hits).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, h := range hits {
+		get(h.CampaignID, h.ChannelID, h.CampaignTotal).DedupHits = h.Count
+	}
+
+	for _, k := range order {
+		if k.total {
+			campaigns = append(campaigns, *byKey[k])
+		} else {
+			channels = append(channels, *byKey[k])
+		}
+	}
+	return channels, campaigns, nil
+}
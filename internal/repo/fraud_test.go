@@ -0,0 +1,52 @@
+package repo
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFraudQueryScope(t *testing.T) {
+	start := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	q := FraudQuery{Start: start, End: start.Add(24 * time.Hour), CampaignID: "camp"}
+	where, args := q.scope("hour", start.Truncate(time.Hour))
+	if where != "hour BETWEEN ? AND ? AND campaign_id = ?" {
+		t.Errorf("where = %q", where)
+	}
+	if len(args) != 3 || !args[0].(time.Time).Equal(start.Truncate(time.Hour)) || args[2] != "camp" {
+		t.Errorf("args = %v", args)
+	}
+}
+
+func TestGapBucketExpr(t *testing.T) {
+	expr := gapBucketExpr("gap")
+	for _, want := range []string{"WHEN gap IS NULL THEN -1", "WHEN gap < 1 THEN 0", "WHEN gap < 3600 THEN 4", "ELSE 5 END"} {
+		if !strings.Contains(expr, want) {
+			t.Errorf("%q missing %q", expr, want)
+		}
+	}
+}
+
+func TestFraudGroups(t *testing.T) {
+	columns, groupBy := fraudGroups("campaign_id", "channel_id", "country")
+	if columns != "campaign_id AS campaign_id, COALESCE(channel_id::text, '') AS channel_id, GROUPING(channel_id) = 1 AS campaign_total" {
+		t.Errorf("columns = %q", columns)
+	}
+	if groupBy != "GROUPING SETS ((campaign_id, channel_id, country), (campaign_id, country))" {
+		t.Errorf("group by = %q", groupBy)
+	}
+}
+
+func TestDistributionSQL(t *testing.T) {
+	sql := distributionSQL(fraudSubnetExpr, "ts BETWEEN ? AND ?")
+	for _, want := range []string{
+		"network(set_masklen(ip::inet, 24))::text",
+		"network(set_masklen(ip::inet, 48))::text",
+		"GROUP BY GROUPING SETS ((" + fraudCampaignExpr + ", " + fraudChannelExpr + ", " + fraudSubnetExpr + ")",
+		"WHERE rank <= ?",
+	} {
+		if !strings.Contains(sql, want) {
+			t.Errorf("distribution SQL missing %q:\n%s", want, sql)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package repo
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tracking/analysis/internal/models"
+)
+
+// actorExprs maps the supported "group by" identities to the column that
+// identifies one actor. Cookieless events have no visitor_id, so their
+// session stands in for the visitor, as in uniqueVisitorsExpr.
+var actorExprs = map[string]string{
+	"visitor": "COALESCE(NULLIF(visitor_id, ''), session_id)",
+	"session": "session_id",
+}
+
+// FunnelStep matches an event by type and, optionally, by URL pattern and
+// props. URL patterns use * as a wildcard and are matched against the path
+// when they start with "/", otherwise against the full URL.
+type FunnelStep struct {
+	Type  string         `json:"type"`
+	URL   string         `json:"url"`
+	Props map[string]any `json:"props"`
+}
+
+// Matches reports whether e satisfies the step.
+func (s FunnelStep) Matches(e *FunnelEvent) bool {
+	if e.Type != s.Type {
+		return false
+	}
+	if s.URL != "" {
+		target := e.URL
+		if strings.HasPrefix(s.URL, "/") {
+			target = ""
+			if u, err := url.Parse(e.URL); err == nil {
+				target = u.Path
+			}
+		}
+		if !globMatch(s.URL, target) {
+			return false
+		}
+	}
+	for k, want := range s.Props {
+		got, ok := e.Props[k]
+		if !ok || fmt.Sprint(got) != fmt.Sprint(want) {
+			return false
+		}
+	}
+	return true
+}
+
+// globMatch matches s against pattern, where * matches any run of characters.
+func globMatch(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
+
+// FunnelEvent is the subset of an event needed to evaluate a funnel.
+type FunnelEvent struct {
+	Actor    string
+	TS       time.Time
+	Type     string
+	URL      string
+	Props    models.JSONMap
+	Country  string
+	Browser  string
+	Referrer string
+}
+
+type FunnelStepResult struct {
+	Step           int      `json:"step"`
+	Type           string   `json:"type"`
+	URL            string   `json:"url,omitempty"`
+	Count          int64    `json:"count"`
+	ConversionRate float64  `json:"conversion_rate"`
+	DropOff        int64    `json:"drop_off"`
+	DropOffRate    float64  `json:"drop_off_rate"`
+	MedianSeconds  *float64 `json:"median_seconds_from_previous"`
+}
+
+type FunnelBreakdown struct {
+	Value string             `json:"value"`
+	Steps []FunnelStepResult `json:"steps"`
+}
+
+// funnelTally accumulates how far each actor got and how long each step took.
+type funnelTally struct {
+	counts    []int64
+	durations [][]float64
+}
+
+func newFunnelTally(steps int) *funnelTally {
+	return &funnelTally{counts: make([]int64, steps), durations: make([][]float64, steps)}
+}
+
+func (t *funnelTally) add(times []time.Time) {
+	for i := range times {
+		t.counts[i]++
+		if i > 0 {
+			t.durations[i] = append(t.durations[i], times[i].Sub(times[i-1]).Seconds())
+		}
+	}
+}
+
+func (t *funnelTally) results(steps []FunnelStep) []FunnelStepResult {
+	out := make([]FunnelStepResult, len(steps))
+	for i, s := range steps {
+		r := FunnelStepResult{Step: i + 1, Type: s.Type, URL: s.URL, Count: t.counts[i]}
+		if t.counts[0] > 0 {
+			r.ConversionRate = roundPct(t.counts[i], t.counts[0])
+		}
+		if i > 0 {
+			r.DropOff = t.counts[i-1] - t.counts[i]
+			if t.counts[i-1] > 0 {
+				r.DropOffRate = roundPct(r.DropOff, t.counts[i-1])
+			}
+			r.MedianSeconds = median(t.durations[i])
+		}
+		out[i] = r
+	}
+	return out
+}
+
+func roundPct(n, d int64) float64 {
+	return math.Round(float64(n)/float64(d)*10000) / 100
+}
+
+func median(xs []float64) *float64 {
+	if len(xs) == 0 {
+		return nil
+	}
+	sort.Float64s(xs)
+	m := xs[len(xs)/2]
+	if len(xs)%2 == 0 {
+		m = (xs[len(xs)/2-1] + xs[len(xs)/2]) / 2
+	}
+	return &m
+}
+
+// funnelPath returns the timestamps of the steps one actor completed, in
+// order. Every occurrence of the first step is tried as a starting point and
+// the deepest path within window is kept, preferring the earliest start.
+func funnelPath(events []FunnelEvent, steps []FunnelStep, window time.Duration) ([]time.Time, int) {
+	var best []time.Time
+	bestStart := -1
+	for i := range events {
+		if !steps[0].Matches(&events[i]) {
+			continue
+		}
+		path := []time.Time{events[i].TS}
+		deadline := events[i].TS.Add(window)
+		for j := i + 1; j < len(events) && len(path) < len(steps); j++ {
+			if events[j].TS.After(deadline) {
+				break
+			}
+			if steps[len(path)].Matches(&events[j]) {
+				path = append(path, events[j].TS)
+			}
+		}
+		if len(path) > len(best) {
+			best, bestStart = path, i
+		}
+		if len(best) == len(steps) {
+			break
+		}
+	}
+	return best, bestStart
+}
+
+// breakdownValue returns the breakdown dimension of the event that entered the funnel.
+func breakdownValue(e *FunnelEvent, breakdown string) string {
+	switch breakdown {
+	case "country":
+		return e.Country
+	case "browser":
+		return e.Browser
+	case "referrer":
+		return NormalizeReferrerHost(e.Referrer)
+	}
+	return ""
+}
+
+// Funnel evaluates ordered steps over events sorted by actor and then time.
+// Breakdown values are taken from each actor's first-step event; at most
+// BreakdownLimit values are returned, largest first.
+type Funnel struct {
+	Steps          []FunnelStep
+	Window         time.Duration
+	Breakdown      string
+	BreakdownLimit int
+
+	total     *funnelTally
+	byValue   map[string]*funnelTally
+	actor     string
+	actorRows []FunnelEvent
+}
+
+func (f *Funnel) init() {
+	if f.total == nil {
+		f.total = newFunnelTally(len(f.Steps))
+		f.byValue = make(map[string]*funnelTally)
+	}
+}
+
+// Add feeds the next event. Events must arrive grouped by actor in time order.
+func (f *Funnel) Add(e FunnelEvent) {
+	f.init()
+	if e.Actor != f.actor {
+		f.flush()
+		f.actor = e.Actor
+	}
+	f.actorRows = append(f.actorRows, e)
+}
+
+func (f *Funnel) flush() {
+	if len(f.actorRows) == 0 {
+		return
+	}
+	path, start := funnelPath(f.actorRows, f.Steps, f.Window)
+	if len(path) > 0 {
+		f.total.add(path)
+		if f.Breakdown != "" {
+			v := breakdownValue(&f.actorRows[start], f.Breakdown)
+			t, ok := f.byValue[v]
+			if !ok {
+				t = newFunnelTally(len(f.Steps))
+				f.byValue[v] = t
+			}
+			t.add(path)
+		}
+	}
+	f.actorRows = f.actorRows[:0]
+}
+
+// Result flushes the last actor and returns overall and per-breakdown steps.
+func (f *Funnel) Result() ([]FunnelStepResult, []FunnelBreakdown) {
+	f.init()
+	f.flush()
+	breakdowns := make([]FunnelBreakdown, 0, len(f.byValue))
+	for v, t := range f.byValue {
+		breakdowns = append(breakdowns, FunnelBreakdown{Value: v, Steps: t.results(f.Steps)})
+	}
+	sort.Slice(breakdowns, func(i, j int) bool {
+		a, b := breakdowns[i].Steps[0].Count, breakdowns[j].Steps[0].Count
+		if a != b {
+			return a > b
+		}
+		return breakdowns[i].Value < breakdowns[j].Value
+	})
+	if f.BreakdownLimit > 0 && len(breakdowns) > f.BreakdownLimit {
+		breakdowns = breakdowns[:f.BreakdownLimit]
+	}
+	return f.total.results(f.Steps), breakdowns
+}
+
+// Funnel streams the non-bot events in [start, end] whose type appears in
+// f.Steps, grouped by the "visitor" or "session" identity, through f.
+func (r *EventRepo) Funnel(start, end time.Time, siteID, by string, f *Funnel) ([]FunnelStepResult, []FunnelBreakdown, error) {
+	actor, ok := actorExprs[by]
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported group by: %s", by)
+	}
+	types := make([]string, 0, len(f.Steps))
+	for _, s := range f.Steps {
+		types = append(types, s.Type)
+	}
+	q := r.DB.Model(&models.Event{}).
+		Select(actor+" AS actor, ts, type, url, props, country, browser, referrer").
+		Where("ts BETWEEN ? AND ? AND is_bot = false AND type IN ?", start, end, types).
+		Where(actor + " != ''")
+	q = r.eventFilters(q, siteID)
+	rows, err := q.Order("actor, ts").Rows()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e, err := scanFunnelEvent(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		f.Add(e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	steps, breakdowns := f.Result()
+	return steps, breakdowns, nil
+}
+
+// scanFunnelEvent reads one row of the Funnel query. The text columns are
+// NULL in rows stored before they were added, which read as "".
+func scanFunnelEvent(rows *sql.Rows) (FunnelEvent, error) {
+	var e FunnelEvent
+	var pageURL, country, browser, referrer sql.NullString
+	err := rows.Scan(&e.Actor, &e.TS, &e.Type, &pageURL, &e.Props, &country, &browser, &referrer)
+	e.URL, e.Country, e.Browser, e.Referrer = pageURL.String, country.String, browser.String, referrer.String
+	return e, err
+}
@@ -0,0 +1,152 @@
+package repo
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/tracking/analysis/internal/models"
+)
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"/pricing", "/pricing", true},
+		{"/pricing", "/pricing/x", false},
+		{"/blog/*", "/blog/post-1", true},
+		{"/blog/*", "/about", false},
+		{"*checkout*", "/shop/checkout/step", true},
+		{"/a*c", "/abc", true},
+		{"/a*c", "/ab", false},
+	}
+	for _, tt := range tests {
+		if got := globMatch(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("globMatch(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestFunnelStepMatches(t *testing.T) {
+	e := &FunnelEvent{Type: "signup", URL: "https://ex.com/join?x=1", Props: models.JSONMap{"plan": "pro", "seats": float64(3)}}
+	if !(FunnelStep{Type: "signup", URL: "/join", Props: map[string]any{"plan": "pro", "seats": float64(3)}}).Matches(e) {
+		t.Error("expected match on type, path and props")
+	}
+	if (FunnelStep{Type: "signup", Props: map[string]any{"plan": "free"}}).Matches(e) {
+		t.Error("props mismatch should not match")
+	}
+	if (FunnelStep{Type: "pageview"}).Matches(e) {
+		t.Error("type mismatch should not match")
+	}
+}
+
+func TestFunnel(t *testing.T) {
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	steps := []FunnelStep{{Type: "pageview", URL: "/pricing"}, {Type: "signup"}, {Type: "purchase"}}
+	f := &Funnel{Steps: steps, Window: time.Hour, Breakdown: "country"}
+
+	pv := func(actor string, at time.Duration, country string) FunnelEvent {
+		return FunnelEvent{Actor: actor, TS: t0.Add(at), Type: "pageview", URL: "https://ex.com/pricing", Country: country}
+	}
+	ev := func(actor, typ string, at time.Duration) FunnelEvent {
+		return FunnelEvent{Actor: actor, TS: t0.Add(at), Type: typ}
+	}
+	for _, e := range []FunnelEvent{
+		// a: completes all steps.
+		pv("a", 0, "DE"), ev("a", "signup", 10*time.Minute), ev("a", "purchase", 20*time.Minute),
+		// b: signup falls outside the first attempt's window, but a later pricing view converts.
+		pv("b", 0, "US"), pv("b", 2*time.Hour, "US"), ev("b", "signup", 2*time.Hour+30*time.Minute),
+		// c: signs up before visiting pricing, which does not count.
+		ev("c", "signup", 0), pv("c", time.Minute, "US"),
+	} {
+		f.Add(e)
+	}
+
+	got, breakdowns := f.Result()
+	wantCounts := []int64{3, 2, 1}
+	for i, w := range wantCounts {
+		if got[i].Count != w {
+			t.Errorf("step %d count = %d, want %d", i+1, got[i].Count, w)
+		}
+	}
+	if got[1].DropOff != 1 || got[1].ConversionRate != 66.67 {
+		t.Errorf("step 2 drop_off=%d conversion=%v", got[1].DropOff, got[1].ConversionRate)
+	}
+	if got[1].MedianSeconds == nil || *got[1].MedianSeconds != 20*60 {
+		t.Errorf("step 2 median = %v, want 1200", got[1].MedianSeconds)
+	}
+	if got[0].MedianSeconds != nil {
+		t.Error("first step should have no median")
+	}
+
+	if len(breakdowns) != 2 || breakdowns[0].Value != "US" || breakdowns[0].Steps[0].Count != 2 {
+		t.Fatalf("breakdowns = %+v", breakdowns)
+	}
+	if breakdowns[1].Value != "DE" || breakdowns[1].Steps[2].Count != 1 {
+		t.Errorf("DE breakdown = %+v", breakdowns[1])
+	}
+}
+
+// nullRowDriver answers every query with one funnel row whose nullable
+// columns are NULL, like rows stored before those columns existed.
+type nullRowDriver struct{}
+
+func (nullRowDriver) Open(string) (driver.Conn, error) { return nullRowConn{}, nil }
+
+type nullRowConn struct{}
+
+func (nullRowConn) Prepare(string) (driver.Stmt, error) { return nullRowStmt{}, nil }
+func (nullRowConn) Close() error                        { return nil }
+func (nullRowConn) Begin() (driver.Tx, error)           { return nil, driver.ErrSkip }
+
+type nullRowStmt struct{}
+
+func (nullRowStmt) Close() error                               { return nil }
+func (nullRowStmt) NumInput() int                              { return -1 }
+func (nullRowStmt) Exec([]driver.Value) (driver.Result, error) { return nil, driver.ErrSkip }
+func (nullRowStmt) Query([]driver.Value) (driver.Rows, error)  { return &nullRows{}, nil }
+
+type nullRows struct{ done bool }
+
+func (*nullRows) Columns() []string {
+	return []string{"actor", "ts", "type", "url", "props", "country", "browser", "referrer"}
+}
+func (*nullRows) Close() error { return nil }
+func (r *nullRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0], dest[1], dest[2] = "v1", time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), "signup"
+	for i := 3; i < len(dest); i++ {
+		dest[i] = nil
+	}
+	return nil
+}
+
+func TestScanFunnelEvent_NullColumns(t *testing.T) {
+	sql.Register("funnel-null-row", nullRowDriver{})
+	db, err := sql.Open("funnel-null-row", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.Query("SELECT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		t.Fatal("no row")
+	}
+	e, err := scanFunnelEvent(rows)
+	if err != nil {
+		t.Fatalf("scanFunnelEvent = %v", err)
+	}
+	if e.Actor != "v1" || e.Type != "signup" || e.URL != "" || e.Browser != "" || e.Props != nil {
+		t.Errorf("event = %+v", e)
+	}
+}
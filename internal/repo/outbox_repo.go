@@ -0,0 +1,147 @@
+package repo
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/tracking/analysis/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// OutboxEntry is a stored row to publish. Key picks the partition.
+type OutboxEntry struct {
+	RowID string
+	Key   string
+	Row   any
+}
+
+// OutboxRepo queues stored clicks and events for the configured sinks.
+// Every sink gets its own copy of each message, so a slow or failing sink
+// does not hold up the others.
+type OutboxRepo struct {
+	DB    *gorm.DB
+	Sinks []string
+
+	mu   sync.Mutex
+	subs []chan struct{}
+}
+
+func NewOutboxRepo(db *gorm.DB, sinks []string) *OutboxRepo {
+	return &OutboxRepo{DB: db, Sinks: sinks}
+}
+
+// Enqueue writes entries for every sink inside tx, the transaction that
+// inserts the rows themselves.
+func (r *OutboxRepo) Enqueue(tx *gorm.DB, kind string, entries []OutboxEntry) error {
+	now := time.Now()
+	msgs := make([]models.OutboxMessage, 0, len(entries)*len(r.Sinks))
+	for _, e := range entries {
+		payload, err := json.Marshal(e.Row)
+		if err != nil {
+			return err
+		}
+		for _, sink := range r.Sinks {
+			msgs = append(msgs, models.OutboxMessage{
+				Sink:          sink,
+				Kind:          kind,
+				RowID:         e.RowID,
+				Key:           e.Key,
+				Payload:       string(payload),
+				NextAttemptAt: now,
+			})
+		}
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return tx.CreateInBatches(msgs, 500).Error
+}
+
+// Subscribe returns a channel that receives a value after messages are
+// committed, so publishers need not wait for their next poll.
+func (r *OutboxRepo) Subscribe() <-chan struct{} {
+	ch := make(chan struct{}, 1)
+	r.mu.Lock()
+	r.subs = append(r.subs, ch)
+	r.mu.Unlock()
+	return ch
+}
+
+// Notify wakes subscribers; call it after the enqueuing transaction commits.
+func (r *OutboxRepo) Notify() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, ch := range r.subs {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Process claims up to limit due messages of sink and passes them to
+// publish. Claiming leases the messages until now+lease in a short
+// transaction, so publish runs without holding row locks and other
+// replicas skip the batch; a batch whose publisher dies is due again once
+// the lease runs out. Published messages are deleted; on error they are
+// released with their attempts raised and retried after backoff, or
+// marked dead once they have failed maxAttempts times. It returns the
+// number of messages handled and publish's error.
+func (r *OutboxRepo) Process(sink string, limit int, now time.Time, lease time.Duration, backoff func(attempts int) time.Duration, maxAttempts int, publish func([]models.OutboxMessage) error) (int, error) {
+	// Postgres keeps microseconds; truncate so the lease compares equal.
+	leasedUntil := now.Add(lease).Truncate(time.Microsecond)
+	msgs, err := r.claim(sink, limit, now, leasedUntil)
+	if err != nil || len(msgs) == 0 {
+		return 0, err
+	}
+	ids := make([]int64, len(msgs))
+	attempts := 0
+	for i, m := range msgs {
+		ids[i] = m.ID
+		attempts = max(attempts, m.Attempts+1)
+	}
+	if publishErr := publish(msgs); publishErr != nil {
+		// Only release rows still under this lease; past it another
+		// replica may have claimed them.
+		err := r.DB.Model(&models.OutboxMessage{}).Where("id IN ? AND leased_until = ?", ids, leasedUntil).Updates(map[string]any{
+			"attempts":        gorm.Expr("attempts + 1"),
+			"next_attempt_at": now.Add(backoff(attempts)),
+			"last_error":      publishErr.Error(),
+			"leased_until":    nil,
+			"dead_at":         gorm.Expr("CASE WHEN attempts + 1 >= ? THEN ?::timestamptz END", maxAttempts, now),
+		}).Error
+		if err != nil {
+			return len(msgs), err
+		}
+		return len(msgs), publishErr
+	}
+	return len(msgs), r.DB.Where("id IN ?", ids).Delete(&models.OutboxMessage{}).Error
+}
+
+// claim leases up to limit due messages of sink until leasedUntil.
+func (r *OutboxRepo) claim(sink string, limit int, now, leasedUntil time.Time) ([]models.OutboxMessage, error) {
+	var msgs []models.OutboxMessage
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("sink = ? AND next_attempt_at <= ? AND (leased_until IS NULL OR leased_until <= ?) AND dead_at IS NULL", sink, now, now).
+			Order("id").Limit(limit).Find(&msgs).Error
+		if err != nil || len(msgs) == 0 {
+			return err
+		}
+		ids := make([]int64, len(msgs))
+		for i, m := range msgs {
+			ids[i] = m.ID
+		}
+		return tx.Model(&models.OutboxMessage{}).Where("id IN ?", ids).Update("leased_until", leasedUntil).Error
+	})
+	return msgs, err
+}
+
+// PruneDead deletes messages that went dead before before. Their payloads
+// carry visitor IDs and IPs.
+func (r *OutboxRepo) PruneDead(before time.Time) (int64, error) {
+	res := r.DB.Where("dead_at < ?", before).Delete(&models.OutboxMessage{})
+	return res.RowsAffected, res.Error
+}
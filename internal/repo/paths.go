@@ -0,0 +1,178 @@
+package repo
+
+import (
+	"database/sql"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tracking/analysis/internal/models"
+)
+
+// Markers for the start and end of a session in page paths.
+const (
+	PathEntry = "(entry)"
+	PathExit  = "(exit)"
+)
+
+type PathCount struct {
+	Pages []string `json:"pages"`
+	Count int64    `json:"count"`
+}
+
+// pagePath reduces a URL to its path, as TopPages does.
+func pagePath(raw string) string {
+	if u, err := url.Parse(raw); err == nil && u.Path != "" {
+		return u.Path
+	}
+	return raw
+}
+
+// PathAnalysis collects page sequences around Page from pageviews grouped by
+// session in time order. Every visit to Page in a session is counted.
+type PathAnalysis struct {
+	Page  string
+	Steps int // pages per sequence, including Page
+
+	session string
+	pages   []string
+	from    map[string]int64
+	to      map[string]int64
+	next    map[string]int64
+	prev    map[string]int64
+	visits  int64
+}
+
+func (p *PathAnalysis) init() {
+	if p.from == nil {
+		p.from = make(map[string]int64)
+		p.to = make(map[string]int64)
+		p.next = make(map[string]int64)
+		p.prev = make(map[string]int64)
+	}
+}
+
+// Add feeds the next pageview. Consecutive views of the same path, such as
+// reloads, are collapsed into one.
+func (p *PathAnalysis) Add(sessionID, rawURL string) {
+	p.init()
+	if sessionID != p.session {
+		p.flush()
+		p.session = sessionID
+	}
+	page := pagePath(rawURL)
+	if n := len(p.pages); n > 0 && p.pages[n-1] == page {
+		return
+	}
+	p.pages = append(p.pages, page)
+}
+
+// pathKey joins pages with a separator that cannot appear in a URL path.
+func pathKey(pages []string) string {
+	return strings.Join(pages, "\n")
+}
+
+func (p *PathAnalysis) flush() {
+	for i, page := range p.pages {
+		if page != p.Page {
+			continue
+		}
+		p.visits++
+
+		seq := append([]string{}, p.pages[i:min(i+p.Steps, len(p.pages))]...)
+		if len(seq) < p.Steps {
+			seq = append(seq, PathExit)
+		}
+		p.from[pathKey(seq)]++
+
+		seq = append([]string{}, p.pages[max(i+1-p.Steps, 0):i+1]...)
+		if len(seq) < p.Steps {
+			seq = append([]string{PathEntry}, seq...)
+		}
+		p.to[pathKey(seq)]++
+
+		if i+1 < len(p.pages) {
+			p.next[p.pages[i+1]]++
+		} else {
+			p.next[PathExit]++
+		}
+		if i > 0 {
+			p.prev[p.pages[i-1]]++
+		} else {
+			p.prev[PathEntry]++
+		}
+	}
+	p.pages = p.pages[:0]
+}
+
+// PathResult holds the most common sequences starting from and ending at
+// the page, and the pages immediately after and before it.
+type PathResult struct {
+	Visits   int64       `json:"visits"`
+	From     []PathCount `json:"from"`
+	To       []PathCount `json:"to"`
+	Next     []NameCount `json:"next"`
+	Previous []NameCount `json:"previous"`
+}
+
+// Result flushes the last session and returns the top limit entries of each list.
+func (p *PathAnalysis) Result(limit int) PathResult {
+	p.init()
+	p.flush()
+	return PathResult{
+		Visits:   p.visits,
+		From:     topPaths(p.from, limit),
+		To:       topPaths(p.to, limit),
+		Next:     mapToSortedNameCounts(p.next, limit),
+		Previous: mapToSortedNameCounts(p.prev, limit),
+	}
+}
+
+func topPaths(m map[string]int64, limit int) []PathCount {
+	counts := mapToSortedNameCounts(m, limit)
+	paths := make([]PathCount, len(counts))
+	for i, c := range counts {
+		paths[i] = PathCount{Pages: strings.Split(c.Name, "\n"), Count: c.Count}
+	}
+	return paths
+}
+
+// Paths streams non-bot pageviews in [start, end] of every session that
+// viewed p.Page through p.
+func (r *EventRepo) Paths(start, end time.Time, siteID string, p *PathAnalysis, limit int) (PathResult, error) {
+	q := r.DB.Model(&models.Event{}).
+		Select("session_id, url").
+		Where("ts BETWEEN ? AND ? AND is_bot = false AND type = 'pageview' AND session_id != ''", start, end)
+	q = r.eventFilters(q, siteID)
+
+	// Only sessions that reached the page matter; the path is matched in Go
+	// since stored URLs carry hosts and query strings.
+	sessions := r.DB.Model(&models.Event{}).
+		Select("DISTINCT session_id").
+		Where("ts BETWEEN ? AND ? AND is_bot = false AND type = 'pageview' AND url LIKE ?", start, end, "%"+escapeLike(p.Page)+"%")
+	sessions = r.eventFilters(sessions, siteID)
+
+	rows, err := q.Where("session_id IN (?)", sessions).Order("session_id, ts").Rows()
+	if err != nil {
+		return PathResult{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		// url is NULL in rows stored before it was added, read as ""
+		var sessionID string
+		var rawURL sql.NullString
+		if err := rows.Scan(&sessionID, &rawURL); err != nil {
+			return PathResult{}, err
+		}
+		p.Add(sessionID, rawURL.String)
+	}
+	if err := rows.Err(); err != nil {
+		return PathResult{}, err
+	}
+	return p.Result(limit), nil
+}
+
+// escapeLike escapes LIKE wildcards so s matches literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
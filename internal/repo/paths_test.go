@@ -0,0 +1,65 @@
+package repo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPathAnalysis(t *testing.T) {
+	p := &PathAnalysis{Page: "/pricing", Steps: 3}
+	for _, pv := range []struct{ session, url string }{
+		{"a", "https://ex.com/"},
+		{"a", "https://ex.com/pricing"},
+		{"a", "https://ex.com/pricing?plan=pro"}, // reload, collapsed
+		{"a", "https://ex.com/signup"},
+		{"a", "https://ex.com/welcome"},
+		{"b", "https://ex.com/pricing"},
+		{"c", "https://ex.com/"},
+		{"c", "https://ex.com/pricing"},
+		{"c", "https://ex.com/signup"},
+		{"c", "https://ex.com/welcome"},
+	} {
+		p.Add(pv.session, pv.url)
+	}
+
+	got := p.Result(10)
+	if got.Visits != 3 {
+		t.Errorf("visits = %d, want 3", got.Visits)
+	}
+	want := []PathCount{
+		{Pages: []string{"/pricing", "/signup", "/welcome"}, Count: 2},
+		{Pages: []string{"/pricing", PathExit}, Count: 1},
+	}
+	if !reflect.DeepEqual(got.From, want) {
+		t.Errorf("from = %+v, want %+v", got.From, want)
+	}
+	want = []PathCount{
+		{Pages: []string{PathEntry, "/", "/pricing"}, Count: 2},
+		{Pages: []string{PathEntry, "/pricing"}, Count: 1},
+	}
+	if !reflect.DeepEqual(got.To, want) {
+		t.Errorf("to = %+v, want %+v", got.To, want)
+	}
+	wantNext := []NameCount{{Name: "/signup", Count: 2}, {Name: PathExit, Count: 1}}
+	if !reflect.DeepEqual(got.Next, wantNext) {
+		t.Errorf("next = %+v, want %+v", got.Next, wantNext)
+	}
+	wantPrev := []NameCount{{Name: "/", Count: 2}, {Name: PathEntry, Count: 1}}
+	if !reflect.DeepEqual(got.Previous, wantPrev) {
+		t.Errorf("previous = %+v, want %+v", got.Previous, wantPrev)
+	}
+}
+
+func TestPathAnalysisLongSession(t *testing.T) {
+	p := &PathAnalysis{Page: "/c", Steps: 2}
+	for _, u := range []string{"/a", "/b", "/c", "/d", "/e"} {
+		p.Add("s", "https://ex.com"+u)
+	}
+	got := p.Result(10)
+	if !reflect.DeepEqual(got.From[0].Pages, []string{"/c", "/d"}) {
+		t.Errorf("from = %+v", got.From)
+	}
+	if !reflect.DeepEqual(got.To[0].Pages, []string{"/b", "/c"}) {
+		t.Errorf("to = %+v", got.To)
+	}
+}
@@ -0,0 +1,236 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tracking/analysis/internal/clickhouse"
+	"github.com/tracking/analysis/internal/models"
+	"gorm.io/gorm"
+)
+
+// privacyLogLockKey serialises appends to the privacy request log so the
+// hash chain has no forks.
+const privacyLogLockKey = 727001
+
+type PrivacyRepo struct {
+	DB *gorm.DB
+	// ClickHouse, when set, holds copies of clicks and events that erases
+	// must reach too.
+	ClickHouse *clickhouse.Client
+}
+
+func NewPrivacyRepo(db *gorm.DB) *PrivacyRepo {
+	return &PrivacyRepo{DB: db}
+}
+
+// subjectFilter matches a subject's rows by visitor ID or by any of the
+// values its IP is stored as (see privacy.SubjectIPs).
+func subjectFilter(q *gorm.DB, visitorID string, ips []string) *gorm.DB {
+	switch {
+	case visitorID != "" && len(ips) > 0:
+		return q.Where("visitor_id = ? OR ip IN ?", visitorID, ips)
+	case visitorID != "":
+		return q.Where("visitor_id = ?", visitorID)
+	default:
+		return q.Where("ip IN ?", ips)
+	}
+}
+
+// FirstSeen returns the time of the oldest stored click or event, or now
+// when there are none. Hashed IPs can date back no further.
+func (r *PrivacyRepo) FirstSeen() (time.Time, error) {
+	var first sql.NullTime
+	err := r.DB.Raw("SELECT LEAST((SELECT MIN(ts) FROM clicks), (SELECT MIN(ts) FROM events))").Row().Scan(&first)
+	if err != nil || !first.Valid {
+		return time.Now(), err
+	}
+	return first.Time, nil
+}
+
+// TruncatedIPOwners returns the trackers and sites that store truncated
+// IPs, whose rows an IP subject cannot be matched against.
+func (r *PrivacyRepo) TruncatedIPOwners() (trackerIDs, siteIDs []string, err error) {
+	if err := r.DB.Model(&models.Tracker{}).Where("ip_mode = ?", "truncate").Pluck("id", &trackerIDs).Error; err != nil {
+		return nil, nil, err
+	}
+	if err := r.DB.Model(&models.Site{}).Where("ip_mode = ?", "truncate").Pluck("id", &siteIDs).Error; err != nil {
+		return nil, nil, err
+	}
+	return trackerIDs, siteIDs, nil
+}
+
+// FindBySubject returns every click and event recorded for a visitor ID or IP.
+func (r *PrivacyRepo) FindBySubject(visitorID string, ips []string) ([]models.Click, []models.Event, error) {
+	var clicks []models.Click
+	if err := subjectFilter(r.DB, visitorID, ips).Order("ts").Find(&clicks).Error; err != nil {
+		return nil, nil, err
+	}
+	var events []models.Event
+	if err := subjectFilter(r.DB, visitorID, ips).Order("ts").Find(&events).Error; err != nil {
+		return nil, nil, err
+	}
+	return clicks, events, nil
+}
+
+// subjectSessionIDs selects the session IDs of a subject's events.
+func subjectSessionIDs(tx *gorm.DB, visitorID string, ips []string) *gorm.DB {
+	return subjectFilter(tx.Model(&models.Event{}), visitorID, ips).
+		Where("session_id != ''").Distinct("session_id")
+}
+
+// FindSessions returns the sessions derived from a subject's events.
+func (r *PrivacyRepo) FindSessions(visitorID string, ips []string) ([]models.Session, error) {
+	var sessions []models.Session
+	err := r.DB.Where("session_id IN (?)", subjectSessionIDs(r.DB, visitorID, ips)).
+		Order("started_at").Find(&sessions).Error
+	return sessions, err
+}
+
+// EraseSubject deletes, or with anonymise strips identifying fields from,
+// every click, event and session recorded for a visitor ID or IP, and
+// deletes the webhook deliveries that carried them. The
+// counts are set on entry, which is appended to the request log in the
+// same transaction, so no erase goes unlogged. The ClickHouse copies are
+// erased before that transaction commits; if that fails nothing is
+// committed and the request can be retried.
+func (r *PrivacyRepo) EraseSubject(visitorID string, ips []string, anonymise bool, entry *models.PrivacyRequest, hash func(*models.PrivacyRequest) string) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		// Sessions are matched through their events, so this runs first.
+		sessionIDs := subjectSessionIDs(tx, visitorID, ips)
+		var res *gorm.DB
+		if anonymise {
+			res = tx.Model(&models.Session{}).Where("session_id IN (?)", sessionIDs).
+				Update("visitor_id", "")
+		} else {
+			res = tx.Where("session_id IN (?)", sessionIDs).Delete(&models.Session{})
+		}
+		if res.Error != nil {
+			return res.Error
+		}
+
+		// Deliveries and outbox messages are matched through clicks and
+		// events, so this runs before those are erased
+		if err := subjectDeliveries(tx, visitorID, ips).Delete(&models.WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		if err := subjectOutbox(tx, visitorID, ips).Delete(&models.OutboxMessage{}).Error; err != nil {
+			return err
+		}
+
+		if anonymise {
+			res = subjectFilter(tx.Model(&models.Click{}), visitorID, ips).
+				Updates(map[string]any{"visitor_id": "", "ip": "", "ua": "", "props": nil})
+		} else {
+			res = subjectFilter(tx, visitorID, ips).Delete(&models.Click{})
+		}
+		if res.Error != nil {
+			return res.Error
+		}
+		entry.ClicksAffected = res.RowsAffected
+
+		if anonymise {
+			res = subjectFilter(tx.Model(&models.Event{}), visitorID, ips).
+				Updates(map[string]any{"visitor_id": "", "session_id": "", "ip": "", "ua": "", "props": nil})
+		} else {
+			res = subjectFilter(tx, visitorID, ips).Delete(&models.Event{})
+		}
+		if res.Error != nil {
+			return res.Error
+		}
+		entry.EventsAffected = res.RowsAffected
+		if r.ClickHouse != nil {
+			if err := eraseClickHouse(r.ClickHouse, visitorID, ips, anonymise); err != nil {
+				return err
+			}
+		}
+		return appendLog(tx, entry, hash)
+	})
+}
+
+// subjectDeliveries matches the webhook deliveries whose payload carries
+// the subject's visitor ID or one of its clicks or events.
+func subjectDeliveries(tx *gorm.DB, visitorID string, ips []string) *gorm.DB {
+	clickIDs := subjectFilter(tx.Model(&models.Click{}), visitorID, ips).Select("id::text")
+	eventIDs := subjectFilter(tx.Model(&models.Event{}), visitorID, ips).Select("id::text")
+	where := "payload::jsonb -> 'data' ->> 'click_id' IN (?) OR payload::jsonb -> 'data' ->> 'event_id' IN (?)"
+	args := []any{clickIDs, eventIDs}
+	if visitorID != "" {
+		where += " OR payload::jsonb -> 'data' ->> 'visitor_id' = ?"
+		args = append(args, visitorID)
+	}
+	return tx.Where(where, args...)
+}
+
+// subjectOutbox matches the outbox messages of the subject's clicks and
+// events, published or not: their payloads are copies of the rows.
+func subjectOutbox(tx *gorm.DB, visitorID string, ips []string) *gorm.DB {
+	clickIDs := subjectFilter(tx.Model(&models.Click{}), visitorID, ips).Select("id::text")
+	eventIDs := subjectFilter(tx.Model(&models.Event{}), visitorID, ips).Select("id::text")
+	return tx.Where("(kind = 'clicks' AND row_id IN (?)) OR (kind = 'events' AND row_id IN (?))", clickIDs, eventIDs)
+}
+
+// eraseClickHouse is EraseSubject for the ClickHouse clicks and events
+// tables. It waits for the mutations to finish.
+func eraseClickHouse(ch *clickhouse.Client, visitorID string, ips []string, anonymise bool) error {
+	var where string
+	switch {
+	case visitorID != "" && len(ips) > 0:
+		where = "visitor_id = {visitor_id:String} OR has({ips:Array(String)}, ip)"
+	case visitorID != "":
+		where = "visitor_id = {visitor_id:String}"
+	default:
+		where = "has({ips:Array(String)}, ip)"
+	}
+	params := clickhouse.Params{"visitor_id": visitorID, "ips": ips}
+	stmts := []string{"ALTER TABLE clicks DELETE WHERE " + where, "ALTER TABLE events DELETE WHERE " + where}
+	if anonymise {
+		stmts = []string{
+			"ALTER TABLE clicks UPDATE visitor_id = '', ip = '', ua = '', props = '{}' WHERE " + where,
+			"ALTER TABLE events UPDATE visitor_id = '', session_id = '', ip = '', ua = '', props = '{}' WHERE " + where,
+		}
+	}
+	for _, stmt := range stmts {
+		if err := ch.Mutate(context.Background(), stmt, params); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AppendLog adds an entry to the request log. hash is called with Seq,
+// PrevHash and CreatedAt filled in and must return the entry's chain hash.
+func (r *PrivacyRepo) AppendLog(entry *models.PrivacyRequest, hash func(*models.PrivacyRequest) string) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		return appendLog(tx, entry, hash)
+	})
+}
+
+func appendLog(tx *gorm.DB, entry *models.PrivacyRequest, hash func(*models.PrivacyRequest) string) error {
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", privacyLogLockKey).Error; err != nil {
+		return err
+	}
+	var last models.PrivacyRequest
+	err := tx.Order("seq DESC").Limit(1).Find(&last).Error
+	if err != nil {
+		return err
+	}
+	entry.Seq = last.Seq + 1
+	entry.PrevHash = last.Hash
+	// Postgres keeps microseconds; truncate so the stored value re-hashes identically.
+	entry.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+	if entry.ID == "" {
+		entry.ID = uuid.New().String()
+	}
+	entry.Hash = hash(entry)
+	return tx.Create(entry).Error
+}
+
+// ListLog returns the full request log, oldest first.
+func (r *PrivacyRepo) ListLog() ([]models.PrivacyRequest, error) {
+	var entries []models.PrivacyRequest
+	err := r.DB.Order("seq").Find(&entries).Error
+	return entries, err
+}
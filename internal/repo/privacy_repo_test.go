@@ -0,0 +1,82 @@
+package repo
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tracking/analysis/internal/models"
+)
+
+func TestSubjectFilter(t *testing.T) {
+	ips := []string{"203.0.113.77", "5f1c0e6a9b2d4c7e8f9a0b1c2d3e4f50"}
+	tests := []struct {
+		visitorID string
+		ips       []string
+		want      string
+		vars      int
+	}{
+		{"v1", ips, "visitor_id = $1 OR ip IN ($2,$3)", 3},
+		{"v1", nil, "visitor_id = $1", 1},
+		{"", ips, "ip IN ($1,$2)", 2},
+	}
+	for _, tt := range tests {
+		var clicks []models.Click
+		stmt := subjectFilter(dryRunDB(t), tt.visitorID, tt.ips).Find(&clicks).Statement
+		if sql := stmt.SQL.String(); !strings.Contains(sql, tt.want) {
+			t.Errorf("subjectFilter(%q, %v) = %s, want %s", tt.visitorID, tt.ips, sql, tt.want)
+		}
+		if len(stmt.Vars) != tt.vars {
+			t.Errorf("subjectFilter(%q, %v) vars = %v", tt.visitorID, tt.ips, stmt.Vars)
+		}
+	}
+}
+
+func TestSubjectDeliveries(t *testing.T) {
+	var deliveries []models.WebhookDelivery
+	stmt := subjectDeliveries(dryRunDB(t), "v1", []string{"203.0.113.77"}).Find(&deliveries).Statement
+	sql := stmt.SQL.String()
+	for _, want := range []string{
+		"payload::jsonb -> 'data' ->> 'click_id' IN (SELECT id::text FROM \"clicks\" WHERE visitor_id = $1 OR ip IN ($2))",
+		"payload::jsonb -> 'data' ->> 'event_id' IN (SELECT id::text FROM \"events\" WHERE visitor_id = $3 OR ip IN ($4))",
+		"payload::jsonb -> 'data' ->> 'visitor_id' = $5",
+	} {
+		if !strings.Contains(sql, want) {
+			t.Errorf("query = %s, want %s", sql, want)
+		}
+	}
+}
+
+func TestSubjectOutbox(t *testing.T) {
+	var msgs []models.OutboxMessage
+	sql := subjectOutbox(dryRunDB(t), "v1", nil).Find(&msgs).Statement.SQL.String()
+	for _, want := range []string{
+		"(kind = 'clicks' AND row_id IN (SELECT id::text FROM \"clicks\" WHERE visitor_id = $1))",
+		"(kind = 'events' AND row_id IN (SELECT id::text FROM \"events\" WHERE visitor_id = $2))",
+	} {
+		if !strings.Contains(sql, want) {
+			t.Errorf("query = %s, want %s", sql, want)
+		}
+	}
+}
+
+func TestEraseClickHouse(t *testing.T) {
+	fake := &fakeClickHouse{}
+	ch := fake.start(t)
+	if err := eraseClickHouse(ch, "v1", []string{"203.0.113.77"}, false); err != nil {
+		t.Fatal(err)
+	}
+	want := "ALTER TABLE events DELETE WHERE visitor_id = {visitor_id:String} OR has({ips:Array(String)}, ip)"
+	if fake.query != want {
+		t.Errorf("query = %s", fake.query)
+	}
+	if fake.params.Get("mutations_sync") != "2" || fake.params.Get("param_ips") != "['203.0.113.77']" {
+		t.Errorf("params = %v", fake.params)
+	}
+
+	if err := eraseClickHouse(ch, "", []string{"203.0.113.77"}, true); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(fake.query, "ALTER TABLE events UPDATE visitor_id = '', session_id = ''") || !strings.HasSuffix(fake.query, "WHERE has({ips:Array(String)}, ip)") {
+		t.Errorf("anonymise query = %s", fake.query)
+	}
+}
@@ -0,0 +1,285 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type ReportRepo struct {
+	DB *gorm.DB
+}
+
+func NewReportRepo(db *gorm.DB) *ReportRepo {
+	return &ReportRepo{DB: db}
+}
+
+// ErrInvalidReport wraps every rejection of a report query's parameters.
+var ErrInvalidReport = errors.New("invalid report")
+
+// Column types reported with a report table.
+const (
+	ColumnString = "string"
+	ColumnDate   = "date"
+	ColumnInt    = "int"
+)
+
+// referrerHostExpr extracts the host from a referrer column in SQL,
+// mirroring NormalizeReferrerHost. "?" is spelled \x3f so gorm does not
+// take it for a bind parameter.
+const referrerHostExpr = `CASE WHEN %[1]s = '' THEN '(direct)' ELSE COALESCE(SUBSTRING(%[1]s FROM '^[A-Za-z][A-Za-z0-9+.-]*://([^/\x3f#]+)'), %[1]s) END`
+
+type reportField struct {
+	expr  string
+	typ   string
+	zoned bool // expr binds the report timezone
+}
+
+// reportDimensions whitelists the dimensions of each report source. Only
+// these expressions are ever written into report SQL.
+var reportDimensions = map[string]map[string]reportField{
+	"clicks": {
+		"date":          {"TO_CHAR(ts AT TIME ZONE ?, 'YYYY-MM-DD')", ColumnDate, true},
+		"hour":          {"EXTRACT(HOUR FROM ts AT TIME ZONE ?)::int", ColumnInt, true},
+		"tracker":       {"tracker_id::text", ColumnString, false},
+		"campaign":      {"COALESCE(campaign_id::text, '')", ColumnString, false},
+		"channel":       {"COALESCE(channel_id::text, '')", ColumnString, false},
+		"country":       {"country", ColumnString, false},
+		"lang":          {"lang", ColumnString, false},
+		"browser":       {"browser", ColumnString, false},
+		"os":            {"os", ColumnString, false},
+		"referrer_host": {fmt.Sprintf(referrerHostExpr, "referer"), ColumnString, false},
+	},
+	"events": {
+		"date":          {"TO_CHAR(ts AT TIME ZONE ?, 'YYYY-MM-DD')", ColumnDate, true},
+		"hour":          {"EXTRACT(HOUR FROM ts AT TIME ZONE ?)::int", ColumnInt, true},
+		"site":          {"site_id::text", ColumnString, false},
+		"event_type":    {"type", ColumnString, false},
+		"country":       {"country", ColumnString, false},
+		"lang":          {"lang", ColumnString, false},
+		"browser":       {"browser", ColumnString, false},
+		"os":            {"os", ColumnString, false},
+		"referrer_host": {fmt.Sprintf(referrerHostExpr, "referrer"), ColumnString, false},
+	},
+}
+
+// reportMetrics whitelists the metrics of each report source. Bots are
+// excluded from every metric except "bots".
+var reportMetrics = map[string]map[string]string{
+	"clicks": {
+		"count":           "COUNT(*) FILTER (WHERE NOT is_bot)",
+		"unique_visitors": "COUNT(DISTINCT NULLIF(visitor_id, '')) FILTER (WHERE NOT is_bot)",
+		"bots":            "COUNT(*) FILTER (WHERE is_bot)",
+	},
+	"events": {
+		"count": "COUNT(*) FILTER (WHERE NOT is_bot)",
+		"unique_visitors": "COUNT(DISTINCT NULLIF(visitor_id, '')) FILTER (WHERE NOT is_bot)" +
+			" + COUNT(DISTINCT CASE WHEN visitor_id = '' THEN session_id END) FILTER (WHERE NOT is_bot)",
+		"unique_sessions": "COUNT(DISTINCT NULLIF(session_id, '')) FILTER (WHERE NOT is_bot)",
+		"bots":            "COUNT(*) FILTER (WHERE is_bot)",
+		"conversions":     "COUNT(*) FILTER (WHERE NOT is_bot AND type = ?)",
+	},
+}
+
+// propDimension matches "props.<key>" dimensions; the key is bound as a
+// parameter, the pattern only keeps names readable.
+var propDimension = regexp.MustCompile(`^props\.([A-Za-z0-9_.-]{1,64})$`)
+
+type ReportFilter struct {
+	Dimension string   `json:"dimension"`
+	Op        string   `json:"op"` // eq, neq, in, contains
+	Value     string   `json:"value"`
+	Values    []string `json:"values"`
+}
+
+type ReportSort struct {
+	By   string `json:"by"`
+	Desc bool   `json:"desc"`
+}
+
+type ReportQuery struct {
+	Source     string
+	Start, End time.Time
+	Metrics    []string
+	Dimensions []string
+	Filters    []ReportFilter
+	Sort       *ReportSort
+	Limit      int
+	// Timezone is the IANA zone the date and hour dimensions are bucketed in.
+	Timezone string
+	// ConversionEvent is the event type counted by the "conversions" metric.
+	ConversionEvent string
+}
+
+type ReportColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Kind string `json:"kind"` // dimension or metric
+}
+
+type ReportTable struct {
+	Columns []ReportColumn `json:"columns"`
+	Rows    [][]any        `json:"rows"`
+}
+
+// resolveDimension returns the SQL expression and bind arguments for a
+// dimension name, or an error if it is not whitelisted for the source.
+func resolveDimension(source, name, tz string) (reportField, []any, error) {
+	if f, ok := reportDimensions[source][name]; ok {
+		if f.zoned {
+			return f, []any{tz}, nil
+		}
+		return f, nil, nil
+	}
+	if m := propDimension.FindStringSubmatch(name); m != nil {
+		return reportField{"COALESCE(props ->> ?, '')", ColumnString, false}, []any{m[1]}, nil
+	}
+	return reportField{}, nil, fmt.Errorf("%w: unsupported dimension for %s: %s", ErrInvalidReport, source, name)
+}
+
+// buildReport translates q into a SELECT on db. Identifiers come only from
+// the whitelists above and ordinals; all user values are bind parameters.
+func buildReport(db *gorm.DB, q ReportQuery) (*gorm.DB, []ReportColumn, error) {
+	metrics, ok := reportMetrics[q.Source]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: unsupported source: %s", ErrInvalidReport, q.Source)
+	}
+	if len(q.Metrics) == 0 {
+		return nil, nil, fmt.Errorf("%w: at least one metric required", ErrInvalidReport)
+	}
+	if q.Timezone == "" {
+		q.Timezone = "UTC"
+	}
+
+	var selects []string
+	var selectArgs []any
+	var columns []ReportColumn
+	for _, name := range q.Dimensions {
+		f, args, err := resolveDimension(q.Source, name, q.Timezone)
+		if err != nil {
+			return nil, nil, err
+		}
+		expr := f.expr
+		if f.typ != ColumnInt {
+			expr = "COALESCE(" + expr + ", '')"
+		}
+		selects = append(selects, expr)
+		selectArgs = append(selectArgs, args...)
+		columns = append(columns, ReportColumn{Name: name, Type: f.typ, Kind: "dimension"})
+	}
+	for _, name := range q.Metrics {
+		expr, ok := metrics[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: unsupported metric for %s: %s", ErrInvalidReport, q.Source, name)
+		}
+		if name == "conversions" {
+			if q.ConversionEvent == "" {
+				return nil, nil, fmt.Errorf("%w: conversions requires conversion_event", ErrInvalidReport)
+			}
+			selectArgs = append(selectArgs, q.ConversionEvent)
+		}
+		selects = append(selects, expr)
+		columns = append(columns, ReportColumn{Name: name, Type: ColumnInt, Kind: "metric"})
+	}
+
+	tx := db.Table(q.Source).
+		Select(strings.Join(selects, ", "), selectArgs...).
+		Where("ts BETWEEN ? AND ?", q.Start, q.End)
+
+	for _, flt := range q.Filters {
+		f, args, err := resolveDimension(q.Source, flt.Dimension, q.Timezone)
+		if err != nil {
+			return nil, nil, err
+		}
+		col := "(" + f.expr + ")::text"
+		switch flt.Op {
+		case "eq", "":
+			tx = tx.Where(col+" = ?", append(args, flt.Value)...)
+		case "neq":
+			tx = tx.Where(col+" != ?", append(args, flt.Value)...)
+		case "in":
+			if len(flt.Values) == 0 {
+				return nil, nil, fmt.Errorf("%w: filter on %s: in requires values", ErrInvalidReport, flt.Dimension)
+			}
+			tx = tx.Where(col+" IN ?", append(args, flt.Values)...)
+		case "contains":
+			tx = tx.Where(col+" ILIKE ?", append(args, "%"+escapeLike(flt.Value)+"%")...)
+		default:
+			return nil, nil, fmt.Errorf("%w: unsupported filter op: %s", ErrInvalidReport, flt.Op)
+		}
+	}
+
+	if len(q.Dimensions) > 0 {
+		ordinals := make([]string, len(q.Dimensions))
+		for i := range ordinals {
+			ordinals[i] = strconv.Itoa(i + 1)
+		}
+		tx = tx.Group(strings.Join(ordinals, ", "))
+	}
+
+	order := len(q.Dimensions) + 1 // first metric, descending
+	desc := true
+	if q.Sort != nil && q.Sort.By != "" {
+		order = 0
+		for i, c := range columns {
+			if c.Name == q.Sort.By {
+				order = i + 1
+				break
+			}
+		}
+		if order == 0 {
+			return nil, nil, fmt.Errorf("%w: sort column not in report: %s", ErrInvalidReport, q.Sort.By)
+		}
+		desc = q.Sort.Desc
+	}
+	direction := "ASC"
+	if desc {
+		direction = "DESC"
+	}
+	tx = tx.Order(fmt.Sprintf("%d %s", order, direction)).Limit(q.Limit)
+	return tx, columns, nil
+}
+
+// Report runs an ad-hoc aggregation over clicks or events.
+func (r *ReportRepo) Report(q ReportQuery) (*ReportTable, error) {
+	tx, columns, err := buildReport(r.DB, q)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := tx.Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	table := &ReportTable{Columns: columns, Rows: [][]any{}}
+	for rows.Next() {
+		dest := make([]any, len(columns))
+		for i, c := range columns {
+			if c.Type == ColumnInt {
+				dest[i] = new(int64)
+			} else {
+				dest[i] = new(string)
+			}
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		row := make([]any, len(columns))
+		for i, d := range dest {
+			switch v := d.(type) {
+			case *int64:
+				row[i] = *v
+			case *string:
+				row[i] = *v
+			}
+		}
+		table.Rows = append(table.Rows, row)
+	}
+	return table, rows.Err()
+}
@@ -0,0 +1,90 @@
+package repo
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func dryRunDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		DryRun:               true,
+		DisableAutomaticPing: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func reportSQL(t *testing.T, q ReportQuery) (string, []any, error) {
+	t.Helper()
+	tx, _, err := buildReport(dryRunDB(t), q)
+	if err != nil {
+		return "", nil, err
+	}
+	var out []map[string]any
+	stmt := tx.Find(&out).Statement
+	return stmt.SQL.String(), stmt.Vars, nil
+}
+
+func TestBuildReport_ValuesAreBound(t *testing.T) {
+	evil := "x'); DROP TABLE events; --"
+	sql, vars, err := reportSQL(t, ReportQuery{
+		Source:          "events",
+		Start:           time.Now().Add(-time.Hour),
+		End:             time.Now(),
+		Metrics:         []string{"count", "conversions"},
+		Dimensions:      []string{"date", "props.plan", "referrer_host"},
+		Filters:         []ReportFilter{{Dimension: "country", Op: "in", Values: []string{evil}}, {Dimension: "props.plan", Value: evil}},
+		Sort:            &ReportSort{By: "props.plan"},
+		Limit:           50,
+		ConversionEvent: evil,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(sql, "DROP") {
+		t.Fatalf("user input leaked into SQL: %s", sql)
+	}
+	if n := strings.Count(sql, "$"); n != len(vars) {
+		t.Errorf("%d placeholders for %d vars: %s", n, len(vars), sql)
+	}
+	if !strings.Contains(sql, "GROUP BY 1, 2, 3") || !strings.Contains(sql, "ORDER BY 2 ASC") {
+		t.Errorf("unexpected grouping or order: %s", sql)
+	}
+	found := 0
+	for _, v := range vars {
+		if v == evil || v == "plan" {
+			found++
+		}
+	}
+	if found < 3 {
+		t.Errorf("expected bound values, got vars %v", vars)
+	}
+}
+
+func TestBuildReport_RejectsUnknown(t *testing.T) {
+	base := ReportQuery{Source: "clicks", Metrics: []string{"count"}, Limit: 10}
+	cases := map[string]func(q *ReportQuery){
+		"source":      func(q *ReportQuery) { q.Source = "users" },
+		"metric":      func(q *ReportQuery) { q.Metrics = []string{"count; DROP"} },
+		"dimension":   func(q *ReportQuery) { q.Dimensions = []string{"event_type"} },
+		"prop key":    func(q *ReportQuery) { q.Dimensions = []string{"props.a'b"} },
+		"filter op":   func(q *ReportQuery) { q.Filters = []ReportFilter{{Dimension: "country", Op: "like"}} },
+		"sort":        func(q *ReportQuery) { q.Sort = &ReportSort{By: "ts"} },
+		"conversions": func(q *ReportQuery) { q.Source = "events"; q.Metrics = []string{"conversions"} },
+		"no metrics":  func(q *ReportQuery) { q.Metrics = nil },
+	}
+	for name, mutate := range cases {
+		q := base
+		mutate(&q)
+		if _, _, err := reportSQL(t, q); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
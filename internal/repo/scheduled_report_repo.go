@@ -0,0 +1,98 @@
+package repo
+
+import (
+	"time"
+
+	"github.com/tracking/analysis/internal/models"
+	"gorm.io/gorm"
+)
+
+type ScheduledReportRepo struct {
+	DB *gorm.DB
+}
+
+func NewScheduledReportRepo(db *gorm.DB) *ScheduledReportRepo {
+	return &ScheduledReportRepo{DB: db}
+}
+
+func (r *ScheduledReportRepo) Create(rep *models.ScheduledReport) error {
+	return r.DB.Create(rep).Error
+}
+
+func (r *ScheduledReportRepo) GetByID(id string) (*models.ScheduledReport, error) {
+	var rep models.ScheduledReport
+	err := r.DB.First(&rep, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &rep, nil
+}
+
+func (r *ScheduledReportRepo) List() ([]models.ScheduledReport, error) {
+	var reports []models.ScheduledReport
+	err := r.DB.Order("created_at DESC").Find(&reports).Error
+	return reports, err
+}
+
+func (r *ScheduledReportRepo) Update(rep *models.ScheduledReport) error {
+	return r.DB.Save(rep).Error
+}
+
+// Delete removes the report and its run history.
+func (r *ScheduledReportRepo) Delete(id string) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("report_id = ?", id).Delete(&models.ScheduledReportRun{}).Error; err != nil {
+			return err
+		}
+		res := tx.Delete(&models.ScheduledReport{}, "id = ?", id)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+// ListDue returns active reports whose next run is at or before now.
+func (r *ScheduledReportRepo) ListDue(now time.Time) ([]models.ScheduledReport, error) {
+	var reports []models.ScheduledReport
+	err := r.DB.Where("active = ? AND next_run_at <= ?", true, now).Find(&reports).Error
+	return reports, err
+}
+
+// Claim moves a due report's next run from due to next and records the run
+// time. The conditional update lets only one replica claim each run.
+func (r *ScheduledReportRepo) Claim(id string, due, next, now time.Time) (bool, error) {
+	res := r.DB.Model(&models.ScheduledReport{}).
+		Where("id = ? AND next_run_at = ?", id, due).
+		Updates(map[string]any{"next_run_at": next, "last_run_at": now})
+	return res.RowsAffected == 1, res.Error
+}
+
+func (r *ScheduledReportRepo) CreateRun(run *models.ScheduledReportRun) error {
+	return r.DB.Create(run).Error
+}
+
+// ListRuns returns run history newest first, without file contents. An
+// empty reportID matches all reports.
+func (r *ScheduledReportRepo) ListRuns(reportID string, limit int) ([]models.ScheduledReportRun, error) {
+	var runs []models.ScheduledReportRun
+	q := r.DB.Omit("content")
+	if reportID != "" {
+		q = q.Where("report_id = ?", reportID)
+	}
+	err := q.Order("created_at DESC").Limit(limit).Find(&runs).Error
+	return runs, err
+}
+
+// GetRun returns a run including its file.
+func (r *ScheduledReportRepo) GetRun(id string) (*models.ScheduledReportRun, error) {
+	var run models.ScheduledReportRun
+	err := r.DB.First(&run, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
@@ -0,0 +1,274 @@
+package repo
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tracking/analysis/internal/models"
+	"gorm.io/gorm"
+)
+
+type SessionRepo struct {
+	DB *gorm.DB
+}
+
+func NewSessionRepo(db *gorm.DB) *SessionRepo {
+	return &SessionRepo{DB: db}
+}
+
+// SessionsFromEvents folds a batch of events into one partial session per
+// (site, session ID). Events without a session ID are skipped.
+func SessionsFromEvents(events []models.Event) []models.Session {
+	type key struct{ site, session string }
+	byKey := make(map[key]*models.Session)
+	var entryAt, exitAt = make(map[key]time.Time), make(map[key]time.Time)
+	var order []key
+
+	for _, e := range events {
+		if e.SessionID == "" {
+			continue
+		}
+		k := key{e.SiteID, e.SessionID}
+		s, ok := byKey[k]
+		if !ok {
+			s = &models.Session{
+				SiteID:    e.SiteID,
+				SessionID: e.SessionID,
+				StartedAt: e.TS,
+				EndedAt:   e.TS,
+			}
+			byKey[k] = s
+			order = append(order, k)
+		}
+		if e.TS.Before(s.StartedAt) {
+			s.StartedAt = e.TS
+		}
+		if e.TS.After(s.EndedAt) {
+			s.EndedAt = e.TS
+		}
+		if s.VisitorID == "" {
+			s.VisitorID = e.VisitorID
+		}
+		if s.Country == "" {
+			s.Country = e.Country
+		}
+		s.IsBot = s.IsBot || e.IsBot
+		s.Events++
+
+		if e.Type != "pageview" {
+			continue
+		}
+		s.Pageviews++
+		if at, seen := entryAt[k]; !seen || e.TS.Before(at) {
+			entryAt[k] = e.TS
+			s.EntryURL = e.URL
+			s.LandingReferrer = e.Referrer
+		}
+		if at, seen := exitAt[k]; !seen || !e.TS.Before(at) {
+			exitAt[k] = e.TS
+			s.ExitURL = e.URL
+		}
+	}
+
+	sessions := make([]models.Session, 0, len(order))
+	for _, k := range order {
+		s := byKey[k]
+		s.DurationSeconds = int64(s.EndedAt.Sub(s.StartedAt).Seconds())
+		s.UTMSource, s.UTMMedium, s.UTMCampaign = parseUTM(s.EntryURL)
+		sessions = append(sessions, *s)
+	}
+	return sessions
+}
+
+func parseUTM(rawURL string) (source, medium, campaign string) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", "", ""
+	}
+	q := u.Query()
+	return q.Get("utm_source"), q.Get("utm_medium"), q.Get("utm_campaign")
+}
+
+// upsertSessionSQL merges a partial session into the stored one. Entry
+// fields are replaced only by an earlier pageview and exit fields only by
+// a later one, so batches may arrive in any order.
+const upsertSessionSQL = `
+INSERT INTO sessions (id, site_id, session_id, visitor_id, started_at, ended_at, duration_seconds,
+	pageviews, events, entry_url, exit_url, landing_referrer, utm_source, utm_medium, utm_campaign,
+	country, is_bot, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, NOW(), NOW())
+ON CONFLICT (site_id, session_id) DO UPDATE SET
+	visitor_id = CASE WHEN sessions.visitor_id = '' THEN EXCLUDED.visitor_id ELSE sessions.visitor_id END,
+	started_at = LEAST(sessions.started_at, EXCLUDED.started_at),
+	ended_at = GREATEST(sessions.ended_at, EXCLUDED.ended_at),
+	duration_seconds = EXTRACT(EPOCH FROM GREATEST(sessions.ended_at, EXCLUDED.ended_at) - LEAST(sessions.started_at, EXCLUDED.started_at))::bigint,
+	pageviews = sessions.pageviews + EXCLUDED.pageviews,
+	events = sessions.events + EXCLUDED.events,
+	entry_url = CASE WHEN EXCLUDED.pageviews > 0 AND (sessions.pageviews = 0 OR EXCLUDED.started_at < sessions.started_at) THEN EXCLUDED.entry_url ELSE sessions.entry_url END,
+	landing_referrer = CASE WHEN EXCLUDED.pageviews > 0 AND (sessions.pageviews = 0 OR EXCLUDED.started_at < sessions.started_at) THEN EXCLUDED.landing_referrer ELSE sessions.landing_referrer END,
+	utm_source = CASE WHEN EXCLUDED.pageviews > 0 AND (sessions.pageviews = 0 OR EXCLUDED.started_at < sessions.started_at) THEN EXCLUDED.utm_source ELSE sessions.utm_source END,
+	utm_medium = CASE WHEN EXCLUDED.pageviews > 0 AND (sessions.pageviews = 0 OR EXCLUDED.started_at < sessions.started_at) THEN EXCLUDED.utm_medium ELSE sessions.utm_medium END,
+	utm_campaign = CASE WHEN EXCLUDED.pageviews > 0 AND (sessions.pageviews = 0 OR EXCLUDED.started_at < sessions.started_at) THEN EXCLUDED.utm_campaign ELSE sessions.utm_campaign END,
+	exit_url = CASE WHEN EXCLUDED.pageviews > 0 AND (sessions.pageviews = 0 OR EXCLUDED.ended_at >= sessions.ended_at) THEN EXCLUDED.exit_url ELSE sessions.exit_url END,
+	country = CASE WHEN sessions.country = '' THEN EXCLUDED.country ELSE sessions.country END,
+	is_bot = sessions.is_bot OR EXCLUDED.is_bot,
+	updated_at = NOW()`
+
+// UpsertFromEvents materialises the sessions touched by an ingested batch.
+func (r *SessionRepo) UpsertFromEvents(events []models.Event) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		for _, s := range SessionsFromEvents(events) {
+			err := tx.Exec(upsertSessionSQL,
+				uuid.New().String(), s.SiteID, s.SessionID, s.VisitorID, s.StartedAt, s.EndedAt, s.DurationSeconds,
+				s.Pageviews, s.Events, s.EntryURL, s.ExitURL, s.LandingReferrer, s.UTMSource, s.UTMMedium, s.UTMCampaign,
+				s.Country, s.IsBot).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// rebuildSessionsSQL recomputes sessions from raw events, for backfilling
+// data ingested before sessions were materialised. Sessions are picked by
+// their events in range, then aggregated over all their events.
+const rebuildSessionsSQL = `
+INSERT INTO sessions (id, site_id, session_id, visitor_id, started_at, ended_at, duration_seconds,
+	pageviews, events, entry_url, exit_url, landing_referrer, utm_source, utm_medium, utm_campaign,
+	country, is_bot, created_at, updated_at)
+SELECT gen_random_uuid(), site_id, session_id, COALESCE(MAX(NULLIF(visitor_id, '')), ''), MIN(ts), MAX(ts),
+	EXTRACT(EPOCH FROM MAX(ts) - MIN(ts))::bigint,
+	COUNT(*) FILTER (WHERE type = 'pageview'), COUNT(*),
+	COALESCE((ARRAY_AGG(url ORDER BY ts) FILTER (WHERE type = 'pageview'))[1], ''),
+	COALESCE((ARRAY_AGG(url ORDER BY ts DESC) FILTER (WHERE type = 'pageview'))[1], ''),
+	COALESCE((ARRAY_AGG(referrer ORDER BY ts) FILTER (WHERE type = 'pageview'))[1], ''),
+	'', '', '',
+	COALESCE(MAX(NULLIF(country, '')), ''), BOOL_OR(is_bot), NOW(), NOW()
+FROM events
+WHERE (site_id, session_id) IN (
+	SELECT DISTINCT site_id, session_id FROM events
+	WHERE session_id != '' AND ts BETWEEN ? AND ? AND (? = '' OR site_id = ?))
+GROUP BY site_id, session_id
+ON CONFLICT (site_id, session_id) DO UPDATE SET
+	visitor_id = EXCLUDED.visitor_id,
+	started_at = EXCLUDED.started_at,
+	ended_at = EXCLUDED.ended_at,
+	duration_seconds = EXCLUDED.duration_seconds,
+	pageviews = EXCLUDED.pageviews,
+	events = EXCLUDED.events,
+	entry_url = EXCLUDED.entry_url,
+	exit_url = EXCLUDED.exit_url,
+	landing_referrer = EXCLUDED.landing_referrer,
+	utm_source = EXCLUDED.utm_source,
+	utm_medium = EXCLUDED.utm_medium,
+	utm_campaign = EXCLUDED.utm_campaign,
+	country = EXCLUDED.country,
+	is_bot = EXCLUDED.is_bot,
+	updated_at = NOW()
+RETURNING id, entry_url`
+
+// sessionUTM is the UTM tags of one rebuilt session.
+type sessionUTM struct {
+	id                       string
+	source, medium, campaign string
+}
+
+// Rebuild recomputes every session with events in [start, end], of one
+// site or, with siteID "", of all. Sessions that straddle the range are
+// rebuilt whole, from their events outside it too. UTM tags are parsed from the
+// entry URL with parseUTM, as on ingest.
+func (r *SessionRepo) Rebuild(start, end time.Time, siteID string) (int64, error) {
+	var rows int64
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		cur, err := tx.Raw(rebuildSessionsSQL, start, end, siteID, siteID).Rows()
+		if err != nil {
+			return err
+		}
+		var tagged []sessionUTM
+		for cur.Next() {
+			var id, entryURL string
+			if err := cur.Scan(&id, &entryURL); err != nil {
+				cur.Close()
+				return err
+			}
+			rows++
+			if u := (sessionUTM{id: id}); strings.Contains(entryURL, "utm_") {
+				u.source, u.medium, u.campaign = parseUTM(entryURL)
+				tagged = append(tagged, u)
+			}
+		}
+		cur.Close()
+		if err := cur.Err(); err != nil {
+			return err
+		}
+		return fillSessionUTM(tx, tagged)
+	})
+	return rows, err
+}
+
+// fillSessionUTM sets the UTM tags of rebuilt sessions, in chunks.
+func fillSessionUTM(tx *gorm.DB, tagged []sessionUTM) error {
+	const chunk = 500
+	for len(tagged) > 0 {
+		n := min(chunk, len(tagged))
+		values := make([]string, n)
+		args := make([]any, 0, 4*n)
+		for i, u := range tagged[:n] {
+			values[i] = "(?::uuid, ?, ?, ?)"
+			args = append(args, u.id, u.source, u.medium, u.campaign)
+		}
+		err := tx.Exec(`UPDATE sessions SET utm_source = v.source, utm_medium = v.medium, utm_campaign = v.campaign
+FROM (VALUES `+strings.Join(values, ", ")+`) AS v(id, source, medium, campaign)
+WHERE sessions.id = v.id`, args...).Error
+		if err != nil {
+			return err
+		}
+		tagged = tagged[n:]
+	}
+	return nil
+}
+
+// sessionFilters scopes the sessions table to non-bot sessions started in
+// [start, end].
+func sessionFilters(q *gorm.DB, start, end time.Time, siteID string) *gorm.DB {
+	q = q.Where("started_at BETWEEN ? AND ? AND is_bot = false", start, end)
+	if siteID != "" {
+		q = q.Where("site_id = ?", siteID)
+	}
+	return q
+}
+
+// SessionSummary returns the session count, single-pageview (bounced)
+// sessions and average duration in seconds for sessions started in
+// [start, end], from the materialised sessions table.
+func (r *EventRepo) SessionSummary(start, end time.Time, siteID string) (sessions, bounces int64, avgDuration float64, err error) {
+	q := sessionFilters(r.DB.Model(&models.Session{}), start, end, siteID).
+		Select("COUNT(*), COUNT(*) FILTER (WHERE pageviews = 1), COALESCE(AVG(duration_seconds), 0)")
+	err = q.Row().Scan(&sessions, &bounces, &avgDuration)
+	return sessions, bounces, avgDuration, err
+}
+
+func (r *EventRepo) TopEntryPages(start, end time.Time, siteID string, limit int) ([]NameCount, error) {
+	return r.topSessionPages("entry_url", start, end, siteID, limit)
+}
+
+func (r *EventRepo) TopExitPages(start, end time.Time, siteID string, limit int) ([]NameCount, error) {
+	return r.topSessionPages("exit_url", start, end, siteID, limit)
+}
+
+// topSessionPages groups sessions by column, which must be a trusted
+// column name.
+func (r *EventRepo) topSessionPages(column string, start, end time.Time, siteID string, limit int) ([]NameCount, error) {
+	q := sessionFilters(r.DB.Model(&models.Session{}), start, end, siteID).
+		Select(column + " AS name, COUNT(*) AS count").
+		Where(column + " != ''")
+	var raw []NameCount
+	err := q.Group(column).Order("count DESC").Limit(500).Find(&raw).Error
+	if err != nil {
+		return nil, err
+	}
+	return pagePathCounts(raw, limit), nil
+}
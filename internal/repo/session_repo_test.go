@@ -0,0 +1,83 @@
+package repo
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tracking/analysis/internal/models"
+	"gorm.io/gorm"
+)
+
+func TestSessionsFromEvents(t *testing.T) {
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	events := []models.Event{
+		{SiteID: "s", SessionID: "a", Type: "click", TS: t0.Add(90 * time.Second)},
+		{SiteID: "s", SessionID: "a", Type: "pageview", TS: t0.Add(60 * time.Second), URL: "https://ex.com/pricing"},
+		{SiteID: "s", SessionID: "a", Type: "pageview", TS: t0, URL: "https://ex.com/?utm_source=news&utm_medium=email",
+			Referrer: "https://mail.example/", VisitorID: "v1", Country: "DE"},
+		{SiteID: "s", SessionID: "", Type: "pageview", TS: t0},
+		{SiteID: "s", SessionID: "b", Type: "scroll_depth", TS: t0, IsBot: true},
+	}
+
+	sessions := SessionsFromEvents(events)
+	if len(sessions) != 2 {
+		t.Fatalf("got %d sessions, want 2", len(sessions))
+	}
+
+	a := sessions[0]
+	if a.SessionID != "a" || a.Pageviews != 2 || a.Events != 3 {
+		t.Errorf("session a counts = %+v", a)
+	}
+	if !a.StartedAt.Equal(t0) || a.DurationSeconds != 90 {
+		t.Errorf("session a start=%v duration=%d", a.StartedAt, a.DurationSeconds)
+	}
+	if a.EntryURL != "https://ex.com/?utm_source=news&utm_medium=email" || a.ExitURL != "https://ex.com/pricing" {
+		t.Errorf("session a entry=%q exit=%q", a.EntryURL, a.ExitURL)
+	}
+	if a.LandingReferrer != "https://mail.example/" || a.UTMSource != "news" || a.UTMMedium != "email" {
+		t.Errorf("session a referrer=%q utm=%q/%q", a.LandingReferrer, a.UTMSource, a.UTMMedium)
+	}
+	if a.VisitorID != "v1" || a.Country != "DE" {
+		t.Errorf("session a visitor=%q country=%q", a.VisitorID, a.Country)
+	}
+
+	b := sessions[1]
+	if b.Pageviews != 0 || b.EntryURL != "" || !b.IsBot {
+		t.Errorf("session b = %+v", b)
+	}
+}
+
+func TestParseUTM_Decodes(t *testing.T) {
+	source, medium, campaign := parseUTM("https://ex.com/?utm_source=news%20letter&utm_medium=e%2Dmail&utm_campaign=spring+sale")
+	if source != "news letter" || medium != "e-mail" || campaign != "spring sale" {
+		t.Errorf("parseUTM = %q, %q, %q", source, medium, campaign)
+	}
+}
+
+func TestFillSessionUTM(t *testing.T) {
+	db := dryRunDB(t)
+	var stmts []int
+	db.Callback().Raw().After("gorm:raw").Register("test:capture", func(tx *gorm.DB) {
+		stmts = append(stmts, len(tx.Statement.Vars))
+	})
+	tagged := make([]sessionUTM, 501)
+	for i := range tagged {
+		tagged[i] = sessionUTM{id: fmt.Sprintf("00000000-0000-0000-0000-%012d", i), source: "news letter"}
+	}
+	if err := fillSessionUTM(db, tagged); err != nil {
+		t.Fatal(err)
+	}
+	if len(stmts) != 2 || stmts[0] != 2000 || stmts[1] != 4 {
+		t.Errorf("statements = %v, want 500 rows then 1", stmts)
+	}
+}
+
+func TestRebuildSessionsSQL_WholeSessions(t *testing.T) {
+	// The range picks sessions; their events are aggregated unbounded
+	outer, picked, ok := strings.Cut(rebuildSessionsSQL, "IN (")
+	if !ok || strings.Contains(outer, "ts BETWEEN") || !strings.Contains(picked, "ts BETWEEN ? AND ?") {
+		t.Errorf("query = %s", rebuildSessionsSQL)
+	}
+}
@@ -31,3 +31,16 @@ func (r *SiteRepo) GetByKey(siteKey string) (*models.Site, error) {
 	}
 	return &s, nil
 }
+
+func (r *SiteRepo) GetByID(id string) (*models.Site, error) {
+	var s models.Site
+	err := r.DB.First(&s, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (r *SiteRepo) Update(s *models.Site) error {
+	return r.DB.Save(s).Error
+}
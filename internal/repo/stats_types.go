@@ -20,3 +20,10 @@ type NameCount struct {
 	Name  string `json:"name"`
 	Count int64  `json:"count"`
 }
+
+type UACount struct {
+	UA      string `json:"ua"`
+	Browser string `json:"browser"`
+	OS      string `json:"os"`
+	Count   int64  `json:"count"`
+}
@@ -0,0 +1,157 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/tracking/analysis/internal/clickhouse"
+	"github.com/tracking/analysis/internal/config"
+	"github.com/tracking/analysis/internal/models"
+	"gorm.io/gorm"
+)
+
+// Analytics store modes, as set in AnalyticsConfiguration.Mode.
+const (
+	ModePostgres   = "postgres"
+	ModeClickHouse = "clickhouse"
+	ModeDual       = "dual"
+)
+
+// ClickStore stores clicks and answers the click stats queries. ClickRepo
+// implements it on Postgres and ClickHouseClickStore on ClickHouse.
+type ClickStore interface {
+	Create(c *models.Click) error
+	CountByDay(start, end time.Time, tz, trackerID, campaignID, channelID string) ([]DailyCount, error)
+	Summary(start, end time.Time, trackerID, campaignID, channelID string) (total, uniqueVisitors, bots int64, err error)
+	TopByGroup(start, end time.Time, dimension string, limit int) ([]GroupCount, error)
+	TopReferrers(start, end time.Time, trackerID, campaignID, channelID string, limit int) ([]NameCount, error)
+	RawUACounts(start, end time.Time, trackerID, campaignID, channelID string) ([]UACount, error)
+	LanguageDistribution(start, end time.Time, trackerID, campaignID, channelID string, limit int) ([]NameCount, error)
+	CountryDistribution(start, end time.Time, trackerID, campaignID, channelID string, limit int) ([]NameCount, error)
+	BotCountByDay(start, end time.Time, tz, trackerID, campaignID, channelID string) ([]DailyCount, error)
+	CountByHour(start, end time.Time, tz, trackerID, campaignID, channelID string) ([]HourlyCount, error)
+}
+
+// EventStore stores events and answers the event stats, session stats,
+// funnel, path and cohort queries. EventRepo implements it on Postgres,
+// where sessions are materialised, and ClickHouseEventStore on ClickHouse,
+// where they are computed from events.
+type EventStore interface {
+	BatchCreate(events []models.Event) error
+	// Import stores the events whose IDs are not stored yet and returns
+	// how many were new, so re-running an import adds nothing. Imported
+	// events are not published to the streaming sinks.
+	Import(events []models.Event) (int64, error)
+	CountByDay(start, end time.Time, tz, siteID string) ([]DailyCount, error)
+	Summary(start, end time.Time, siteID string) (total, uniqueVisitors, uniqueSessions, bots int64, err error)
+	TopByGroup(start, end time.Time, dimension string, limit int) ([]GroupCount, error)
+	TopReferrers(start, end time.Time, siteID string, limit int) ([]NameCount, error)
+	TopPages(start, end time.Time, siteID string, limit int) ([]NameCount, error)
+	RawUACounts(start, end time.Time, siteID string) ([]UACount, error)
+	LanguageDistribution(start, end time.Time, siteID string, limit int) ([]NameCount, error)
+	CountryDistribution(start, end time.Time, siteID string, limit int) ([]NameCount, error)
+	BotCountByDay(start, end time.Time, tz, siteID string) ([]DailyCount, error)
+	CountByHour(start, end time.Time, tz, siteID string) ([]HourlyCount, error)
+	SessionSummary(start, end time.Time, siteID string) (sessions, bounces int64, avgDuration float64, err error)
+	TopEntryPages(start, end time.Time, siteID string, limit int) ([]NameCount, error)
+	TopExitPages(start, end time.Time, siteID string, limit int) ([]NameCount, error)
+	Funnel(start, end time.Time, siteID, by string, f *Funnel) ([]FunnelStepResult, []FunnelBreakdown, error)
+	Paths(start, end time.Time, siteID string, p *PathAnalysis, limit int) (PathResult, error)
+	Cohort(q CohortQuery) ([]CohortRow, error)
+}
+
+var (
+	_ ClickStore = (*ClickRepo)(nil)
+	_ ClickStore = (*ClickHouseClickStore)(nil)
+	_ ClickStore = (*DualClickStore)(nil)
+	_ EventStore = (*EventRepo)(nil)
+	_ EventStore = (*ClickHouseEventStore)(nil)
+	_ EventStore = (*DualEventStore)(nil)
+)
+
+// DualClickStore writes every click to Primary and then Secondary, and
+// answers queries from the embedded ClickStore, which is one of the two.
+// Only Primary's errors fail a write; Secondary's are logged, and gaps are
+// filled by a backfill.
+type DualClickStore struct {
+	ClickStore
+	Primary   ClickStore
+	Secondary ClickStore
+}
+
+func (s *DualClickStore) Create(c *models.Click) error {
+	if err := s.Primary.Create(c); err != nil {
+		return err
+	}
+	if err := s.Secondary.Create(c); err != nil {
+		slog.Warn("secondary click write failed", "error", err, "click", c.ID)
+	}
+	return nil
+}
+
+// DualEventStore is DualClickStore for events.
+type DualEventStore struct {
+	EventStore
+	Primary   EventStore
+	Secondary EventStore
+}
+
+func (s *DualEventStore) BatchCreate(events []models.Event) error {
+	if err := s.Primary.BatchCreate(events); err != nil {
+		return err
+	}
+	if err := s.Secondary.BatchCreate(events); err != nil {
+		slog.Warn("secondary event write failed", "error", err, "events", len(events))
+	}
+	return nil
+}
+
+func (s *DualEventStore) Import(events []models.Event) (int64, error) {
+	n, err := s.Primary.Import(events)
+	if err != nil {
+		return n, err
+	}
+	if _, err := s.Secondary.Import(events); err != nil {
+		slog.Warn("secondary event import failed", "error", err, "events", len(events))
+	}
+	return n, nil
+}
+
+// NewStores returns the click and event stores cfg selects, creating the
+// ClickHouse tables when ClickHouse is used. In dual mode Postgres is the
+// primary, so its outbox and the features reading raw rows from Postgres
+// keep working while ClickHouse catches up.
+func NewStores(cfg config.AnalyticsConfiguration, db *gorm.DB, clicks *ClickRepo, events *EventRepo) (ClickStore, EventStore, error) {
+	if cfg.Mode == "" || cfg.Mode == ModePostgres {
+		return clicks, events, nil
+	}
+	if cfg.Mode != ModeClickHouse && cfg.Mode != ModeDual {
+		return nil, nil, fmt.Errorf("unsupported analytics mode %q", cfg.Mode)
+	}
+	if cfg.ClickHouseURL == "" {
+		return nil, nil, fmt.Errorf("analytics mode %q needs ClickHouseURL", cfg.Mode)
+	}
+	ch := clickhouse.New(cfg.ClickHouseURL, cfg.ClickHouseDatabase, cfg.ClickHouseUser, cfg.ClickHousePassword)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := MigrateClickHouse(ctx, ch); err != nil {
+		return nil, nil, fmt.Errorf("clickhouse schema: %w", err)
+	}
+	chClicks, chEvents := NewClickHouseClickStore(ch, db), NewClickHouseEventStore(ch, db)
+	if cfg.Mode == ModeClickHouse {
+		return chClicks, chEvents, nil
+	}
+
+	dualClicks := &DualClickStore{ClickStore: clicks, Primary: clicks, Secondary: chClicks}
+	dualEvents := &DualEventStore{EventStore: events, Primary: events, Secondary: chEvents}
+	switch cfg.ReadFrom {
+	case "", ModePostgres:
+	case ModeClickHouse:
+		dualClicks.ClickStore, dualEvents.EventStore = chClicks, chEvents
+	default:
+		return nil, nil, fmt.Errorf("unsupported analytics ReadFrom %q", cfg.ReadFrom)
+	}
+	return dualClicks, dualEvents, nil
+}
@@ -1,6 +1,8 @@
 package repo
 
 import (
+	"time"
+
 	"github.com/tracking/analysis/internal/models"
 	"gorm.io/gorm"
 )
@@ -45,3 +47,33 @@ func (r *TokenRepo) ExistsByShortCode(shortCode string) (bool, error) {
 	err := r.DB.Model(&models.Token{}).Where("short_code = ?", shortCode).Count(&count).Error
 	return count > 0, err
 }
+
+// ExpiredUnnotified returns up to 100 tokens expired at or before now whose
+// expiry has not been notified yet.
+func (r *TokenRepo) ExpiredUnnotified(now time.Time) ([]models.Token, error) {
+	var tokens []models.Token
+	err := r.DB.Where("expires_at <= ? AND expiry_notified = ?", now, false).
+		Limit(100).Find(&tokens).Error
+	return tokens, err
+}
+
+// MarkExpiryNotified marks a token's expiry notified and creates its webhook
+// deliveries in one transaction, so a crash cannot lose the notification.
+// The conditional update lets only one replica claim each token; claimed is
+// false, and nothing is created, if another got there first.
+func (r *TokenRepo) MarkExpiryNotified(id string, deliveries []models.WebhookDelivery) (claimed bool, err error) {
+	err = r.DB.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&models.Token{}).
+			Where("id = ? AND expiry_notified = ?", id, false).
+			Update("expiry_notified", true)
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		claimed = true
+		if len(deliveries) == 0 {
+			return nil
+		}
+		return tx.Create(&deliveries).Error
+	})
+	return claimed && err == nil, err
+}
@@ -7,19 +7,32 @@ import (
 	"github.com/mssola/useragent"
 )
 
-// ParseUADistribution parses raw UA strings into browser and OS distributions.
-func ParseUADistribution(uaCounts []NameCount, limit int) (browsers, oses []NameCount) {
+// ParseUA extracts the browser and OS names from a raw UA string.
+func ParseUA(raw string) (browser, os string) {
+	if raw == "" {
+		return "", ""
+	}
+	ua := useragent.New(raw)
+	browser, _ = ua.Browser()
+	os = ua.OSInfo().Name
+	return browser, os
+}
+
+// ParseUADistribution builds browser and OS distributions. Rows that already
+// carry parsed browser/OS names (e.g. when the raw UA was dropped for privacy)
+// are used as-is; otherwise the raw UA string is parsed.
+func ParseUADistribution(uaCounts []UACount, limit int) (browsers, oses []NameCount) {
 	browserMap := make(map[string]int64)
 	osMap := make(map[string]int64)
 
 	for _, uc := range uaCounts {
-		ua := useragent.New(uc.Name)
-		browserName, _ := ua.Browser()
+		browserName, osName := uc.Browser, uc.OS
+		if browserName == "" && osName == "" {
+			browserName, osName = ParseUA(uc.UA)
+		}
 		if browserName == "" {
 			browserName = "Unknown"
 		}
-		osInfo := ua.OSInfo()
-		osName := osInfo.Name
 		if osName == "" {
 			osName = "Unknown"
 		}
@@ -44,13 +57,39 @@ func NormalizeReferrerHost(ref string) string {
 	return u.Host
 }
 
+// referrerHostCounts re-aggregates per-referrer counts by hostname.
+func referrerHostCounts(raw []NameCount, limit int) []NameCount {
+	hostMap := make(map[string]int64)
+	for _, r := range raw {
+		hostMap[NormalizeReferrerHost(r.Name)] += r.Count
+	}
+	return mapToSortedNameCounts(hostMap, limit)
+}
+
+// pagePathCounts re-aggregates per-URL counts by path, dropping the
+// protocol, host and query.
+func pagePathCounts(raw []NameCount, limit int) []NameCount {
+	pathMap := make(map[string]int64)
+	for _, r := range raw {
+		path := r.Name
+		if u, err := url.Parse(r.Name); err == nil && u.Path != "" {
+			path = u.Path
+		}
+		pathMap[path] += r.Count
+	}
+	return mapToSortedNameCounts(pathMap, limit)
+}
+
 func mapToSortedNameCounts(m map[string]int64, limit int) []NameCount {
 	result := make([]NameCount, 0, len(m))
 	for name, count := range m {
 		result = append(result, NameCount{Name: name, Count: count})
 	}
 	sort.Slice(result, func(i, j int) bool {
-		return result[i].Count > result[j].Count
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Name < result[j].Name
 	})
 	if limit > 0 && len(result) > limit {
 		result = result[:limit]
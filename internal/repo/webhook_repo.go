@@ -0,0 +1,112 @@
+package repo
+
+import (
+	"time"
+
+	"github.com/tracking/analysis/internal/models"
+	"gorm.io/gorm"
+)
+
+type WebhookRepo struct {
+	DB *gorm.DB
+}
+
+func NewWebhookRepo(db *gorm.DB) *WebhookRepo {
+	return &WebhookRepo{DB: db}
+}
+
+func (r *WebhookRepo) Create(w *models.Webhook) error {
+	return r.DB.Create(w).Error
+}
+
+func (r *WebhookRepo) GetByID(id string) (*models.Webhook, error) {
+	var w models.Webhook
+	err := r.DB.First(&w, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+func (r *WebhookRepo) List() ([]models.Webhook, error) {
+	var hooks []models.Webhook
+	err := r.DB.Order("created_at DESC").Find(&hooks).Error
+	return hooks, err
+}
+
+func (r *WebhookRepo) ListActive() ([]models.Webhook, error) {
+	var hooks []models.Webhook
+	err := r.DB.Where("active = ?", true).Find(&hooks).Error
+	return hooks, err
+}
+
+func (r *WebhookRepo) Update(w *models.Webhook) error {
+	return r.DB.Save(w).Error
+}
+
+// Delete removes the webhook and its delivery log.
+func (r *WebhookRepo) Delete(id string) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("webhook_id = ?", id).Delete(&models.WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		res := tx.Delete(&models.Webhook{}, "id = ?", id)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+func (r *WebhookRepo) CreateDeliveries(deliveries []models.WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return r.DB.Create(&deliveries).Error
+}
+
+func (r *WebhookRepo) GetDelivery(id string) (*models.WebhookDelivery, error) {
+	var d models.WebhookDelivery
+	err := r.DB.First(&d, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (r *WebhookRepo) UpdateDelivery(d *models.WebhookDelivery) error {
+	return r.DB.Save(d).Error
+}
+
+// ListDeliveries returns the delivery log newest first. Empty filters match all.
+func (r *WebhookRepo) ListDeliveries(webhookID, status string, limit int) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	q := r.DB
+	if webhookID != "" {
+		q = q.Where("webhook_id = ?", webhookID)
+	}
+	if status != "" {
+		q = q.Where("status = ?", status)
+	}
+	err := q.Order("created_at DESC").Limit(limit).Find(&deliveries).Error
+	return deliveries, err
+}
+
+// PruneDeliveries deletes delivered and failed deliveries created before
+// before. Pending ones are kept until they finish.
+func (r *WebhookRepo) PruneDeliveries(before time.Time) (int64, error) {
+	res := r.DB.Where("status <> ? AND created_at < ?", "pending", before).Delete(&models.WebhookDelivery{})
+	return res.RowsAffected, res.Error
+}
+
+// PendingDeliveries lists deliveries still awaiting an attempt, used to
+// rebuild the Redis queue.
+func (r *WebhookRepo) PendingDeliveries() ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	err := r.DB.Select("id, next_attempt_at, created_at").
+		Where("status = ?", "pending").Find(&deliveries).Error
+	return deliveries, err
+}
@@ -11,10 +11,17 @@ import (
 	"math"
 	"time"
 
+	"github.com/redis/go-redis/v9"
+	"github.com/tracking/analysis/internal/alert"
 	"github.com/tracking/analysis/internal/config"
+	"github.com/tracking/analysis/internal/export"
+	"github.com/tracking/analysis/internal/live"
 	"github.com/tracking/analysis/internal/models"
+	"github.com/tracking/analysis/internal/privacy"
 	"github.com/tracking/analysis/internal/repo"
+	"github.com/tracking/analysis/internal/schedule"
 	"github.com/tracking/analysis/internal/security"
+	"github.com/tracking/analysis/internal/webhook"
 	"gorm.io/gorm"
 )
 
@@ -26,8 +33,22 @@ type AdminHandlers struct {
 	TargetRepo   *repo.TargetRepo
 	SiteRepo     *repo.SiteRepo
 	TokenRepo    *repo.TokenRepo
-	ClickRepo    *repo.ClickRepo
-	EventRepo    *repo.EventRepo
+	ClickRepo    repo.ClickStore
+	EventRepo    repo.EventStore
+	PrivacyRepo  *repo.PrivacyRepo
+	SessionRepo  *repo.SessionRepo
+	ReportRepo   *repo.ReportRepo
+	WebhookRepo  *repo.WebhookRepo
+	Webhooks     *webhook.Service
+	AlertRepo    *repo.AlertRepo
+	Alerts       *alert.Service
+
+	ScheduledReportRepo *repo.ScheduledReportRepo
+	Schedules           *schedule.Service
+	ExportRepo          *repo.ExportRepo
+	Exports             *export.Service
+	FraudRepo           *repo.FraudRepo
+	Redis               *redis.Client
 }
 
 // Session token generation using HMAC
@@ -66,15 +87,50 @@ func (h *AdminHandlers) Register(d *Dispatcher) {
 	d.Register("admin.channel.create", h.ChannelCreate)
 	d.Register("admin.channel.batchImport", h.ChannelBatchImport)
 	d.Register("admin.channel.list", h.ChannelList)
+	d.Register("admin.channel.update", h.ChannelUpdate)
 	d.Register("admin.target.create", h.TargetCreate)
 	d.Register("admin.target.list", h.TargetList)
 	d.Register("admin.site.create", h.SiteCreate)
 	d.Register("admin.site.list", h.SiteList)
+	d.Register("admin.site.update", h.SiteUpdate)
 	d.Register("admin.token.generate", h.TokenGenerate)
 	d.Register("admin.token.list", h.TokenList)
 	d.Register("admin.token.delete", h.TokenDelete)
 	d.Register("admin.stats.clicks", h.StatsClicks)
 	d.Register("admin.stats.events", h.StatsEvents)
+	d.Register("admin.sessions.rebuild", h.SessionsRebuild)
+	d.Register("admin.funnel.query", h.FunnelQuery)
+	d.Register("admin.cohort.query", h.CohortQuery)
+	d.Register("admin.paths.query", h.PathsQuery)
+	d.Register("admin.report.query", h.ReportQuery)
+	d.Register("admin.fraud.report", h.FraudReport)
+	d.Register("admin.webhook.create", h.WebhookCreate)
+	d.Register("admin.webhook.list", h.WebhookList)
+	d.Register("admin.webhook.update", h.WebhookUpdate)
+	d.Register("admin.webhook.delete", h.WebhookDelete)
+	d.Register("admin.webhook.deliveries", h.WebhookDeliveries)
+	d.Register("admin.webhook.replay", h.WebhookReplay)
+	d.Register("admin.alert.create", h.AlertCreate)
+	d.Register("admin.alert.list", h.AlertList)
+	d.Register("admin.alert.update", h.AlertUpdate)
+	d.Register("admin.alert.delete", h.AlertDelete)
+	d.Register("admin.alert.history", h.AlertHistory)
+	d.Register("admin.alert.evaluate", h.AlertEvaluate)
+	d.Register("admin.scheduledReport.create", h.ScheduledReportCreate)
+	d.Register("admin.scheduledReport.list", h.ScheduledReportList)
+	d.Register("admin.scheduledReport.update", h.ScheduledReportUpdate)
+	d.Register("admin.scheduledReport.delete", h.ScheduledReportDelete)
+	d.Register("admin.scheduledReport.sendNow", h.ScheduledReportSendNow)
+	d.Register("admin.scheduledReport.history", h.ScheduledReportHistory)
+	d.Register("admin.scheduledReport.download", h.ScheduledReportDownload)
+	d.Register("admin.export.create", h.ExportCreate)
+	d.Register("admin.export.status", h.ExportStatus)
+	d.Register("admin.export.list", h.ExportList)
+	d.Register("admin.import.run", h.ImportRun)
+	d.Register("admin.privacy.export", h.PrivacyExport)
+	d.Register("admin.privacy.erase", h.PrivacyErase)
+	d.Register("admin.privacy.log", h.PrivacyLog)
+	d.Register("admin.stream.ticket", h.StreamTicket)
 }
 
 // admin.login
@@ -99,9 +155,13 @@ func (h *AdminHandlers) TrackerCreate(_ context.Context, params json.RawMessage)
 		return nil, err
 	}
 	var p struct {
-		AdminToken string `json:"admin_token"`
-		Name       string `json:"name"`
-		Type       string `json:"type"`
+		AdminToken    string `json:"admin_token"`
+		Name          string `json:"name"`
+		Type          string `json:"type"`
+		IPMode        string `json:"ip_mode"`
+		DropUA        bool   `json:"drop_ua"`
+		Timezone      string `json:"timezone"`
+		AppendClickID bool   `json:"append_click_id"`
 	}
 	if err := json.Unmarshal(params, &p); err != nil {
 		return nil, NewRPCError(ErrCodeInvalidParams, err.Error())
@@ -109,10 +169,26 @@ func (h *AdminHandlers) TrackerCreate(_ context.Context, params json.RawMessage)
 	if p.Type != "ad" && p.Type != "web" {
 		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "type must be 'ad' or 'web'")
 	}
+	if !privacy.ValidIPMode(p.IPMode) {
+		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "ip_mode must be 'full', 'truncate' or 'hash'")
+	}
+	if p.Timezone != "" && !validTimezone(p.Timezone) {
+		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "timezone must be an IANA zone name")
+	}
+	if p.IPMode == "" {
+		p.IPMode = privacy.IPModeFull
+	}
+	if p.Timezone == "" {
+		p.Timezone = "UTC"
+	}
 	tracker := &models.Tracker{
-		Name:   p.Name,
-		Type:   p.Type,
-		Status: "active",
+		Name:          p.Name,
+		Type:          p.Type,
+		Status:        "active",
+		IPMode:        p.IPMode,
+		DropUA:        p.DropUA,
+		Timezone:      p.Timezone,
+		AppendClickID: p.AppendClickID,
 	}
 	if err := h.TrackerRepo.Create(tracker); err != nil {
 		return nil, NewRPCError(ErrCodeDBError, err.Error())
@@ -142,14 +218,24 @@ func (h *AdminHandlers) TrackerUpdate(_ context.Context, params json.RawMessage)
 		return nil, err
 	}
 	var p struct {
-		AdminToken string `json:"admin_token"`
-		ID         string `json:"id"`
-		Name       string `json:"name"`
-		Status     string `json:"status"`
+		AdminToken    string `json:"admin_token"`
+		ID            string `json:"id"`
+		Name          string `json:"name"`
+		Status        string `json:"status"`
+		IPMode        string `json:"ip_mode"`
+		DropUA        *bool  `json:"drop_ua"`
+		Timezone      string `json:"timezone"`
+		AppendClickID *bool  `json:"append_click_id"`
 	}
 	if err := json.Unmarshal(params, &p); err != nil {
 		return nil, NewRPCError(ErrCodeInvalidParams, err.Error())
 	}
+	if !privacy.ValidIPMode(p.IPMode) {
+		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "ip_mode must be 'full', 'truncate' or 'hash'")
+	}
+	if p.Timezone != "" && !validTimezone(p.Timezone) {
+		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "timezone must be an IANA zone name")
+	}
 	tracker, err := h.TrackerRepo.GetByID(p.ID)
 	if err != nil {
 		return nil, NewRPCError(ErrCodeDBError, "tracker not found")
@@ -160,6 +246,18 @@ func (h *AdminHandlers) TrackerUpdate(_ context.Context, params json.RawMessage)
 	if p.Status != "" {
 		tracker.Status = p.Status
 	}
+	if p.IPMode != "" {
+		tracker.IPMode = p.IPMode
+	}
+	if p.DropUA != nil {
+		tracker.DropUA = *p.DropUA
+	}
+	if p.Timezone != "" {
+		tracker.Timezone = p.Timezone
+	}
+	if p.AppendClickID != nil {
+		tracker.AppendClickID = *p.AppendClickID
+	}
 	if err := h.TrackerRepo.Update(tracker); err != nil {
 		return nil, NewRPCError(ErrCodeDBError, err.Error())
 	}
@@ -237,24 +335,30 @@ func (h *AdminHandlers) ChannelCreate(_ context.Context, params json.RawMessage)
 		return nil, err
 	}
 	var p struct {
-		AdminToken string     `json:"admin_token"`
-		TrackerID  string     `json:"tracker_id"`
-		CampaignID string     `json:"campaign_id"`
-		Name       string     `json:"name"`
-		Source     string     `json:"source"`
-		Medium     string     `json:"medium"`
-		Tags       models.JSONMap `json:"tags"`
+		AdminToken      string         `json:"admin_token"`
+		TrackerID       string         `json:"tracker_id"`
+		CampaignID      string         `json:"campaign_id"`
+		Name            string         `json:"name"`
+		Source          string         `json:"source"`
+		Medium          string         `json:"medium"`
+		Tags            models.JSONMap `json:"tags"`
+		TargetCountries []string       `json:"target_countries"`
 	}
 	if err := json.Unmarshal(params, &p); err != nil {
 		return nil, NewRPCError(ErrCodeInvalidParams, err.Error())
 	}
+	targets, rpcErr := normalizeCountries(p.TargetCountries)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
 	channel := &models.Channel{
-		TrackerID:  p.TrackerID,
-		CampaignID: p.CampaignID,
-		Name:       p.Name,
-		Source:     p.Source,
-		Medium:     p.Medium,
-		Tags:       p.Tags,
+		TrackerID:       p.TrackerID,
+		CampaignID:      p.CampaignID,
+		Name:            p.Name,
+		Source:          p.Source,
+		Medium:          p.Medium,
+		Tags:            p.Tags,
+		TargetCountries: targets,
 	}
 	if err := h.ChannelRepo.Create(channel); err != nil {
 		return nil, NewRPCError(ErrCodeDBError, err.Error())
@@ -273,6 +377,13 @@ func (h *AdminHandlers) ChannelBatchImport(_ context.Context, params json.RawMes
 	if err := json.Unmarshal(params, &p); err != nil {
 		return nil, NewRPCError(ErrCodeInvalidParams, err.Error())
 	}
+	for i := range p.Channels {
+		targets, rpcErr := normalizeCountries(p.Channels[i].TargetCountries)
+		if rpcErr != nil {
+			return nil, rpcErr
+		}
+		p.Channels[i].TargetCountries = targets
+	}
 	if err := h.ChannelRepo.BatchImport(p.Channels); err != nil {
 		return nil, NewRPCError(ErrCodeDBError, err.Error())
 	}
@@ -296,6 +407,53 @@ func (h *AdminHandlers) ChannelList(_ context.Context, params json.RawMessage) (
 	return channels, nil
 }
 
+// admin.channel.update
+func (h *AdminHandlers) ChannelUpdate(_ context.Context, params json.RawMessage) (any, *RPCError) {
+	if err := h.requireAuth(params); err != nil {
+		return nil, err
+	}
+	var p struct {
+		AdminToken      string         `json:"admin_token"`
+		ID              string         `json:"id"`
+		Name            string         `json:"name"`
+		Source          string         `json:"source"`
+		Medium          string         `json:"medium"`
+		Tags            models.JSONMap `json:"tags"`
+		TargetCountries *[]string      `json:"target_countries"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, NewRPCError(ErrCodeInvalidParams, err.Error())
+	}
+	channel, err := h.ChannelRepo.GetByID(p.ID)
+	if err != nil {
+		return nil, NewRPCError(ErrCodeDBError, "channel not found")
+	}
+	if p.Name != "" {
+		channel.Name = p.Name
+	}
+	if p.Source != "" {
+		channel.Source = p.Source
+	}
+	if p.Medium != "" {
+		channel.Medium = p.Medium
+	}
+	if p.Tags != nil {
+		channel.Tags = p.Tags
+	}
+	// An empty list clears the targets
+	if p.TargetCountries != nil {
+		targets, rpcErr := normalizeCountries(*p.TargetCountries)
+		if rpcErr != nil {
+			return nil, rpcErr
+		}
+		channel.TargetCountries = targets
+	}
+	if err := h.ChannelRepo.Update(channel); err != nil {
+		return nil, NewRPCError(ErrCodeDBError, err.Error())
+	}
+	return channel, nil
+}
+
 // admin.target.create
 func (h *AdminHandlers) TargetCreate(_ context.Context, params json.RawMessage) (any, *RPCError) {
 	if err := h.requireAuth(params); err != nil {
@@ -344,14 +502,32 @@ func (h *AdminHandlers) SiteCreate(_ context.Context, params json.RawMessage) (a
 		AdminToken string `json:"admin_token"`
 		Name       string `json:"name"`
 		Domain     string `json:"domain"`
+		IPMode     string `json:"ip_mode"`
+		DropUA     bool   `json:"drop_ua"`
+		Timezone   string `json:"timezone"`
 	}
 	if err := json.Unmarshal(params, &p); err != nil {
 		return nil, NewRPCError(ErrCodeInvalidParams, err.Error())
 	}
+	if !privacy.ValidIPMode(p.IPMode) {
+		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "ip_mode must be 'full', 'truncate' or 'hash'")
+	}
+	if p.Timezone != "" && !validTimezone(p.Timezone) {
+		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "timezone must be an IANA zone name")
+	}
+	if p.IPMode == "" {
+		p.IPMode = privacy.IPModeFull
+	}
+	if p.Timezone == "" {
+		p.Timezone = "UTC"
+	}
 	site := &models.Site{
-		Name:   p.Name,
-		Domain: p.Domain,
-		Status: "active",
+		Name:     p.Name,
+		Domain:   p.Domain,
+		Status:   "active",
+		IPMode:   p.IPMode,
+		DropUA:   p.DropUA,
+		Timezone: p.Timezone,
 	}
 	if err := h.SiteRepo.Create(site); err != nil {
 		return nil, NewRPCError(ErrCodeDBError, err.Error())
@@ -371,6 +547,58 @@ func (h *AdminHandlers) SiteList(_ context.Context, params json.RawMessage) (any
 	return sites, nil
 }
 
+// admin.site.update
+func (h *AdminHandlers) SiteUpdate(_ context.Context, params json.RawMessage) (any, *RPCError) {
+	if err := h.requireAuth(params); err != nil {
+		return nil, err
+	}
+	var p struct {
+		AdminToken string `json:"admin_token"`
+		ID         string `json:"id"`
+		Name       string `json:"name"`
+		Domain     string `json:"domain"`
+		Status     string `json:"status"`
+		IPMode     string `json:"ip_mode"`
+		DropUA     *bool  `json:"drop_ua"`
+		Timezone   string `json:"timezone"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, NewRPCError(ErrCodeInvalidParams, err.Error())
+	}
+	if !privacy.ValidIPMode(p.IPMode) {
+		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "ip_mode must be 'full', 'truncate' or 'hash'")
+	}
+	if p.Timezone != "" && !validTimezone(p.Timezone) {
+		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "timezone must be an IANA zone name")
+	}
+	site, err := h.SiteRepo.GetByID(p.ID)
+	if err != nil {
+		return nil, NewRPCError(ErrCodeDBError, "site not found")
+	}
+	if p.Name != "" {
+		site.Name = p.Name
+	}
+	if p.Domain != "" {
+		site.Domain = p.Domain
+	}
+	if p.Status != "" {
+		site.Status = p.Status
+	}
+	if p.IPMode != "" {
+		site.IPMode = p.IPMode
+	}
+	if p.DropUA != nil {
+		site.DropUA = *p.DropUA
+	}
+	if p.Timezone != "" {
+		site.Timezone = p.Timezone
+	}
+	if err := h.SiteRepo.Update(site); err != nil {
+		return nil, NewRPCError(ErrCodeDBError, err.Error())
+	}
+	return site, nil
+}
+
 // admin.token.generate — generates a short-code tracking token stored in DB
 func (h *AdminHandlers) TokenGenerate(_ context.Context, params json.RawMessage) (any, *RPCError) {
 	if err := h.requireAuth(params); err != nil {
@@ -383,6 +611,7 @@ func (h *AdminHandlers) TokenGenerate(_ context.Context, params json.RawMessage)
 		ChannelID  string `json:"channel_id"`
 		TargetID   string `json:"target_id"`
 		Mode       string `json:"mode"`
+		ExpSeconds int64  `json:"exp_seconds"` // 0 never expires
 	}
 	if err := json.Unmarshal(params, &p); err != nil {
 		return nil, NewRPCError(ErrCodeInvalidParams, err.Error())
@@ -390,6 +619,14 @@ func (h *AdminHandlers) TokenGenerate(_ context.Context, params json.RawMessage)
 	if p.Mode == "" {
 		p.Mode = "302"
 	}
+	if p.ExpSeconds < 0 {
+		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "exp_seconds must not be negative")
+	}
+	var expiresAt *time.Time
+	if p.ExpSeconds > 0 {
+		t := time.Now().Add(time.Duration(p.ExpSeconds) * time.Second)
+		expiresAt = &t
+	}
 
 	// Generate unique short code with collision retry
 	var shortCode string
@@ -415,6 +652,7 @@ func (h *AdminHandlers) TokenGenerate(_ context.Context, params json.RawMessage)
 		ChannelID:  p.ChannelID,
 		TargetID:   p.TargetID,
 		Mode:       p.Mode,
+		ExpiresAt:  expiresAt,
 	}
 	if err := h.TokenRepo.Create(token); err != nil {
 		return nil, NewRPCError(ErrCodeDBError, err.Error())
@@ -434,6 +672,7 @@ func (h *AdminHandlers) TokenGenerate(_ context.Context, params json.RawMessage)
 		"channel_id":   token.ChannelID,
 		"target_id":    token.TargetID,
 		"mode":         token.Mode,
+		"expires_at":   token.ExpiresAt,
 		"created_at":   token.CreatedAt,
 		"tracking_url": trackingURL,
 	}, nil
@@ -467,6 +706,7 @@ func (h *AdminHandlers) TokenList(_ context.Context, params json.RawMessage) (an
 			"channel_id":   t.ChannelID,
 			"target_id":    t.TargetID,
 			"mode":         t.Mode,
+			"expires_at":   t.ExpiresAt,
 			"created_at":   t.CreatedAt,
 			"tracking_url": fmt.Sprintf("%s/%s/%s", h.Config.ServiceConfiguration.ExportURL, prefix, t.ShortCode),
 		}
@@ -501,82 +741,157 @@ func safeDivide(numerator, denominator int64) float64 {
 	return math.Round(float64(numerator)/float64(denominator)*10000) / 100
 }
 
+// validTimezone reports whether name is an IANA zone known to both Go and
+// Postgres. "Local" is rejected since it means different zones to each.
+func validTimezone(name string) bool {
+	if name == "" || name == "Local" {
+		return false
+	}
+	_, err := time.LoadLocation(name)
+	return err == nil
+}
+
+// statsLocation resolves the zone used to interpret dates and bucket days:
+// the explicit timezone param, else the default of the tracker or site the
+// query is scoped to, else UTC.
+func (h *AdminHandlers) statsLocation(timezone, trackerID, siteID string) (*time.Location, *RPCError) {
+	if timezone == "" && trackerID != "" {
+		if tracker, err := h.TrackerRepo.GetByID(trackerID); err == nil {
+			timezone = tracker.Timezone
+		}
+	}
+	if timezone == "" && siteID != "" {
+		if site, err := h.SiteRepo.GetByID(siteID); err == nil {
+			timezone = site.Timezone
+		}
+	}
+	if timezone == "" {
+		return time.UTC, nil
+	}
+	if !validTimezone(timezone) {
+		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "timezone must be an IANA zone name")
+	}
+	loc, _ := time.LoadLocation(timezone)
+	return loc, nil
+}
+
+// parseDateRange parses inclusive YYYY-MM-DD bounds in loc into
+// [start of start day, end of end day].
+func parseDateRange(startDate, endDate string, loc *time.Location) (time.Time, time.Time, *RPCError) {
+	start, err := time.ParseInLocation("2006-01-02", startDate, loc)
+	if err != nil {
+		return time.Time{}, time.Time{}, NewRPCErrorWithMessage(ErrCodeInvalidParams, "invalid start_date, expected YYYY-MM-DD")
+	}
+	end, err := time.ParseInLocation("2006-01-02", endDate, loc)
+	if err != nil {
+		return time.Time{}, time.Time{}, NewRPCErrorWithMessage(ErrCodeInvalidParams, "invalid end_date, expected YYYY-MM-DD")
+	}
+	// AddDate rather than 24h, since days around DST changes are 23 or 25 hours.
+	return start, end.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+}
+
 // admin.stats.clicks
 func (h *AdminHandlers) StatsClicks(_ context.Context, params json.RawMessage) (any, *RPCError) {
 	if err := h.requireAuth(params); err != nil {
 		return nil, err
 	}
 	var p struct {
-		StartDate  string `json:"start_date"`
-		EndDate    string `json:"end_date"`
-		TrackerID  string `json:"tracker_id"`
-		CampaignID string `json:"campaign_id"`
-		ChannelID  string `json:"channel_id"`
+		StartDate  string         `json:"start_date"`
+		EndDate    string         `json:"end_date"`
+		TrackerID  string         `json:"tracker_id"`
+		CampaignID string         `json:"campaign_id"`
+		ChannelID  string         `json:"channel_id"`
+		Timezone   string         `json:"timezone"`
+		Compare    *compareParams `json:"compare"`
 	}
 	if err := json.Unmarshal(params, &p); err != nil {
 		return nil, NewRPCError(ErrCodeInvalidParams, err.Error())
 	}
-	start, err := time.Parse("2006-01-02", p.StartDate)
-	if err != nil {
-		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "invalid start_date, expected YYYY-MM-DD")
+	loc, rpcErr := h.statsLocation(p.Timezone, p.TrackerID, "")
+	if rpcErr != nil {
+		return nil, rpcErr
 	}
-	end, err := time.Parse("2006-01-02", p.EndDate)
-	if err != nil {
-		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "invalid end_date, expected YYYY-MM-DD")
+	start, end, rpcErr := parseDateRange(p.StartDate, p.EndDate, loc)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	tz := loc.String()
+	var cstart, cend time.Time
+	if p.Compare != nil {
+		if cstart, cend, rpcErr = compareRange(p.Compare, start, end, loc); rpcErr != nil {
+			return nil, rpcErr
+		}
 	}
-	end = end.Add(24*time.Hour - time.Nanosecond)
 
-	daily, err := h.ClickRepo.CountByDay(start, end, p.TrackerID, p.CampaignID, p.ChannelID)
+	result, rpcErr := h.clickStats(start, end, tz, p.TrackerID, p.CampaignID, p.ChannelID, 10)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	result["timezone"] = tz
+	if p.Compare != nil {
+		previous, rpcErr := h.clickStats(cstart, cend, tz, p.TrackerID, p.CampaignID, p.ChannelID, compareTopLimit)
+		if rpcErr != nil {
+			return nil, rpcErr
+		}
+		result["compare"] = buildComparison(result, previous, start, end, cstart, cend)
+	}
+	return result, nil
+}
+
+// clickStats computes the admin.stats.clicks payload for one date range,
+// with at most limit entries per top-N list.
+func (h *AdminHandlers) clickStats(start, end time.Time, tz, trackerID, campaignID, channelID string, limit int) (map[string]any, *RPCError) {
+	daily, err := h.ClickRepo.CountByDay(start, end, tz, trackerID, campaignID, channelID)
 	if err != nil {
 		return nil, NewRPCError(ErrCodeDBError, err.Error())
 	}
-	total, uniqueVisitors, bots, err := h.ClickRepo.Summary(start, end, p.TrackerID, p.CampaignID, p.ChannelID)
+	total, uniqueVisitors, bots, err := h.ClickRepo.Summary(start, end, trackerID, campaignID, channelID)
 	if err != nil {
 		return nil, NewRPCError(ErrCodeDBError, err.Error())
 	}
-	topTrackers, err := h.ClickRepo.TopByGroup(start, end, "tracker_id", 10)
+	topTrackers, err := h.ClickRepo.TopByGroup(start, end, "tracker_id", limit)
 	if err != nil {
 		return nil, NewRPCError(ErrCodeDBError, err.Error())
 	}
-	topChannels, err := h.ClickRepo.TopByGroup(start, end, "channel_id", 10)
+	topChannels, err := h.ClickRepo.TopByGroup(start, end, "channel_id", limit)
 	if err != nil {
 		return nil, NewRPCError(ErrCodeDBError, err.Error())
 	}
-	topCampaigns, err := h.ClickRepo.TopByGroup(start, end, "campaign_id", 10)
+	topCampaigns, err := h.ClickRepo.TopByGroup(start, end, "campaign_id", limit)
 	if err != nil {
 		return nil, NewRPCError(ErrCodeDBError, err.Error())
 	}
 
 	// New aggregations — graceful on failure
-	topReferrers, err := h.ClickRepo.TopReferrers(start, end, p.TrackerID, p.CampaignID, p.ChannelID, 10)
+	topReferrers, err := h.ClickRepo.TopReferrers(start, end, trackerID, campaignID, channelID, limit)
 	if err != nil {
 		log.Printf("StatsClicks: TopReferrers error: %v", err)
 		topReferrers = []repo.NameCount{}
 	}
-	rawUA, err := h.ClickRepo.RawUACounts(start, end, p.TrackerID, p.CampaignID, p.ChannelID)
+	rawUA, err := h.ClickRepo.RawUACounts(start, end, trackerID, campaignID, channelID)
 	var browsers, oses []repo.NameCount
 	if err != nil {
 		log.Printf("StatsClicks: RawUACounts error: %v", err)
 		browsers, oses = []repo.NameCount{}, []repo.NameCount{}
 	} else {
-		browsers, oses = repo.ParseUADistribution(rawUA, 10)
+		browsers, oses = repo.ParseUADistribution(rawUA, limit)
 	}
-	languages, err := h.ClickRepo.LanguageDistribution(start, end, p.TrackerID, p.CampaignID, p.ChannelID, 10)
+	languages, err := h.ClickRepo.LanguageDistribution(start, end, trackerID, campaignID, channelID, limit)
 	if err != nil {
 		log.Printf("StatsClicks: LanguageDistribution error: %v", err)
 		languages = []repo.NameCount{}
 	}
-	countries, err := h.ClickRepo.CountryDistribution(start, end, p.TrackerID, p.CampaignID, p.ChannelID, 10)
+	countries, err := h.ClickRepo.CountryDistribution(start, end, trackerID, campaignID, channelID, limit)
 	if err != nil {
 		log.Printf("StatsClicks: CountryDistribution error: %v", err)
 		countries = []repo.NameCount{}
 	}
-	botDaily, err := h.ClickRepo.BotCountByDay(start, end, p.TrackerID, p.CampaignID, p.ChannelID)
+	botDaily, err := h.ClickRepo.BotCountByDay(start, end, tz, trackerID, campaignID, channelID)
 	if err != nil {
 		log.Printf("StatsClicks: BotCountByDay error: %v", err)
 		botDaily = []repo.DailyCount{}
 	}
-	hourly, err := h.ClickRepo.CountByHour(start, end, p.TrackerID, p.CampaignID, p.ChannelID)
+	hourly, err := h.ClickRepo.CountByHour(start, end, tz, trackerID, campaignID, channelID)
 	if err != nil {
 		log.Printf("StatsClicks: CountByHour error: %v", err)
 		hourly = []repo.HourlyCount{}
@@ -589,17 +904,17 @@ func (h *AdminHandlers) StatsClicks(_ context.Context, params json.RawMessage) (
 			"bots":            bots,
 			"bot_rate":        safeDivide(bots, total),
 		},
-		"daily":          daily,
-		"top_trackers":   topTrackers,
-		"top_channels":   topChannels,
-		"top_campaigns":  topCampaigns,
-		"top_referrers":  topReferrers,
-		"browsers":       browsers,
-		"oses":           oses,
-		"languages":      languages,
-		"countries":      countries,
-		"bot_daily":      botDaily,
-		"hourly":         hourly,
+		"daily":         daily,
+		"top_trackers":  topTrackers,
+		"top_channels":  topChannels,
+		"top_campaigns": topCampaigns,
+		"top_referrers": topReferrers,
+		"browsers":      browsers,
+		"oses":          oses,
+		"languages":     languages,
+		"countries":     countries,
+		"bot_daily":     botDaily,
+		"hourly":        hourly,
 	}, nil
 }
 
@@ -609,98 +924,155 @@ func (h *AdminHandlers) StatsEvents(_ context.Context, params json.RawMessage) (
 		return nil, err
 	}
 	var p struct {
-		StartDate string `json:"start_date"`
-		EndDate   string `json:"end_date"`
-		SiteID    string `json:"site_id"`
+		StartDate string         `json:"start_date"`
+		EndDate   string         `json:"end_date"`
+		SiteID    string         `json:"site_id"`
+		Timezone  string         `json:"timezone"`
+		Compare   *compareParams `json:"compare"`
 	}
 	if err := json.Unmarshal(params, &p); err != nil {
 		return nil, NewRPCError(ErrCodeInvalidParams, err.Error())
 	}
-	start, err := time.Parse("2006-01-02", p.StartDate)
-	if err != nil {
-		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "invalid start_date, expected YYYY-MM-DD")
+	loc, rpcErr := h.statsLocation(p.Timezone, "", p.SiteID)
+	if rpcErr != nil {
+		return nil, rpcErr
 	}
-	end, err := time.Parse("2006-01-02", p.EndDate)
-	if err != nil {
-		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "invalid end_date, expected YYYY-MM-DD")
+	start, end, rpcErr := parseDateRange(p.StartDate, p.EndDate, loc)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	tz := loc.String()
+	var cstart, cend time.Time
+	if p.Compare != nil {
+		if cstart, cend, rpcErr = compareRange(p.Compare, start, end, loc); rpcErr != nil {
+			return nil, rpcErr
+		}
+	}
+
+	result, rpcErr := h.eventStats(start, end, tz, p.SiteID, 10)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	result["timezone"] = tz
+	if p.Compare != nil {
+		previous, rpcErr := h.eventStats(cstart, cend, tz, p.SiteID, compareTopLimit)
+		if rpcErr != nil {
+			return nil, rpcErr
+		}
+		result["compare"] = buildComparison(result, previous, start, end, cstart, cend)
 	}
-	end = end.Add(24*time.Hour - time.Nanosecond)
+	return result, nil
+}
 
-	daily, err := h.EventRepo.CountByDay(start, end, p.SiteID)
+// eventStats computes the admin.stats.events payload for one date range,
+// with at most limit entries per top-N list.
+func (h *AdminHandlers) eventStats(start, end time.Time, tz, siteID string, limit int) (map[string]any, *RPCError) {
+	daily, err := h.EventRepo.CountByDay(start, end, tz, siteID)
 	if err != nil {
 		return nil, NewRPCError(ErrCodeDBError, err.Error())
 	}
-	total, uniqueVisitors, uniqueSessions, bots, err := h.EventRepo.Summary(start, end, p.SiteID)
+	total, uniqueVisitors, uniqueSessions, bots, err := h.EventRepo.Summary(start, end, siteID)
 	if err != nil {
 		return nil, NewRPCError(ErrCodeDBError, err.Error())
 	}
-	topSites, err := h.EventRepo.TopByGroup(start, end, "site_id", 10)
+	topSites, err := h.EventRepo.TopByGroup(start, end, "site_id", limit)
 	if err != nil {
 		return nil, NewRPCError(ErrCodeDBError, err.Error())
 	}
-	topTypes, err := h.EventRepo.TopByGroup(start, end, "type", 10)
+	topTypes, err := h.EventRepo.TopByGroup(start, end, "type", limit)
 	if err != nil {
 		return nil, NewRPCError(ErrCodeDBError, err.Error())
 	}
 
 	// New aggregations — graceful on failure
-	topReferrers, err := h.EventRepo.TopReferrers(start, end, p.SiteID, 10)
+	topReferrers, err := h.EventRepo.TopReferrers(start, end, siteID, limit)
 	if err != nil {
 		log.Printf("StatsEvents: TopReferrers error: %v", err)
 		topReferrers = []repo.NameCount{}
 	}
-	topPages, err := h.EventRepo.TopPages(start, end, p.SiteID, 10)
+	topPages, err := h.EventRepo.TopPages(start, end, siteID, limit)
 	if err != nil {
 		log.Printf("StatsEvents: TopPages error: %v", err)
 		topPages = []repo.NameCount{}
 	}
-	rawUA, err := h.EventRepo.RawUACounts(start, end, p.SiteID)
+	rawUA, err := h.EventRepo.RawUACounts(start, end, siteID)
 	var browsers, oses []repo.NameCount
 	if err != nil {
 		log.Printf("StatsEvents: RawUACounts error: %v", err)
 		browsers, oses = []repo.NameCount{}, []repo.NameCount{}
 	} else {
-		browsers, oses = repo.ParseUADistribution(rawUA, 10)
+		browsers, oses = repo.ParseUADistribution(rawUA, limit)
 	}
-	languages, err := h.EventRepo.LanguageDistribution(start, end, p.SiteID, 10)
+	languages, err := h.EventRepo.LanguageDistribution(start, end, siteID, limit)
 	if err != nil {
 		log.Printf("StatsEvents: LanguageDistribution error: %v", err)
 		languages = []repo.NameCount{}
 	}
-	countries, err := h.EventRepo.CountryDistribution(start, end, p.SiteID, 10)
+	countries, err := h.EventRepo.CountryDistribution(start, end, siteID, limit)
 	if err != nil {
 		log.Printf("StatsEvents: CountryDistribution error: %v", err)
 		countries = []repo.NameCount{}
 	}
-	botDaily, err := h.EventRepo.BotCountByDay(start, end, p.SiteID)
+	botDaily, err := h.EventRepo.BotCountByDay(start, end, tz, siteID)
 	if err != nil {
 		log.Printf("StatsEvents: BotCountByDay error: %v", err)
 		botDaily = []repo.DailyCount{}
 	}
-	hourly, err := h.EventRepo.CountByHour(start, end, p.SiteID)
+	hourly, err := h.EventRepo.CountByHour(start, end, tz, siteID)
 	if err != nil {
 		log.Printf("StatsEvents: CountByHour error: %v", err)
 		hourly = []repo.HourlyCount{}
 	}
+	sessions, bounces, avgDuration, err := h.EventRepo.SessionSummary(start, end, siteID)
+	if err != nil {
+		log.Printf("StatsEvents: SessionSummary error: %v", err)
+	}
+	entryPages, err := h.EventRepo.TopEntryPages(start, end, siteID, limit)
+	if err != nil {
+		log.Printf("StatsEvents: TopEntryPages error: %v", err)
+		entryPages = []repo.NameCount{}
+	}
+	exitPages, err := h.EventRepo.TopExitPages(start, end, siteID, limit)
+	if err != nil {
+		log.Printf("StatsEvents: TopExitPages error: %v", err)
+		exitPages = []repo.NameCount{}
+	}
 
 	return map[string]any{
 		"summary": map[string]any{
-			"total":           total,
-			"unique_visitors": uniqueVisitors,
-			"unique_sessions": uniqueSessions,
-			"bots":            bots,
-			"bot_rate":        safeDivide(bots, total),
+			"total":                total,
+			"unique_visitors":      uniqueVisitors,
+			"unique_sessions":      uniqueSessions,
+			"bots":                 bots,
+			"bot_rate":             safeDivide(bots, total),
+			"sessions":             sessions,
+			"bounce_rate":          safeDivide(bounces, sessions),
+			"avg_session_duration": avgDuration,
 		},
-		"daily":          daily,
-		"top_sites":      topSites,
-		"top_types":      topTypes,
-		"top_referrers":  topReferrers,
-		"top_pages":      topPages,
-		"browsers":       browsers,
-		"oses":           oses,
-		"languages":      languages,
-		"countries":      countries,
-		"bot_daily":      botDaily,
-		"hourly":         hourly,
+		"daily":           daily,
+		"top_sites":       topSites,
+		"top_types":       topTypes,
+		"top_referrers":   topReferrers,
+		"top_pages":       topPages,
+		"top_entry_pages": entryPages,
+		"top_exit_pages":  exitPages,
+		"browsers":        browsers,
+		"oses":            oses,
+		"languages":       languages,
+		"countries":       countries,
+		"bot_daily":       botDaily,
+		"hourly":          hourly,
 	}, nil
 }
+
+// admin.stream.ticket — issues a single-use ticket for GET /admin/stream
+func (h *AdminHandlers) StreamTicket(ctx context.Context, params json.RawMessage) (any, *RPCError) {
+	if err := h.requireAuth(params); err != nil {
+		return nil, err
+	}
+	ticket, err := live.IssueTicket(ctx, h.Redis)
+	if err != nil {
+		return nil, NewRPCError(ErrCodeInternalError, err.Error())
+	}
+	return map[string]any{"ticket": ticket, "expires_in": int(live.TicketTTL / time.Second)}, nil
+}
@@ -0,0 +1,267 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tracking/analysis/internal/alert"
+	"github.com/tracking/analysis/internal/models"
+	"gorm.io/gorm"
+)
+
+type alertParams struct {
+	AdminToken    string   `json:"admin_token"`
+	ID            string   `json:"id"`
+	Name          string   `json:"name"`
+	Source        string   `json:"source"`
+	Metric        string   `json:"metric"`
+	TrackerID     *string  `json:"tracker_id"`
+	CampaignID    *string  `json:"campaign_id"`
+	ChannelID     *string  `json:"channel_id"`
+	SiteID        *string  `json:"site_id"`
+	Condition     string   `json:"condition"`
+	Threshold     *float64 `json:"threshold"`
+	WindowMinutes int      `json:"window_minutes"`
+	BaselineDays  int      `json:"baseline_days"`
+	Notify        []string `json:"notify"`
+	EmailTo       []string `json:"email_to"`
+	SlackURL      *string  `json:"slack_url"`
+	Active        *bool    `json:"active"`
+}
+
+func setIfPresent(dst *string, src *string) {
+	if src != nil {
+		*dst = *src
+	}
+}
+
+// apply copies the set fields of p onto rule and validates the result.
+func (p *alertParams) apply(rule *models.AlertRule) *RPCError {
+	if p.Name != "" {
+		rule.Name = p.Name
+	}
+	if p.Source != "" {
+		rule.Source = p.Source
+	}
+	if p.Metric != "" {
+		rule.Metric = p.Metric
+	}
+	setIfPresent(&rule.TrackerID, p.TrackerID)
+	setIfPresent(&rule.CampaignID, p.CampaignID)
+	setIfPresent(&rule.ChannelID, p.ChannelID)
+	setIfPresent(&rule.SiteID, p.SiteID)
+	if p.Condition != "" {
+		rule.Condition = p.Condition
+	}
+	if p.Threshold != nil {
+		rule.Threshold = *p.Threshold
+	}
+	if p.WindowMinutes != 0 {
+		rule.WindowMinutes = p.WindowMinutes
+	}
+	if p.BaselineDays != 0 {
+		rule.BaselineDays = p.BaselineDays
+	}
+	if p.Notify != nil {
+		rule.Notify = p.Notify
+	}
+	if p.EmailTo != nil {
+		rule.EmailTo = p.EmailTo
+	}
+	setIfPresent(&rule.SlackURL, p.SlackURL)
+	if p.Active != nil {
+		rule.Active = *p.Active
+	}
+	if rule.WindowMinutes == 0 {
+		rule.WindowMinutes = 60
+	}
+	if rule.BaselineDays == 0 {
+		rule.BaselineDays = 7
+	}
+
+	if rule.Name == "" {
+		return NewRPCErrorWithMessage(ErrCodeInvalidParams, "name required")
+	}
+	switch rule.Source {
+	case "clicks":
+		if rule.SiteID != "" {
+			return NewRPCErrorWithMessage(ErrCodeInvalidParams, "site_id applies to events rules only")
+		}
+	case "events":
+		if rule.TrackerID != "" || rule.CampaignID != "" || rule.ChannelID != "" {
+			return NewRPCErrorWithMessage(ErrCodeInvalidParams, "tracker_id, campaign_id and channel_id apply to clicks rules only")
+		}
+	default:
+		return NewRPCErrorWithMessage(ErrCodeInvalidParams, "source must be 'clicks' or 'events'")
+	}
+	if !alert.ValidMetric(rule.Source, rule.Metric) {
+		return NewRPCErrorWithMessage(ErrCodeInvalidParams, fmt.Sprintf("unsupported metric for %s: %s", rule.Source, rule.Metric))
+	}
+	switch rule.Condition {
+	case alert.ConditionAbove, alert.ConditionRisePct:
+	case alert.ConditionBelow:
+		// Values are never negative, so below 0 could never fire
+		if rule.Threshold == 0 {
+			return NewRPCErrorWithMessage(ErrCodeInvalidParams, "below threshold must be greater than 0; use below 1 to alert on no traffic")
+		}
+	case alert.ConditionDropPct:
+		if rule.Threshold > 100 {
+			return NewRPCErrorWithMessage(ErrCodeInvalidParams, "drop_pct threshold must be at most 100")
+		}
+	default:
+		return NewRPCErrorWithMessage(ErrCodeInvalidParams, "condition must be 'above', 'below', 'drop_pct' or 'rise_pct'")
+	}
+	if rule.Threshold < 0 {
+		return NewRPCErrorWithMessage(ErrCodeInvalidParams, "threshold must not be negative")
+	}
+	if rule.WindowMinutes < 5 || rule.WindowMinutes > 1440 {
+		return NewRPCErrorWithMessage(ErrCodeInvalidParams, "window_minutes must be between 5 and 1440")
+	}
+	if rule.BaselineDays < 1 || rule.BaselineDays > 28 {
+		return NewRPCErrorWithMessage(ErrCodeInvalidParams, "baseline_days must be between 1 and 28")
+	}
+	for _, n := range rule.Notify {
+		switch n {
+		case alert.NotifyWebhook:
+		case alert.NotifyEmail:
+			if len(rule.EmailTo) == 0 {
+				return NewRPCErrorWithMessage(ErrCodeInvalidParams, "email_to required for email notifications")
+			}
+		case alert.NotifySlack:
+			if !validWebhookURL(rule.SlackURL) {
+				return NewRPCErrorWithMessage(ErrCodeInvalidParams, "slack_url must be an absolute http or https URL")
+			}
+		default:
+			return NewRPCErrorWithMessage(ErrCodeInvalidParams, fmt.Sprintf("unsupported notify channel: %s", n))
+		}
+	}
+	return nil
+}
+
+// admin.alert.create — adds a threshold or baseline rule on a clicks or events summary metric
+func (h *AdminHandlers) AlertCreate(_ context.Context, params json.RawMessage) (any, *RPCError) {
+	if err := h.requireAuth(params); err != nil {
+		return nil, err
+	}
+	var p alertParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, NewRPCError(ErrCodeInvalidParams, err.Error())
+	}
+	rule := &models.AlertRule{Active: true, State: alert.StateOK}
+	if p.Threshold == nil {
+		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "threshold required")
+	}
+	if err := p.apply(rule); err != nil {
+		return nil, err
+	}
+	if err := h.AlertRepo.Create(rule); err != nil {
+		return nil, NewRPCError(ErrCodeDBError, err.Error())
+	}
+	return rule, nil
+}
+
+// admin.alert.list — rules with their current state and last evaluated value
+func (h *AdminHandlers) AlertList(_ context.Context, params json.RawMessage) (any, *RPCError) {
+	if err := h.requireAuth(params); err != nil {
+		return nil, err
+	}
+	rules, err := h.AlertRepo.List()
+	if err != nil {
+		return nil, NewRPCError(ErrCodeDBError, err.Error())
+	}
+	return rules, nil
+}
+
+// admin.alert.update
+func (h *AdminHandlers) AlertUpdate(_ context.Context, params json.RawMessage) (any, *RPCError) {
+	if err := h.requireAuth(params); err != nil {
+		return nil, err
+	}
+	var p alertParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, NewRPCError(ErrCodeInvalidParams, err.Error())
+	}
+	rule, err := h.AlertRepo.GetByID(p.ID)
+	if err != nil {
+		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "alert rule not found")
+	}
+	if rpcErr := p.apply(rule); rpcErr != nil {
+		return nil, rpcErr
+	}
+	if err := h.AlertRepo.Update(rule); err != nil {
+		return nil, NewRPCError(ErrCodeDBError, err.Error())
+	}
+	return rule, nil
+}
+
+// admin.alert.delete — removes a rule and its history
+func (h *AdminHandlers) AlertDelete(_ context.Context, params json.RawMessage) (any, *RPCError) {
+	if err := h.requireAuth(params); err != nil {
+		return nil, err
+	}
+	var p struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, NewRPCError(ErrCodeInvalidParams, err.Error())
+	}
+	if err := h.AlertRepo.Delete(p.ID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "alert rule not found")
+		}
+		return nil, NewRPCError(ErrCodeDBError, err.Error())
+	}
+	return map[string]bool{"ok": true}, nil
+}
+
+// admin.alert.history — firing and resolved events, newest first
+func (h *AdminHandlers) AlertHistory(_ context.Context, params json.RawMessage) (any, *RPCError) {
+	if err := h.requireAuth(params); err != nil {
+		return nil, err
+	}
+	var p struct {
+		RuleID string `json:"rule_id"`
+		Limit  int    `json:"limit"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, NewRPCError(ErrCodeInvalidParams, err.Error())
+	}
+	if p.Limit <= 0 || p.Limit > 500 {
+		p.Limit = 100
+	}
+	events, err := h.AlertRepo.ListEvents(p.RuleID, p.Limit)
+	if err != nil {
+		return nil, NewRPCError(ErrCodeDBError, err.Error())
+	}
+	return events, nil
+}
+
+// admin.alert.evaluate — measures a rule now without changing its state or notifying
+func (h *AdminHandlers) AlertEvaluate(_ context.Context, params json.RawMessage) (any, *RPCError) {
+	if err := h.requireAuth(params); err != nil {
+		return nil, err
+	}
+	var p struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, NewRPCError(ErrCodeInvalidParams, err.Error())
+	}
+	rule, err := h.AlertRepo.GetByID(p.ID)
+	if err != nil {
+		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "alert rule not found")
+	}
+	current, baseline, err := h.Alerts.Measure(rule, time.Now())
+	if err != nil {
+		return nil, NewRPCError(ErrCodeDBError, err.Error())
+	}
+	return map[string]any{
+		"value":    current,
+		"baseline": baseline,
+		"firing":   alert.Evaluate(rule, current, baseline),
+		"state":    rule.State,
+	}, nil
+}
@@ -0,0 +1,42 @@
+package rpc
+
+import (
+	"testing"
+
+	"github.com/tracking/analysis/internal/models"
+)
+
+func TestAlertParamsApply(t *testing.T) {
+	zero, one, thirty := 0.0, 1.0, 30.0
+	site := "site-1"
+	valid := alertParams{Name: "SDK down", Source: "events", Metric: "total", SiteID: &site, Condition: "below", Threshold: &one, WindowMinutes: 30}
+	rule := &models.AlertRule{Active: true}
+	if err := valid.apply(rule); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if rule.BaselineDays != 7 || rule.WindowMinutes != 30 {
+		t.Errorf("defaults not applied: %+v", rule)
+	}
+
+	tracker := "tr-1"
+	tests := []struct {
+		name string
+		p    alertParams
+	}{
+		{"unknown source", alertParams{Name: "x", Source: "sessions", Metric: "total", Condition: "above", Threshold: &thirty}},
+		{"metric for other source", alertParams{Name: "x", Source: "clicks", Metric: "unique_sessions", Condition: "above", Threshold: &thirty}},
+		{"tracker on events", alertParams{Name: "x", Source: "events", Metric: "total", TrackerID: &tracker, Condition: "above", Threshold: &thirty}},
+		{"site on clicks", alertParams{Name: "x", Source: "clicks", Metric: "total", SiteID: &site, Condition: "above", Threshold: &thirty}},
+		{"below zero never fires", alertParams{Name: "x", Source: "events", Metric: "total", Condition: "below", Threshold: &zero}},
+		{"unknown condition", alertParams{Name: "x", Source: "clicks", Metric: "bot_rate", Condition: "equals", Threshold: &thirty}},
+		{"window too short", alertParams{Name: "x", Source: "clicks", Metric: "bot_rate", Condition: "above", Threshold: &thirty, WindowMinutes: 1}},
+		{"email without recipients", alertParams{Name: "x", Source: "clicks", Metric: "bot_rate", Condition: "above", Threshold: &thirty, Notify: []string{"email"}}},
+		{"slack without url", alertParams{Name: "x", Source: "clicks", Metric: "bot_rate", Condition: "above", Threshold: &thirty, Notify: []string{"slack"}}},
+		{"unknown channel", alertParams{Name: "x", Source: "clicks", Metric: "bot_rate", Condition: "above", Threshold: &thirty, Notify: []string{"sms"}}},
+	}
+	for _, tt := range tests {
+		if err := tt.p.apply(&models.AlertRule{}); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
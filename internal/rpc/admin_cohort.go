@@ -0,0 +1,81 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/tracking/analysis/internal/repo"
+)
+
+// maxCohortPeriods caps the retention matrix width per unit.
+var maxCohortPeriods = map[string]int{"day": 90, "week": 52}
+
+// admin.cohort.query — retention matrix of visitors grouped by first-seen day or week
+func (h *AdminHandlers) CohortQuery(_ context.Context, params json.RawMessage) (any, *RPCError) {
+	if err := h.requireAuth(params); err != nil {
+		return nil, err
+	}
+	var p struct {
+		StartDate   string `json:"start_date"`
+		EndDate     string `json:"end_date"`
+		SiteID      string `json:"site_id"`
+		Unit        string `json:"unit"`
+		Periods     int    `json:"periods"`
+		FirstEvent  string `json:"first_event"`
+		ReturnEvent string `json:"return_event"`
+		ChannelID   string `json:"channel_id"`
+		Timezone    string `json:"timezone"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, NewRPCError(ErrCodeInvalidParams, err.Error())
+	}
+	loc, rpcErr := h.statsLocation(p.Timezone, "", p.SiteID)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	start, end, rpcErr := parseDateRange(p.StartDate, p.EndDate, loc)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	if p.Unit == "" {
+		p.Unit = "week"
+	}
+	maxPeriods, ok := maxCohortPeriods[p.Unit]
+	if !ok {
+		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "unit must be 'day' or 'week'")
+	}
+	if p.Periods == 0 {
+		p.Periods = 8
+	}
+	if p.Periods < 1 || p.Periods > maxPeriods {
+		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "periods out of range")
+	}
+
+	q := repo.CohortQuery{
+		Start:       start,
+		End:         end,
+		SiteID:      p.SiteID,
+		Unit:        p.Unit,
+		Periods:     p.Periods,
+		FirstEvent:  p.FirstEvent,
+		ReturnEvent: p.ReturnEvent,
+		Timezone:    loc.String(),
+	}
+	if p.ChannelID != "" {
+		ch, err := h.ChannelRepo.GetByID(p.ChannelID)
+		if err != nil {
+			return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "channel not found")
+		}
+		q.Channel = &repo.ChannelMatch{ID: ch.ID, Source: ch.Source, Medium: ch.Medium}
+	}
+
+	cohorts, err := h.EventRepo.Cohort(q)
+	if err != nil {
+		return nil, NewRPCError(ErrCodeDBError, err.Error())
+	}
+	return map[string]any{
+		"unit":    p.Unit,
+		"periods": p.Periods,
+		"cohorts": cohorts,
+	}, nil
+}
@@ -0,0 +1,140 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/tracking/analysis/internal/export"
+	"github.com/tracking/analysis/internal/models"
+)
+
+// exportView is an export job with a fresh download URL once it is done.
+type exportView struct {
+	*models.ExportJob
+	DownloadURL  string     `json:"download_url,omitempty"`
+	URLExpiresAt *time.Time `json:"url_expires_at,omitempty"`
+}
+
+func (h *AdminHandlers) exportView(job *models.ExportJob) exportView {
+	v := exportView{ExportJob: job}
+	if job.Status == export.StatusDone {
+		u, exp := h.Exports.DownloadURL(job.ID, time.Now())
+		v.DownloadURL, v.URLExpiresAt = u, &exp
+	}
+	return v
+}
+
+// admin.export.create — queues a bulk export of raw clicks or events to CSV, NDJSON or Parquet
+func (h *AdminHandlers) ExportCreate(_ context.Context, params json.RawMessage) (any, *RPCError) {
+	if err := h.requireAuth(params); err != nil {
+		return nil, err
+	}
+	var p struct {
+		Source      string `json:"source"`
+		Format      string `json:"format"`
+		StartDate   string `json:"start_date"`
+		EndDate     string `json:"end_date"`
+		Timezone    string `json:"timezone"`
+		TrackerID   string `json:"tracker_id"`
+		CampaignID  string `json:"campaign_id"`
+		ChannelID   string `json:"channel_id"`
+		SiteID      string `json:"site_id"`
+		EventType   string `json:"event_type"`
+		IncludeBots bool   `json:"include_bots"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, NewRPCError(ErrCodeInvalidParams, err.Error())
+	}
+	switch p.Source {
+	case "clicks":
+		if p.SiteID != "" || p.EventType != "" {
+			return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "site_id and event_type apply to events exports only")
+		}
+	case "events":
+		if p.TrackerID != "" || p.CampaignID != "" || p.ChannelID != "" {
+			return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "tracker_id, campaign_id and channel_id apply to clicks exports only")
+		}
+	default:
+		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "source must be 'clicks' or 'events'")
+	}
+	if p.Format == "" {
+		p.Format = export.FormatCSV
+	}
+	if _, ok := export.ContentTypes[p.Format]; !ok {
+		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "format must be 'csv', 'ndjson' or 'parquet'")
+	}
+	loc, rpcErr := h.statsLocation(p.Timezone, p.TrackerID, p.SiteID)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	start, end, rpcErr := parseDateRange(p.StartDate, p.EndDate, loc)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	if end.Before(start) {
+		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "end_date must not be before start_date")
+	}
+
+	job := &models.ExportJob{
+		Source:      p.Source,
+		Format:      p.Format,
+		StartDate:   p.StartDate,
+		EndDate:     p.EndDate,
+		Timezone:    loc.String(),
+		TrackerID:   p.TrackerID,
+		CampaignID:  p.CampaignID,
+		ChannelID:   p.ChannelID,
+		SiteID:      p.SiteID,
+		EventType:   p.EventType,
+		IncludeBots: p.IncludeBots,
+		Status:      export.StatusQueued,
+	}
+	if err := h.ExportRepo.Create(job); err != nil {
+		return nil, NewRPCError(ErrCodeDBError, err.Error())
+	}
+	return job, nil
+}
+
+// admin.export.status — a job's progress, with a signed download URL once done
+func (h *AdminHandlers) ExportStatus(_ context.Context, params json.RawMessage) (any, *RPCError) {
+	if err := h.requireAuth(params); err != nil {
+		return nil, err
+	}
+	var p struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, NewRPCError(ErrCodeInvalidParams, err.Error())
+	}
+	job, err := h.ExportRepo.GetByID(p.ID)
+	if err != nil {
+		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "export not found")
+	}
+	return h.exportView(job), nil
+}
+
+// admin.export.list — recent jobs, newest first
+func (h *AdminHandlers) ExportList(_ context.Context, params json.RawMessage) (any, *RPCError) {
+	if err := h.requireAuth(params); err != nil {
+		return nil, err
+	}
+	var p struct {
+		Limit int `json:"limit"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, NewRPCError(ErrCodeInvalidParams, err.Error())
+	}
+	if p.Limit <= 0 || p.Limit > 500 {
+		p.Limit = 100
+	}
+	jobs, err := h.ExportRepo.List(p.Limit)
+	if err != nil {
+		return nil, NewRPCError(ErrCodeDBError, err.Error())
+	}
+	views := make([]exportView, len(jobs))
+	for i := range jobs {
+		views[i] = h.exportView(&jobs[i])
+	}
+	return views, nil
+}
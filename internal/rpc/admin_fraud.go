@@ -0,0 +1,263 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tracking/analysis/internal/models"
+	"github.com/tracking/analysis/internal/repo"
+)
+
+// Fraud report thresholds, in percent of a row's clicks. Rows with fewer
+// than fraudMinClicks clicks are never flagged.
+const (
+	fraudMinClicks         = 20
+	fraudDuplicateRate     = 20 // duplicate_clicks
+	fraudBeaconMismatch    = 50 // no_beacon
+	fraudTopIPShare        = 10 // ip_concentration
+	fraudTopSubnetShare    = 25 // ip_concentration
+	fraudTopUAShare        = 50 // low_ua_diversity
+	fraudOffTargetRate     = 20 // off_target
+	fraudFastRepeatRate    = 20 // fast_repeats
+	fraudFastRepeatSeconds = 10
+	fraudTopSubnets        = 5
+	defaultBeaconWindow    = 30 // minutes
+	maxBeaconWindow        = 24 * 60
+)
+
+// timeToClickBuckets name the repeat gap buckets of repo.RepeatGapBounds.
+var timeToClickBuckets = []string{"under_1s", "1s_10s", "10s_1m", "1m_10m", "10m_1h", "over_1h"}
+
+// fraudRow is one channel or campaign of admin.fraud.report. Rates and
+// shares are percentages.
+type fraudRow struct {
+	CampaignID           string           `json:"campaign_id"`
+	CampaignName         string           `json:"campaign_name"`
+	ChannelID            string           `json:"channel_id,omitempty"`
+	ChannelName          string           `json:"channel_name,omitempty"`
+	Clicks               int64            `json:"clicks"`
+	Bots                 int64            `json:"bots"`
+	BotRate              float64          `json:"bot_rate"`
+	DedupHits            int64            `json:"dedup_hits"`
+	DuplicateRate        float64          `json:"duplicate_rate"`
+	RedirectClicks       int64            `json:"redirect_clicks"`
+	UnconfirmedRedirects int64            `json:"unconfirmed_redirects"`
+	BeaconMismatchRate   float64          `json:"beacon_mismatch_rate"`
+	UniqueIPs            int64            `json:"unique_ips"`
+	TopIPShare           float64          `json:"top_ip_share"`
+	UniqueSubnets        int64            `json:"unique_subnets"`
+	TopSubnetShare       float64          `json:"top_subnet_share"`
+	TopSubnets           []repo.NameCount `json:"top_subnets"`
+	UniqueUAs            int64            `json:"unique_uas"`
+	TopUAShare           float64          `json:"top_ua_share"`
+	UAEntropy            float64          `json:"ua_entropy"`
+	TargetCountries      []string         `json:"target_countries"`
+	OffTargetClicks      int64            `json:"off_target_clicks"`
+	OffTargetRate        float64          `json:"off_target_rate"`
+	UnknownCountryClicks int64            `json:"unknown_country_clicks"`
+	TimeToClick          map[string]int64 `json:"time_to_click"`
+	FastRepeatRate       float64          `json:"fast_repeat_rate"`
+	Flags                []string         `json:"flags"`
+}
+
+// admin.fraud.report — click fraud indicators per channel and per campaign
+func (h *AdminHandlers) FraudReport(_ context.Context, params json.RawMessage) (any, *RPCError) {
+	if err := h.requireAuth(params); err != nil {
+		return nil, err
+	}
+	var p struct {
+		StartDate           string `json:"start_date"`
+		EndDate             string `json:"end_date"`
+		TrackerID           string `json:"tracker_id"`
+		CampaignID          string `json:"campaign_id"`
+		ChannelID           string `json:"channel_id"`
+		Timezone            string `json:"timezone"`
+		BeaconWindowMinutes int    `json:"beacon_window_minutes"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, NewRPCError(ErrCodeInvalidParams, err.Error())
+	}
+	if p.BeaconWindowMinutes == 0 {
+		p.BeaconWindowMinutes = defaultBeaconWindow
+	}
+	if p.BeaconWindowMinutes < 1 || p.BeaconWindowMinutes > maxBeaconWindow {
+		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "beacon_window_minutes must be between 1 and 1440")
+	}
+	loc, rpcErr := h.statsLocation(p.Timezone, p.TrackerID, "")
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	start, end, rpcErr := parseDateRange(p.StartDate, p.EndDate, loc)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+
+	channelCounts, campaignCounts, err := h.FraudRepo.Counts(repo.FraudQuery{
+		Start:        start,
+		End:          end,
+		TrackerID:    p.TrackerID,
+		CampaignID:   p.CampaignID,
+		ChannelID:    p.ChannelID,
+		BeaconWindow: time.Duration(p.BeaconWindowMinutes) * time.Minute,
+		Top:          fraudTopSubnets,
+	})
+	if err != nil {
+		return nil, NewRPCError(ErrCodeDBError, err.Error())
+	}
+	channels, err := h.ChannelRepo.List(p.TrackerID, p.CampaignID)
+	if err != nil {
+		return nil, NewRPCError(ErrCodeDBError, err.Error())
+	}
+	campaigns, err := h.CampaignRepo.List(p.TrackerID)
+	if err != nil {
+		return nil, NewRPCError(ErrCodeDBError, err.Error())
+	}
+	campaignNames := make(map[string]string, len(campaigns))
+	for _, c := range campaigns {
+		campaignNames[c.ID] = c.Name
+	}
+
+	channelRows, campaignRows := buildFraudReport(channelCounts, campaignCounts, channels, campaignNames)
+	return map[string]any{
+		"timezone":              loc.String(),
+		"beacon_window_minutes": p.BeaconWindowMinutes,
+		"channels":              channelRows,
+		"campaigns":             campaignRows,
+	}, nil
+}
+
+// buildFraudReport turns per-channel and per-campaign counts into report
+// rows. A campaign's off-target clicks are summed from its channels, each
+// measured against its own target countries. Rows are ordered by clicks,
+// most first.
+func buildFraudReport(channelCounts, campaignCounts []repo.FraudCounts, channels []models.Channel, campaignNames map[string]string) (channelRows, campaignRows []fraudRow) {
+	byID := make(map[string]models.Channel, len(channels))
+	for _, ch := range channels {
+		byID[ch.ID] = ch
+	}
+
+	offTarget := map[string]int64{}
+	for _, c := range channelCounts {
+		ch := byID[c.ChannelID]
+		row := fraudRowOf(c, []string(ch.TargetCountries))
+		row.CampaignName, row.ChannelName = campaignNames[c.CampaignID], ch.Name
+		channelRows = append(channelRows, row)
+		offTarget[c.CampaignID] += row.OffTargetClicks
+	}
+	for _, c := range campaignCounts {
+		row := fraudRowOf(c, nil)
+		row.CampaignName = campaignNames[c.CampaignID]
+		row.OffTargetClicks = offTarget[c.CampaignID]
+		row.OffTargetRate = safeDivide(row.OffTargetClicks, row.Clicks-row.UnknownCountryClicks)
+		row.Flags = fraudFlags(row)
+		campaignRows = append(campaignRows, row)
+	}
+
+	byClicks := func(rows []fraudRow) {
+		sort.SliceStable(rows, func(i, j int) bool { return rows[i].Clicks > rows[j].Clicks })
+	}
+	byClicks(channelRows)
+	byClicks(campaignRows)
+	return channelRows, campaignRows
+}
+
+// fraudRowOf computes the indicators of c. Clicks from countries outside
+// targets are off-target; an empty targets list means untargeted. Clicks
+// GeoIP could not place are counted apart and left out of the off-target
+// rate, so a missing or stale database does not look like fraud.
+func fraudRowOf(c repo.FraudCounts, targets []string) fraudRow {
+	row := fraudRow{
+		CampaignID:           c.CampaignID,
+		ChannelID:            c.ChannelID,
+		Clicks:               c.Clicks,
+		Bots:                 c.Bots,
+		BotRate:              safeDivide(c.Bots, c.Clicks),
+		DedupHits:            c.DedupHits,
+		RedirectClicks:       c.Redirects,
+		UnconfirmedRedirects: c.UnconfirmedRedirects,
+		BeaconMismatchRate:   safeDivide(c.UnconfirmedRedirects, c.Redirects),
+		TargetCountries:      append([]string{}, targets...),
+	}
+	// Duplicates are only detected on JS clicks, so they are weighed
+	// against the JS clicks that were attempted
+	row.DuplicateRate = safeDivide(c.DedupHits, c.Clicks-c.Redirects+c.DedupHits)
+
+	row.UniqueIPs = c.IPs.Unique
+	row.TopIPShare = safeDivide(c.IPs.TopCount(), c.Clicks)
+	row.UniqueSubnets = c.Subnets.Unique
+	row.TopSubnetShare = safeDivide(c.Subnets.TopCount(), c.Clicks)
+	row.TopSubnets = append([]repo.NameCount{}, c.Subnets.Top...)
+
+	row.UniqueUAs = c.UAs.Unique
+	row.TopUAShare = safeDivide(c.UAs.TopCount(), c.Clicks)
+	row.UAEntropy = round2(c.UAs.Entropy)
+
+	row.UnknownCountryClicks = c.Countries[""]
+	if len(targets) > 0 {
+		for country, n := range c.Countries {
+			if country != "" && !models.StringList(targets).Contains(country) {
+				row.OffTargetClicks += n
+			}
+		}
+		row.OffTargetRate = safeDivide(row.OffTargetClicks, c.Clicks-row.UnknownCountryClicks)
+	}
+
+	row.TimeToClick = map[string]int64{"first": c.FirstClicks}
+	var fast int64
+	for i, n := range c.Gaps {
+		row.TimeToClick[timeToClickBuckets[i]] = n
+		if i < len(repo.RepeatGapBounds) && repo.RepeatGapBounds[i] <= fraudFastRepeatSeconds {
+			fast += n
+		}
+	}
+	row.FastRepeatRate = safeDivide(fast, c.Clicks)
+	row.Flags = fraudFlags(row)
+	return row
+}
+
+// fraudFlags names the indicators of row above their thresholds.
+func fraudFlags(row fraudRow) []string {
+	flags := []string{}
+	if row.Clicks < fraudMinClicks {
+		return flags
+	}
+	if row.DuplicateRate >= fraudDuplicateRate {
+		flags = append(flags, "duplicate_clicks")
+	}
+	if row.RedirectClicks >= fraudMinClicks && row.BeaconMismatchRate >= fraudBeaconMismatch {
+		flags = append(flags, "no_beacon")
+	}
+	if row.TopIPShare >= fraudTopIPShare || row.TopSubnetShare >= fraudTopSubnetShare {
+		flags = append(flags, "ip_concentration")
+	}
+	if row.TopUAShare >= fraudTopUAShare {
+		flags = append(flags, "low_ua_diversity")
+	}
+	if row.OffTargetRate >= fraudOffTargetRate {
+		flags = append(flags, "off_target")
+	}
+	if row.FastRepeatRate >= fraudFastRepeatRate {
+		flags = append(flags, "fast_repeats")
+	}
+	return flags
+}
+
+// normalizeCountries upper-cases a channel's target countries and checks
+// they are ISO 3166 alpha-2 codes.
+func normalizeCountries(countries []string) (models.StringList, *RPCError) {
+	var out models.StringList
+	for _, c := range countries {
+		c = strings.ToUpper(strings.TrimSpace(c))
+		if len(c) != 2 || c[0] < 'A' || c[0] > 'Z' || c[1] < 'A' || c[1] > 'Z' {
+			return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, fmt.Sprintf("invalid target country %q", c))
+		}
+		if !out.Contains(c) {
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,132 @@
+package rpc
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/tracking/analysis/internal/models"
+	"github.com/tracking/analysis/internal/repo"
+)
+
+func fraudCounts(campaign, channel string, clicks int64) repo.FraudCounts {
+	return repo.FraudCounts{
+		CampaignID: campaign,
+		ChannelID:  channel,
+		Clicks:     clicks,
+		Countries:  map[string]int64{},
+		Gaps:       make([]int64, len(repo.RepeatGapBounds)+1),
+	}
+}
+
+func TestBuildFraudReport(t *testing.T) {
+	// A farm: 100 clicks from one /24, one UA, mostly abroad, fast repeats
+	farm := fraudCounts("camp", "farm", 100)
+	farm.Bots = 10
+	farm.Redirects, farm.UnconfirmedRedirects = 40, 30
+	farm.DedupHits = 20
+	farm.IPs = repo.Distribution{Unique: 10, Entropy: 3.32, Top: []repo.NameCount{{Name: "203.0.113.0", Count: 10}}}
+	farm.Subnets = repo.Distribution{Unique: 1, Top: []repo.NameCount{{Name: "203.0.113.0/24", Count: 100}}}
+	farm.UAs = repo.Distribution{Unique: 1, Top: []repo.NameCount{{Name: "curl/8.0", Count: 100}}}
+	farm.Countries["DE"], farm.Countries["VN"] = 30, 70
+	farm.FirstClicks, farm.Gaps[0], farm.Gaps[1], farm.Gaps[5] = 10, 50, 30, 10
+
+	// Organic traffic: spread out, varied, on target
+	organic := fraudCounts("camp", "organic", 40)
+	organic.Redirects = 20
+	organic.IPs = repo.Distribution{Unique: 40, Entropy: 5.32, Top: []repo.NameCount{{Name: "2001:db8::1", Count: 1}}}
+	organic.Subnets = repo.Distribution{Unique: 40, Entropy: 5.32, Top: []repo.NameCount{{Name: "2001:db8::/48", Count: 1}}}
+	organic.UAs = repo.Distribution{Unique: 8, Entropy: 3, Top: []repo.NameCount{{Name: "ua 0", Count: 5}}}
+	organic.Countries["DE"], organic.Countries[""] = 30, 10
+	organic.FirstClicks = 40
+
+	// The campaign's own counts, as the repo groups them
+	camp := fraudCounts("camp", "", 140)
+	camp.Bots, camp.DedupHits = 10, 20
+	camp.Redirects, camp.UnconfirmedRedirects = 60, 30
+	camp.IPs = repo.Distribution{Unique: 50, Top: []repo.NameCount{{Name: "203.0.113.0", Count: 10}}}
+	camp.Subnets = repo.Distribution{Unique: 41, Top: []repo.NameCount{{Name: "203.0.113.0/24", Count: 100}}}
+	camp.UAs = repo.Distribution{Unique: 9, Top: []repo.NameCount{{Name: "curl/8.0", Count: 100}}}
+	camp.Countries["DE"], camp.Countries["VN"], camp.Countries[""] = 60, 70, 10
+	camp.FirstClicks = 50
+
+	channels := []models.Channel{
+		{ID: "farm", Name: "Farm", TargetCountries: models.StringList{"DE"}},
+		{ID: "organic", Name: "Organic", TargetCountries: models.StringList{"DE", "AT"}},
+	}
+	chRows, campRows := buildFraudReport([]repo.FraudCounts{organic, farm}, []repo.FraudCounts{camp}, channels, map[string]string{"camp": "Spring"})
+
+	if len(chRows) != 2 || chRows[0].ChannelID != "farm" {
+		t.Fatalf("channel rows = %+v", chRows)
+	}
+	f := chRows[0]
+	checks := []struct {
+		name      string
+		got, want float64
+	}{
+		{"bot_rate", f.BotRate, 10},
+		{"duplicate_rate", f.DuplicateRate, 25}, // 20 / (100 - 40 + 20)
+		{"beacon_mismatch_rate", f.BeaconMismatchRate, 75},
+		{"top_ip_share", f.TopIPShare, 10},
+		{"top_subnet_share", f.TopSubnetShare, 100},
+		{"top_ua_share", f.TopUAShare, 100},
+		{"ua_entropy", f.UAEntropy, 0},
+		{"off_target_rate", f.OffTargetRate, 70},
+		{"fast_repeat_rate", f.FastRepeatRate, 80},
+	}
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("farm %s = %v, want %v", c.name, c.got, c.want)
+		}
+	}
+	if f.UniqueSubnets != 1 || len(f.TopSubnets) != 1 || f.TopSubnets[0].Name != "203.0.113.0/24" {
+		t.Errorf("farm subnets = %d %+v", f.UniqueSubnets, f.TopSubnets)
+	}
+	if f.TimeToClick["first"] != 10 || f.TimeToClick["under_1s"] != 50 || f.TimeToClick["over_1h"] != 10 {
+		t.Errorf("farm time_to_click = %v", f.TimeToClick)
+	}
+	wantFlags := []string{"duplicate_clicks", "no_beacon", "ip_concentration", "low_ua_diversity", "off_target", "fast_repeats"}
+	if !slices.Equal(f.Flags, wantFlags) {
+		t.Errorf("farm flags = %v, want %v", f.Flags, wantFlags)
+	}
+
+	o := chRows[1]
+	if len(o.Flags) != 0 || o.UAEntropy != 3 || o.UniqueSubnets != 40 || o.OffTargetClicks != 0 || o.UnknownCountryClicks != 10 {
+		t.Errorf("organic row = %+v", o)
+	}
+
+	if len(campRows) != 1 {
+		t.Fatalf("campaign rows = %+v", campRows)
+	}
+	c := campRows[0]
+	if c.CampaignName != "Spring" || c.Clicks != 140 || c.DedupHits != 20 || c.UnconfirmedRedirects != 30 {
+		t.Errorf("campaign totals = %+v", c)
+	}
+	// Off-target clicks are judged against each channel's own targets
+	if c.OffTargetClicks != 70 || c.UnknownCountryClicks != 10 || c.OffTargetRate != 53.85 {
+		t.Errorf("campaign off-target = %d (%v%%)", c.OffTargetClicks, c.OffTargetRate)
+	}
+	if c.UniqueIPs != 50 || c.TimeToClick["first"] != 50 || !slices.Equal(c.TargetCountries, []string{}) {
+		t.Errorf("campaign row = %+v", c)
+	}
+}
+
+func TestFraudFlags_MinClicks(t *testing.T) {
+	small := fraudCounts("camp", "ch", fraudMinClicks-1)
+	small.IPs = repo.Distribution{Unique: 1, Top: []repo.NameCount{{Name: "198.51.100.7", Count: fraudMinClicks - 1}}}
+	row := fraudRowOf(small, nil)
+	if row.TopIPShare != 100 || len(row.Flags) != 0 {
+		t.Errorf("row = %+v", row)
+	}
+}
+
+func TestNormalizeCountries(t *testing.T) {
+	got, err := normalizeCountries([]string{"de", " AT ", "DE"})
+	if err != nil || !slices.Equal(got, models.StringList{"DE", "AT"}) {
+		t.Errorf("normalizeCountries = %v, %v", got, err)
+	}
+	for _, bad := range []string{"DEU", "D1", ""} {
+		if _, err := normalizeCountries([]string{bad}); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/tracking/analysis/internal/repo"
+)
+
+const (
+	maxFunnelSteps      = 10
+	defaultFunnelWindow = 24 * time.Hour
+	maxFunnelWindow     = 90 * 24 * time.Hour
+)
+
+// admin.funnel.query — ordered step conversion, drop-off and time between steps
+func (h *AdminHandlers) FunnelQuery(_ context.Context, params json.RawMessage) (any, *RPCError) {
+	if err := h.requireAuth(params); err != nil {
+		return nil, err
+	}
+	var p struct {
+		StartDate     string            `json:"start_date"`
+		EndDate       string            `json:"end_date"`
+		SiteID        string            `json:"site_id"`
+		Steps         []repo.FunnelStep `json:"steps"`
+		WindowSeconds int64             `json:"window_seconds"`
+		By            string            `json:"by"`
+		Breakdown     string            `json:"breakdown"`
+		Timezone      string            `json:"timezone"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, NewRPCError(ErrCodeInvalidParams, err.Error())
+	}
+	loc, rpcErr := h.statsLocation(p.Timezone, "", p.SiteID)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	start, end, rpcErr := parseDateRange(p.StartDate, p.EndDate, loc)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	if len(p.Steps) < 2 || len(p.Steps) > maxFunnelSteps {
+		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "steps must contain 2 to 10 entries")
+	}
+	for _, s := range p.Steps {
+		if s.Type == "" {
+			return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "every step requires a type")
+		}
+	}
+	window := defaultFunnelWindow
+	if p.WindowSeconds != 0 {
+		window = time.Duration(p.WindowSeconds) * time.Second
+	}
+	if window <= 0 || window > maxFunnelWindow {
+		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "window_seconds must be between 1 and 7776000")
+	}
+	if p.By == "" {
+		p.By = "visitor"
+	}
+	if p.By != "visitor" && p.By != "session" {
+		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "by must be 'visitor' or 'session'")
+	}
+	switch p.Breakdown {
+	case "", "country", "browser", "referrer":
+	default:
+		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "breakdown must be 'country', 'browser' or 'referrer'")
+	}
+
+	f := &repo.Funnel{Steps: p.Steps, Window: window, Breakdown: p.Breakdown, BreakdownLimit: 20}
+	steps, breakdowns, err := h.EventRepo.Funnel(start, end, p.SiteID, p.By, f)
+	if err != nil {
+		return nil, NewRPCError(ErrCodeDBError, err.Error())
+	}
+	result := map[string]any{
+		"by":             p.By,
+		"window_seconds": int64(window / time.Second),
+		"steps":          steps,
+	}
+	if p.Breakdown != "" {
+		result["breakdown"] = map[string]any{"by": p.Breakdown, "values": breakdowns}
+	}
+	return result, nil
+}
@@ -0,0 +1,68 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/tracking/analysis/internal/importer"
+	"github.com/tracking/analysis/internal/privacy"
+)
+
+// admin.import.run — imports a Matomo, Plausible, GA4 or mapped export into a site's events and rebuilds its sessions
+func (h *AdminHandlers) ImportRun(_ context.Context, params json.RawMessage) (any, *RPCError) {
+	if err := h.requireAuth(params); err != nil {
+		return nil, err
+	}
+	var p struct {
+		SiteID  string          `json:"site_id"`
+		Source  string          `json:"source"`
+		Format  string          `json:"format"`
+		Data    string          `json:"data"`
+		Mapping json.RawMessage `json:"mapping"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, NewRPCError(ErrCodeInvalidParams, err.Error())
+	}
+	if p.SiteID == "" || p.Data == "" {
+		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "site_id and data are required")
+	}
+	site, err := h.SiteRepo.GetByID(p.SiteID)
+	if err != nil {
+		return nil, NewRPCError(ErrCodeDBError, "site not found")
+	}
+	opts := importer.Options{
+		SiteID:  site.ID,
+		Source:  p.Source,
+		Format:  p.Format,
+		Privacy: privacy.Settings{IPMode: site.IPMode, DropUA: site.DropUA},
+		Secret:  h.Config.SecurityConfiguration.TokenSecret,
+	}
+	if loc, err := time.LoadLocation(site.Timezone); err == nil {
+		opts.Location = loc
+	}
+	if len(p.Mapping) > 0 && string(p.Mapping) != "null" {
+		if opts.Mapping, err = importer.ParseMapping(p.Mapping); err != nil {
+			return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, err.Error())
+		}
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, err.Error())
+	}
+
+	res, err := importer.Run(strings.NewReader(p.Data), opts, h.EventRepo)
+	if errors.Is(err, importer.ErrStore) {
+		return nil, NewRPCError(ErrCodeDBError, err.Error())
+	}
+	if err != nil {
+		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, err.Error())
+	}
+	if res.Imported > 0 {
+		if res.Sessions, err = h.SessionRepo.Rebuild(res.First, res.Last, site.ID); err != nil {
+			return nil, NewRPCError(ErrCodeDBError, err.Error())
+		}
+	}
+	return res, nil
+}
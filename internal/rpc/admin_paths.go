@@ -0,0 +1,66 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+
+	"github.com/tracking/analysis/internal/repo"
+)
+
+// admin.paths.query — common page sequences from and to a page, with next and previous pages
+func (h *AdminHandlers) PathsQuery(_ context.Context, params json.RawMessage) (any, *RPCError) {
+	if err := h.requireAuth(params); err != nil {
+		return nil, err
+	}
+	var p struct {
+		StartDate string `json:"start_date"`
+		EndDate   string `json:"end_date"`
+		SiteID    string `json:"site_id"`
+		URL       string `json:"url"`
+		Steps     int    `json:"steps"`
+		Limit     int    `json:"limit"`
+		Timezone  string `json:"timezone"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, NewRPCError(ErrCodeInvalidParams, err.Error())
+	}
+	loc, rpcErr := h.statsLocation(p.Timezone, "", p.SiteID)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	start, end, rpcErr := parseDateRange(p.StartDate, p.EndDate, loc)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	if p.URL == "" {
+		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "url required")
+	}
+	page := p.URL
+	if u, err := url.Parse(p.URL); err == nil && u.Path != "" {
+		page = u.Path
+	}
+	if p.Steps == 0 {
+		p.Steps = 3
+	}
+	if p.Steps < 2 || p.Steps > 6 {
+		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "steps must be between 2 and 6")
+	}
+	if p.Limit <= 0 || p.Limit > 100 {
+		p.Limit = 10
+	}
+
+	result, err := h.EventRepo.Paths(start, end, p.SiteID, &repo.PathAnalysis{Page: page, Steps: p.Steps}, p.Limit)
+	if err != nil {
+		return nil, NewRPCError(ErrCodeDBError, err.Error())
+	}
+	return map[string]any{
+		"page":     page,
+		"steps":    p.Steps,
+		"visits":   result.Visits,
+		"from":     result.From,
+		"to":       result.To,
+		"next":     result.Next,
+		"previous": result.Previous,
+	}, nil
+}
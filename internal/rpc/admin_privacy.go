@@ -0,0 +1,155 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/tracking/analysis/internal/models"
+	"github.com/tracking/analysis/internal/privacy"
+)
+
+type privacySubjectParams struct {
+	AdminToken string `json:"admin_token"`
+	VisitorID  string `json:"visitor_id"`
+	IP         string `json:"ip"`
+}
+
+func (h *AdminHandlers) logPrivacyRequest(entry *models.PrivacyRequest) error {
+	secret := h.Config.SecurityConfiguration.TokenSecret
+	return h.PrivacyRepo.AppendLog(entry, func(e *models.PrivacyRequest) string {
+		return privacy.ChainHash(e, secret)
+	})
+}
+
+// subjectIPs expands an IP subject into every value it may be stored as,
+// and lists the trackers and sites whose truncated IPs it cannot match.
+func (h *AdminHandlers) subjectIPs(ip string) ([]string, map[string][]string, *RPCError) {
+	if ip == "" {
+		return nil, nil, nil
+	}
+	first, err := h.PrivacyRepo.FirstSeen()
+	if err != nil {
+		return nil, nil, NewRPCError(ErrCodeDBError, err.Error())
+	}
+	trackers, sites, err := h.PrivacyRepo.TruncatedIPOwners()
+	if err != nil {
+		return nil, nil, NewRPCError(ErrCodeDBError, err.Error())
+	}
+	unmatched := map[string][]string{"tracker_ids": trackers, "site_ids": sites}
+	return privacy.SubjectIPs(ip, h.Config.SecurityConfiguration.TokenSecret, first, time.Now()), unmatched, nil
+}
+
+// admin.privacy.export — returns every click, event and session for a visitor_id or IP
+func (h *AdminHandlers) PrivacyExport(_ context.Context, params json.RawMessage) (any, *RPCError) {
+	if err := h.requireAuth(params); err != nil {
+		return nil, err
+	}
+	var p privacySubjectParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, NewRPCError(ErrCodeInvalidParams, err.Error())
+	}
+	if p.VisitorID == "" && p.IP == "" {
+		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "visitor_id or ip required")
+	}
+
+	ips, unmatched, rpcErr := h.subjectIPs(p.IP)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	clicks, events, err := h.PrivacyRepo.FindBySubject(p.VisitorID, ips)
+	if err != nil {
+		return nil, NewRPCError(ErrCodeDBError, err.Error())
+	}
+	sessions, err := h.PrivacyRepo.FindSessions(p.VisitorID, ips)
+	if err != nil {
+		return nil, NewRPCError(ErrCodeDBError, err.Error())
+	}
+
+	entry := &models.PrivacyRequest{
+		Action:         "export",
+		SubjectHash:    privacy.SubjectHash(p.VisitorID, p.IP, h.Config.SecurityConfiguration.TokenSecret),
+		ClicksAffected: int64(len(clicks)),
+		EventsAffected: int64(len(events)),
+		Requester:      h.Config.AdminConfiguration.Username,
+	}
+	if err := h.logPrivacyRequest(entry); err != nil {
+		return nil, NewRPCError(ErrCodeDBError, err.Error())
+	}
+
+	return map[string]any{
+		"request_id":   entry.ID,
+		"visitor_id":   p.VisitorID,
+		"ip":           p.IP,
+		"clicks":       clicks,
+		"events":       events,
+		"sessions":     sessions,
+		"ip_truncated": unmatched,
+	}, nil
+}
+
+// admin.privacy.erase — deletes or anonymises every click, event and session for a visitor_id or IP
+func (h *AdminHandlers) PrivacyErase(_ context.Context, params json.RawMessage) (any, *RPCError) {
+	if err := h.requireAuth(params); err != nil {
+		return nil, err
+	}
+	var p struct {
+		privacySubjectParams
+		Mode string `json:"mode"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, NewRPCError(ErrCodeInvalidParams, err.Error())
+	}
+	if p.VisitorID == "" && p.IP == "" {
+		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "visitor_id or ip required")
+	}
+	if p.Mode == "" {
+		p.Mode = "delete"
+	}
+	if p.Mode != "delete" && p.Mode != "anonymise" {
+		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "mode must be 'delete' or 'anonymise'")
+	}
+
+	ips, unmatched, rpcErr := h.subjectIPs(p.IP)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	entry := &models.PrivacyRequest{
+		Action:      "erase",
+		Mode:        p.Mode,
+		SubjectHash: privacy.SubjectHash(p.VisitorID, p.IP, h.Config.SecurityConfiguration.TokenSecret),
+		Requester:   h.Config.AdminConfiguration.Username,
+	}
+	secret := h.Config.SecurityConfiguration.TokenSecret
+	err := h.PrivacyRepo.EraseSubject(p.VisitorID, ips, p.Mode == "anonymise", entry, func(e *models.PrivacyRequest) string {
+		return privacy.ChainHash(e, secret)
+	})
+	if err != nil {
+		return nil, NewRPCError(ErrCodeDBError, err.Error())
+	}
+
+	return map[string]any{
+		"request_id":      entry.ID,
+		"mode":            p.Mode,
+		"clicks_affected": entry.ClicksAffected,
+		"events_affected": entry.EventsAffected,
+		"ip_truncated":    unmatched,
+	}, nil
+}
+
+// admin.privacy.log — lists the request log and verifies its hash chain
+func (h *AdminHandlers) PrivacyLog(_ context.Context, params json.RawMessage) (any, *RPCError) {
+	if err := h.requireAuth(params); err != nil {
+		return nil, err
+	}
+	entries, err := h.PrivacyRepo.ListLog()
+	if err != nil {
+		return nil, NewRPCError(ErrCodeDBError, err.Error())
+	}
+	brokenAt := privacy.VerifyChain(entries, h.Config.SecurityConfiguration.TokenSecret)
+	return map[string]any{
+		"entries":   entries,
+		"intact":    brokenAt == "",
+		"broken_at": brokenAt,
+	}, nil
+}
@@ -0,0 +1,92 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/tracking/analysis/internal/repo"
+)
+
+const (
+	maxReportDimensions = 5
+	maxReportLimit      = 1000
+)
+
+// scopeFilter returns the value of an equality filter on dimension, so a
+// report scoped to one tracker or site can default to its timezone.
+func scopeFilter(filters []repo.ReportFilter, dimension string) string {
+	for _, f := range filters {
+		if f.Dimension == dimension && (f.Op == "" || f.Op == "eq") {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+// admin.report.query — ad-hoc metrics over whitelisted dimensions of clicks or events
+func (h *AdminHandlers) ReportQuery(_ context.Context, params json.RawMessage) (any, *RPCError) {
+	if err := h.requireAuth(params); err != nil {
+		return nil, err
+	}
+	// Custom reports are only implemented on Postgres; refuse rather than
+	// answer from a different store than the other stats
+	if h.Config.AnalyticsConfiguration.ReadFrom == repo.ModeClickHouse {
+		return nil, NewRPCErrorWithMessage(ErrCodeInvalidRequest, "custom reports are not available with read_from = clickhouse")
+	}
+	var p struct {
+		StartDate       string              `json:"start_date"`
+		EndDate         string              `json:"end_date"`
+		Source          string              `json:"source"`
+		Metrics         []string            `json:"metrics"`
+		Dimensions      []string            `json:"dimensions"`
+		Filters         []repo.ReportFilter `json:"filters"`
+		Sort            *repo.ReportSort    `json:"sort"`
+		Limit           int                 `json:"limit"`
+		ConversionEvent string              `json:"conversion_event"`
+		Timezone        string              `json:"timezone"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, NewRPCError(ErrCodeInvalidParams, err.Error())
+	}
+	loc, rpcErr := h.statsLocation(p.Timezone, scopeFilter(p.Filters, "tracker"), scopeFilter(p.Filters, "site"))
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	start, end, rpcErr := parseDateRange(p.StartDate, p.EndDate, loc)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	if p.Source == "" {
+		p.Source = "events"
+	}
+	if len(p.Dimensions) > maxReportDimensions {
+		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "at most 5 dimensions")
+	}
+	if p.Limit <= 0 {
+		p.Limit = 100
+	}
+	if p.Limit > maxReportLimit {
+		p.Limit = maxReportLimit
+	}
+
+	table, err := h.ReportRepo.Report(repo.ReportQuery{
+		Source:          p.Source,
+		Start:           start,
+		End:             end,
+		Metrics:         p.Metrics,
+		Dimensions:      p.Dimensions,
+		Filters:         p.Filters,
+		Sort:            p.Sort,
+		Limit:           p.Limit,
+		ConversionEvent: p.ConversionEvent,
+		Timezone:        loc.String(),
+	})
+	if err != nil {
+		if errors.Is(err, repo.ErrInvalidReport) {
+			return nil, NewRPCError(ErrCodeInvalidParams, err.Error())
+		}
+		return nil, NewRPCError(ErrCodeDBError, err.Error())
+	}
+	return table, nil
+}
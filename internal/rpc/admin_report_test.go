@@ -0,0 +1,23 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/tracking/analysis/internal/config"
+	"github.com/tracking/analysis/internal/repo"
+)
+
+func TestReportQuery_RefusedWhenReadingFromClickHouse(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.AdminConfiguration.Username = "admin"
+	cfg.AnalyticsConfiguration.ReadFrom = repo.ModeClickHouse
+	h := &AdminHandlers{Config: cfg}
+	params, _ := json.Marshal(map[string]any{"admin_token": h.generateSessionToken("admin"), "start_date": "2024-03-01", "end_date": "2024-03-07"})
+
+	_, rpcErr := h.ReportQuery(context.Background(), params)
+	if rpcErr == nil || rpcErr.Code != ErrCodeInvalidRequest {
+		t.Errorf("error = %+v, want invalid_request", rpcErr)
+	}
+}
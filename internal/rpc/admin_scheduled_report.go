@@ -0,0 +1,277 @@
+package rpc
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"time"
+
+	"github.com/tracking/analysis/internal/models"
+	"github.com/tracking/analysis/internal/schedule"
+	"gorm.io/gorm"
+)
+
+type scheduledReportParams struct {
+	AdminToken string   `json:"admin_token"`
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Cron       string   `json:"cron"`
+	Timezone   string   `json:"timezone"`
+	Source     string   `json:"source"`
+	TrackerID  *string  `json:"tracker_id"`
+	CampaignID *string  `json:"campaign_id"`
+	ChannelID  *string  `json:"channel_id"`
+	SiteID     *string  `json:"site_id"`
+	Format     string   `json:"format"`
+	RangeDays  int      `json:"range_days"`
+	Recipients []string `json:"recipients"`
+	Active     *bool    `json:"active"`
+}
+
+// apply copies the set fields of p onto r, validates the result and
+// schedules the next run after now.
+func (p *scheduledReportParams) apply(r *models.ScheduledReport, now time.Time) *RPCError {
+	if p.Name != "" {
+		r.Name = p.Name
+	}
+	if p.Cron != "" {
+		r.Cron = p.Cron
+	}
+	if p.Timezone != "" {
+		r.Timezone = p.Timezone
+	}
+	if p.Source != "" {
+		r.Source = p.Source
+	}
+	setIfPresent(&r.TrackerID, p.TrackerID)
+	setIfPresent(&r.CampaignID, p.CampaignID)
+	setIfPresent(&r.ChannelID, p.ChannelID)
+	setIfPresent(&r.SiteID, p.SiteID)
+	if p.Format != "" {
+		r.Format = p.Format
+	}
+	if p.RangeDays != 0 {
+		r.RangeDays = p.RangeDays
+	}
+	if p.Recipients != nil {
+		r.Recipients = p.Recipients
+	}
+	if p.Active != nil {
+		r.Active = *p.Active
+	}
+	if r.Timezone == "" {
+		r.Timezone = "UTC"
+	}
+	if r.Format == "" {
+		r.Format = schedule.FormatCSV
+	}
+	if r.RangeDays == 0 {
+		r.RangeDays = 7
+	}
+
+	if r.Name == "" {
+		return NewRPCErrorWithMessage(ErrCodeInvalidParams, "name required")
+	}
+	if _, err := schedule.ParseCron(r.Cron); err != nil {
+		return NewRPCErrorWithMessage(ErrCodeInvalidParams, fmt.Sprintf("invalid cron: %v", err))
+	}
+	if !validTimezone(r.Timezone) {
+		return NewRPCErrorWithMessage(ErrCodeInvalidParams, "timezone must be an IANA zone name")
+	}
+	switch r.Source {
+	case "clicks":
+		if r.SiteID != "" {
+			return NewRPCErrorWithMessage(ErrCodeInvalidParams, "site_id applies to events reports only")
+		}
+	case "events":
+		if r.TrackerID != "" || r.CampaignID != "" || r.ChannelID != "" {
+			return NewRPCErrorWithMessage(ErrCodeInvalidParams, "tracker_id, campaign_id and channel_id apply to clicks reports only")
+		}
+	default:
+		return NewRPCErrorWithMessage(ErrCodeInvalidParams, "source must be 'clicks' or 'events'")
+	}
+	if _, ok := schedule.ContentTypes[r.Format]; !ok {
+		return NewRPCErrorWithMessage(ErrCodeInvalidParams, "format must be 'csv', 'xlsx' or 'html'")
+	}
+	if r.RangeDays < 1 || r.RangeDays > 366 {
+		return NewRPCErrorWithMessage(ErrCodeInvalidParams, "range_days must be between 1 and 366")
+	}
+	if len(r.Recipients) == 0 {
+		return NewRPCErrorWithMessage(ErrCodeInvalidParams, "recipients required")
+	}
+	for _, addr := range r.Recipients {
+		if _, err := mail.ParseAddress(addr); err != nil {
+			return NewRPCErrorWithMessage(ErrCodeInvalidParams, fmt.Sprintf("invalid recipient: %s", addr))
+		}
+	}
+
+	next, err := schedule.NextRun(r.Cron, r.Timezone, now)
+	if err != nil {
+		return NewRPCError(ErrCodeInternalError, err.Error())
+	}
+	if next == nil {
+		return NewRPCErrorWithMessage(ErrCodeInvalidParams, "cron never matches")
+	}
+	r.NextRunAt = next
+	return nil
+}
+
+// ReportStats is the stats source for scheduled reports: the same payload
+// admin.stats.clicks and admin.stats.events return.
+func (h *AdminHandlers) ReportStats(source string, start, end time.Time, tz string, scope schedule.Scope) (map[string]any, error) {
+	var stats map[string]any
+	var rpcErr *RPCError
+	if source == "events" {
+		stats, rpcErr = h.eventStats(start, end, tz, scope.SiteID, 10)
+	} else {
+		stats, rpcErr = h.clickStats(start, end, tz, scope.TrackerID, scope.CampaignID, scope.ChannelID, 10)
+	}
+	if rpcErr != nil {
+		return nil, fmt.Errorf("%s: %v", rpcErr.Message, rpcErr.Data)
+	}
+	return stats, nil
+}
+
+// admin.scheduledReport.create — emails a clicks or events report on a cron schedule
+func (h *AdminHandlers) ScheduledReportCreate(_ context.Context, params json.RawMessage) (any, *RPCError) {
+	if err := h.requireAuth(params); err != nil {
+		return nil, err
+	}
+	var p scheduledReportParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, NewRPCError(ErrCodeInvalidParams, err.Error())
+	}
+	r := &models.ScheduledReport{Active: true}
+	if err := p.apply(r, time.Now()); err != nil {
+		return nil, err
+	}
+	if err := h.ScheduledReportRepo.Create(r); err != nil {
+		return nil, NewRPCError(ErrCodeDBError, err.Error())
+	}
+	return r, nil
+}
+
+// admin.scheduledReport.list
+func (h *AdminHandlers) ScheduledReportList(_ context.Context, params json.RawMessage) (any, *RPCError) {
+	if err := h.requireAuth(params); err != nil {
+		return nil, err
+	}
+	reports, err := h.ScheduledReportRepo.List()
+	if err != nil {
+		return nil, NewRPCError(ErrCodeDBError, err.Error())
+	}
+	return reports, nil
+}
+
+// admin.scheduledReport.update — changes a report and reschedules its next run
+func (h *AdminHandlers) ScheduledReportUpdate(_ context.Context, params json.RawMessage) (any, *RPCError) {
+	if err := h.requireAuth(params); err != nil {
+		return nil, err
+	}
+	var p scheduledReportParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, NewRPCError(ErrCodeInvalidParams, err.Error())
+	}
+	r, err := h.ScheduledReportRepo.GetByID(p.ID)
+	if err != nil {
+		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "scheduled report not found")
+	}
+	if rpcErr := p.apply(r, time.Now()); rpcErr != nil {
+		return nil, rpcErr
+	}
+	if err := h.ScheduledReportRepo.Update(r); err != nil {
+		return nil, NewRPCError(ErrCodeDBError, err.Error())
+	}
+	return r, nil
+}
+
+// admin.scheduledReport.delete — removes a report and its generated files
+func (h *AdminHandlers) ScheduledReportDelete(_ context.Context, params json.RawMessage) (any, *RPCError) {
+	if err := h.requireAuth(params); err != nil {
+		return nil, err
+	}
+	var p struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, NewRPCError(ErrCodeInvalidParams, err.Error())
+	}
+	if err := h.ScheduledReportRepo.Delete(p.ID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "scheduled report not found")
+		}
+		return nil, NewRPCError(ErrCodeDBError, err.Error())
+	}
+	return map[string]bool{"ok": true}, nil
+}
+
+// admin.scheduledReport.sendNow — generates and emails a report immediately without moving its schedule
+func (h *AdminHandlers) ScheduledReportSendNow(_ context.Context, params json.RawMessage) (any, *RPCError) {
+	if err := h.requireAuth(params); err != nil {
+		return nil, err
+	}
+	var p struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, NewRPCError(ErrCodeInvalidParams, err.Error())
+	}
+	r, err := h.ScheduledReportRepo.GetByID(p.ID)
+	if err != nil {
+		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "scheduled report not found")
+	}
+	// A failed send is still a recorded run, so it is returned rather than
+	// raised; its status and error say what went wrong.
+	run, _ := h.Schedules.Send(r, schedule.TriggerManual, time.Now())
+	return run, nil
+}
+
+// admin.scheduledReport.history — generated files and send outcomes, newest first
+func (h *AdminHandlers) ScheduledReportHistory(_ context.Context, params json.RawMessage) (any, *RPCError) {
+	if err := h.requireAuth(params); err != nil {
+		return nil, err
+	}
+	var p struct {
+		ReportID string `json:"report_id"`
+		Limit    int    `json:"limit"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, NewRPCError(ErrCodeInvalidParams, err.Error())
+	}
+	if p.Limit <= 0 || p.Limit > 500 {
+		p.Limit = 100
+	}
+	runs, err := h.ScheduledReportRepo.ListRuns(p.ReportID, p.Limit)
+	if err != nil {
+		return nil, NewRPCError(ErrCodeDBError, err.Error())
+	}
+	return runs, nil
+}
+
+// admin.scheduledReport.download — a generated file, base64 encoded
+func (h *AdminHandlers) ScheduledReportDownload(_ context.Context, params json.RawMessage) (any, *RPCError) {
+	if err := h.requireAuth(params); err != nil {
+		return nil, err
+	}
+	var p struct {
+		RunID string `json:"run_id"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, NewRPCError(ErrCodeInvalidParams, err.Error())
+	}
+	run, err := h.ScheduledReportRepo.GetRun(p.RunID)
+	if err != nil {
+		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "report run not found")
+	}
+	if len(run.Content) == 0 {
+		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "report run has no file")
+	}
+	return map[string]any{
+		"filename":     run.Filename,
+		"content_type": run.ContentType,
+		"content":      base64.StdEncoding.EncodeToString(run.Content),
+	}, nil
+}
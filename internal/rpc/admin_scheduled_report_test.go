@@ -0,0 +1,44 @@
+package rpc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tracking/analysis/internal/models"
+)
+
+func TestScheduledReportParamsApply(t *testing.T) {
+	now := time.Date(2024, 3, 6, 12, 0, 0, 0, time.UTC) // a Wednesday
+	valid := scheduledReportParams{Name: "Weekly clicks", Cron: "0 8 * * mon", Timezone: "Europe/Berlin", Source: "clicks", Recipients: []string{"ops@example.com"}}
+	r := &models.ScheduledReport{Active: true}
+	if err := valid.apply(r, now); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if r.Format != "csv" || r.RangeDays != 7 {
+		t.Errorf("defaults not applied: %+v", r)
+	}
+	want := time.Date(2024, 3, 11, 7, 0, 0, 0, time.UTC) // 08:00 CET
+	if r.NextRunAt == nil || !r.NextRunAt.Equal(want) {
+		t.Errorf("next run = %v, want %v", r.NextRunAt, want)
+	}
+
+	site := "site-1"
+	to := []string{"ops@example.com"}
+	tests := []struct {
+		name string
+		p    scheduledReportParams
+	}{
+		{"bad cron", scheduledReportParams{Name: "x", Cron: "0 8 * *", Source: "clicks", Recipients: to}},
+		{"cron never matches", scheduledReportParams{Name: "x", Cron: "0 0 30 2 *", Source: "clicks", Recipients: to}},
+		{"bad timezone", scheduledReportParams{Name: "x", Cron: "@daily", Timezone: "Mars/Olympus", Source: "clicks", Recipients: to}},
+		{"site on clicks", scheduledReportParams{Name: "x", Cron: "@daily", Source: "clicks", SiteID: &site, Recipients: to}},
+		{"unknown format", scheduledReportParams{Name: "x", Cron: "@daily", Source: "events", Format: "pdf", Recipients: to}},
+		{"no recipients", scheduledReportParams{Name: "x", Cron: "@daily", Source: "events"}},
+		{"bad recipient", scheduledReportParams{Name: "x", Cron: "@daily", Source: "events", Recipients: []string{"not an address"}}},
+	}
+	for _, tt := range tests {
+		if err := tt.p.apply(&models.ScheduledReport{}, now); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
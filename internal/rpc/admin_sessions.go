@@ -0,0 +1,36 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// admin.sessions.rebuild — recomputes sessions from raw events in a date range
+func (h *AdminHandlers) SessionsRebuild(_ context.Context, params json.RawMessage) (any, *RPCError) {
+	if err := h.requireAuth(params); err != nil {
+		return nil, err
+	}
+	var p struct {
+		StartDate string `json:"start_date"`
+		EndDate   string `json:"end_date"`
+		Timezone  string `json:"timezone"`
+		SiteID    string `json:"site_id"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, NewRPCError(ErrCodeInvalidParams, err.Error())
+	}
+	loc, rpcErr := h.statsLocation(p.Timezone, "", "")
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	start, end, rpcErr := parseDateRange(p.StartDate, p.EndDate, loc)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+
+	rows, err := h.SessionRepo.Rebuild(start, end, p.SiteID)
+	if err != nil {
+		return nil, NewRPCError(ErrCodeDBError, err.Error())
+	}
+	return map[string]any{"sessions": rows}, nil
+}
@@ -0,0 +1,54 @@
+package rpc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidTimezone(t *testing.T) {
+	for _, tz := range []string{"UTC", "Asia/Shanghai", "America/New_York"} {
+		if !validTimezone(tz) {
+			t.Errorf("validTimezone(%q) = false", tz)
+		}
+	}
+	for _, tz := range []string{"", "Local", "Mars/Olympus", "+08:00"} {
+		if validTimezone(tz) {
+			t.Errorf("validTimezone(%q) = true", tz)
+		}
+	}
+}
+
+func TestParseDateRange_InLocation(t *testing.T) {
+	loc, _ := time.LoadLocation("Asia/Shanghai")
+	start, end, err := parseDateRange("2024-05-01", "2024-05-01", loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2024, 4, 30, 16, 0, 0, 0, time.UTC); !start.Equal(want) {
+		t.Errorf("start = %v, want %v", start.UTC(), want)
+	}
+	if want := time.Date(2024, 5, 1, 16, 0, 0, 0, time.UTC).Add(-time.Nanosecond); !end.Equal(want) {
+		t.Errorf("end = %v, want %v", end.UTC(), want)
+	}
+}
+
+func TestParseDateRange_DST(t *testing.T) {
+	loc, _ := time.LoadLocation("America/New_York")
+	// 2024-03-10 is 23 hours long in New York.
+	start, end, err := parseDateRange("2024-03-10", "2024-03-10", loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := end.Sub(start) + time.Nanosecond; got != 23*time.Hour {
+		t.Errorf("day length = %v, want 23h", got)
+	}
+}
+
+func TestParseDateRange_Invalid(t *testing.T) {
+	if _, _, err := parseDateRange("2024-5-1", "2024-05-01", time.UTC); err == nil {
+		t.Error("expected error for malformed start_date")
+	}
+	if _, _, err := parseDateRange("2024-05-01", "", time.UTC); err == nil {
+		t.Error("expected error for missing end_date")
+	}
+}
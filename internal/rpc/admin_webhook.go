@@ -0,0 +1,213 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/tracking/analysis/internal/models"
+	"github.com/tracking/analysis/internal/webhook"
+	"gorm.io/gorm"
+)
+
+type webhookParams struct {
+	AdminToken       string   `json:"admin_token"`
+	ID               string   `json:"id"`
+	Name             string   `json:"name"`
+	URL              string   `json:"url"`
+	Kinds            []string `json:"kinds"`
+	TrackerID        *string  `json:"tracker_id"`
+	SiteID           *string  `json:"site_id"`
+	ConversionEvents []string `json:"conversion_events"`
+	BotSpikeLimit    *int     `json:"bot_spike_limit"`
+	Active           *bool    `json:"active"`
+	RotateSecret     bool     `json:"rotate_secret"`
+}
+
+func validWebhookURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != ""
+}
+
+// apply copies the set fields of p onto w and validates the result.
+func (p *webhookParams) apply(w *models.Webhook) *RPCError {
+	if p.Name != "" {
+		w.Name = p.Name
+	}
+	if p.URL != "" {
+		w.URL = p.URL
+	}
+	if p.Kinds != nil {
+		w.Kinds = p.Kinds
+	}
+	if p.TrackerID != nil {
+		w.TrackerID = *p.TrackerID
+	}
+	if p.SiteID != nil {
+		w.SiteID = *p.SiteID
+	}
+	if p.ConversionEvents != nil {
+		w.ConversionEvents = p.ConversionEvents
+	}
+	if p.BotSpikeLimit != nil {
+		w.BotSpikeLimit = *p.BotSpikeLimit
+	}
+	if p.Active != nil {
+		w.Active = *p.Active
+	}
+
+	if w.Name == "" {
+		return NewRPCErrorWithMessage(ErrCodeInvalidParams, "name required")
+	}
+	if !validWebhookURL(w.URL) {
+		return NewRPCErrorWithMessage(ErrCodeInvalidParams, "url must be an absolute http or https URL")
+	}
+	if len(w.Kinds) == 0 {
+		return NewRPCErrorWithMessage(ErrCodeInvalidParams, "kinds required")
+	}
+	for _, k := range w.Kinds {
+		if !webhook.ValidKind(k) {
+			return NewRPCErrorWithMessage(ErrCodeInvalidParams, fmt.Sprintf("unsupported kind: %s", k))
+		}
+	}
+	if w.Kinds.Contains(webhook.KindConversion) && len(w.ConversionEvents) == 0 {
+		return NewRPCErrorWithMessage(ErrCodeInvalidParams, "conversion_events required for conversion webhooks")
+	}
+	if w.BotSpikeLimit < 0 {
+		return NewRPCErrorWithMessage(ErrCodeInvalidParams, "bot_spike_limit must not be negative")
+	}
+	if w.BotSpikeLimit == 0 {
+		w.BotSpikeLimit = webhook.DefaultBotSpikeLimit
+	}
+	return nil
+}
+
+// admin.webhook.create — subscribes a URL to click, conversion, bot_spike and token_expired notifications
+func (h *AdminHandlers) WebhookCreate(_ context.Context, params json.RawMessage) (any, *RPCError) {
+	if err := h.requireAuth(params); err != nil {
+		return nil, err
+	}
+	var p webhookParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, NewRPCError(ErrCodeInvalidParams, err.Error())
+	}
+	w := &models.Webhook{Secret: webhook.NewSecret(), Active: true}
+	if err := p.apply(w); err != nil {
+		return nil, err
+	}
+	if err := h.WebhookRepo.Create(w); err != nil {
+		return nil, NewRPCError(ErrCodeDBError, err.Error())
+	}
+	h.Webhooks.Invalidate()
+	return w, nil
+}
+
+// admin.webhook.list
+func (h *AdminHandlers) WebhookList(_ context.Context, params json.RawMessage) (any, *RPCError) {
+	if err := h.requireAuth(params); err != nil {
+		return nil, err
+	}
+	hooks, err := h.WebhookRepo.List()
+	if err != nil {
+		return nil, NewRPCError(ErrCodeDBError, err.Error())
+	}
+	return hooks, nil
+}
+
+// admin.webhook.update — changes filters, kinds or state; rotate_secret issues a new signing secret
+func (h *AdminHandlers) WebhookUpdate(_ context.Context, params json.RawMessage) (any, *RPCError) {
+	if err := h.requireAuth(params); err != nil {
+		return nil, err
+	}
+	var p webhookParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, NewRPCError(ErrCodeInvalidParams, err.Error())
+	}
+	w, err := h.WebhookRepo.GetByID(p.ID)
+	if err != nil {
+		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "webhook not found")
+	}
+	if rpcErr := p.apply(w); rpcErr != nil {
+		return nil, rpcErr
+	}
+	if p.RotateSecret {
+		w.Secret = webhook.NewSecret()
+	}
+	if err := h.WebhookRepo.Update(w); err != nil {
+		return nil, NewRPCError(ErrCodeDBError, err.Error())
+	}
+	h.Webhooks.Invalidate()
+	return w, nil
+}
+
+// admin.webhook.delete — removes a webhook and its delivery log
+func (h *AdminHandlers) WebhookDelete(_ context.Context, params json.RawMessage) (any, *RPCError) {
+	if err := h.requireAuth(params); err != nil {
+		return nil, err
+	}
+	var p struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, NewRPCError(ErrCodeInvalidParams, err.Error())
+	}
+	if err := h.WebhookRepo.Delete(p.ID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "webhook not found")
+		}
+		return nil, NewRPCError(ErrCodeDBError, err.Error())
+	}
+	h.Webhooks.Invalidate()
+	return map[string]bool{"ok": true}, nil
+}
+
+// admin.webhook.deliveries — the delivery log, newest first
+func (h *AdminHandlers) WebhookDeliveries(_ context.Context, params json.RawMessage) (any, *RPCError) {
+	if err := h.requireAuth(params); err != nil {
+		return nil, err
+	}
+	var p struct {
+		WebhookID string `json:"webhook_id"`
+		Status    string `json:"status"`
+		Limit     int    `json:"limit"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, NewRPCError(ErrCodeInvalidParams, err.Error())
+	}
+	switch p.Status {
+	case "", webhook.StatusPending, webhook.StatusDelivered, webhook.StatusFailed:
+	default:
+		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "status must be 'pending', 'delivered' or 'failed'")
+	}
+	if p.Limit <= 0 || p.Limit > 500 {
+		p.Limit = 100
+	}
+	deliveries, err := h.WebhookRepo.ListDeliveries(p.WebhookID, p.Status, p.Limit)
+	if err != nil {
+		return nil, NewRPCError(ErrCodeDBError, err.Error())
+	}
+	return deliveries, nil
+}
+
+// admin.webhook.replay — queues a logged delivery's payload again as a new delivery
+func (h *AdminHandlers) WebhookReplay(ctx context.Context, params json.RawMessage) (any, *RPCError) {
+	if err := h.requireAuth(params); err != nil {
+		return nil, err
+	}
+	var p struct {
+		DeliveryID string `json:"delivery_id"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, NewRPCError(ErrCodeInvalidParams, err.Error())
+	}
+	d, err := h.Webhooks.Replay(ctx, p.DeliveryID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "delivery not found")
+		}
+		return nil, NewRPCError(ErrCodeDBError, err.Error())
+	}
+	return d, nil
+}
@@ -0,0 +1,49 @@
+package rpc
+
+import (
+	"testing"
+
+	"github.com/tracking/analysis/internal/models"
+	"github.com/tracking/analysis/internal/webhook"
+)
+
+func TestWebhookParamsApply(t *testing.T) {
+	valid := webhookParams{Name: "crm", URL: "https://example.com/hook", Kinds: []string{"click"}}
+	w := &models.Webhook{Active: true}
+	if err := valid.apply(w); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if w.BotSpikeLimit != webhook.DefaultBotSpikeLimit {
+		t.Errorf("bot_spike_limit = %d, want default %d", w.BotSpikeLimit, webhook.DefaultBotSpikeLimit)
+	}
+
+	tests := []struct {
+		name string
+		p    webhookParams
+	}{
+		{"missing name", webhookParams{URL: "https://example.com", Kinds: []string{"click"}}},
+		{"relative url", webhookParams{Name: "x", URL: "/hook", Kinds: []string{"click"}}},
+		{"bad scheme", webhookParams{Name: "x", URL: "ftp://example.com", Kinds: []string{"click"}}},
+		{"no kinds", webhookParams{Name: "x", URL: "https://example.com"}},
+		{"unknown kind", webhookParams{Name: "x", URL: "https://example.com", Kinds: []string{"refund"}}},
+		{"conversion without events", webhookParams{Name: "x", URL: "https://example.com", Kinds: []string{"conversion"}}},
+	}
+	for _, tt := range tests {
+		if err := tt.p.apply(&models.Webhook{}); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
+
+func TestWebhookParamsApply_PartialUpdate(t *testing.T) {
+	w := &models.Webhook{Name: "crm", URL: "https://example.com/hook", Kinds: models.StringList{"click"}, TrackerID: "tr-1", Active: true}
+	empty := ""
+	inactive := false
+	p := webhookParams{TrackerID: &empty, Active: &inactive}
+	if err := p.apply(w); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if w.TrackerID != "" || w.Active || w.Name != "crm" || !w.Kinds.Contains("click") {
+		t.Errorf("unexpected webhook after update: %+v", w)
+	}
+}
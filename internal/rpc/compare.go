@@ -0,0 +1,228 @@
+package rpc
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/tracking/analysis/internal/repo"
+)
+
+// compareTopLimit is how deep the comparison period's top-N lists are
+// fetched, so items in the primary top 10 are usually found in them.
+const compareTopLimit = 100
+
+type compareParams struct {
+	Period    string `json:"period"` // previous, week, month, year or custom
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+}
+
+// compareRange returns the comparison range for the primary range
+// [start, end], both in loc.
+func compareRange(c *compareParams, start, end time.Time, loc *time.Location) (time.Time, time.Time, *RPCError) {
+	switch c.Period {
+	case "", "previous":
+		days := rangeDays(start, end)
+		return start.AddDate(0, 0, -days), start.Add(-time.Nanosecond), nil
+	case "week":
+		return start.AddDate(0, 0, -7), end.AddDate(0, 0, -7), nil
+	case "month":
+		return shiftMonths(start, -1), endOfDay(shiftMonths(end, -1)), nil
+	case "year":
+		return shiftMonths(start, -12), endOfDay(shiftMonths(end, -12)), nil
+	case "custom":
+		return parseDateRange(c.StartDate, c.EndDate, loc)
+	}
+	return time.Time{}, time.Time{}, NewRPCErrorWithMessage(ErrCodeInvalidParams, "compare.period must be 'previous', 'week', 'month', 'year' or 'custom'")
+}
+
+// rangeDays counts the calendar days in [start, end]. Rounding absorbs the
+// hour gained or lost across DST changes.
+func rangeDays(start, end time.Time) int {
+	return int(math.Round(end.Add(time.Nanosecond).Sub(start).Hours() / 24))
+}
+
+// shiftMonths moves t's date by months, clamping the day to the target
+// month's length so 31 March shifts to 29 February rather than 2 March.
+func shiftMonths(t time.Time, months int) time.Time {
+	y, m, d := t.Date()
+	first := time.Date(y, m+time.Month(months), 1, 0, 0, 0, 0, t.Location())
+	if last := first.AddDate(0, 1, -1).Day(); d > last {
+		d = last
+	}
+	return time.Date(first.Year(), first.Month(), d, 0, 0, 0, 0, t.Location())
+}
+
+func endOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location()).AddDate(0, 0, 1).Add(-time.Nanosecond)
+}
+
+type change struct {
+	Current   float64  `json:"current"`
+	Previous  *float64 `json:"previous"`
+	Change    *float64 `json:"change"`
+	ChangePct *float64 `json:"change_pct"`
+}
+
+func newChange(current float64, previous *float64) change {
+	c := change{Current: current, Previous: previous}
+	if previous == nil {
+		return c
+	}
+	diff := round2(current - *previous)
+	c.Change = &diff
+	if *previous != 0 {
+		pct := round2((current - *previous) / *previous * 100)
+		c.ChangePct = &pct
+	}
+	return c
+}
+
+func round2(f float64) float64 {
+	return math.Round(f*100) / 100
+}
+
+type itemChange struct {
+	Name    string `json:"name"`
+	GroupID string `json:"group_id,omitempty"`
+	change
+}
+
+type dayChange struct {
+	Date         string `json:"date"`
+	PreviousDate string `json:"previous_date,omitempty"`
+	change
+}
+
+// buildComparison compares two stats payloads from clickStats or eventStats.
+// Summary metrics and top-N lists are matched by key; top-N items missing
+// from the comparison period have a null previous value. Daily series are
+// aligned by day offset from the start of each range.
+func buildComparison(current, previous map[string]any, start, end, cstart, cend time.Time) map[string]any {
+	out := map[string]any{
+		"start_date": cstart.Format("2006-01-02"),
+		"end_date":   cend.Format("2006-01-02"),
+		"summary":    previous["summary"],
+	}
+
+	cur, _ := current["summary"].(map[string]any)
+	prev, _ := previous["summary"].(map[string]any)
+	summary := make(map[string]change, len(cur))
+	for k, v := range cur {
+		c, ok := toFloat(v)
+		if !ok {
+			continue
+		}
+		var pp *float64
+		if p, ok := toFloat(prev[k]); ok {
+			pp = &p
+		}
+		summary[k] = newChange(c, pp)
+	}
+	out["changes"] = summary
+
+	curDaily, _ := current["daily"].([]repo.DailyCount)
+	prevDaily, _ := previous["daily"].([]repo.DailyCount)
+	out["daily"] = alignDaily(curDaily, prevDaily, start, cstart, cend, rangeDays(start, end))
+
+	keys := make([]string, 0, len(current))
+	for k := range current {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	top := make(map[string][]itemChange)
+	for _, k := range keys {
+		if items, ok := compareList(current[k], previous[k]); ok {
+			top[k] = items
+		}
+	}
+	out["top"] = top
+	return out
+}
+
+// alignDaily pairs day i of the primary range with day i of the comparison
+// range, filling days without data with zero. Comparison days past cend,
+// as when a 31-day month is compared with a 30-day one, have no
+// counterpart and get a null previous value.
+func alignDaily(current, previous []repo.DailyCount, start, cstart, cend time.Time, days int) []dayChange {
+	curByDate := dailyByDate(current)
+	prevByDate := dailyByDate(previous)
+	out := make([]dayChange, days)
+	for i := range out {
+		d := start.AddDate(0, 0, i).Format("2006-01-02")
+		day := cstart.AddDate(0, 0, i)
+		if day.After(cend) {
+			out[i] = dayChange{Date: d, change: newChange(float64(curByDate[d]), nil)}
+			continue
+		}
+		pd := day.Format("2006-01-02")
+		p := float64(prevByDate[pd])
+		out[i] = dayChange{Date: d, PreviousDate: pd, change: newChange(float64(curByDate[d]), &p)}
+	}
+	return out
+}
+
+func dailyByDate(daily []repo.DailyCount) map[string]int64 {
+	m := make(map[string]int64, len(daily))
+	for _, d := range daily {
+		key := d.Date
+		if len(key) > 10 {
+			key = key[:10] // DATE columns may scan as RFC 3339 timestamps
+		}
+		m[key] += d.Count
+	}
+	return m
+}
+
+// compareList compares two top-N lists of the same type, keeping the
+// primary list's order.
+func compareList(current, previous any) ([]itemChange, bool) {
+	switch cur := current.(type) {
+	case []repo.NameCount:
+		prev := make(map[string]float64)
+		if p, ok := previous.([]repo.NameCount); ok {
+			for _, nc := range p {
+				prev[nc.Name] = float64(nc.Count)
+			}
+		}
+		items := make([]itemChange, len(cur))
+		for i, nc := range cur {
+			items[i] = itemChange{Name: nc.Name, change: newChange(float64(nc.Count), lookup(prev, nc.Name))}
+		}
+		return items, true
+	case []repo.GroupCount:
+		prev := make(map[string]float64)
+		if p, ok := previous.([]repo.GroupCount); ok {
+			for _, gc := range p {
+				prev[gc.GroupID] = float64(gc.Count)
+			}
+		}
+		items := make([]itemChange, len(cur))
+		for i, gc := range cur {
+			items[i] = itemChange{Name: gc.Name, GroupID: gc.GroupID, change: newChange(float64(gc.Count), lookup(prev, gc.GroupID))}
+		}
+		return items, true
+	}
+	return nil, false
+}
+
+func lookup(m map[string]float64, key string) *float64 {
+	if v, ok := m[key]; ok {
+		return &v
+	}
+	return nil
+}
+
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	}
+	return 0, false
+}
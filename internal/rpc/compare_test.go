@@ -0,0 +1,101 @@
+package rpc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tracking/analysis/internal/repo"
+)
+
+func TestCompareRange(t *testing.T) {
+	start, end, _ := parseDateRange("2024-03-25", "2024-03-31", time.UTC)
+	tests := []struct {
+		period             string
+		wantStart, wantEnd string
+	}{
+		{"previous", "2024-03-18", "2024-03-24"},
+		{"week", "2024-03-18", "2024-03-24"},
+		{"month", "2024-02-25", "2024-02-29"},
+		{"year", "2023-03-25", "2023-03-31"},
+	}
+	for _, tt := range tests {
+		cs, ce, err := compareRange(&compareParams{Period: tt.period}, start, end, time.UTC)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.period, err)
+		}
+		if got := cs.Format("2006-01-02"); got != tt.wantStart {
+			t.Errorf("%s: start = %s, want %s", tt.period, got, tt.wantStart)
+		}
+		if got := ce.Format("2006-01-02"); got != tt.wantEnd {
+			t.Errorf("%s: end = %s, want %s", tt.period, got, tt.wantEnd)
+		}
+		if !ce.Equal(endOfDay(ce)) {
+			t.Errorf("%s: end %v is not end of day", tt.period, ce)
+		}
+	}
+
+	cs, ce, err := compareRange(&compareParams{Period: "custom", StartDate: "2023-01-01", EndDate: "2023-01-07"}, start, end, time.UTC)
+	if err != nil || cs.Format("2006-01-02") != "2023-01-01" || ce.Format("2006-01-02") != "2023-01-07" {
+		t.Errorf("custom = %v..%v, %v", cs, ce, err)
+	}
+	if _, _, err := compareRange(&compareParams{Period: "fortnight"}, start, end, time.UTC); err == nil {
+		t.Error("expected error for unknown period")
+	}
+}
+
+func TestBuildComparison(t *testing.T) {
+	start, end, _ := parseDateRange("2024-05-08", "2024-05-09", time.UTC)
+	cstart, cend, _ := parseDateRange("2024-05-06", "2024-05-07", time.UTC)
+	current := map[string]any{
+		"summary":   map[string]any{"total": int64(150), "bot_rate": 2.0},
+		"daily":     []repo.DailyCount{{Date: "2024-05-08T00:00:00Z", Count: 100}, {Date: "2024-05-09T00:00:00Z", Count: 50}},
+		"countries": []repo.NameCount{{Name: "DE", Count: 90}, {Name: "FR", Count: 60}},
+		"top_sites": []repo.GroupCount{{GroupID: "s1", Name: "Shop", Count: 150}},
+		"timezone":  "UTC",
+	}
+	previous := map[string]any{
+		"summary":   map[string]any{"total": int64(100), "bot_rate": 0.0},
+		"daily":     []repo.DailyCount{{Date: "2024-05-06T00:00:00Z", Count: 100}},
+		"countries": []repo.NameCount{{Name: "DE", Count: 60}},
+		"top_sites": []repo.GroupCount{{GroupID: "s1", Name: "Shop", Count: 100}},
+	}
+
+	out := buildComparison(current, previous, start, end, cstart, cend)
+
+	changes := out["changes"].(map[string]change)
+	if c := changes["total"]; *c.Change != 50 || *c.ChangePct != 50 {
+		t.Errorf("total change = %+v", c)
+	}
+	if c := changes["bot_rate"]; *c.Change != 2 || c.ChangePct != nil {
+		t.Errorf("bot_rate change from zero = %+v", c)
+	}
+
+	daily := out["daily"].([]dayChange)
+	if len(daily) != 2 || daily[1].PreviousDate != "2024-05-07" || *daily[1].Previous != 0 || *daily[0].ChangePct != 0 {
+		t.Errorf("daily = %+v", daily)
+	}
+
+	top := out["top"].(map[string][]itemChange)
+	if c := top["countries"]; *c[0].ChangePct != 50 || c[1].Previous != nil {
+		t.Errorf("countries = %+v", c)
+	}
+	if c := top["top_sites"]; c[0].GroupID != "s1" || *c[0].Change != 50 {
+		t.Errorf("top_sites = %+v", c)
+	}
+	if _, ok := top["timezone"]; ok {
+		t.Error("non-list values should not be compared")
+	}
+}
+
+func TestAlignDaily_ShorterComparison(t *testing.T) {
+	// 31 days of May against the 30 days of April
+	start, end, _ := parseDateRange("2024-05-01", "2024-05-31", time.UTC)
+	cstart, cend, _ := compareRange(&compareParams{Period: "month"}, start, end, time.UTC)
+	daily := alignDaily(nil, []repo.DailyCount{{Date: "2024-04-30", Count: 7}}, start, cstart, cend, rangeDays(start, end))
+	if len(daily) != 31 || *daily[29].Previous != 7 || daily[29].PreviousDate != "2024-04-30" {
+		t.Fatalf("daily[29] = %+v", daily[29])
+	}
+	if last := daily[30]; last.Previous != nil || last.PreviousDate != "" || last.Change != nil {
+		t.Errorf("day past the comparison range = %+v", last)
+	}
+}
@@ -87,6 +87,33 @@ func (d *Dispatcher) GinHandler() gin.HandlerFunc {
 	}
 }
 
+// BeaconHandler serves navigator.sendBeacon requests. Beacons are sent as
+// text/plain to avoid a CORS preflight and their response is never read, so
+// the body is decoded as a JSON-RPC request regardless of Content-Type and
+// the reply is always 204. Only the listed methods may be called this way.
+func (d *Dispatcher) BeaconHandler(allowed ...string) gin.HandlerFunc {
+	allow := make(map[string]bool, len(allowed))
+	for _, m := range allowed {
+		allow[m] = true
+	}
+	return func(c *gin.Context) {
+		var req Request
+		if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil || req.JSONRPC != "2.0" {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		handler, ok := d.methods[req.Method]
+		if !ok || !allow[req.Method] {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		ctx := context.WithValue(c.Request.Context(), ginContextKey, c)
+		handler(ctx, req.Params)
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
+
 type contextKey string
 
 const ginContextKey contextKey = "gin"
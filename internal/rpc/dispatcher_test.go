@@ -156,3 +156,57 @@ func TestDispatcher_HandlerError(t *testing.T) {
 		t.Errorf("error message = %q, want %q", resp.Error.Message, "rate_limited")
 	}
 }
+
+func performBeacon(t *testing.T, d *Dispatcher, body []byte, allowed ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/rpc/beacon", bytes.NewReader(body))
+	c.Request.Header.Set("Content-Type", "text/plain;charset=UTF-8")
+	d.BeaconHandler(allowed...)(c)
+	return w
+}
+
+func TestDispatcher_BeaconAllowed(t *testing.T) {
+	d := NewDispatcher()
+	called := false
+	d.Register("track.collectEvents", func(ctx context.Context, params json.RawMessage) (interface{}, *RPCError) {
+		called = true
+		return nil, nil
+	})
+
+	body, _ := json.Marshal(Request{JSONRPC: "2.0", Method: "track.collectEvents", ID: 1})
+	w := performBeacon(t, d, body, "track.collectEvents")
+	if !called {
+		t.Error("beacon handler did not dispatch text/plain body")
+	}
+	if w.Code != http.StatusNoContent {
+		t.Errorf("status = %d, want 204", w.Code)
+	}
+}
+
+func TestDispatcher_BeaconNotAllowed(t *testing.T) {
+	d := NewDispatcher()
+	called := false
+	d.Register("admin.login", func(ctx context.Context, params json.RawMessage) (interface{}, *RPCError) {
+		called = true
+		return nil, nil
+	})
+
+	body, _ := json.Marshal(Request{JSONRPC: "2.0", Method: "admin.login", ID: 1})
+	w := performBeacon(t, d, body, "track.collectEvents")
+	if called {
+		t.Error("beacon dispatched a method outside the allow list")
+	}
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", w.Code)
+	}
+}
+
+func TestDispatcher_BeaconInvalidBody(t *testing.T) {
+	d := NewDispatcher()
+	w := performBeacon(t, d, []byte(`{invalid`), "track.collectEvents")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", w.Code)
+	}
+}
@@ -0,0 +1,37 @@
+package rpc
+
+import "time"
+
+// correctEventTime maps a client-reported event time onto the server clock.
+//
+// clientTS is the client's wall clock at capture (Unix ms, 0 if absent) and
+// offsetMs the monotonic time elapsed between capture and send (nil if the
+// client could not measure it). sentAt is the client's wall clock at send;
+// its difference from serverNow is the clock skew.
+//
+// The monotonic offset is preferred because it is immune to clock changes
+// on the device. Results slightly in the future are clamped to serverNow;
+// results more than window in the future, or older than maxAge, are
+// rejected with ok=false.
+func correctEventTime(serverNow, sentAt time.Time, clientTS int64, offsetMs *int64, window, maxAge time.Duration) (t time.Time, ok bool) {
+	switch {
+	case offsetMs != nil && *offsetMs >= 0:
+		t = serverNow.Add(-time.Duration(*offsetMs) * time.Millisecond)
+	case clientTS > 0:
+		skew := serverNow.Sub(sentAt)
+		t = time.UnixMilli(clientTS).Add(skew)
+	default:
+		return serverNow, true
+	}
+
+	if t.After(serverNow) {
+		if t.Sub(serverNow) > window {
+			return time.Time{}, false
+		}
+		t = serverNow
+	}
+	if serverNow.Sub(t) > maxAge {
+		return time.Time{}, false
+	}
+	return t, true
+}
@@ -0,0 +1,81 @@
+package rpc
+
+import (
+	"testing"
+	"time"
+)
+
+func int64Ptr(v int64) *int64 { return &v }
+
+func TestCorrectEventTime_NoClientTime(t *testing.T) {
+	now := time.Now()
+	got, ok := correctEventTime(now, now, 0, nil, time.Minute, time.Hour)
+	if !ok || !got.Equal(now) {
+		t.Errorf("got %v ok=%v, want server time", got, ok)
+	}
+}
+
+func TestCorrectEventTime_SkewCorrected(t *testing.T) {
+	now := time.UnixMilli(1_700_000_000_000)
+	// Client clock runs 90s slow: it sent at now-90s by its own clock and
+	// captured the event 5s before sending.
+	sentAt := now.Add(-90 * time.Second)
+	clientTS := sentAt.Add(-5 * time.Second).UnixMilli()
+
+	got, ok := correctEventTime(now, sentAt, clientTS, nil, 5*time.Minute, time.Hour)
+	if !ok {
+		t.Fatal("event rejected")
+	}
+	if want := now.Add(-5 * time.Second); !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestCorrectEventTime_PrefersMonotonicOffset(t *testing.T) {
+	now := time.UnixMilli(1_700_000_000_000)
+	// Wall clock says 1h ago but the monotonic offset says 2s
+	clientTS := now.Add(-time.Hour).UnixMilli()
+
+	got, ok := correctEventTime(now, now, clientTS, int64Ptr(2000), 5*time.Minute, 24*time.Hour)
+	if !ok {
+		t.Fatal("event rejected")
+	}
+	if want := now.Add(-2 * time.Second); !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestCorrectEventTime_PreservesBatchOrder(t *testing.T) {
+	now := time.Now()
+	a, _ := correctEventTime(now, now, 0, int64Ptr(3000), time.Minute, time.Hour)
+	b, _ := correctEventTime(now, now, 0, int64Ptr(1000), time.Minute, time.Hour)
+	if !a.Before(b) {
+		t.Errorf("earlier event %v not before later event %v", a, b)
+	}
+}
+
+func TestCorrectEventTime_FutureClamped(t *testing.T) {
+	now := time.UnixMilli(1_700_000_000_000)
+	clientTS := now.Add(10 * time.Second).UnixMilli()
+
+	got, ok := correctEventTime(now, now, clientTS, nil, time.Minute, time.Hour)
+	if !ok || !got.Equal(now) {
+		t.Errorf("got %v ok=%v, want clamped to server time", got, ok)
+	}
+}
+
+func TestCorrectEventTime_FarFutureRejected(t *testing.T) {
+	now := time.UnixMilli(1_700_000_000_000)
+	clientTS := now.Add(10 * time.Minute).UnixMilli()
+
+	if _, ok := correctEventTime(now, now, clientTS, nil, time.Minute, time.Hour); ok {
+		t.Error("event beyond the window should be rejected")
+	}
+}
+
+func TestCorrectEventTime_TooOldRejected(t *testing.T) {
+	now := time.Now()
+	if _, ok := correctEventTime(now, now, 0, int64Ptr((2 * time.Hour).Milliseconds()), time.Minute, time.Hour); ok {
+		t.Error("event older than maxAge should be rejected")
+	}
+}
@@ -4,6 +4,9 @@ import (
 	"context"
 	"crypto/rsa"
 	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -11,20 +14,35 @@ import (
 	"github.com/tracking/analysis/internal/config"
 	"github.com/tracking/analysis/internal/dedup"
 	"github.com/tracking/analysis/internal/geo"
+	"github.com/tracking/analysis/internal/live"
 	"github.com/tracking/analysis/internal/middleware"
 	"github.com/tracking/analysis/internal/models"
+	"github.com/tracking/analysis/internal/privacy"
 	"github.com/tracking/analysis/internal/repo"
+	"github.com/tracking/analysis/internal/webhook"
+)
+
+const (
+	// maxEventAge is the oldest event accepted on ingest. It matches the
+	// SDK outbox, which drops batches older than a day.
+	maxEventAge = 24 * time.Hour
+	// eventBatchDedupTTL covers the SDK's retry horizon for unsent batches.
+	eventBatchDedupTTL = 24 * time.Hour
 )
 
 type TrackHandlers struct {
 	Config      *config.Config
 	Redis       *redis.Client
 	PrivKey     *rsa.PrivateKey
-	ClickRepo   *repo.ClickRepo
-	EventRepo   *repo.EventRepo
+	TrackerRepo *repo.TrackerRepo
+	ClickRepo   repo.ClickStore
+	EventRepo   repo.EventStore
+	SessionRepo *repo.SessionRepo
 	SiteRepo    *repo.SiteRepo
 	TokenRepo   *repo.TokenRepo
 	GeoResolver *geo.Resolver
+	Webhooks    *webhook.Service
+	Bot         *bot.Pipeline
 }
 
 // track.collectClick
@@ -34,11 +52,11 @@ func (h *TrackHandlers) CollectClick(ctx context.Context, params json.RawMessage
 		return nil, NewRPCError(ErrCodeInvalidParams, err.Error())
 	}
 
-	ip, ua, lang, referer, secFetch := extractClientInfo(ctx)
+	ip, ua, lang, referer := extractClientInfo(ctx)
 
 	// Rate limiting
 	if err := middleware.CheckRateLimit(ctx, h.Redis, &h.Config.RateLimitConfiguration, ip, ua, ""); err != nil {
-		return nil, NewRPCError(ErrCodeRateLimited, nil)
+		return nil, rateLimitedError(err)
 	}
 
 	// Anti-replay
@@ -70,40 +88,78 @@ func (h *TrackHandlers) CollectClick(ctx context.Context, params json.RawMessage
 	if err != nil {
 		return nil, NewRPCError(ErrCodeInvalidToken, nil)
 	}
+	if tkn.Expired(time.Now()) {
+		return nil, NewRPCError(ErrCodeExpiredToken, nil)
+	}
 
-	// Bot detection
-	recentHits := bot.CountRecentHits(ctx, h.Redis, ip)
-	botScore := bot.Score(ua, lang, secFetch, referer, recentHits)
-	blocked, suspected := bot.IsBot(botScore, &h.Config.BotConfiguration)
-	if blocked && h.Config.BotConfiguration.BlockMode == "reject" {
-		return nil, NewRPCError(ErrCodeBotBlocked, nil)
+	// Bot detection; verified search engine crawlers are marked, never
+	// rejected. The page's automation checks are scored, not stored.
+	country := h.GeoResolver.Country(ip)
+	env := payload.Env
+	if env == nil {
+		env = models.JSONMap{}
+	}
+	verdict := h.scoreBot(ctx, ip, referer, country, env)
+	delete(env, bot.ChecksKey)
+	blocked, suspected := bot.IsBot(verdict.Score, &h.Config.BotConfiguration)
+	if blocked {
+		if err := h.Webhooks.RecordBotBlocked(ctx, webhook.Scope{TrackerID: tkn.TrackerID}); err != nil {
+			log.Printf("CollectClick: bot spike webhook error: %v", err)
+		}
+		if h.Config.BotConfiguration.BlockMode == "reject" && verdict.Crawler == "" {
+			return nil, NewRPCError(ErrCodeBotBlocked, nil)
+		}
 	}
 
 	// Dedup check
 	if dedup.CheckClickDedup(ctx, h.Redis, &h.Config.SecurityConfiguration, tkn.TrackerID, tkn.ChannelID, payload.VisitorID) {
+		if err := dedup.RecordHit(ctx, h.Redis, tkn.TrackerID, tkn.CampaignID, tkn.ChannelID, time.Now()); err != nil {
+			log.Printf("CollectClick: dedup hit record error: %v", err)
+		}
 		return map[string]any{"target_url": "", "click_id": "", "dedup": true}, nil
 	}
 
+	// Apply the tracker's privacy settings; geo and bot scoring above use the full values
+	privacySettings := privacy.Strictest
+	if tracker, err := h.TrackerRepo.GetByID(tkn.TrackerID); err == nil {
+		privacySettings = privacy.Settings{IPMode: tracker.IPMode, DropUA: tracker.DropUA}
+	} else {
+		log.Printf("CollectClick: tracker lookup error, storing with the strictest privacy mode: %v", err)
+	}
+	now := time.Now()
+	storedIP, storedUA := privacy.Apply(privacySettings, ip, ua, h.Config.SecurityConfiguration.TokenSecret, now)
+	browser, osName := repo.ParseUA(ua)
+
 	// Write click
 	click := &models.Click{
-		TS:           time.Now(),
+		TS:           now,
 		TrackerID:    tkn.TrackerID,
 		CampaignID:   tkn.CampaignID,
 		ChannelID:    tkn.ChannelID,
 		TargetID:     tkn.TargetID,
 		VisitorID:    payload.VisitorID,
-		IP:           ip,
-		Country:      h.GeoResolver.Country(ip),
-		UA:           ua,
+		IP:           storedIP,
+		Country:      country,
+		UA:           storedUA,
+		Browser:      browser,
+		OS:           osName,
 		Lang:         lang,
 		Referer:      referer,
-		Props:        payload.Env,
+		Props:        verdict.Annotate(env),
 		SuspectedBot: suspected,
 		IsBot:        blocked,
 	}
 	if err := h.ClickRepo.Create(click); err != nil {
 		return nil, NewRPCError(ErrCodeDBError, nil)
 	}
+	if err := live.Publish(ctx, h.Redis, live.ClickMessage(click)); err != nil {
+		log.Printf("CollectClick: live publish error: %v", err)
+	}
+	if !click.IsBot {
+		if err := h.Webhooks.Emit(ctx, webhook.KindClick, webhook.Scope{TrackerID: click.TrackerID}, webhook.ClickData(click)); err != nil {
+			log.Printf("CollectClick: click webhook error: %v", err)
+		}
+	}
 
 	return map[string]any{"click_id": click.ID, "target_id": tkn.TargetID}, nil
 }
@@ -115,11 +171,11 @@ func (h *TrackHandlers) CollectEvents(ctx context.Context, params json.RawMessag
 		return nil, NewRPCError(ErrCodeInvalidParams, err.Error())
 	}
 
-	ip, ua, lang, _, secFetch := extractClientInfo(ctx)
+	ip, ua, lang, _ := extractClientInfo(ctx)
 
 	// Rate limiting
 	if err := middleware.CheckRateLimit(ctx, h.Redis, &h.Config.RateLimitConfiguration, ip, ua, ""); err != nil {
-		return nil, NewRPCError(ErrCodeRateLimited, nil)
+		return nil, rateLimitedError(err)
 	}
 
 	// Anti-replay
@@ -139,14 +195,19 @@ func (h *TrackHandlers) CollectEvents(ctx context.Context, params json.RawMessag
 	// Parse decrypted payload
 	var payload struct {
 		SiteKey   string `json:"site_key"`
+		BatchID   string `json:"batch_id"`
 		VisitorID string `json:"visitor_id"`
 		SessionID string `json:"session_id"`
+		SentAt    int64  `json:"sent_at"` // client clock at send, Unix ms
 		Events    []struct {
+			TS       int64          `json:"ts"`        // client clock at capture, Unix ms
+			OffsetMs *int64         `json:"offset_ms"` // monotonic ms from capture to send
 			Type     string         `json:"type"`
 			URL      string         `json:"url"`
 			Title    string         `json:"title"`
 			Referrer string         `json:"referrer"`
 			Props    models.JSONMap `json:"props"`
+			Consent  bool           `json:"consent"`
 		} `json:"events"`
 	}
 	if err := json.Unmarshal(plaintext, &payload); err != nil {
@@ -159,46 +220,136 @@ func (h *TrackHandlers) CollectEvents(ctx context.Context, params json.RawMessag
 		return nil, NewRPCErrorWithMessage(ErrCodeInvalidParams, "invalid site_key")
 	}
 
-	// Bot detection
-	recentHits := bot.CountRecentHits(ctx, h.Redis, ip)
-	botScore := bot.Score(ua, lang, secFetch, "", recentHits)
-	blocked, suspected := bot.IsBot(botScore, &h.Config.BotConfiguration)
-	if blocked && h.Config.BotConfiguration.BlockMode == "reject" {
-		return nil, NewRPCError(ErrCodeBotBlocked, nil)
+	// Bot detection; the SDK's requests carry the page as referer, so
+	// frequency is judged as if none was sent
+	country := h.GeoResolver.Country(ip)
+	verdict := h.scoreBot(ctx, ip, "", country, nil)
+	blocked, suspected := bot.IsBot(verdict.Score, &h.Config.BotConfiguration)
+	if blocked {
+		if err := h.Webhooks.RecordBotBlocked(ctx, webhook.Scope{SiteID: site.ID}); err != nil {
+			log.Printf("CollectEvents: bot spike webhook error: %v", err)
+		}
+		if h.Config.BotConfiguration.BlockMode == "reject" && verdict.Crawler == "" {
+			return nil, NewRPCError(ErrCodeBotBlocked, nil)
+		}
 	}
 
-	// Build events
-	country := h.GeoResolver.Country(ip)
-	events := make([]models.Event, 0, len(payload.Events))
+	// Build events; geo and bot scoring above use the full values before
+	// the site's privacy settings are applied
 	now := time.Now()
+	privacySettings := privacy.Settings{IPMode: site.IPMode, DropUA: site.DropUA}
+	storedIP, storedUA := privacy.Apply(privacySettings, ip, ua, h.Config.SecurityConfiguration.TokenSecret, now)
+	browser, osName := repo.ParseUA(ua)
+	sentAt := time.Unix(envelope.TS, 0)
+	if payload.SentAt > 0 {
+		sentAt = time.UnixMilli(payload.SentAt)
+	}
+	window := time.Duration(h.Config.SecurityConfiguration.TSWindowSeconds) * time.Second
+	events := make([]models.Event, 0, len(payload.Events))
+	rejected := 0
 	for _, e := range payload.Events {
+		ts, ok := correctEventTime(now, sentAt, e.TS, e.OffsetMs, window, maxEventAge)
+		if !ok {
+			rejected++
+			continue
+		}
+		var clientTS *time.Time
+		if e.TS > 0 {
+			t := time.UnixMilli(e.TS)
+			clientTS = &t
+		}
+		// Without consent only the session ID may identify the visitor
+		visitorID := ""
+		if e.Consent {
+			visitorID = payload.VisitorID
+		}
 		events = append(events, models.Event{
-			TS:           now,
+			TS:           ts,
+			ClientTS:     clientTS,
+			ServerTS:     now,
 			SiteID:       site.ID,
 			Type:         e.Type,
-			VisitorID:    payload.VisitorID,
+			VisitorID:    visitorID,
 			SessionID:    payload.SessionID,
 			URL:          e.URL,
 			Title:        e.Title,
 			Referrer:     e.Referrer,
-			IP:           ip,
+			IP:           storedIP,
 			Country:      country,
-			UA:           ua,
+			UA:           storedUA,
+			Browser:      browser,
+			OS:           osName,
 			Lang:         lang,
-			Props:        e.Props,
+			Props:        verdict.Annotate(e.Props),
+			Consent:      e.Consent,
 			SuspectedBot: suspected,
 			IsBot:        blocked,
 		})
 	}
 
+	if len(events) == 0 {
+		return map[string]any{"ok": true, "server_time": now.Unix(), "rejected": rejected}, nil
+	}
+
+	// SDK retries reuse the batch ID; acknowledge duplicates without storing them
+	if !dedup.ClaimEventBatch(ctx, h.Redis, site.ID, payload.BatchID, eventBatchDedupTTL) {
+		return map[string]any{"ok": true, "server_time": now.Unix(), "dedup": true}, nil
+	}
 	if err := h.EventRepo.BatchCreate(events); err != nil {
+		dedup.ReleaseEventBatch(ctx, h.Redis, site.ID, payload.BatchID)
 		return nil, NewRPCError(ErrCodeDBError, nil)
 	}
+	// Sessions are derived data and can be rebuilt, so a failure here must
+	// not fail the batch the client will otherwise retry.
+	if err := h.SessionRepo.UpsertFromEvents(events); err != nil {
+		log.Printf("CollectEvents: session upsert error: %v", err)
+	}
+	msgs := make([]live.Message, len(events))
+	for i := range events {
+		msgs[i] = live.EventMessage(&events[i])
+	}
+	if err := live.Publish(ctx, h.Redis, msgs...); err != nil {
+		log.Printf("CollectEvents: live publish error: %v", err)
+	}
+	for i := range events {
+		e := &events[i]
+		if e.IsBot {
+			continue
+		}
+		scope := webhook.Scope{SiteID: e.SiteID, EventType: e.Type}
+		if err := h.Webhooks.Emit(ctx, webhook.KindConversion, scope, webhook.ConversionData(e)); err != nil {
+			log.Printf("CollectEvents: conversion webhook error: %v", err)
+		}
+	}
+
+	return map[string]any{"ok": true, "server_time": now.Unix(), "rejected": rejected}, nil
+}
 
-	return map[string]any{"ok": true, "server_time": now.Unix()}, nil
+// rateLimitedError reports a rate limit with a retry_after hint in seconds
+// that the SDK uses to schedule its next attempt.
+func rateLimitedError(err error) *RPCError {
+	var data any
+	var rlErr *middleware.RateLimitError
+	if errors.As(err, &rlErr) {
+		data = map[string]int{"retry_after": int(rlErr.RetryAfter.Seconds())}
+	}
+	return NewRPCError(ErrCodeRateLimited, data)
+}
+
+// scoreBot runs the bot pipeline over the request behind ctx. env is the
+// click page's report, nil for events.
+func (h *TrackHandlers) scoreBot(ctx context.Context, ip, referer, country string, env models.JSONMap) bot.Verdict {
+	var req *http.Request
+	if c := GinContext(ctx); c != nil {
+		req = c.Request
+	}
+	r := bot.FromHTTP(req)
+	r.IP, r.Referer, r.Country, r.Env = ip, referer, country, env
+	r.RecentHits = bot.CountRecentHits(ctx, h.Redis, ip)
+	return h.Bot.Score(ctx, r)
 }
 
-func extractClientInfo(ctx context.Context) (ip, ua, lang, referer, secFetch string) {
+func extractClientInfo(ctx context.Context) (ip, ua, lang, referer string) {
 	c := GinContext(ctx)
 	if c == nil {
 		return
@@ -207,6 +358,5 @@ func extractClientInfo(ctx context.Context) (ip, ua, lang, referer, secFetch str
 	ua = c.GetHeader("User-Agent")
 	lang = c.GetHeader("Accept-Language")
 	referer = c.GetHeader("Referer")
-	secFetch = c.GetHeader("Sec-Fetch-Mode")
 	return
 }
@@ -0,0 +1,62 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronErrors(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "x * * * *"} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("ParseCron(%q): expected error", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	tests := []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"*/15 * * * *", time.Date(2024, 1, 1, 10, 7, 30, 0, time.UTC), time.Date(2024, 1, 1, 10, 15, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"0 9 * * mon-fri", time.Date(2024, 1, 5, 10, 0, 0, 0, time.UTC), time.Date(2024, 1, 8, 9, 0, 0, 0, time.UTC)},
+		{"0 0 1 jan,jul *", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 7, 0, 0, 0, 0, time.UTC)},
+		// Day of month and day of week both restricted: either matches.
+		{"0 0 15 * fri", time.Date(2024, 3, 9, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 0 20 * fri", time.Date(2024, 3, 9, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)},
+		// 02:30 does not exist in Berlin on 2024-03-31.
+		{"30 2 * * *", time.Date(2024, 3, 30, 3, 0, 0, 0, berlin), time.Date(2024, 4, 1, 2, 30, 0, 0, berlin)},
+		{"0 8 * * *", time.Date(2024, 3, 30, 9, 0, 0, 0, berlin), time.Date(2024, 3, 31, 8, 0, 0, 0, berlin)},
+	}
+	for _, tt := range tests {
+		c, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("ParseCron(%q): %v", tt.expr, err)
+		}
+		if got := c.Next(tt.from); !got.Equal(tt.want) {
+			t.Errorf("%q after %v = %v, want %v", tt.expr, tt.from, got, tt.want)
+		}
+	}
+
+	c, _ := ParseCron("0 0 30 2 *")
+	if got := c.Next(time.Now()); !got.IsZero() {
+		t.Errorf("impossible schedule matched %v", got)
+	}
+}
+
+func TestReportRange(t *testing.T) {
+	ny, _ := time.LoadLocation("America/New_York")
+	now := time.Date(2024, 3, 11, 13, 0, 0, 0, time.UTC) // Monday 09:00 in New York
+	start, end := ReportRange(7, now, ny)
+	if got := start.Format(time.RFC3339); got != "2024-03-04T00:00:00-05:00" {
+		t.Errorf("start = %s", got)
+	}
+	if got := end.Format("2006-01-02 15:04:05 MST"); got != "2024-03-10 23:59:59 EDT" {
+		t.Errorf("end = %s", got)
+	}
+}
@@ -0,0 +1,267 @@
+package schedule
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tracking/analysis/internal/repo"
+)
+
+// Report formats.
+const (
+	FormatCSV  = "csv"
+	FormatXLSX = "xlsx"
+	FormatHTML = "html"
+)
+
+// ContentTypes maps each format to the MIME type of its file.
+var ContentTypes = map[string]string{
+	FormatCSV:  "text/csv; charset=utf-8",
+	FormatXLSX: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	FormatHTML: "text/html; charset=utf-8",
+}
+
+// Section is one table of a report: the summary, a daily series or a top-N list.
+type Section struct {
+	Name    string
+	Columns [2]string
+	Rows    [][2]string
+}
+
+// Sections flattens an admin.stats.clicks or admin.stats.events payload
+// into tables: summary first, then daily series, then the top-N lists,
+// each group in key order. Values of other types are skipped.
+func Sections(stats map[string]any) []Section {
+	var out []Section
+	if summary, ok := stats["summary"].(map[string]any); ok {
+		s := Section{Name: "summary", Columns: [2]string{"metric", "value"}}
+		keys := make([]string, 0, len(summary))
+		for k := range summary {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			s.Rows = append(s.Rows, [2]string{k, formatCell(summary[k])})
+		}
+		out = append(out, s)
+	}
+
+	keys := make([]string, 0, len(stats))
+	for k := range stats {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var series, lists []Section
+	for _, k := range keys {
+		switch v := stats[k].(type) {
+		case []repo.DailyCount:
+			s := Section{Name: k, Columns: [2]string{"date", "count"}}
+			for _, d := range v {
+				date := d.Date
+				if len(date) > 10 {
+					date = date[:10] // DATE columns may scan as RFC 3339 timestamps
+				}
+				s.Rows = append(s.Rows, [2]string{date, strconv.FormatInt(d.Count, 10)})
+			}
+			series = append(series, s)
+		case []repo.HourlyCount:
+			s := Section{Name: k, Columns: [2]string{"hour", "count"}}
+			for _, h := range v {
+				s.Rows = append(s.Rows, [2]string{strconv.Itoa(h.Hour), strconv.FormatInt(h.Count, 10)})
+			}
+			series = append(series, s)
+		case []repo.NameCount:
+			s := Section{Name: k, Columns: [2]string{"name", "count"}}
+			for _, n := range v {
+				s.Rows = append(s.Rows, [2]string{n.Name, strconv.FormatInt(n.Count, 10)})
+			}
+			lists = append(lists, s)
+		case []repo.GroupCount:
+			s := Section{Name: k, Columns: [2]string{"name", "count"}}
+			for _, g := range v {
+				name := g.Name
+				if name == "" {
+					name = g.GroupID
+				}
+				s.Rows = append(s.Rows, [2]string{name, strconv.FormatInt(g.Count, 10)})
+			}
+			lists = append(lists, s)
+		}
+	}
+	return append(append(out, series...), lists...)
+}
+
+func formatCell(v any) string {
+	switch n := v.(type) {
+	case float64:
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	case int64:
+		return strconv.FormatInt(n, 10)
+	case int:
+		return strconv.Itoa(n)
+	}
+	return fmt.Sprint(v)
+}
+
+// Title heads a rendered report.
+type Title struct {
+	Name   string
+	Source string
+	Scope  string
+	Start  string
+	End    string
+}
+
+// Render writes sections in format.
+func Render(format string, title Title, sections []Section) ([]byte, error) {
+	switch format {
+	case FormatCSV:
+		return renderCSV(sections)
+	case FormatXLSX:
+		return renderXLSX(sections)
+	case FormatHTML:
+		return renderHTML(title, sections)
+	}
+	return nil, fmt.Errorf("unsupported format: %s", format)
+}
+
+// renderCSV writes one long table with section, name and value columns,
+// which pivots cleanly in a spreadsheet.
+func renderCSV(sections []Section) ([]byte, error) {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	w.Write([]string{"section", "name", "value"})
+	for _, s := range sections {
+		for _, r := range s.Rows {
+			w.Write([]string{s.Name, r[0], r[1]})
+		}
+	}
+	w.Flush()
+	return b.Bytes(), w.Error()
+}
+
+const xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+%s</Types>`
+
+const xlsxRootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`
+
+// renderXLSX writes a minimal Office Open XML workbook with one sheet per
+// section. Cells are inline strings, or numbers where the value parses as one.
+func renderXLSX(sections []Section) ([]byte, error) {
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	write := func(name, content string) error {
+		f, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		_, err = f.Write([]byte(content))
+		return err
+	}
+
+	var overrides, sheets, rels strings.Builder
+	used := make(map[string]bool)
+	for i, s := range sections {
+		n := i + 1
+		fmt.Fprintf(&overrides, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`+"\n", n)
+		fmt.Fprintf(&sheets, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, xmlEscape(sheetName(s.Name, used)), n, n)
+		fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, n, n)
+		if err := write(fmt.Sprintf("xl/worksheets/sheet%d.xml", n), worksheetXML(s)); err != nil {
+			return nil, err
+		}
+	}
+	files := []struct{ name, content string }{
+		{"[Content_Types].xml", fmt.Sprintf(xlsxContentTypes, overrides.String())},
+		{"_rels/.rels", xlsxRootRels},
+		{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>` + sheets.String() + `</sheets></workbook>`},
+		{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` + rels.String() + `</Relationships>`},
+	}
+	for _, f := range files {
+		if err := write(f.name, f.content); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// sheetName fits name to Excel's 31-character limit, keeping names unique.
+func sheetName(name string, used map[string]bool) string {
+	if len(name) > 31 {
+		name = name[:31]
+	}
+	base := name
+	for i := 2; used[name]; i++ {
+		suffix := "_" + strconv.Itoa(i)
+		name = base[:min(len(base), 31-len(suffix))] + suffix
+	}
+	used[name] = true
+	return name
+}
+
+func worksheetXML(s Section) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	rows := append([][2]string{s.Columns}, s.Rows...)
+	for i, r := range rows {
+		fmt.Fprintf(&b, `<row r="%d">`, i+1)
+		for j, v := range r {
+			ref := fmt.Sprintf("%c%d", 'A'+j, i+1)
+			if _, err := strconv.ParseFloat(v, 64); err == nil && i > 0 {
+				fmt.Fprintf(&b, `<c r="%s"><v>%s</v></c>`, ref, v)
+			} else {
+				fmt.Fprintf(&b, `<c r="%s" t="inlineStr"><is><t>%s</t></is></c>`, ref, xmlEscape(v))
+			}
+		}
+		b.WriteString(`</row>`)
+	}
+	b.WriteString(`</sheetData></worksheet>`)
+	return b.String()
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+var htmlReport = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.Title.Name}}</title></head>
+<body style="font-family:sans-serif;color:#222">
+<h2>{{.Title.Name}}</h2>
+<p>{{.Title.Source}}{{if .Title.Scope}} for {{.Title.Scope}}{{end}}, {{.Title.Start}} to {{.Title.End}}</p>
+{{range .Sections}}<h3>{{.Name}}</h3>
+<table cellpadding="4" style="border-collapse:collapse">
+<tr>{{range .Columns}}<th align="left" style="border-bottom:1px solid #ccc">{{.}}</th>{{end}}</tr>
+{{range .Rows}}<tr><td>{{index . 0}}</td><td align="right">{{index . 1}}</td></tr>
+{{end}}</table>
+{{end}}</body></html>
+`))
+
+func renderHTML(title Title, sections []Section) ([]byte, error) {
+	var b bytes.Buffer
+	err := htmlReport.Execute(&b, struct {
+		Title    Title
+		Sections []Section
+	}{title, sections})
+	return b.Bytes(), err
+}
@@ -0,0 +1,100 @@
+package schedule
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/tracking/analysis/internal/repo"
+)
+
+func sampleStats() map[string]any {
+	return map[string]any{
+		"summary":       map[string]any{"total_clicks": int64(42), "bot_rate": 2.5},
+		"daily":         []repo.DailyCount{{Date: "2024-03-04T00:00:00Z", Count: 40}, {Date: "2024-03-05", Count: 2}},
+		"top_campaigns": []repo.GroupCount{{GroupID: "c-1", Name: "Spring <sale>", Count: 30}},
+		"timezone":      "UTC",
+	}
+}
+
+func TestSections(t *testing.T) {
+	sections := Sections(sampleStats())
+	var names []string
+	for _, s := range sections {
+		names = append(names, s.Name)
+	}
+	if got := strings.Join(names, ","); got != "summary,daily,top_campaigns" {
+		t.Fatalf("sections = %s", got)
+	}
+	if sections[0].Rows[0] != [2]string{"bot_rate", "2.5"} {
+		t.Errorf("summary row = %v", sections[0].Rows[0])
+	}
+	if sections[1].Rows[0][0] != "2024-03-04" {
+		t.Errorf("daily date = %q", sections[1].Rows[0][0])
+	}
+}
+
+func TestRenderCSV(t *testing.T) {
+	out, err := Render(FormatCSV, Title{}, Sections(sampleStats()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"section,name,value", "summary,total_clicks,42", "daily,2024-03-05,2", "top_campaigns,Spring <sale>,30"} {
+		if !strings.Contains(string(out), line+"\n") {
+			t.Errorf("csv missing %q:\n%s", line, out)
+		}
+	}
+}
+
+func TestRenderXLSX(t *testing.T) {
+	out, err := Render(FormatXLSX, Title{}, Sections(sampleStats()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(out), int64(len(out)))
+	if err != nil {
+		t.Fatalf("not a zip: %v", err)
+	}
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, _ := f.Open()
+		b, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(b)
+	}
+	if !strings.Contains(files["xl/workbook.xml"], `<sheet name="top_campaigns" sheetId="3"`) {
+		t.Errorf("workbook sheets: %s", files["xl/workbook.xml"])
+	}
+	if !strings.Contains(files["xl/worksheets/sheet3.xml"], "Spring &lt;sale&gt;") {
+		t.Errorf("sheet not escaped: %s", files["xl/worksheets/sheet3.xml"])
+	}
+	if !strings.Contains(files["xl/worksheets/sheet3.xml"], `<c r="B2"><v>30</v></c>`) {
+		t.Errorf("count not numeric: %s", files["xl/worksheets/sheet3.xml"])
+	}
+}
+
+func TestRenderHTML(t *testing.T) {
+	title := Title{Name: "Weekly", Source: "clicks", Scope: "tracker tr-1", Start: "2024-03-04", End: "2024-03-10"}
+	out, err := Render(FormatHTML, title, Sections(sampleStats()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"clicks for tracker tr-1, 2024-03-04 to 2024-03-10", "Spring &lt;sale&gt;", ">42<"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("html missing %q", want)
+		}
+	}
+}
+
+func TestSheetName(t *testing.T) {
+	used := map[string]bool{}
+	long := strings.Repeat("a", 40)
+	if got := sheetName(long, used); len(got) != 31 {
+		t.Errorf("len = %d", len(got))
+	}
+	if got := sheetName(long, used); got != strings.Repeat("a", 29)+"_2" {
+		t.Errorf("duplicate = %q", got)
+	}
+}
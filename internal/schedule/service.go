@@ -0,0 +1,237 @@
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/tracking/analysis/internal/models"
+	"github.com/tracking/analysis/internal/notify"
+	"github.com/tracking/analysis/internal/repo"
+)
+
+// Run triggers.
+const (
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+)
+
+// Run statuses.
+const (
+	StatusSent   = "sent"
+	StatusFailed = "failed"
+)
+
+const tickInterval = time.Minute
+
+// Scope narrows a report to a tracker, campaign, channel or site.
+type Scope struct {
+	TrackerID  string
+	CampaignID string
+	ChannelID  string
+	SiteID     string
+}
+
+// StatsFunc returns the admin.stats.clicks or admin.stats.events payload
+// for source over [start, end], bucketed by days in tz.
+type StatsFunc func(source string, start, end time.Time, tz string, scope Scope) (map[string]any, error)
+
+type Service struct {
+	Repo   *repo.ScheduledReportRepo
+	Stats  StatsFunc
+	Mailer *notify.Mailer
+}
+
+func NewService(reportRepo *repo.ScheduledReportRepo, stats StatsFunc, mailer *notify.Mailer) *Service {
+	return &Service{Repo: reportRepo, Stats: stats, Mailer: mailer}
+}
+
+// NextRun returns the first time after after that expr matches in tz, or
+// nil if it never does.
+func NextRun(expr, tz string, after time.Time) (*time.Time, error) {
+	c, err := ParseCron(expr)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, err
+	}
+	next := c.Next(after.In(loc))
+	if next.IsZero() {
+		return nil, nil
+	}
+	next = next.UTC()
+	return &next, nil
+}
+
+// ReportRange covers the rangeDays complete days before now in loc, so a
+// report sent on Monday morning with a range of 7 covers the previous
+// Monday to Sunday.
+func ReportRange(rangeDays int, now time.Time, loc *time.Location) (time.Time, time.Time) {
+	now = now.In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	return today.AddDate(0, 0, -rangeDays), today.Add(-time.Nanosecond)
+}
+
+// Run sends due reports until ctx is done.
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.sendDue(now)
+		}
+	}
+}
+
+func (s *Service) sendDue(now time.Time) {
+	reports, err := s.Repo.ListDue(now)
+	if err != nil {
+		slog.Warn("scheduled report list failed", "error", err)
+		return
+	}
+	for i := range reports {
+		r := &reports[i]
+		next, err := NextRun(r.Cron, r.Timezone, now)
+		if err != nil || next == nil {
+			// Validated on save, so only a zone dropped from tzdata or a
+			// schedule that has run out gets here.
+			slog.Error("scheduled report has no next run, deactivating", "error", err, "report", r.ID)
+			r.Active, r.NextRunAt = false, nil
+			if err := s.Repo.Update(r); err != nil {
+				slog.Error("scheduled report write failed", "error", err, "report", r.ID)
+			}
+			continue
+		}
+		// Claiming moves next_run_at, so each run is sent by one replica
+		// and a missed run is sent once rather than once per missed slot.
+		ok, err := s.Repo.Claim(r.ID, *r.NextRunAt, *next, now)
+		if err != nil || !ok {
+			continue
+		}
+		if _, err := s.Send(r, TriggerSchedule, now); err != nil {
+			slog.Warn("scheduled report failed", "error", err, "report", r.ID)
+		}
+	}
+}
+
+// Send generates report for the range ending before now, emails it and
+// records the run. The run is returned even when sending failed.
+func (s *Service) Send(report *models.ScheduledReport, trigger string, now time.Time) (*models.ScheduledReportRun, error) {
+	run := &models.ScheduledReportRun{
+		ReportID:   report.ID,
+		Trigger:    trigger,
+		Status:     StatusSent,
+		Recipients: report.Recipients,
+	}
+	mail, err := s.generate(report, run, now)
+	if err == nil {
+		err = s.Mailer.Send(*mail)
+	}
+	if err != nil {
+		run.Status, run.Error = StatusFailed, err.Error()
+	}
+	if dbErr := s.Repo.CreateRun(run); dbErr != nil {
+		slog.Error("scheduled report run write failed", "error", dbErr, "report", report.ID)
+	}
+	return run, err
+}
+
+// generate renders report into run and builds the mail carrying it: HTML
+// reports are the mail body, CSV and XLSX reports are attached.
+func (s *Service) generate(report *models.ScheduledReport, run *models.ScheduledReportRun, now time.Time) (*notify.Mail, error) {
+	loc, err := time.LoadLocation(report.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	start, end := ReportRange(report.RangeDays, now, loc)
+	run.StartDate, run.EndDate = start.Format("2006-01-02"), end.Format("2006-01-02")
+
+	scope := Scope{TrackerID: report.TrackerID, CampaignID: report.CampaignID, ChannelID: report.ChannelID, SiteID: report.SiteID}
+	stats, err := s.Stats(report.Source, start, end, loc.String(), scope)
+	if err != nil {
+		return nil, fmt.Errorf("stats: %w", err)
+	}
+	sections := Sections(stats)
+	title := Title{Name: report.Name, Source: report.Source, Scope: scopeText(scope), Start: run.StartDate, End: run.EndDate}
+	content, err := Render(report.Format, title, sections)
+	if err != nil {
+		return nil, err
+	}
+	run.Filename = fmt.Sprintf("%s-%s-%s.%s", slug(report.Name), run.StartDate, run.EndDate, report.Format)
+	run.ContentType = ContentTypes[report.Format]
+	run.Content, run.Size = content, len(content)
+
+	mail := &notify.Mail{
+		To:      report.Recipients,
+		Subject: fmt.Sprintf("%s: %s to %s", report.Name, run.StartDate, run.EndDate),
+		Text:    summaryText(title, sections),
+	}
+	if report.Format == FormatHTML {
+		mail.HTML = string(content)
+	} else {
+		mail.Attachments = []notify.Attachment{{Filename: run.Filename, ContentType: run.ContentType, Data: content}}
+	}
+	return mail, nil
+}
+
+// summaryText is the plain-text mail body: the heading and summary metrics.
+func summaryText(title Title, sections []Section) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n\n%s", title.Name, title.Source)
+	if title.Scope != "" {
+		fmt.Fprintf(&b, " for %s", title.Scope)
+	}
+	fmt.Fprintf(&b, ", %s to %s\n", title.Start, title.End)
+	for _, s := range sections {
+		if s.Name != "summary" {
+			continue
+		}
+		b.WriteString("\n")
+		for _, r := range s.Rows {
+			fmt.Fprintf(&b, "%s: %s\n", r[0], r[1])
+		}
+	}
+	return b.String()
+}
+
+func scopeText(scope Scope) string {
+	var parts []string
+	for _, p := range []struct{ name, id string }{
+		{"tracker", scope.TrackerID},
+		{"campaign", scope.CampaignID},
+		{"channel", scope.ChannelID},
+		{"site", scope.SiteID},
+	} {
+		if p.id != "" {
+			parts = append(parts, p.name+" "+p.id)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// slug lowercases name and keeps letters and digits for use in a filename.
+func slug(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	s := strings.TrimSuffix(b.String(), "-")
+	if s == "" {
+		return "report"
+	}
+	return s
+}
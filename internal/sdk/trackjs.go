@@ -14,8 +14,20 @@ func GenerateSDK(publicKeyPEM, kid, rpcEndpoint string) string {
     publicKeyPEM: "%s",
     rpcEndpoint: "%s/rpc"
   };
+  CONFIG.beaconEndpoint = CONFIG.rpcEndpoint + "/beacon";
+
+  // Until consent is granted the SDK runs cookieless: only the
+  // session-scoped ID is used and no visitor ID is persisted.
+  function hasConsent() {
+    try {
+      return localStorage.getItem("_tk_consent") === "granted";
+    } catch (e) {
+      return false;
+    }
+  }
 
   function getVisitorID() {
+    if (!hasConsent()) return "";
     var id = localStorage.getItem("_tk_vid");
     if (!id) {
       id = crypto.randomUUID();
@@ -69,14 +81,18 @@ func GenerateSDK(publicKeyPEM, kid, rpcEndpoint string) string {
     };
   }
 
-  async function sendRPC(method, params) {
+  async function rpcBody(method, params) {
     var encrypted = await encrypt(params);
-    var body = JSON.stringify({
+    return JSON.stringify({
       jsonrpc: "2.0",
       method: method,
       params: encrypted,
       id: crypto.randomUUID()
     });
+  }
+
+  async function sendRPC(method, params) {
+    var body = await rpcBody(method, params);
     var resp = await fetch(CONFIG.rpcEndpoint, {
       method: "POST",
       headers: { "Content-Type": "application/json" },
@@ -85,42 +101,425 @@ func GenerateSDK(publicKeyPEM, kid, rpcEndpoint string) string {
     return resp.json();
   }
 
+  // Event batches are persisted in an outbox before they are sent and are
+  // removed only once the server accepts them, so events survive page
+  // unloads, network errors and rate limiting. The server deduplicates
+  // retries by batch_id.
+  var OUTBOX_KEY = "_tk_outbox";
+  var OUTBOX_MAX_BATCHES = 50;
+  var OUTBOX_MAX_AGE_MS = 24 * 60 * 60 * 1000;
+  var RETRY_BASE_MS = 1000;
+  var RETRY_MAX_MS = 5 * 60 * 1000;
+  var BEACON_CONFIRM_MS = 10 * 1000;
+  // Prepared beacon bodies carry the time they were encrypted, which the
+  // server checks against its timestamp window; older ones are not sent.
+  var BEACON_BODY_MAX_AGE_MS = 60 * 1000;
+  // rate_limited, database_error and internal_error are worth retrying;
+  // any other error means the batch will never be accepted.
+  var RETRYABLE_CODES = { 4003: true, 5001: true, "-32603": true };
+
+  function loadOutbox() {
+    try {
+      var batches = JSON.parse(localStorage.getItem(OUTBOX_KEY) || "[]");
+      return Array.isArray(batches) ? batches : [];
+    } catch (e) {
+      return [];
+    }
+  }
+
+  function saveOutbox(batches) {
+    try {
+      if (batches.length === 0) {
+        localStorage.removeItem(OUTBOX_KEY);
+      } else {
+        localStorage.setItem(OUTBOX_KEY, JSON.stringify(batches));
+      }
+    } catch (e) {}
+  }
+
+  function removeBatch(batchID) {
+    saveOutbox(loadOutbox().filter(function(b) { return b.batch_id !== batchID; }));
+  }
+
+  function updateBatch(batch) {
+    saveOutbox(loadOutbox().map(function(b) { return b.batch_id === batch.batch_id ? batch : b; }));
+  }
+
+  // Identifies this page load; monotonic timestamps are only comparable
+  // within the page that recorded them.
+  var PAGE_ID = crypto.randomUUID();
+
+  function monotonicNow() {
+    return window.performance && performance.now ? performance.now() : null;
+  }
+
+  // Adds the send-time fields: sent_at on the batch and, for events
+  // captured on this page, offset_ms measured on the monotonic clock.
+  function batchParams(batch) {
+    var mono = monotonicNow();
+    return {
+      site_key: batch.site_key,
+      batch_id: batch.batch_id,
+      visitor_id: batch.visitor_id,
+      session_id: batch.session_id,
+      sent_at: Date.now(),
+      events: batch.events.map(function(e) {
+        var out = {};
+        for (var k in e) {
+          if (k !== "mono" && k !== "page") out[k] = e[k];
+        }
+        if (e.page === PAGE_ID && e.mono !== null && mono !== null) {
+          out.offset_ms = Math.max(0, Math.round(mono - e.mono));
+        }
+        return out;
+      })
+    };
+  }
+
+  // Stamps an event with the client wall clock and monotonic clock at capture.
+  function stamp(event) {
+    event.ts = Date.now();
+    event.mono = monotonicNow();
+    event.page = PAGE_ID;
+    return event;
+  }
+
   var queue = [];
   var flushTimer = null;
+  var retryTimer = null;
+  var draining = false;
 
-  function flushEvents(siteKey) {
+  // Moves queued events into a new outbox batch and starts encrypting its
+  // beacon body; with beaconNow the body is beaconed once it is ready.
+  function enqueueBatch(siteKey, beaconNow) {
     if (queue.length === 0) return;
-    var events = queue.splice(0, queue.length);
-    sendRPC("track.collectEvents", {
+    var batch = {
+      batch_id: crypto.randomUUID(),
       site_key: siteKey,
       visitor_id: getVisitorID(),
       session_id: getSessionID(),
-      events: events
+      events: queue.splice(0, queue.length),
+      attempts: 0,
+      next_at: 0,
+      created_at: Date.now()
+    };
+    var batches = loadOutbox();
+    batches.push(batch);
+    if (batches.length > OUTBOX_MAX_BATCHES) {
+      batches = batches.slice(batches.length - OUTBOX_MAX_BATCHES);
+    }
+    saveOutbox(batches);
+    prepareBeacon(batch, beaconNow);
+  }
+
+  // Encrypts a batch for sendBeacon ahead of time and keeps the body in
+  // the outbox. pagehide and visibilitychange handlers cannot wait for
+  // WebCrypto, since the page may be frozen or gone before it finishes.
+  // With send, the body is beaconed as soon as it is ready instead.
+  async function prepareBeacon(batch, send) {
+    var body;
+    try {
+      body = await rpcBody("track.collectEvents", batchParams(batch));
+    } catch (e) {
+      return;
+    }
+    var batches = loadOutbox();
+    batches.forEach(function(b) {
+      if (b.batch_id !== batch.batch_id) return;
+      if (send && navigator.sendBeacon && navigator.sendBeacon(CONFIG.beaconEndpoint, body)) {
+        b.next_at = Date.now() + BEACON_CONFIRM_MS;
+        delete b.beacon;
+      } else {
+        b.beacon = body;
+        b.beacon_at = Date.now();
+      }
     });
+    saveOutbox(batches);
+  }
+
+  function scheduleRetry() {
+    if (retryTimer) clearTimeout(retryTimer);
+    retryTimer = null;
+    var batches = loadOutbox();
+    if (batches.length === 0) return;
+    var earliest = Infinity;
+    batches.forEach(function(b) { earliest = Math.min(earliest, b.next_at); });
+    retryTimer = setTimeout(drainOutbox, Math.max(0, earliest - Date.now()));
+  }
+
+  // Sends every due batch. Failed batches back off exponentially, or for
+  // as long as the server's retry_after hint asks.
+  async function drainOutbox() {
+    if (draining) return;
+    draining = true;
+    try {
+      var batches = loadOutbox();
+      for (var i = 0; i < batches.length; i++) {
+        var batch = batches[i];
+        var now = Date.now();
+        if (now - batch.created_at > OUTBOX_MAX_AGE_MS) {
+          removeBatch(batch.batch_id);
+          continue;
+        }
+        if (batch.next_at > now) continue;
+
+        var retryAfter = 0;
+        var done = false;
+        try {
+          var resp = await sendRPC("track.collectEvents", batchParams(batch));
+          if (!resp.error || !RETRYABLE_CODES[resp.error.code]) {
+            done = true;
+          } else if (resp.error.data && resp.error.data.retry_after) {
+            retryAfter = resp.error.data.retry_after;
+          }
+        } catch (e) {
+          // Network error; retry later
+        }
+        if (done) {
+          removeBatch(batch.batch_id);
+          continue;
+        }
+        batch.attempts++;
+        batch.next_at = Date.now() + (retryAfter > 0
+          ? retryAfter * 1000
+          : Math.min(RETRY_BASE_MS * Math.pow(2, batch.attempts - 1), RETRY_MAX_MS));
+        updateBatch(batch);
+        prepareBeacon(batch, false);
+        if (retryAfter > 0) break;
+      }
+    } finally {
+      draining = false;
+      scheduleRetry();
+    }
+  }
+
+  function flushEvents(siteKey) {
+    enqueueBatch(siteKey);
+    drainOutbox();
+  }
+
+  // Hands due batches to navigator.sendBeacon when the page is hidden or
+  // unloading. Prepared bodies are sent synchronously from the handler;
+  // batches without a fresh one, including events queued by the handlers
+  // themselves, are encrypted and beaconed on a best-effort basis. A
+  // beaconed batch stays in the outbox and is confirmed by a normal send
+  // later; the server drops the duplicate if the beacon arrived.
+  function flushWithBeacon(siteKey) {
+    if (flushTimer) clearTimeout(flushTimer);
+    var now = Date.now();
+    var batches = loadOutbox();
+    var unprepared = [];
+    batches.forEach(function(b) {
+      if (b.next_at > now) return;
+      if (!b.beacon || now - b.beacon_at > BEACON_BODY_MAX_AGE_MS) {
+        unprepared.push(b);
+      } else if (navigator.sendBeacon && navigator.sendBeacon(CONFIG.beaconEndpoint, b.beacon)) {
+        b.next_at = now + BEACON_CONFIRM_MS;
+      }
+      // Each body's nonce is accepted once
+      delete b.beacon;
+    });
+    saveOutbox(batches);
+    unprepared.forEach(function(b) { prepareBeacon(b, true); });
+    enqueueBatch(siteKey, true);
+  }
+
+  var lastPageURL = null;
+  var lastPageReferrer = null;
+
+  // Calls onChange after every client-side route change. The callback is
+  // deferred so routers have a chance to update document.title first.
+  function hookHistory(onChange) {
+    function fire() { setTimeout(onChange, 0); }
+    ["pushState", "replaceState"].forEach(function(name) {
+      var original = history[name];
+      if (typeof original !== "function") return;
+      history[name] = function() {
+        var result = original.apply(this, arguments);
+        fire();
+        return result;
+      };
+    });
+    window.addEventListener("popstate", fire);
+    window.addEventListener("hashchange", fire);
+  }
+
+  function pageFields(overrides, defaultReferrer) {
+    var o = overrides || {};
+    return {
+      url: o.url || location.href,
+      title: o.title !== undefined ? o.title : document.title,
+      referrer: o.referrer !== undefined ? o.referrer : (defaultReferrer || document.referrer)
+    };
+  }
+
+  // Called with the outgoing URL before a new pageview replaces it.
+  var pageLeaveHooks = [];
+
+  var DEFAULT_DOWNLOAD_EXTENSIONS = [
+    "pdf", "zip", "rar", "7z", "gz", "tar", "dmg", "exe", "msi", "apk",
+    "doc", "docx", "xls", "xlsx", "ppt", "pptx", "csv", "txt", "mp3", "mp4"
+  ];
+  var SCROLL_MILESTONES = [25, 50, 75, 100];
+  var HEARTBEAT_MS = 15 * 1000;
+
+  function closestAnchor(el) {
+    while (el && el.tagName !== "A") el = el.parentElement;
+    return el && el.href ? el : null;
+  }
+
+  // Auto-capture modules are opt-in via init({ autoCapture: { ... } }) and
+  // emit ordinary events through trackEvent.
+  function startAutoCapture(sdk, opts) {
+    if (opts.scroll) {
+      var reached = {};
+      pageLeaveHooks.push(function() { reached = {}; });
+      window.addEventListener("scroll", function() {
+        var doc = document.documentElement;
+        var scrollable = doc.scrollHeight - window.innerHeight;
+        var depth = scrollable <= 0 ? 100 : Math.round((window.scrollY / scrollable) * 100);
+        SCROLL_MILESTONES.forEach(function(m) {
+          if (depth >= m && !reached[m]) {
+            reached[m] = true;
+            sdk.trackEvent("scroll_depth", { depth: m });
+          }
+        });
+      }, { passive: true });
+    }
+
+    if (opts.outbound || opts.downloads) {
+      var extensions = Array.isArray(opts.downloads) ? opts.downloads : DEFAULT_DOWNLOAD_EXTENSIONS;
+      document.addEventListener("click", function(e) {
+        var a = closestAnchor(e.target);
+        if (!a) return;
+        var link;
+        try { link = new URL(a.href, location.href); } catch (err) { return; }
+        var ext = link.pathname.split(".").length > 1 ? link.pathname.split(".").pop().toLowerCase() : "";
+        if (opts.downloads && (a.hasAttribute("download") || extensions.indexOf(ext) !== -1)) {
+          sdk.trackEvent("file_download", { href: link.href, extension: ext });
+        } else if (opts.outbound && /^https?:$/.test(link.protocol) && link.hostname !== location.hostname) {
+          sdk.trackEvent("outbound_click", { href: link.href, host: link.hostname });
+        }
+      }, true);
+    }
+
+    if (opts.forms) {
+      // Only form identity is recorded, never field values
+      document.addEventListener("submit", function(e) {
+        var form = e.target;
+        if (!form || form.tagName !== "FORM") return;
+        sdk.trackEvent("form_submit", {
+          form_id: form.id || "",
+          form_name: form.getAttribute("name") || "",
+          action: form.getAttribute("action") || "",
+          method: (form.getAttribute("method") || "get").toLowerCase()
+        });
+      }, true);
+    }
+
+    if (opts.engagement) {
+      // Engaged time only accrues while the page is visible. It is reported
+      // on a heartbeat, when the page is hidden and when the route changes.
+      var engagedMs = 0;
+      var visibleSince = document.visibilityState === "visible" ? Date.now() : null;
+      var collect = function() {
+        if (visibleSince !== null) {
+          var now = Date.now();
+          engagedMs += now - visibleSince;
+          visibleSince = now;
+        }
+      };
+      var report = function(url) {
+        collect();
+        if (engagedMs <= 0) return;
+        sdk.trackEvent("engagement", { engaged_ms: engagedMs }, url ? { url: url } : undefined);
+        engagedMs = 0;
+      };
+      pageLeaveHooks.push(report);
+      document.addEventListener("visibilitychange", function() {
+        if (document.visibilityState === "hidden") {
+          report();
+          visibleSince = null;
+        } else {
+          visibleSince = Date.now();
+        }
+      });
+      setInterval(function() {
+        if (document.visibilityState === "visible") report();
+      }, HEARTBEAT_MS);
+    }
   }
 
   window.TrackSDK = {
-    init: function(siteKey) {
+    // options.consent: initial consent state (see setConsent).
+    // options.spa: track route changes automatically (default true).
+    // options.autoCapture: { scroll, outbound, downloads, forms, engagement };
+    // downloads may be an array of file extensions.
+    init: function(siteKey, options) {
+      var self = this;
+      options = options || {};
       this._siteKey = siteKey;
+      if (options.consent !== undefined) {
+        this.setConsent(options.consent);
+      }
       this.trackPageview();
+      if (options.spa !== false) {
+        hookHistory(function() { self.trackPageview(); });
+      }
+      if (options.autoCapture) {
+        startAutoCapture(this, options.autoCapture);
+      }
+      document.addEventListener("visibilitychange", function() {
+        if (document.visibilityState === "hidden") flushWithBeacon(siteKey);
+      });
+      window.addEventListener("pagehide", function() { flushWithBeacon(siteKey); });
+      window.addEventListener("online", drainOutbox);
+      // Resend anything left over from earlier page views
+      drainOutbox();
+    },
+    // setConsent accepts "granted"/"denied" or a boolean. Denying consent
+    // also forgets any previously persisted visitor ID.
+    setConsent: function(state) {
+      try {
+        if (state === true || state === "granted") {
+          localStorage.setItem("_tk_consent", "granted");
+        } else {
+          localStorage.removeItem("_tk_consent");
+          localStorage.removeItem("_tk_vid");
+        }
+      } catch (e) {}
     },
-    trackPageview: function() {
-      queue.push({
+    // overrides may set url, title and referrer for virtual pageviews. By
+    // default the referrer is the previous route, or document.referrer for
+    // the first pageview. Repeats of the current URL are ignored.
+    trackPageview: function(overrides) {
+      var page = pageFields(overrides, lastPageURL);
+      if (page.url === lastPageURL) return;
+      if (lastPageURL !== null) {
+        var leaving = lastPageURL;
+        pageLeaveHooks.forEach(function(fn) { fn(leaving); });
+      }
+      lastPageURL = page.url;
+      lastPageReferrer = page.referrer;
+      queue.push(stamp({
         type: "pageview",
-        url: location.href,
-        title: document.title,
-        referrer: document.referrer
-      });
+        url: page.url,
+        title: page.title,
+        referrer: page.referrer,
+        consent: hasConsent()
+      }));
       this._scheduleFlush();
     },
-    trackEvent: function(eventType, props) {
-      queue.push({
+    trackEvent: function(eventType, props, overrides) {
+      var page = pageFields(overrides, lastPageReferrer);
+      queue.push(stamp({
         type: eventType,
-        url: location.href,
-        title: document.title,
-        referrer: document.referrer,
-        props: props || {}
-      });
+        url: page.url,
+        title: page.title,
+        referrer: page.referrer,
+        props: props || {},
+        consent: hasConsent()
+      }));
       this._scheduleFlush();
     },
     _scheduleFlush: function() {
@@ -133,7 +532,11 @@ func GenerateSDK(publicKeyPEM, kid, rpcEndpoint string) string {
 `, kid, escapedPEM, rpcEndpoint)
 }
 
-func GenerateClickPage(token, publicKeyPEM, kid, rpcEndpoint, targetURL string) string {
+// GenerateClickPage renders the JS click page. Besides the click it
+// reports automation checks and, when challengeBits is above 0, solves
+// the proof of work in challengeSeed. With appendClickID the click ID is
+// added to the target URL.
+func GenerateClickPage(token, publicKeyPEM, kid, rpcEndpoint, targetURL, challengeSeed string, challengeBits int, appendClickID bool) string {
 	escapedPEM := strings.ReplaceAll(publicKeyPEM, "\n", "\\n")
 	return fmt.Sprintf(`<!DOCTYPE html>
 <html>
@@ -147,9 +550,26 @@ func GenerateClickPage(token, publicKeyPEM, kid, rpcEndpoint, targetURL string)
     publicKeyPEM: "%s",
     rpcEndpoint: "%s/rpc",
     token: "%s",
-    targetURL: "%s"
+    targetURL: "%s",
+    challengeSeed: "%s",
+    challengeBits: %d,
+    appendClickID: %t
   };
 
+  // Input seen before the redirect; synthetic events are counted apart
+  var gestures = { first_ms: null, moves: 0, clicks: 0, keys: 0, touches: 0, untrusted: 0 };
+  function onInput(e) {
+    if (!e.isTrusted) { gestures.untrusted++; return; }
+    if (gestures.first_ms === null) gestures.first_ms = Math.round(e.timeStamp);
+    if (e.type === "mousemove") gestures.moves++;
+    else if (e.type === "mousedown") gestures.clicks++;
+    else if (e.type === "keydown") gestures.keys++;
+    else if (e.type === "touchstart") gestures.touches++;
+  }
+  ["mousemove", "mousedown", "keydown", "touchstart"].forEach(function(type) {
+    document.addEventListener(type, onInput, { capture: true, passive: true });
+  });
+
   function base64Encode(buf) {
     var bytes = new Uint8Array(buf);
     var binary = "";
@@ -168,13 +588,59 @@ func GenerateClickPage(token, publicKeyPEM, kid, rpcEndpoint, targetURL string)
       { name: "RSA-OAEP", hash: "SHA-256" }, false, ["encrypt"]);
   }
 
+  function webglRenderer() {
+    try {
+      var gl = document.createElement("canvas").getContext("webgl");
+      if (!gl) return "";
+      var info = gl.getExtension("WEBGL_debug_renderer_info");
+      return String(gl.getParameter(info ? info.UNMASKED_RENDERER_WEBGL : gl.RENDERER));
+    } catch(e) {
+      return "";
+    }
+  }
+
+  function leadingZeroBits(hash) {
+    var n = 0;
+    for (var i = 0; i < hash.length; i++) {
+      if (hash[i] !== 0) return n + Math.clz32(hash[i]) - 24;
+      n += 8;
+    }
+    return n;
+  }
+
+  // Find a nonce whose SHA-256 with the seed starts with enough zero
+  // bits; give up after a few seconds rather than hold up the redirect
+  async function solveChallenge(seed, bits) {
+    if (!seed) return null;
+    var encoder = new TextEncoder();
+    var deadline = Date.now() + 3000;
+    for (var nonce = 0; Date.now() < deadline; nonce++) {
+      var hash = await crypto.subtle.digest("SHA-256", encoder.encode(seed + ":" + nonce));
+      if (leadingZeroBits(new Uint8Array(hash)) >= bits) {
+        return { seed: seed, nonce: String(nonce) };
+      }
+    }
+    return null;
+  }
+
+  var targetURL = CONFIG.targetURL;
   try {
+    // Solve the challenge while the keys are set up, so the redirect
+    // waits for whichever takes longer rather than for both
+    var powPending = solveChallenge(CONFIG.challengeSeed, CONFIG.challengeBits);
+
     var visitorID = localStorage.getItem("_tk_vid");
     if (!visitorID) {
       visitorID = crypto.randomUUID();
       localStorage.setItem("_tk_vid", visitorID);
     }
 
+    var pubKey = await importPublicKey(CONFIG.publicKeyPEM);
+    var dataKey = crypto.getRandomValues(new Uint8Array(32));
+    var ek = await crypto.subtle.encrypt({ name: "RSA-OAEP" }, pubKey, dataKey);
+    var aesKey = await crypto.subtle.importKey("raw", dataKey, "AES-GCM", false, ["encrypt"]);
+    var nonce = crypto.getRandomValues(new Uint8Array(12));
+
     var payload = {
       token: CONFIG.token,
       visitor_id: visitorID,
@@ -183,15 +649,17 @@ func GenerateClickPage(token, publicKeyPEM, kid, rpcEndpoint, targetURL string)
         screen_height: screen.height,
         timezone: Intl.DateTimeFormat().resolvedOptions().timeZone,
         language: navigator.language,
-        platform: navigator.platform
+        platform: navigator.platform,
+        checks: {
+          webdriver: navigator.webdriver === true,
+          plugins: navigator.plugins ? navigator.plugins.length : null,
+          mimes: navigator.mimeTypes ? navigator.mimeTypes.length : null,
+          webgl: webglRenderer(),
+          pow: await powPending,
+          gestures: gestures
+        }
       }
     };
-
-    var pubKey = await importPublicKey(CONFIG.publicKeyPEM);
-    var dataKey = crypto.getRandomValues(new Uint8Array(32));
-    var ek = await crypto.subtle.encrypt({ name: "RSA-OAEP" }, pubKey, dataKey);
-    var aesKey = await crypto.subtle.importKey("raw", dataKey, "AES-GCM", false, ["encrypt"]);
-    var nonce = crypto.getRandomValues(new Uint8Array(12));
     var plaintext = new TextEncoder().encode(JSON.stringify(payload));
     var ct = await crypto.subtle.encrypt({ name: "AES-GCM", iv: nonce }, aesKey, plaintext);
 
@@ -209,17 +677,25 @@ func GenerateClickPage(token, publicKeyPEM, kid, rpcEndpoint, targetURL string)
       id: "1"
     });
 
-    await fetch(CONFIG.rpcEndpoint, {
+    var resp = await fetch(CONFIG.rpcEndpoint, {
       method: "POST",
       headers: { "Content-Type": "application/json" },
       body: body
     });
+    // Carry the click ID to the landing page, where the site's visitor
+    // ID can be linked to it
+    var reply = await resp.json();
+    if (CONFIG.appendClickID && reply.result && reply.result.click_id) {
+      var landing = new URL(targetURL);
+      landing.searchParams.set("_tk_cid", reply.result.click_id);
+      targetURL = landing.href;
+    }
   } catch(e) {
     console.error("tracking error:", e);
   }
-  window.location.href = CONFIG.targetURL;
+  window.location.href = targetURL;
 })();
 </script>
 </body>
-</html>`, kid, escapedPEM, rpcEndpoint, token, targetURL)
+</html>`, kid, escapedPEM, rpcEndpoint, token, targetURL, challengeSeed, challengeBits, appendClickID)
 }
@@ -0,0 +1,92 @@
+package sink
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+	"github.com/tracking/analysis/internal/config"
+)
+
+const kafkaDialTimeout = 5 * time.Second
+
+// KafkaSink produces to the topics <Prefix>clicks and <Prefix>events with
+// acks=all. Messages are partitioned by key with murmur2, as the Java
+// client's default partitioner does, so one visitor's rows stay in order
+// on one partition. The row id is sent as the "id" header.
+type KafkaSink struct {
+	Prefix string
+	Writer *kafka.Writer
+}
+
+func NewKafkaSink(cfg config.SinkConfiguration) (*KafkaSink, error) {
+	mechanism, err := kafkaSASL(cfg)
+	if err != nil {
+		return nil, err
+	}
+	transport := &kafka.Transport{
+		ClientID:    clientName,
+		DialTimeout: kafkaDialTimeout,
+		SASL:        mechanism,
+	}
+	if cfg.KafkaTLS {
+		transport.TLS = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	return &KafkaSink{
+		Prefix: cfg.KafkaTopicPrefix,
+		Writer: &kafka.Writer{
+			Addr:         kafka.TCP(cfg.KafkaBrokers...),
+			Balancer:     &kafka.Murmur2Balancer{},
+			RequiredAcks: kafka.RequireAll,
+			// Publish is called with whole outbox batches; don't linger
+			// waiting for more.
+			BatchSize:    batchSize,
+			BatchTimeout: 10 * time.Millisecond,
+			Transport:    transport,
+		},
+	}, nil
+}
+
+// kafkaSASL returns the SASL mechanism cfg selects, or nil for none.
+func kafkaSASL(cfg config.SinkConfiguration) (sasl.Mechanism, error) {
+	switch cfg.KafkaSASLMechanism {
+	case "":
+		return nil, nil
+	case "plain":
+		return plain.Mechanism{Username: cfg.KafkaUsername, Password: cfg.KafkaPassword}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, cfg.KafkaUsername, cfg.KafkaPassword)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, cfg.KafkaUsername, cfg.KafkaPassword)
+	}
+	return nil, fmt.Errorf("unsupported KafkaSASLMechanism %q", cfg.KafkaSASLMechanism)
+}
+
+func (s *KafkaSink) Name() string { return NameKafka }
+
+func (s *KafkaSink) Publish(ctx context.Context, msgs []Message) error {
+	return s.Writer.WriteMessages(ctx, s.messages(msgs)...)
+}
+
+func (s *KafkaSink) messages(msgs []Message) []kafka.Message {
+	out := make([]kafka.Message, len(msgs))
+	for i, m := range msgs {
+		out[i] = kafka.Message{
+			Topic:   s.Prefix + m.Kind,
+			Key:     []byte(m.Key),
+			Value:   m.Value,
+			Headers: []kafka.Header{{Key: "id", Value: []byte(m.ID)}},
+			Time:    m.Time,
+		}
+	}
+	return out
+}
+
+func (s *KafkaSink) Close() error {
+	return s.Writer.Close()
+}
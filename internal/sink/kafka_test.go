@@ -0,0 +1,65 @@
+package sink
+
+import (
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/tracking/analysis/internal/config"
+)
+
+func TestKafkaPartitioner(t *testing.T) {
+	// Partitions the Java client's default partitioner picks for these keys
+	// out of 100, from murmur2 vectors in its UtilsTest.
+	cases := map[string]int{
+		"21":                       (-973932308 & 0x7fffffff) % 100,
+		"foobar":                   (-790332482 & 0x7fffffff) % 100,
+		"a-little-bit-long-string": (-985981536 & 0x7fffffff) % 100,
+		"abc":                      479470107 % 100,
+	}
+	s, err := NewKafkaSink(config.SinkConfiguration{KafkaBrokers: []string{"127.0.0.1:9092"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	partitions := make([]int, 100)
+	for i := range partitions {
+		partitions[i] = i
+	}
+	for key, want := range cases {
+		if got := s.Writer.Balancer.Balance(kafka.Message{Key: []byte(key)}, partitions...); got != want {
+			t.Errorf("partition for %q = %d, want %d", key, got, want)
+		}
+	}
+	if s.Writer.RequiredAcks != kafka.RequireAll {
+		t.Errorf("acks = %v", s.Writer.RequiredAcks)
+	}
+}
+
+func TestKafkaSinkMessages(t *testing.T) {
+	s := &KafkaSink{Prefix: "tracking."}
+	msgs := s.messages(testMessages())
+	if len(msgs) != 3 {
+		t.Fatalf("got %d messages", len(msgs))
+	}
+	m := msgs[1]
+	if m.Topic != "tracking.events" || string(m.Key) != "visitor-1" || string(m.Value) != `{"id":"e1"}` {
+		t.Errorf("message = %+v", m)
+	}
+	if len(m.Headers) != 1 || m.Headers[0].Key != "id" || string(m.Headers[0].Value) != "e1" {
+		t.Errorf("headers = %+v", m.Headers)
+	}
+}
+
+func TestKafkaSASL(t *testing.T) {
+	for mechanism, want := range map[string]string{"plain": "PLAIN", "scram-sha-256": "SCRAM-SHA-256", "scram-sha-512": "SCRAM-SHA-512"} {
+		m, err := kafkaSASL(config.SinkConfiguration{KafkaSASLMechanism: mechanism, KafkaUsername: "u", KafkaPassword: "p"})
+		if err != nil || m.Name() != want {
+			t.Errorf("%s: %v, %v", mechanism, m, err)
+		}
+	}
+	if m, err := kafkaSASL(config.SinkConfiguration{}); m != nil || err != nil {
+		t.Errorf("no mechanism: %v, %v", m, err)
+	}
+	if _, err := NewKafkaSink(config.SinkConfiguration{KafkaSASLMechanism: "gssapi"}); err == nil {
+		t.Error("expected error for unsupported mechanism")
+	}
+}
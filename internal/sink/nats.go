@@ -0,0 +1,96 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const natsDialTimeout = 5 * time.Second
+
+// NATSSink publishes to the subjects <Prefix>clicks and <Prefix>events and
+// waits for JetStream acknowledgements, so a stream must capture those
+// subjects. Each message carries the row id as Nats-Msg-Id, letting
+// JetStream drop redelivered duplicates within its dedup window.
+type NATSSink struct {
+	URL    string
+	Prefix string
+	// CredsFile, when set, authenticates with a NATS credentials file.
+	CredsFile string
+
+	mu sync.Mutex
+	nc *nats.Conn
+	js jetstream.JetStream
+}
+
+func (s *NATSSink) Name() string { return NameNATS }
+
+// connect dials on first use; afterwards the client reconnects by itself.
+func (s *NATSSink) connect() error {
+	if s.nc != nil {
+		return nil
+	}
+	opts := []nats.Option{nats.Name(clientName), nats.Timeout(natsDialTimeout), nats.MaxReconnects(-1)}
+	if s.CredsFile != "" {
+		opts = append(opts, nats.UserCredentials(s.CredsFile))
+	}
+	nc, err := nats.Connect(s.URL, opts...)
+	if err != nil {
+		return err
+	}
+	js, err := jetstream.New(nc)
+	if err != nil {
+		nc.Close()
+		return err
+	}
+	s.nc, s.js = nc, js
+	return nil
+}
+
+func (s *NATSSink) Publish(ctx context.Context, msgs []Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.connect(); err != nil {
+		return err
+	}
+	futures := make([]jetstream.PubAckFuture, len(msgs))
+	for i, m := range msgs {
+		f, err := s.js.PublishMsgAsync(natsMsg(s.Prefix, m), jetstream.WithMsgID(m.ID))
+		if err != nil {
+			return err
+		}
+		futures[i] = f
+	}
+	for i, f := range futures {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("nats: %d of %d messages unacknowledged: %w", len(msgs)-i, len(msgs), ctx.Err())
+		case <-f.Ok():
+		case err := <-f.Err():
+			if errors.Is(err, nats.ErrNoResponders) || errors.Is(err, jetstream.ErrNoStreamResponse) {
+				err = errors.New("no JetStream stream for subject")
+			}
+			return fmt.Errorf("nats: %s: %w", f.Msg().Subject, err)
+		}
+	}
+	return nil
+}
+
+func natsMsg(prefix string, m Message) *nats.Msg {
+	return &nats.Msg{Subject: prefix + m.Kind, Data: m.Value}
+}
+
+func (s *NATSSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.nc != nil {
+		s.nc.Close()
+		s.nc, s.js = nil, nil
+	}
+	return nil
+}
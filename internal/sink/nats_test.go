@@ -0,0 +1,127 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type natsPub struct {
+	subject, msgID, payload string
+}
+
+// fakeNATS speaks enough of the NATS protocol to answer each HPUB with a
+// JetStream ack, or with a 503 status when noStream is set.
+type fakeNATS struct {
+	ln       net.Listener
+	noStream bool
+	mu       sync.Mutex
+	pubs     []natsPub
+}
+
+func newFakeNATS(t *testing.T, noStream bool) *fakeNATS {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	f := &fakeNATS{ln: ln, noStream: noStream}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeNATS) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	w.WriteString("INFO {\"server_id\":\"fake\",\"headers\":true,\"max_payload\":1048576}\r\n")
+	w.Flush()
+	sid := "1"
+	for seq := 1; ; {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		op, args, _ := strings.Cut(strings.TrimSpace(line), " ")
+		switch op {
+		case "PING":
+			w.WriteString("PONG\r\n")
+		case "SUB":
+			// SUB <subject> [queue] <sid>; acks go to the reply inbox
+			a := strings.Fields(args)
+			sid = a[len(a)-1]
+		case "HPUB":
+			// HPUB <subject> <reply> <header size> <total size>
+			a := strings.Fields(args)
+			hdrLen, _ := strconv.Atoi(a[2])
+			total, _ := strconv.Atoi(a[3])
+			body := make([]byte, total+2)
+			if _, err := io.ReadFull(r, body); err != nil {
+				return
+			}
+			pub := natsPub{subject: a[0], payload: string(body[hdrLen:total])}
+			for _, h := range strings.Split(string(body[:hdrLen]), "\r\n") {
+				if v, ok := strings.CutPrefix(h, "Nats-Msg-Id: "); ok {
+					pub.msgID = v
+				}
+			}
+			if f.noStream {
+				hdr := "NATS/1.0 503\r\n\r\n"
+				fmt.Fprintf(w, "HMSG %s %s %d %d\r\n%s\r\n", a[1], sid, len(hdr), len(hdr), hdr)
+				break
+			}
+			f.mu.Lock()
+			f.pubs = append(f.pubs, pub)
+			f.mu.Unlock()
+			ack := fmt.Sprintf(`{"stream":"TRACKING","seq":%d}`, seq)
+			seq++
+			fmt.Fprintf(w, "MSG %s %s %d\r\n%s\r\n", a[1], sid, len(ack), ack)
+		}
+		w.Flush()
+	}
+}
+
+func TestNATSSinkPublish(t *testing.T) {
+	srv := newFakeNATS(t, false)
+	s := &NATSSink{URL: "nats://" + srv.ln.Addr().String(), Prefix: "tracking."}
+	defer s.Close()
+
+	for i := 0; i < 2; i++ { // the second batch reuses the connection
+		if err := s.Publish(context.Background(), testMessages()); err != nil {
+			t.Fatalf("batch %d: %v", i, err)
+		}
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if len(srv.pubs) != 6 {
+		t.Fatalf("got %d publishes, want 6", len(srv.pubs))
+	}
+	if p := srv.pubs[1]; p.subject != "tracking.events" || p.msgID != "e1" || p.payload != `{"id":"e1"}` {
+		t.Errorf("unexpected publish %+v", p)
+	}
+}
+
+func TestNATSSinkNoStream(t *testing.T) {
+	srv := newFakeNATS(t, true)
+	s := &NATSSink{URL: "nats://" + srv.ln.Addr().String(), Prefix: "tracking."}
+	defer s.Close()
+
+	err := s.Publish(context.Background(), testMessages())
+	if err == nil || !strings.Contains(err.Error(), "no JetStream stream") {
+		t.Fatalf("got %v, want no stream error", err)
+	}
+}
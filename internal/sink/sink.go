@@ -0,0 +1,191 @@
+// Package sink publishes stored clicks and events to streaming systems
+// (Kafka, NATS JetStream, Redis Streams) from a transactional outbox, with
+// at-least-once delivery.
+package sink
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/tracking/analysis/internal/config"
+	"github.com/tracking/analysis/internal/models"
+	"github.com/tracking/analysis/internal/repo"
+)
+
+// Sink names, as listed in SinkConfiguration.Sinks.
+const (
+	NameKafka = "kafka"
+	NameNATS  = "nats"
+	NameRedis = "redis"
+)
+
+const (
+	batchSize      = 500
+	pollInterval   = time.Second
+	publishTimeout = 10 * time.Second
+	// publishLease outlasts publishTimeout, so a live publisher keeps its
+	// batch until it finishes.
+	publishLease = time.Minute
+	maxBackoff   = 5 * time.Minute
+	// MaxAttempts is how many times a batch is tried before its messages
+	// are marked dead; at maxBackoff that is about a day.
+	MaxAttempts = 300
+	// DeadRetention is how long dead messages are kept for inspection.
+	DeadRetention = 7 * 24 * time.Hour
+	pruneInterval = time.Hour
+	// clientName identifies the service to brokers.
+	clientName = "tracking-analysis"
+)
+
+// Message is one stored row to publish. ID is the row's id, which
+// consumers can use to drop the duplicates at-least-once delivery allows.
+type Message struct {
+	ID    string
+	Kind  string // "clicks" or "events"
+	Key   string
+	Value []byte // the row as JSON
+	Time  time.Time
+}
+
+// Sink publishes messages to one streaming system.
+type Sink interface {
+	Name() string
+	// Publish returns nil only once every message is acknowledged.
+	Publish(ctx context.Context, msgs []Message) error
+	Close() error
+}
+
+// New returns the sinks cfg selects.
+func New(cfg config.SinkConfiguration, rdb *redis.Client) ([]Sink, error) {
+	var sinks []Sink
+	for _, name := range cfg.Sinks {
+		switch name {
+		case NameKafka:
+			if len(cfg.KafkaBrokers) == 0 {
+				return nil, fmt.Errorf("kafka sink needs KafkaBrokers")
+			}
+			k, err := NewKafkaSink(cfg)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, k)
+		case NameNATS:
+			if cfg.NATSURL == "" {
+				return nil, fmt.Errorf("nats sink needs NATSURL")
+			}
+			sinks = append(sinks, &NATSSink{URL: cfg.NATSURL, Prefix: cfg.NATSSubjectPrefix, CredsFile: cfg.NATSCredsFile})
+		case NameRedis:
+			sinks = append(sinks, &RedisSink{Client: rdb, Prefix: cfg.RedisStreamPrefix, MaxLen: cfg.RedisStreamMaxLen})
+		default:
+			return nil, fmt.Errorf("unsupported sink %q", name)
+		}
+	}
+	return sinks, nil
+}
+
+// Backoff is the delay before retrying a batch that failed attempts times.
+func Backoff(attempts int) time.Duration {
+	d := time.Second
+	for i := 1; i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, maxBackoff)
+}
+
+type Service struct {
+	Repo  *repo.OutboxRepo
+	Sinks []Sink
+}
+
+func NewService(outbox *repo.OutboxRepo, sinks []Sink) *Service {
+	return &Service{Repo: outbox, Sinks: sinks}
+}
+
+// Run publishes outbox messages to each sink, and prunes dead ones, until
+// ctx is done. Sinks run independently, so one that is down only builds up
+// its own backlog.
+func (s *Service) Run(ctx context.Context) {
+	for _, sk := range s.Sinks {
+		go s.run(ctx, sk, s.Repo.Subscribe())
+	}
+	prune := time.NewTicker(pruneInterval)
+	defer prune.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			for _, sk := range s.Sinks {
+				sk.Close()
+			}
+			return
+		case <-prune.C:
+			if n, err := s.Repo.PruneDead(time.Now().Add(-DeadRetention)); err != nil {
+				slog.Warn("outbox prune failed", "error", err)
+			} else if n > 0 {
+				slog.Info("dead outbox messages pruned", "count", n)
+			}
+		}
+	}
+}
+
+func (s *Service) run(ctx context.Context, sk Sink, wake <-chan struct{}) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		n, err := s.Repo.Process(sk.Name(), batchSize, time.Now(), publishLease, Backoff, MaxAttempts, func(msgs []models.OutboxMessage) error {
+			pctx, cancel := context.WithTimeout(ctx, publishTimeout)
+			defer cancel()
+			return sk.Publish(pctx, messages(msgs))
+		})
+		if err != nil {
+			slog.Warn("sink publish failed", "sink", sk.Name(), "messages", n, "error", err)
+		}
+		if err == nil && n == batchSize {
+			continue // more are due
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+func messages(rows []models.OutboxMessage) []Message {
+	msgs := make([]Message, len(rows))
+	for i, r := range rows {
+		msgs[i] = Message{ID: r.RowID, Kind: r.Kind, Key: r.Key, Value: []byte(r.Payload), Time: r.CreatedAt}
+	}
+	return msgs
+}
+
+// RedisSink appends messages to the streams <Prefix>clicks and
+// <Prefix>events with fields id, key and payload.
+type RedisSink struct {
+	Client *redis.Client
+	Prefix string
+	// MaxLen approximately caps each stream; 0 leaves them unbounded.
+	MaxLen int64
+}
+
+func (s *RedisSink) Name() string { return NameRedis }
+
+func (s *RedisSink) Publish(ctx context.Context, msgs []Message) error {
+	pipe := s.Client.Pipeline()
+	for _, m := range msgs {
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: s.Prefix + m.Kind,
+			MaxLen: s.MaxLen,
+			Approx: s.MaxLen > 0,
+			Values: []any{"id", m.ID, "key", m.Key, "payload", m.Value},
+		})
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Close leaves the shared Redis client open.
+func (s *RedisSink) Close() error { return nil }
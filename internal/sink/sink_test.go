@@ -0,0 +1,82 @@
+package sink
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/tracking/analysis/internal/config"
+)
+
+func testMessages() []Message {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	return []Message{
+		{ID: "c1", Kind: "clicks", Key: "visitor-1", Value: []byte(`{"id":"c1"}`), Time: now},
+		{ID: "e1", Kind: "events", Key: "visitor-1", Value: []byte(`{"id":"e1"}`), Time: now.Add(time.Second)},
+		{ID: "c2", Kind: "clicks", Key: "visitor-2", Value: []byte(`{"id":"c2"}`), Time: now.Add(2 * time.Second)},
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	cases := map[int]time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		4:  8 * time.Second,
+		9:  256 * time.Second,
+		10: maxBackoff,
+		50: maxBackoff,
+	}
+	for attempts, want := range cases {
+		if got := Backoff(attempts); got != want {
+			t.Errorf("Backoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	sinks, err := New(config.SinkConfiguration{
+		Sinks:        []string{"kafka", "nats", "redis"},
+		KafkaBrokers: []string{"127.0.0.1:9092"},
+		NATSURL:      "nats://127.0.0.1:4222",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sinks) != 3 || sinks[0].Name() != NameKafka || sinks[1].Name() != NameNATS || sinks[2].Name() != NameRedis {
+		t.Errorf("unexpected sinks %v", sinks)
+	}
+
+	if _, err := New(config.SinkConfiguration{Sinks: []string{"pulsar"}}, nil); err == nil {
+		t.Error("expected error for unsupported sink")
+	}
+	if _, err := New(config.SinkConfiguration{Sinks: []string{"kafka"}}, nil); err == nil {
+		t.Error("expected error for kafka without brokers")
+	}
+}
+
+func TestRedisSink(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	s := &RedisSink{Client: rdb, Prefix: "tracking:", MaxLen: 1000}
+
+	if err := s.Publish(context.Background(), testMessages()); err != nil {
+		t.Fatal(err)
+	}
+	clicks, err := rdb.XRange(context.Background(), "tracking:clicks", "-", "+").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clicks) != 2 {
+		t.Fatalf("got %d clicks, want 2", len(clicks))
+	}
+	v := clicks[0].Values
+	if v["id"] != "c1" || v["key"] != "visitor-1" || v["payload"] != `{"id":"c1"}` {
+		t.Errorf("unexpected entry %v", v)
+	}
+	events, _ := rdb.XLen(context.Background(), "tracking:events").Result()
+	if events != 1 {
+		t.Errorf("got %d events, want 1", events)
+	}
+}
@@ -0,0 +1,538 @@
+// Package webhook delivers signed event notifications to subscriber URLs.
+// Deliveries are recorded in Postgres and scheduled through a Redis sorted
+// set, so retries survive restarts and are shared between replicas.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/tracking/analysis/internal/models"
+	"github.com/tracking/analysis/internal/repo"
+	"gorm.io/gorm"
+)
+
+// Kinds a webhook can subscribe to.
+const (
+	KindClick        = "click"
+	KindConversion   = "conversion"
+	KindBotSpike     = "bot_spike"
+	KindTokenExpired = "token_expired"
+	KindAlert        = "alert"
+)
+
+var Kinds = []string{KindClick, KindConversion, KindBotSpike, KindTokenExpired, KindAlert}
+
+// Delivery statuses.
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+const (
+	queueKey = "webhook:queue"
+	// MaxAttempts is how many times a delivery is tried before it is failed.
+	MaxAttempts = 8
+	baseBackoff = 30 * time.Second
+	maxBackoff  = 6 * time.Hour
+	// DefaultBotSpikeLimit applies when a webhook sets no bot_spike_limit.
+	DefaultBotSpikeLimit = 100
+	// DeliveryRetention is how long delivered and failed deliveries stay in
+	// the log. Their payloads carry visitor IDs and URLs.
+	DeliveryRetention = 30 * 24 * time.Hour
+
+	pollInterval    = time.Second
+	expiryInterval  = time.Minute
+	pruneInterval   = time.Hour
+	batchSize       = 20
+	cacheTTL        = 30 * time.Second
+	deliveryTimeout = 10 * time.Second
+)
+
+// ValidKind reports whether kind is a known webhook kind.
+func ValidKind(kind string) bool {
+	for _, k := range Kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// NewSecret returns a random signing secret for a new webhook.
+func NewSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Sign returns the X-Webhook-Signature value for body sent at ts: the hex
+// HMAC-SHA256 of "<ts>.<body>" keyed by the webhook secret.
+func Sign(secret string, ts int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(ts, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Backoff is the delay before retrying after the given number of failed
+// attempts: 30s doubling each time, capped at 6h.
+func Backoff(attempts int) time.Duration {
+	d := baseBackoff
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	return d
+}
+
+// Scope describes where a triggering event happened, matched against
+// webhook filters.
+type Scope struct {
+	TrackerID string
+	SiteID    string
+	EventType string // conversions only
+	BotCount  int64  // bot_spike only: blocked bots so far this minute
+}
+
+// Matches reports whether w should receive a kind event in scope.
+func Matches(w *models.Webhook, kind string, s Scope) bool {
+	if !w.Active || !w.Kinds.Contains(kind) {
+		return false
+	}
+	if w.TrackerID != "" && w.TrackerID != s.TrackerID {
+		return false
+	}
+	if w.SiteID != "" && w.SiteID != s.SiteID {
+		return false
+	}
+	switch kind {
+	case KindConversion:
+		return w.ConversionEvents.Contains(s.EventType)
+	case KindBotSpike:
+		limit := w.BotSpikeLimit
+		if limit <= 0 {
+			limit = DefaultBotSpikeLimit
+		}
+		// Only the crossing fires, so a spike notifies once per minute.
+		return s.BotCount == int64(limit)
+	}
+	return true
+}
+
+// Envelope is the JSON body of every delivery. ID identifies the triggering
+// event and is kept across retries and replays for receiver deduplication.
+type Envelope struct {
+	ID        string    `json:"id"`
+	Kind      string    `json:"kind"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+type Service struct {
+	Repo      *repo.WebhookRepo
+	TokenRepo *repo.TokenRepo
+	Redis     *redis.Client
+	Client    *http.Client
+
+	mu     sync.Mutex
+	hooks  []models.Webhook
+	loaded time.Time
+}
+
+func NewService(webhookRepo *repo.WebhookRepo, tokenRepo *repo.TokenRepo, rdb *redis.Client) *Service {
+	return &Service{
+		Repo:      webhookRepo,
+		TokenRepo: tokenRepo,
+		Redis:     rdb,
+		Client:    &http.Client{Timeout: deliveryTimeout},
+	}
+}
+
+// Invalidate drops the cached webhook list after an admin change. Other
+// replicas pick changes up within cacheTTL.
+func (s *Service) Invalidate() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.loaded = time.Time{}
+	s.mu.Unlock()
+}
+
+// active returns the cached list of active webhooks, reloading it after cacheTTL.
+func (s *Service) active() []models.Webhook {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.loaded) > cacheTTL {
+		hooks, err := s.Repo.ListActive()
+		if err != nil {
+			slog.Warn("webhook list failed", "error", err)
+		} else {
+			s.hooks, s.loaded = hooks, time.Now()
+		}
+	}
+	return s.hooks
+}
+
+func (s *Service) matching(kind string, scope Scope) []models.Webhook {
+	var out []models.Webhook
+	for _, w := range s.active() {
+		if Matches(&w, kind, scope) {
+			out = append(out, w)
+		}
+	}
+	return out
+}
+
+// subscribed reports whether any active webhook takes kind, ignoring filters.
+func (s *Service) subscribed(kind string) bool {
+	for _, w := range s.active() {
+		if w.Kinds.Contains(kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// Emit queues data for every active webhook matching kind and scope. A nil
+// Service emits nothing.
+func (s *Service) Emit(ctx context.Context, kind string, scope Scope, data any) error {
+	if s == nil {
+		return nil
+	}
+	deliveries, err := s.newDeliveries(kind, scope, data)
+	if err != nil || len(deliveries) == 0 {
+		return err
+	}
+	if err := s.Repo.CreateDeliveries(deliveries); err != nil {
+		return err
+	}
+	return s.enqueueAll(ctx, deliveries)
+}
+
+// newDeliveries builds, without storing, one pending delivery of data for
+// every active webhook matching kind and scope.
+func (s *Service) newDeliveries(kind string, scope Scope, data any) ([]models.WebhookDelivery, error) {
+	hooks := s.matching(kind, scope)
+	if len(hooks) == 0 {
+		return nil, nil
+	}
+	now := time.Now()
+	body, err := json.Marshal(Envelope{ID: uuid.New().String(), Kind: kind, CreatedAt: now, Data: data})
+	if err != nil {
+		return nil, err
+	}
+	deliveries := make([]models.WebhookDelivery, len(hooks))
+	for i, w := range hooks {
+		deliveries[i] = models.WebhookDelivery{
+			ID:            uuid.New().String(),
+			WebhookID:     w.ID,
+			Kind:          kind,
+			Payload:       string(body),
+			Status:        StatusPending,
+			NextAttemptAt: &now,
+		}
+	}
+	return deliveries, nil
+}
+
+func (s *Service) enqueueAll(ctx context.Context, deliveries []models.WebhookDelivery) error {
+	for _, d := range deliveries {
+		if err := s.enqueue(ctx, d.ID, *d.NextAttemptAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordBotBlocked counts a blocked bot in scope's tracker or site for the
+// current minute and emits bot_spike to webhooks whose limit it reaches.
+func (s *Service) RecordBotBlocked(ctx context.Context, scope Scope) error {
+	if s == nil || !s.subscribed(KindBotSpike) {
+		return nil
+	}
+	owner, id := "tracker", scope.TrackerID
+	if id == "" {
+		owner, id = "site", scope.SiteID
+	}
+	minute := time.Now().Truncate(time.Minute)
+	key := fmt.Sprintf("webhook:bots:%s:%s:%d", owner, id, minute.Unix())
+	n, err := s.Redis.Incr(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+	if n == 1 {
+		s.Redis.Expire(ctx, key, 2*time.Minute)
+	}
+	scope.BotCount = n
+	return s.Emit(ctx, KindBotSpike, scope, map[string]any{
+		"tracker_id":     scope.TrackerID,
+		"site_id":        scope.SiteID,
+		"blocked":        n,
+		"window_start":   minute,
+		"window_seconds": 60,
+	})
+}
+
+// Replay queues a new delivery of a logged delivery's payload.
+func (s *Service) Replay(ctx context.Context, deliveryID string) (*models.WebhookDelivery, error) {
+	orig, err := s.Repo.GetDelivery(deliveryID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	d := models.WebhookDelivery{
+		ID:            uuid.New().String(),
+		WebhookID:     orig.WebhookID,
+		Kind:          orig.Kind,
+		Payload:       orig.Payload,
+		Status:        StatusPending,
+		NextAttemptAt: &now,
+		ReplayOf:      orig.ID,
+	}
+	if err := s.Repo.CreateDeliveries([]models.WebhookDelivery{d}); err != nil {
+		return nil, err
+	}
+	d.CreatedAt = now
+	return &d, s.enqueue(ctx, d.ID, now)
+}
+
+func (s *Service) enqueue(ctx context.Context, id string, due time.Time) error {
+	return s.Redis.ZAdd(ctx, queueKey, redis.Z{Score: float64(due.UnixMilli()), Member: id}).Err()
+}
+
+// Run delivers due webhooks and emits token expiries until ctx is done.
+func (s *Service) Run(ctx context.Context) {
+	s.requeuePending(ctx)
+	poll := time.NewTicker(pollInterval)
+	defer poll.Stop()
+	expiry := time.NewTicker(expiryInterval)
+	defer expiry.Stop()
+	prune := time.NewTicker(pruneInterval)
+	defer prune.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-poll.C:
+			s.processDue(ctx, time.Now())
+		case <-expiry.C:
+			s.emitExpiredTokens(ctx, time.Now())
+		case <-prune.C:
+			if n, err := s.Repo.PruneDeliveries(time.Now().Add(-DeliveryRetention)); err != nil {
+				slog.Warn("webhook delivery prune failed", "error", err)
+			} else if n > 0 {
+				slog.Info("webhook deliveries pruned", "count", n)
+			}
+		}
+	}
+}
+
+// requeuePending restores pending deliveries to the queue, covering a lost
+// Redis and deliveries claimed by a replica that stopped mid-attempt.
+func (s *Service) requeuePending(ctx context.Context) {
+	pending, err := s.Repo.PendingDeliveries()
+	if err != nil {
+		slog.Error("webhook requeue failed", "error", err)
+		return
+	}
+	for _, d := range pending {
+		due := d.CreatedAt
+		if d.NextAttemptAt != nil {
+			due = *d.NextAttemptAt
+		}
+		z := redis.Z{Score: float64(due.UnixMilli()), Member: d.ID}
+		if err := s.Redis.ZAddNX(ctx, queueKey, z).Err(); err != nil {
+			slog.Error("webhook requeue failed", "error", err)
+			return
+		}
+	}
+	if len(pending) > 0 {
+		slog.Info("webhook deliveries requeued", "count", len(pending))
+	}
+}
+
+// processDue attempts a batch of due deliveries. ZREM claims each one, so
+// only one replica attempts it.
+func (s *Service) processDue(ctx context.Context, now time.Time) {
+	ids, err := s.Redis.ZRangeByScore(ctx, queueKey, &redis.ZRangeBy{
+		Min: "-inf", Max: strconv.FormatInt(now.UnixMilli(), 10), Count: batchSize,
+	}).Result()
+	if err != nil {
+		slog.Warn("webhook queue read failed", "error", err)
+		return
+	}
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		if n, err := s.Redis.ZRem(ctx, queueKey, id).Result(); err != nil || n == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			s.attempt(ctx, id)
+		}(id)
+	}
+	wg.Wait()
+}
+
+func (s *Service) attempt(ctx context.Context, id string) {
+	d, err := s.Repo.GetDelivery(id)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			slog.Warn("webhook delivery load failed", "error", err, "delivery", id)
+			s.enqueue(ctx, id, time.Now().Add(baseBackoff))
+		}
+		return
+	}
+	if d.Status != StatusPending {
+		return
+	}
+
+	// final errors fail the delivery without further retries
+	final := false
+	hook, err := s.Repo.GetByID(d.WebhookID)
+	switch {
+	case err == nil && hook.Active:
+		d.ResponseCode, err = s.send(ctx, hook, d)
+	case err == nil:
+		err, final = errors.New("webhook inactive"), true
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		err, final = errors.New("webhook deleted"), true
+	}
+	d.Attempts++
+	now := time.Now()
+	if err == nil {
+		d.Status, d.DeliveredAt, d.NextAttemptAt, d.LastError = StatusDelivered, &now, nil, ""
+	} else {
+		d.LastError = err.Error()
+		if final || d.Attempts >= MaxAttempts {
+			d.Status, d.NextAttemptAt = StatusFailed, nil
+		} else {
+			next := now.Add(Backoff(d.Attempts))
+			d.NextAttemptAt = &next
+		}
+	}
+	if err := s.Repo.UpdateDelivery(d); err != nil {
+		slog.Error("webhook delivery update failed", "error", err, "delivery", id)
+	}
+	if d.Status == StatusPending {
+		if err := s.enqueue(ctx, d.ID, *d.NextAttemptAt); err != nil {
+			slog.Error("webhook requeue failed", "error", err, "delivery", id)
+		}
+	}
+}
+
+// send POSTs a delivery to its webhook. Any 2xx response is success.
+func (s *Service) send(ctx context.Context, hook *models.Webhook, d *models.WebhookDelivery) (int, error) {
+	body := []byte(d.Payload)
+	ts := time.Now().Unix()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "tracking-webhooks/1")
+	req.Header.Set("X-Webhook-ID", hook.ID)
+	req.Header.Set("X-Webhook-Delivery", d.ID)
+	req.Header.Set("X-Webhook-Kind", d.Kind)
+	req.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(ts, 10))
+	req.Header.Set("X-Webhook-Signature", Sign(hook.Secret, ts, body))
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+func (s *Service) emitExpiredTokens(ctx context.Context, now time.Time) {
+	tokens, err := s.TokenRepo.ExpiredUnnotified(now)
+	if err != nil {
+		slog.Warn("token expiry scan failed", "error", err)
+	}
+	for i := range tokens {
+		t := &tokens[i]
+		deliveries, err := s.newDeliveries(KindTokenExpired, Scope{TrackerID: t.TrackerID}, TokenData(t))
+		if err == nil {
+			var claimed bool
+			claimed, err = s.TokenRepo.MarkExpiryNotified(t.ID, deliveries)
+			if claimed {
+				err = s.enqueueAll(ctx, deliveries)
+			}
+		}
+		if err != nil {
+			slog.Warn("token expiry webhook failed", "error", err, "token", t.ID)
+		}
+	}
+}
+
+// ClickData is the payload of a click webhook. IP and User-Agent are omitted.
+func ClickData(c *models.Click) map[string]any {
+	return map[string]any{
+		"click_id":      c.ID,
+		"ts":            c.TS,
+		"tracker_id":    c.TrackerID,
+		"campaign_id":   c.CampaignID,
+		"channel_id":    c.ChannelID,
+		"target_id":     c.TargetID,
+		"visitor_id":    c.VisitorID,
+		"country":       c.Country,
+		"browser":       c.Browser,
+		"os":            c.OS,
+		"referer":       c.Referer,
+		"suspected_bot": c.SuspectedBot,
+	}
+}
+
+// ConversionData is the payload of a conversion webhook.
+func ConversionData(e *models.Event) map[string]any {
+	return map[string]any{
+		"event_id":   e.ID,
+		"ts":         e.TS,
+		"site_id":    e.SiteID,
+		"type":       e.Type,
+		"visitor_id": e.VisitorID,
+		"session_id": e.SessionID,
+		"url":        e.URL,
+		"country":    e.Country,
+		"props":      e.Props,
+	}
+}
+
+// TokenData is the payload of a token_expired webhook.
+func TokenData(t *models.Token) map[string]any {
+	return map[string]any{
+		"token_id":    t.ID,
+		"short_code":  t.ShortCode,
+		"tracker_id":  t.TrackerID,
+		"campaign_id": t.CampaignID,
+		"channel_id":  t.ChannelID,
+		"target_id":   t.TargetID,
+		"expires_at":  t.ExpiresAt,
+	}
+}
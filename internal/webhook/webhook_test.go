@@ -0,0 +1,125 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/tracking/analysis/internal/models"
+)
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"id":"1"}`)
+	sig := Sign("secret", 1700000000, body)
+	if sig != Sign("secret", 1700000000, body) {
+		t.Error("signature should be deterministic")
+	}
+	if sig == Sign("other", 1700000000, body) {
+		t.Error("signature should depend on the secret")
+	}
+	if sig == Sign("secret", 1700000001, body) {
+		t.Error("signature should depend on the timestamp")
+	}
+	if len(sig) != len("sha256=")+64 || sig[:7] != "sha256=" {
+		t.Errorf("unexpected signature format %q", sig)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{7, 32 * time.Minute},
+		{20, 6 * time.Hour},
+	}
+	for _, tt := range tests {
+		if got := Backoff(tt.attempts); got != tt.want {
+			t.Errorf("Backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	w := &models.Webhook{
+		Active:           true,
+		Kinds:            models.StringList{KindClick, KindConversion, KindBotSpike},
+		TrackerID:        "tr-1",
+		ConversionEvents: models.StringList{"signup"},
+		BotSpikeLimit:    5,
+	}
+	tests := []struct {
+		name  string
+		kind  string
+		scope Scope
+		want  bool
+	}{
+		{"click in tracker", KindClick, Scope{TrackerID: "tr-1"}, true},
+		{"click in other tracker", KindClick, Scope{TrackerID: "tr-2"}, false},
+		{"unsubscribed kind", KindTokenExpired, Scope{TrackerID: "tr-1"}, false},
+		{"conversion event", KindConversion, Scope{TrackerID: "tr-1", EventType: "signup"}, true},
+		{"other event", KindConversion, Scope{TrackerID: "tr-1", EventType: "pageview"}, false},
+		{"below spike limit", KindBotSpike, Scope{TrackerID: "tr-1", BotCount: 4}, false},
+		{"at spike limit", KindBotSpike, Scope{TrackerID: "tr-1", BotCount: 5}, true},
+		{"past spike limit", KindBotSpike, Scope{TrackerID: "tr-1", BotCount: 6}, false},
+	}
+	for _, tt := range tests {
+		if got := Matches(w, tt.kind, tt.scope); got != tt.want {
+			t.Errorf("%s: Matches = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	w.Active = false
+	if Matches(w, KindClick, Scope{TrackerID: "tr-1"}) {
+		t.Error("inactive webhook should not match")
+	}
+}
+
+func TestSend_SignsRequest(t *testing.T) {
+	var gotSig, gotTS, gotKind string
+	var gotBody []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotSig = r.Header.Get("X-Webhook-Signature")
+		gotTS = r.Header.Get("X-Webhook-Timestamp")
+		gotKind = r.Header.Get("X-Webhook-Kind")
+		gotBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	s := &Service{Client: srv.Client()}
+	hook := &models.Webhook{ID: "hook-1", URL: srv.URL, Secret: "secret"}
+	d := &models.WebhookDelivery{ID: "d-1", Kind: KindClick, Payload: `{"id":"e-1"}`}
+	code, err := s.send(context.Background(), hook, d)
+	if err != nil || code != http.StatusNoContent {
+		t.Fatalf("send = %d, %v", code, err)
+	}
+	if gotKind != KindClick || string(gotBody) != d.Payload {
+		t.Errorf("got kind %q body %q", gotKind, gotBody)
+	}
+	ts, _ := strconv.ParseInt(gotTS, 10, 64)
+	if gotSig != Sign("secret", ts, gotBody) {
+		t.Errorf("signature %q does not verify", gotSig)
+	}
+}
+
+func TestSend_Non2xxFails(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	s := &Service{Client: srv.Client()}
+	hook := &models.Webhook{ID: "hook-1", URL: srv.URL, Secret: "secret"}
+	code, err := s.send(context.Background(), hook, &models.WebhookDelivery{ID: "d-1", Payload: "{}"})
+	if err == nil || code != http.StatusInternalServerError {
+		t.Errorf("send = %d, %v; want 500 error", code, err)
+	}
+}